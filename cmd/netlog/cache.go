@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/redis"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+// cacheDumpJSON prints the cached owners as JSON
+var cacheDumpJSON = false
+
+// cachedOwner is an entry of netlog cache dump
+type cachedOwner struct {
+	IP        string `json:"ip"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the Redis cache of IP owners",
+	Long: `Manage the owners of IP addresses cached in Redis by the resolvers. Other
+data in the database, such as the usage accounting, is left alone.`,
+}
+
+var cacheWarmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "Cache the owners of all OVN floating IPs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		cfg, cache, err := loadCache(cmd)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		kube, err := newKubernetes(cfg, nil)
+		if err != nil {
+			return err
+		}
+
+		ofips, err := kube.ListOFIPs(ctx)
+		if err != nil {
+			return err
+		}
+		cached, skipped := 0, 0
+		for ip, name := range ofips {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			owner, err := types.ParseOFIPName(name)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Skipping %s: %v\n", ip, err)
+				skipped++
+				continue
+			}
+			if err := cache.SetIP(ip, redis.IPInfo{Namespace: owner.Namespace, Name: owner.Name}); err != nil {
+				return err
+			}
+			cached++
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Cached %d owners, skipped %d\n", cached, skipped)
+		return nil
+	},
+}
+
+var cacheFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Delete all cached owners",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		_, cache, err := loadCache(cmd)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		deleted, err := cache.DeleteIPs(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Deleted %d cached owners\n", deleted)
+		return nil
+	},
+}
+
+var cacheDumpCmd = &cobra.Command{
+	Use:   "dump",
+	Short: "Print all cached owners",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		_, cache, err := loadCache(cmd)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		owners := []cachedOwner{}
+		err = cache.ScanIPs(ctx, func(ip string, info redis.IPInfo) error {
+			owners = append(owners, cachedOwner{IP: ip, Namespace: info.Namespace, Name: info.Name})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		sort.Slice(owners, func(i, j int) bool { return owners[i].IP < owners[j].IP })
+
+		if cacheDumpJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(owners)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "IP\tNAMESPACE\tNAME")
+		for _, o := range owners {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", o.IP, o.Namespace, o.Name)
+		}
+		return w.Flush()
+	},
+}
+
+// loadCache loads the configuration and creates its Redis client
+func loadCache(cmd *cobra.Command) (*config.Config, *redis.Client, error) {
+	cfg, err := config.Load(ConfigFile, cmd.Flags())
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	cache, err := newRedis(cfg, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, cache, nil
+}
+
+func init() {
+	cacheDumpCmd.Flags().BoolVar(&cacheDumpJSON, "json", false, "Print the cached owners as JSON")
+	cacheCmd.AddCommand(cacheWarmCmd, cacheFlushCmd, cacheDumpCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/k8s"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/ownerfile"
+	"github.com/highscaleco/netlog/pkg/redis"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// informerSyncTimeout is how long the resolvers wait for the informers to
+// list the cluster before attributing with a partial index
+const informerSyncTimeout = 30 * time.Second
+
+// newRedis creates the Redis client of the resolvers section, recorder may be
+// nil. It does not connect, Redis is optional for the lookups.
+func newRedis(cfg *config.Config, recorder *metrics.Recorder) (*redis.Client, error) {
+	c := cfg.Resolvers.Redis
+	client, err := redis.New(redis.Options{
+		Addr:                  c.Addr,
+		Username:              c.Username,
+		Password:              c.Password,
+		DB:                    c.DB,
+		TLS:                   c.TLS.Enabled,
+		TLSCAFile:             c.TLS.CAFile,
+		TLSCertFile:           c.TLS.CertFile,
+		TLSKeyFile:            c.TLS.KeyFile,
+		TLSInsecureSkipVerify: c.TLS.InsecureSkipVerify,
+	}, recorder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create redis client: %v", err)
+	}
+	return client, nil
+}
+
+// newKubernetes creates the Kubernetes client of the resolvers section,
+// recorder may be nil
+func newKubernetes(cfg *config.Config, recorder *metrics.Recorder) (*k8s.Client, error) {
+	c := cfg.Resolvers.Kubernetes
+	return k8s.New(k8s.Options{
+		Kubeconfig: c.Kubeconfig,
+		Context:    c.Context,
+		InCluster:  c.InCluster,
+	}, recorder)
+}
+
+// newResolver creates the resolver chain of the resolvers section in the
+// order of resolvers.order: the Pods, Services and Nodes watched by the
+// informers, the owner file and the OVN floating IPs. The informers and the
+// reloads of the owner file run until ctx is done, the index is nil when the
+// informers are disabled. The lookups are recorded with recorder, which may
+// be nil.
+func newResolver(ctx context.Context, cfg *config.Config, cache *redis.Client, kube *k8s.Client, recorder *metrics.Recorder) (types.Chain, *k8s.Index, error) {
+	var chain types.Chain
+	var index *k8s.Index
+	for _, name := range cfg.Resolvers.Order {
+		switch name {
+		case "informers":
+			if !cfg.Resolvers.Kubernetes.Informers {
+				continue
+			}
+			var err error
+			c := cfg.Resolvers.Kubernetes
+			index, err = kube.NewIndex(k8s.IndexOptions{
+				Precedence:      c.Precedence,
+				Workloads:       c.Workloads,
+				Labels:          c.Labels,
+				NamespaceLabels: c.NamespaceLabels,
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to create kubernetes index: %v", err)
+			}
+			index.Start(ctx)
+			syncCtx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+			err = index.WaitForSync(syncCtx)
+			cancel()
+			if err != nil {
+				log.Printf("resolvers: attributing with a partial index: %v", err)
+			}
+			chain = append(chain, types.NewClusterResolver(index, recorder))
+		case "static":
+			c := cfg.Resolvers.Static
+			if c.File == "" {
+				continue
+			}
+			owners := ownerfile.New(c.File, recorder)
+			if err := owners.Load(); err != nil {
+				return nil, nil, fmt.Errorf("failed to load owner file: %v", err)
+			}
+			go owners.Run(ctx, time.Duration(c.ReloadInterval))
+			chain = append(chain, owners)
+		case "redis":
+			chain = append(chain, types.NewRedisResolver(cache, recorder))
+		case "kubernetes":
+			chain = append(chain, types.NewKubernetesResolver(kube, cache, recorder))
+		}
+	}
+	return chain, index, nil
+}
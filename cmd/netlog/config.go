@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+)
+
+// configPrint prints the effective configuration after validating it
+var configPrint = false
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the configuration file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file]",
+	Short: "Validate a configuration file",
+	Long: `Validate a configuration file together with the NETLOG_* environment
+variables overriding it, and the alert rules it refers to. Every problem is
+reported, the command fails if there is any:
+
+  netlog config validate /etc/netlog/config.yaml`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filename := ConfigFile
+		if len(args) > 0 {
+			filename = args[0]
+		}
+		if filename == "" {
+			return fmt.Errorf("no configuration file given")
+		}
+
+		cfg, err := config.Load(filename, nil)
+		if err != nil {
+			return fmt.Errorf("%s is invalid:\n%v", filename, err)
+		}
+		if configPrint {
+			data, err := yaml.Marshal(cfg)
+			if err != nil {
+				return fmt.Errorf("failed to encode configuration: %v", err)
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(data))
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s is valid\n", filename)
+		return nil
+	},
+}
+
+func init() {
+	configValidateCmd.Flags().BoolVar(&configPrint, "print", false, "Print the effective configuration including defaults and environment overrides")
+	configCmd.AddCommand(configValidateCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// lookupJSON prints the lookup as JSON
+	lookupJSON = false
+	// lookupStore stores an owner found in Kubernetes in Redis
+	lookupStore = false
+)
+
+// lookupResult is the JSON output of netlog lookup
+type lookupResult struct {
+	IP    string       `json:"ip"`
+	Steps []types.Step `json:"steps"`
+	Owner *types.OFIP  `json:"owner"`
+	Error string       `json:"error,omitempty"`
+}
+
+var lookupCmd = &cobra.Command{
+	Use:   "lookup <ip>",
+	Short: "Show how an IP address is attributed to a workload",
+	Long: `Run the resolver chain of netlog for an IP address and print the result of
+every step in the order of --resolver-order: the Pods, Services and Nodes
+watched by the informers, the owner file, the Redis cache, the OVN floating
+IPs in Kubernetes and the final owner. The lookup is read-only, pass --store
+to store an owner found in Kubernetes in Redis like netlog itself does.
+
+  netlog lookup 203.0.113.10 --redis-addr redis:6379 --kube-context prod`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ip := net.ParseIP(args[0])
+		if ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid IPv4 address %q", args[0])
+		}
+
+		cfg, err := config.Load(ConfigFile, cmd.Flags())
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+		cache, err := newRedis(cfg, nil)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		kube, err := newKubernetes(cfg, nil)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		resolver, _, err := newResolver(ctx, cfg, cache, kube, nil)
+		if err != nil {
+			return err
+		}
+		for _, r := range resolver {
+			if kr, ok := r.(*types.KubernetesResolver); ok {
+				kr.SetReadOnly(!lookupStore)
+			}
+		}
+
+		owner, steps, lookupErr := resolver.Trace(ip.String())
+		if lookupJSON {
+			result := lookupResult{IP: ip.String(), Steps: steps, Owner: owner}
+			if lookupErr != nil {
+				result.Error = lookupErr.Error()
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(result)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "STEP\tRESULT\tOWNER\tDURATION\tDETAIL")
+		for _, step := range steps {
+			stepOwner := ""
+			if step.Owner != nil {
+				stepOwner = step.Owner.String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", step.Resolver, step.Result, stepOwner, step.Duration.Round(time.Microsecond), step.Detail)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if lookupErr != nil {
+			fmt.Fprintf(cmd.OutOrStdout(), "\n%s is not attributed: %v\n", ip, lookupErr)
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "\n%s is owned by %s\n", ip, owner)
+		return nil
+	},
+}
+
+func init() {
+	lookupCmd.Flags().BoolVar(&lookupJSON, "json", false, "Print the lookup as JSON")
+	lookupCmd.Flags().BoolVar(&lookupStore, "store", false, "Store an owner found in Kubernetes in Redis")
+	rootCmd.AddCommand(lookupCmd)
+}
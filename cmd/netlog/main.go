@@ -3,26 +3,197 @@ package main
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/google/gopacket/pcap"
+	"github.com/highscaleco/netlog/pkg/accounting"
+	"github.com/highscaleco/netlog/pkg/alert"
+	"github.com/highscaleco/netlog/pkg/blocklist"
 	"github.com/highscaleco/netlog/pkg/capture"
+	"github.com/highscaleco/netlog/pkg/clickhouse"
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/detect"
+	"github.com/highscaleco/netlog/pkg/elasticsearch"
+	"github.com/highscaleco/netlog/pkg/flowapi"
+	"github.com/highscaleco/netlog/pkg/health"
 	"github.com/highscaleco/netlog/pkg/metrics"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/highscaleco/netlog/pkg/otlp"
+	"github.com/highscaleco/netlog/pkg/ownerfile"
+	"github.com/highscaleco/netlog/pkg/redis"
+	"github.com/highscaleco/netlog/pkg/sink"
+	"github.com/highscaleco/netlog/pkg/spool"
+	"github.com/highscaleco/netlog/pkg/top"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/spf13/cobra"
 )
 
+// The flags override the settings of the configuration file, see config.Load
 var (
+	// ConfigFile specifies the configuration file
+	ConfigFile = ""
 	// FormatFlag specifies the output format
 	FormatFlag = "text"
+	// JSONFlag selects the JSON output format, deprecated in favor of --format json
+	JSONFlag = false
 	// InterfaceFlag specifies the network interface to capture from
 	InterfaceFlag = "en1"
+	// RedisAddr specifies the address of the Redis cache
+	RedisAddr = "localhost:6379"
+	// RedisUsername specifies the Redis ACL user
+	RedisUsername = ""
+	// RedisPassword specifies the Redis password
+	RedisPassword = ""
+	// RedisDB specifies the Redis database number
+	RedisDB = 0
+	// RedisTLS enables TLS towards Redis
+	RedisTLS = false
+	// RedisTLSCAFile specifies the CA certificates verifying Redis
+	RedisTLSCAFile = ""
+	// RedisTLSCertFile specifies the client certificate presented to Redis
+	RedisTLSCertFile = ""
+	// RedisTLSKeyFile specifies the key of the client certificate
+	RedisTLSKeyFile = ""
+	// RedisTLSInsecureSkipVerify disables TLS verification towards Redis
+	RedisTLSInsecureSkipVerify = false
+	// Kubeconfig specifies the kubeconfig file
+	Kubeconfig = ""
+	// KubeContext specifies the kubeconfig context
+	KubeContext = ""
+	// InCluster selects the in-cluster Kubernetes configuration
+	InCluster = false
+	// KubeInformers enables the Pod, Service and Node resolvers
+	KubeInformers = true
+	// KubePrecedence orders the kinds preferred when an IP matches several
+	KubePrecedence = []string{"pod", "service", "node", "ovnfip", "iptablesfiprule", "ovndnatrule", "ovnsnatrule", "iptableseip", "ovneip"}
+	// KubeWorkloads resolves the controllers owning pods
+	KubeWorkloads = true
+	// KubeLabels lists the labels of the owners copied to flows
+	KubeLabels []string
+	// KubeNamespaceLabels lists the labels of the namespaces copied to flows
+	KubeNamespaceLabels []string
+	// ResolverOrder lists the resolvers tried for an IP
+	ResolverOrder = []string{"informers", "static", "redis", "kubernetes"}
+	// StaticOwnersFile specifies the file mapping IPs and CIDRs to owners
+	StaticOwnersFile = ""
+	// StaticOwnersReloadInterval specifies how often the owner file is checked for changes
+	StaticOwnersReloadInterval = ownerfile.DefaultReloadInterval
 	// MetricsAddr specifies the address to expose metrics on
 	MetricsAddr = ":9090"
+	// HealthAddr specifies the address of the health endpoints
+	HealthAddr = ""
+	// PprofAddr specifies the address of the profiling endpoints
+	PprofAddr = ""
+	// MetricsLabelProfile specifies the label set of the traffic metrics
+	MetricsLabelProfile = metrics.ProfileFull
+	// MetricsLabels overrides the label set of the profile
+	MetricsLabels []string
+	// MetricsRemoteCIDRPrefix specifies the prefix length of the remote_cidr label
+	MetricsRemoteCIDRPrefix = metrics.DefaultRemoteCIDRPrefix
+	// MetricsMaxSeries specifies the maximum number of series per metric
+	MetricsMaxSeries = metrics.DefaultMaxSeries
+	// MetricsConstLabels specifies labels added to every metric, e.g. the node or cluster
+	MetricsConstLabels map[string]string
+	// SinkHTTPURL specifies an HTTP endpoint flows are posted to
+	SinkHTTPURL = ""
+	// SpoolDir specifies the directory used to spool flows for network sinks
+	SpoolDir = ""
+	// SpoolMaxBytes specifies the maximum size of each sink spool
+	SpoolMaxBytes int64 = spool.DefaultMaxBytes
+	// OTLPEndpoint specifies the OTLP receiver to export to
+	OTLPEndpoint = ""
+	// OTLPProtocol specifies the OTLP transport (grpc or http)
+	OTLPProtocol = otlp.ProtocolGRPC
+	// OTLPInsecure disables TLS towards the OTLP receiver
+	OTLPInsecure = false
+	// OTLPHeaders specifies the headers sent with every OTLP export
+	OTLPHeaders map[string]string
+	// OTLPExport specifies which signals are exported over OTLP
+	OTLPExport = []string{"flows", "metrics"}
+	// OTLPMetricsInterval specifies how often metrics are exported over OTLP
+	OTLPMetricsInterval = otlp.DefaultMetricsInterval
+	// ESURLs specifies the Elasticsearch/OpenSearch nodes flows are indexed to
+	ESURLs []string
+	// ESUsername specifies the Elasticsearch basic auth user
+	ESUsername = ""
+	// ESPassword specifies the Elasticsearch basic auth password
+	ESPassword = ""
+	// ESAPIKey specifies the Elasticsearch API key
+	ESAPIKey = ""
+	// ESIndexPrefix specifies the prefix of the daily flow indices
+	ESIndexPrefix = elasticsearch.DefaultIndexPrefix
+	// ESBulkActions specifies the number of documents per bulk request
+	ESBulkActions = elasticsearch.DefaultBulkActions
+	// ESBulkBytes specifies the maximum size of a bulk request
+	ESBulkBytes = elasticsearch.DefaultBulkBytes
+	// ESMaxRetries specifies how often rejected documents are retried
+	ESMaxRetries = elasticsearch.DefaultMaxRetries
+	// ESInsecureSkipVerify disables TLS verification towards Elasticsearch
+	ESInsecureSkipVerify = false
+	// ClickhouseURL specifies the ClickHouse HTTP interface flows are inserted to
+	ClickhouseURL = ""
+	// ClickhouseUsername specifies the ClickHouse user
+	ClickhouseUsername = ""
+	// ClickhousePassword specifies the ClickHouse password
+	ClickhousePassword = ""
+	// ClickhouseDatabase specifies the database of the flow table
+	ClickhouseDatabase = clickhouse.DefaultDatabase
+	// ClickhouseTable specifies the name of the flow table
+	ClickhouseTable = clickhouse.DefaultTable
+	// ClickhouseEventsTable specifies the name of the security event table
+	ClickhouseEventsTable = clickhouse.DefaultEventsTable
+	// ClickhouseBatchSize specifies the number of rows per insert
+	ClickhouseBatchSize = clickhouse.DefaultBatchSize
+	// Accounting enables per-namespace usage accounting in Redis
+	Accounting = false
+	// AccountingRollupInterval specifies how often hourly usage is rolled up into days
+	AccountingRollupInterval = accounting.DefaultRollupInterval
+	// AlertRules specifies the file of the traffic alert rules
+	AlertRules = ""
+	// AlertWebhookURL specifies a webhook alerts are posted to
+	AlertWebhookURL = ""
+	// AlertmanagerURL specifies an Alertmanager alerts are sent to
+	AlertmanagerURL = ""
+	// AlertEvaluationInterval specifies how often alert rules are evaluated
+	AlertEvaluationInterval = alert.DefaultEvaluationInterval
+	// Detect enables the detection of port scans, SYN floods and UDP amplification
+	Detect = false
+	// DetectWindow specifies the time range packets are counted over by the detections
+	DetectWindow = detect.DefaultWindow
+	// DetectCooldown specifies the minimum time between repeated events
+	DetectCooldown = detect.DefaultCooldown
+	// DetectPortScanPorts specifies the number of distinct ports of a port scan
+	DetectPortScanPorts = detect.DefaultPortScanPorts
+	// DetectPortScanHosts specifies the number of distinct hosts of a port scan
+	DetectPortScanHosts = detect.DefaultPortScanHosts
+	// DetectSYNFloodHalfOpen specifies the number of half-open connections of a SYN flood
+	DetectSYNFloodHalfOpen = detect.DefaultSYNFloodHalfOpen
+	// DetectAmplificationBytes specifies the minimum of reflected bytes of a UDP amplification
+	DetectAmplificationBytes int64 = detect.DefaultAmplificationBytes
+	// DetectAmplificationRatio specifies the minimum ratio of reflected to requested bytes
+	DetectAmplificationRatio float64 = detect.DefaultAmplificationRatio
+	// Blocklists specifies the threat intelligence lists by name
+	Blocklists map[string]string
+	// BlocklistReloadInterval specifies how often blocklist files are checked for changes
+	BlocklistReloadInterval = blocklist.DefaultReloadInterval
+	// BlocklistCooldown specifies the minimum time between events for the same remote endpoint
+	BlocklistCooldown = blocklist.DefaultCooldown
+	// Top enables the top talkers rankings
+	Top = true
+	// TopWindows specifies the time ranges of the top talkers rankings
+	TopWindows = top.DefaultWindows
+	// TopCapacity specifies the number of keys tracked per ranking and bucket
+	TopCapacity = top.DefaultCapacity
+	// TopMetricsLimit specifies the number of entries per ranking exported as gauges
+	TopMetricsLimit = top.DefaultMetricsLimit
+	// ShutdownTimeout specifies how long flows are drained into the sinks on shutdown
+	ShutdownTimeout = sink.DefaultShutdownTimeout
+	// FlowsBuffer specifies the number of recent flows served by the flows API
+	FlowsBuffer = flowapi.DefaultCapacity
 )
 
 var rootCmd = &cobra.Command{
@@ -31,36 +202,213 @@ var rootCmd = &cobra.Command{
 	Long: `NetLog is a lightweight network packet capture tool that monitors network traffic
 and provides real-time insights into your network activity.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		// Setting --format keeps --json in effect across configuration reloads
+		if JSONFlag && !cmd.Flags().Changed("format") {
+			if err := cmd.Flags().Set("format", "json"); err != nil {
+				return err
+			}
+		}
+		cfg, err := config.Load(ConfigFile, cmd.Flags())
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+
 		// Initialize metrics
-		metrics.Init()
+		recorder, err := metrics.NewRecorder(metrics.Options{
+			ConstLabels:      cfg.Metrics.ConstLabels,
+			Profile:          cfg.Metrics.LabelProfile,
+			Labels:           cfg.Metrics.Labels,
+			RemoteCIDRPrefix: cfg.Metrics.RemoteCIDRPrefix,
+			MaxSeries:        cfg.Metrics.MaxSeries,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to initialize metrics: %v", err)
+		}
+		recorder.Registry().MustRegister(
+			collectors.NewGoCollector(),
+			collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		)
+
+		// Create Redis and Kubernetes clients
+		cache, err := newRedis(cfg, recorder)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+		kube, err := newKubernetes(cfg, recorder)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
 		// Create capture instance
-		capture := capture.NewCapture(
-			InterfaceFlag,
-			65536,             // bufferSize
-			true,              // promiscuous
-			pcap.BlockForever, // timeout
-			"tcp or udp",      // filter
-			65536,             // maxPacketSize
-			10000,             // maxConnections
-		)
-		if capture == nil {
-			return fmt.Errorf("failed to create capture instance")
+		capture, err := newCapture(cfg.Capture.Interface)
+		if err != nil {
+			return err
+		}
+		capture.SetRecorder(recorder)
+		resolver, index, err := newResolver(ctx, cfg, cache, kube, recorder)
+		if err != nil {
+			return err
+		}
+		capture.SetResolver(resolver)
+
+		// Create security detector
+		var detector *detect.Detector
+		if cfg.Detect.Enabled {
+			detector = detect.New(detect.Options{
+				Window:             time.Duration(cfg.Detect.Window),
+				Cooldown:           time.Duration(cfg.Detect.Cooldown),
+				PortScanPorts:      cfg.Detect.PortScanPorts,
+				PortScanHosts:      cfg.Detect.PortScanHosts,
+				SYNFloodHalfOpen:   cfg.Detect.SYNFloodHalfOpen,
+				AmplificationBytes: cfg.Detect.AmplificationBytes,
+				AmplificationRatio: cfg.Detect.AmplificationRatio,
+			}, recorder)
+			capture.AddObserver(detector)
+		}
+
+		// Create OTLP client
+		var otlpClient *otlp.Client
+		if cfg.OTLP.Endpoint != "" {
+			var err error
+			otlpClient, err = otlp.New(otlp.Options{
+				Endpoint: cfg.OTLP.Endpoint,
+				Protocol: cfg.OTLP.Protocol,
+				Insecure: cfg.OTLP.Insecure,
+				Headers:  cfg.OTLP.Headers,
+			})
+			if err != nil {
+				return fmt.Errorf("failed to create otlp client: %v", err)
+			}
+			defer otlpClient.Close()
+		}
+
+		// Load blocklists
+		var blocklists *blocklist.Matcher
+		if len(cfg.Blocklists.Lists) > 0 {
+			lists, err := cfg.Blocklists.ParseLists()
+			if err != nil {
+				return fmt.Errorf("invalid blocklist: %v", err)
+			}
+			blocklists = blocklist.New(lists, blocklist.Options{Cooldown: time.Duration(cfg.Blocklists.Cooldown)}, recorder)
+			if err := blocklists.Load(); err != nil {
+				return fmt.Errorf("failed to load blocklists: %v", err)
+			}
+		}
+
+		// Create alert engine, its rules are set by the pipeline
+		alerts := alert.NewEngine(nil, nil, time.Duration(cfg.Alerts.EvaluationInterval), recorder)
+
+		// Create top talkers rankings
+		var talkers *top.Tracker
+		if cfg.Top.Enabled {
+			talkers = top.New(top.Options{
+				Windows:      config.Durations(cfg.Top.Windows),
+				Capacity:     cfg.Top.Capacity,
+				MetricsLimit: cfg.Top.MetricsLimit,
+			})
+			if err := recorder.RegisterTopTalkers(talkers); err != nil {
+				return fmt.Errorf("failed to register top talkers metrics: %v", err)
+			}
+		}
+
+		// Keep recent flows for the flows API
+		var flows *flowapi.Ring
+		if cfg.Flows.Buffer > 0 {
+			flows = flowapi.NewRing(cfg.Flows.Buffer)
+		}
+
+		// Create output sinks
+		static, err := newSinks(cfg, cache, otlpClient, alerts, talkers, flows, recorder)
+		if err != nil {
+			return err
+		}
+		pipe, err := newPipeline(cfg, static, alerts, recorder)
+		if err != nil {
+			return err
+		}
+		defer pipe.Close()
+
+		// Report open connections from the connection table of the capture
+		connections := metrics.ConnectionSourceFunc(func() []metrics.ConnectionCount {
+			active := capture.ActiveConnections()
+			counts := make([]metrics.ConnectionCount, len(active))
+			for i, conn := range active {
+				counts[i] = metrics.ConnectionCount(conn)
+			}
+			return counts
+		})
+		if err := recorder.RegisterConnections(connections); err != nil {
+			return fmt.Errorf("failed to register connection metrics: %v", err)
 		}
 
 		// Start packet capture
-		ctx := context.Background()
 		if err := capture.Start(ctx); err != nil {
 			return fmt.Errorf("failed to start capture: %v", err)
 		}
 
-		// Start metrics server
-		go func() {
-			http.Handle("/metrics", promhttp.Handler())
-			if err := http.ListenAndServe(MetricsAddr, nil); err != nil {
-				fmt.Printf("Error starting metrics server: %v\n", err)
-			}
-		}()
+		// Reload filters, alert rules and sinks when the configuration changes
+		if ConfigFile != "" {
+			go pipe.watch(ctx, ConfigFile, cmd.Flags())
+		}
+
+		// Report whether packets are captured, attributed and delivered
+		checker := health.NewChecker(health.DefaultTimeout)
+		checker.Add("capture", func(ctx context.Context) error { return capture.Healthy() })
+		checker.Add("kubernetes", kube.Ping)
+		if index != nil {
+			checker.Add("informers", index.Ready)
+		}
+		checker.Add("redis", cache.Ping)
+		checker.Add("sinks", func(ctx context.Context) error { return pipe.out.Healthy() })
+
+		// Start metrics, health and profiling servers
+		healthAddr := cfg.Metrics.HealthAddr
+		if healthAddr == "" {
+			healthAddr = cfg.Metrics.Addr
+		}
+		srv := servers{}
+		srv.handle(cfg.Metrics.Addr, "/metrics", recorder.Handler())
+		if talkers != nil {
+			srv.handle(cfg.Metrics.Addr, "/api/v1/top", talkers.Handler())
+		}
+		if flows != nil {
+			srv.handle(cfg.Metrics.Addr, "/api/v1/flows", flows.ListHandler())
+			srv.handle(cfg.Metrics.Addr, "/api/v1/flows/stream", flows.StreamHandler())
+		}
+		srv.handle(healthAddr, "/healthz", health.LivenessHandler())
+		srv.handle(healthAddr, "/readyz", checker.Handler())
+		srv.handlePprof(cfg.Metrics.PprofAddr)
+		httpServers := srv.start(ctx)
+
+		// Start OTLP metrics export
+		if otlpClient != nil && exportsSignal(cfg, "metrics") {
+			exporter := otlp.NewMetricExporter(otlpClient, recorder.Registry(), time.Duration(cfg.OTLP.MetricsInterval))
+			go exporter.Run(ctx)
+		}
+
+		// Start alert evaluation
+		go alerts.Run(ctx)
+
+		// Start security detection and deliver its events to the sinks
+		if detector != nil {
+			go detector.Run(ctx)
+			go writeEvents(ctx, pipe.out, detector.Events())
+		}
+
+		// Start blocklist reloads and deliver matches to the sinks
+		if blocklists != nil {
+			go blocklists.Run(ctx, time.Duration(cfg.Blocklists.ReloadInterval))
+			go writeEvents(ctx, pipe.out, blocklists.Events())
+		}
+
+		// Start usage rollup job
+		if cfg.Accounting.Enabled {
+			go accounting.New(cache.Redis(), accounting.Options{}).RunRollup(ctx, time.Duration(cfg.Accounting.RollupInterval))
+		}
 
 		// Start metrics cleanup goroutine
 		go func() {
@@ -72,7 +420,7 @@ and provides real-time insights into your network activity.`,
 				case <-ctx.Done():
 					return
 				case <-ticker.C:
-					metrics.CleanupMetrics()
+					recorder.CleanupMetrics()
 				}
 			}
 		}()
@@ -81,29 +429,38 @@ and provides real-time insights into your network activity.`,
 		sigChan := make(chan os.Signal, 1)
 		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
-		// Process packets
+		// Process packets until the capture closes the channel
+		processed := make(chan struct{})
 		go func() {
+			defer close(processed)
 			for packet := range capture.Packets() {
-				var output string
-				if FormatFlag == "json" {
-					output = packet.JSONString()
-				} else {
-					output = packet.String()
+				if !pipe.Match(packet) {
+					continue
+				}
+				if blocklists != nil {
+					packet = blocklists.Tag(packet)
 				}
-				if output != "" {
-					fmt.Println(output)
+				if err := pipe.out.Write(ctx, []types.AggregatedInfo{packet}); err != nil {
+					fmt.Printf("Error writing flow: %v\n", err)
 				}
 
 				// Update Prometheus metrics
-				if packet.Namespace != "" {
-					metrics.UpdateMetrics(
-						packet.Namespace,
-						packet.Name,
-						packet.Source,
-						packet.Destination,
-						packet.Protocol,
-						packet.Port,
-						packet.Direction,
+				if packet.Owned() {
+					recorder.UpdateFlowMetrics(
+						metrics.FlowLabels{
+							Namespace:       packet.Namespace,
+							Name:            packet.Name,
+							Kind:            packet.Kind,
+							Workload:        packet.Workload,
+							WorkloadKind:    packet.WorkloadKind,
+							Source:          packet.Source,
+							Destination:     packet.Destination,
+							Protocol:        packet.Protocol,
+							Port:            packet.Port,
+							Direction:       packet.Direction,
+							Labels:          packet.Labels,
+							NamespaceLabels: packet.NamespaceLabels,
+						},
 						packet.TotalBytes,
 						packet.Packets,
 						packet.EndTime.Sub(packet.StartTime).Seconds(),
@@ -112,18 +469,193 @@ and provides real-time insights into your network activity.`,
 			}
 		}()
 
-		// Wait for shutdown signal
+		// Wait for shutdown signal, a second one terminates the process
 		<-sigChan
-		capture.Stop()
+		signal.Stop(sigChan)
 
+		timeout := time.Duration(pipe.config().Sinks.ShutdownTimeout)
+		if err := shutdown(capture, processed, cancel, pipe, httpServers, timeout); err != nil {
+			return fmt.Errorf("shutdown incomplete: %v", err)
+		}
 		return nil
 	},
 }
 
+// newCapture creates a capture of the TCP and UDP traffic of iface
+func newCapture(iface string) (*capture.Capture, error) {
+	c := capture.NewCapture(
+		iface,
+		65536,             // bufferSize
+		true,              // promiscuous
+		pcap.BlockForever, // timeout
+		"tcp or udp",      // filter
+		65536,             // maxPacketSize
+		10000,             // maxConnections
+	)
+	if c == nil {
+		return nil, fmt.Errorf("failed to create capture instance")
+	}
+	return c, nil
+}
+
+// writeEvents writes security events to out until events is closed or ctx
+// is cancelled
+func writeEvents(ctx context.Context, out *sink.Multi, events <-chan types.Event) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := out.WriteEvents(ctx, []types.Event{event}); err != nil {
+				fmt.Printf("Error writing event: %v\n", err)
+			}
+		}
+	}
+}
+
+// newSinks creates the sinks that are not reloaded with the configuration.
+// Network sinks are batched and, when a spool directory is configured,
+// protected by a disk spool.
+func newSinks(cfg *config.Config, cache *redis.Client, otlpClient *otlp.Client, alerts *alert.Engine, talkers *top.Tracker, flows *flowapi.Ring, recorder *metrics.Recorder) ([]sink.Sink, error) {
+	sinks := []sink.Sink{alerts}
+
+	if talkers != nil {
+		sinks = append(sinks, talkers)
+	}
+
+	if flows != nil {
+		sinks = append(sinks, flows)
+	}
+
+	if cfg.Accounting.Enabled {
+		// Increments are not idempotent, a batch applied by Redis but
+		// reported as failed would be counted again on replay of the spool
+		s, err := newNetworkSink(accounting.New(cache.Redis(), accounting.Options{}), sink.DefaultBatchSize, config.Spool{}, recorder)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	if otlpClient != nil && exportsSignal(cfg, "flows") {
+		s, err := newNetworkSink(otlp.NewLogSink(otlpClient), sink.DefaultBatchSize, cfg.Sinks.Spool, recorder)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+
+	return sinks, nil
+}
+
+// exportsSignal reports whether signal is exported over OTLP
+func exportsSignal(cfg *config.Config, signal string) bool {
+	for _, s := range cfg.OTLP.Export {
+		if s == signal {
+			return true
+		}
+	}
+	return false
+}
+
+// newNetworkSink wraps a sink talking to a remote system with a spool and a
+// batcher flushing batchSize records at a time
+func newNetworkSink(s sink.Sink, batchSize int, spoolCfg config.Spool, recorder *metrics.Recorder) (sink.Sink, error) {
+	if spoolCfg.Dir != "" {
+		sp, err := spool.Open(filepath.Join(spoolCfg.Dir, s.Name()), spoolCfg.MaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spool for %s sink: %v", s.Name(), err)
+		}
+		s = sink.NewSpooled(s, sp, sink.DefaultRetryInterval, recorder)
+	}
+	return sink.NewBatcher(s, batchSize, sink.DefaultFlushInterval, recorder), nil
+}
+
 func init() {
-	rootCmd.Flags().StringVarP(&FormatFlag, "format", "f", "text", "Output format (text or json)")
+	rootCmd.PersistentFlags().StringVarP(&ConfigFile, "config", "c", "", "Configuration file (YAML or JSON), reloaded on SIGHUP and when it changes")
+	rootCmd.Flags().StringVarP(&FormatFlag, "format", "f", "text", "Output format (text, json or proto)")
+	rootCmd.Flags().BoolVar(&JSONFlag, "json", false, "Enable JSON output format")
+	rootCmd.Flags().MarkDeprecated("json", "use --format json instead")
+	rootCmd.PersistentFlags().StringVar(&RedisAddr, "redis-addr", "localhost:6379", "Redis server address")
+	rootCmd.PersistentFlags().StringVar(&RedisUsername, "redis-username", "", "Redis ACL username (default user if empty)")
+	rootCmd.PersistentFlags().StringVar(&RedisPassword, "redis-password", "", "Redis password")
+	rootCmd.PersistentFlags().IntVar(&RedisDB, "redis-db", 0, "Redis database number")
+	rootCmd.PersistentFlags().BoolVar(&RedisTLS, "redis-tls", false, "Connect to Redis over TLS")
+	rootCmd.PersistentFlags().StringVar(&RedisTLSCAFile, "redis-tls-ca-file", "", "CA certificates verifying Redis instead of the system roots")
+	rootCmd.PersistentFlags().StringVar(&RedisTLSCertFile, "redis-tls-cert-file", "", "Client certificate presented to Redis")
+	rootCmd.PersistentFlags().StringVar(&RedisTLSKeyFile, "redis-tls-key-file", "", "Key of the client certificate presented to Redis")
+	rootCmd.PersistentFlags().BoolVar(&RedisTLSInsecureSkipVerify, "redis-tls-insecure-skip-verify", false, "Disable TLS certificate verification towards Redis")
+	rootCmd.PersistentFlags().StringVar(&Kubeconfig, "kubeconfig", "", "Kubeconfig file (default: $KUBECONFIG, ~/.kube/config, then the in-cluster configuration)")
+	rootCmd.PersistentFlags().StringVar(&KubeContext, "kube-context", "", "Kubeconfig context to use (default: the current context)")
+	rootCmd.PersistentFlags().BoolVar(&InCluster, "in-cluster", false, "Use the service account of the pod instead of a kubeconfig")
+	rootCmd.PersistentFlags().BoolVar(&KubeInformers, "kube-informers", true, "Attribute the IPs of Pods, Services and Nodes watched with informers")
+	rootCmd.PersistentFlags().StringSliceVar(&KubePrecedence, "kube-precedence", KubePrecedence, "Kinds preferred when an IP belongs to several objects, including the kube-ovn NAT kinds, kinds left out are not watched")
+	rootCmd.PersistentFlags().BoolVar(&KubeWorkloads, "kube-workloads", true, "Resolve the workload owning a pod, such as its Deployment, StatefulSet, DaemonSet, Job or KubeVirt VM")
+	rootCmd.PersistentFlags().StringSliceVar(&KubeLabels, "kube-labels", nil, "Labels of the owning Pod, Service or Node to add to flows")
+	rootCmd.PersistentFlags().StringSliceVar(&KubeNamespaceLabels, "kube-namespace-labels", nil, "Labels of the namespace of the owner to add to flows")
+	rootCmd.PersistentFlags().StringSliceVar(&ResolverOrder, "resolver-order", ResolverOrder, "Resolvers tried for an IP until one knows its owner (informers, static, redis, kubernetes), resolvers left out are not used")
+	rootCmd.PersistentFlags().StringVar(&StaticOwnersFile, "static-owners-file", "", "YAML or CSV file mapping IPs and CIDRs to owners without Kubernetes objects (disabled if empty)")
+	rootCmd.PersistentFlags().DurationVar(&StaticOwnersReloadInterval, "static-owners-reload-interval", ownerfile.DefaultReloadInterval, "Interval between checks of the owner file for changes")
 	rootCmd.Flags().StringVarP(&InterfaceFlag, "interface", "i", "eth0", "Network interface to capture from")
-	rootCmd.Flags().StringVarP(&MetricsAddr, "metrics-addr", "m", ":9090", "Address to expose metrics on")
+	rootCmd.Flags().StringVarP(&MetricsAddr, "metrics-addr", "m", ":9090", "Address to expose metrics and the HTTP API on (disabled if empty)")
+	rootCmd.Flags().StringVar(&HealthAddr, "health-addr", "", "Address to expose /healthz and /readyz on (default: the metrics address)")
+	rootCmd.Flags().StringVar(&PprofAddr, "pprof-addr", "", "Address to expose /debug/pprof on, may be the metrics address (disabled if empty)")
+	rootCmd.Flags().StringVar(&MetricsLabelProfile, "metrics-label-profile", metrics.ProfileFull, "Label set of the traffic metrics (full, workload or remote-cidr)")
+	rootCmd.Flags().StringSliceVar(&MetricsLabels, "metrics-labels", nil, "Custom label set of the traffic metrics, overrides --metrics-label-profile")
+	rootCmd.Flags().IntVar(&MetricsRemoteCIDRPrefix, "metrics-remote-cidr-prefix", metrics.DefaultRemoteCIDRPrefix, "Prefix length of the remote_cidr label")
+	rootCmd.Flags().IntVar(&MetricsMaxSeries, "metrics-max-series", metrics.DefaultMaxSeries, "Maximum number of series per traffic metric, further label combinations go to an overflow series (0 disables the limit)")
+	rootCmd.Flags().StringToStringVar(&MetricsConstLabels, "metrics-const-labels", nil, "Labels added to every metric, e.g. node=worker-1,cluster=prod")
+	rootCmd.Flags().StringVar(&SinkHTTPURL, "sink-http-url", "", "HTTP endpoint to post flows to as newline-delimited JSON")
+	rootCmd.Flags().StringVar(&SpoolDir, "spool-dir", "", "Directory to spool flows in while a network sink is unavailable (disabled if empty)")
+	rootCmd.Flags().Int64Var(&SpoolMaxBytes, "spool-max-bytes", spool.DefaultMaxBytes, "Maximum size of the spool of each network sink, oldest flows are discarded first")
+	rootCmd.Flags().StringVar(&OTLPEndpoint, "otlp-endpoint", "", "OTLP receiver to export to, host:port for grpc or a URL for http (disabled if empty)")
+	rootCmd.Flags().StringVar(&OTLPProtocol, "otlp-protocol", otlp.ProtocolGRPC, "OTLP transport (grpc or http)")
+	rootCmd.Flags().BoolVar(&OTLPInsecure, "otlp-insecure", false, "Disable TLS towards the OTLP receiver")
+	rootCmd.Flags().StringToStringVar(&OTLPHeaders, "otlp-headers", nil, "Headers sent with every OTLP export, e.g. authorization=\"Bearer <token>\"")
+	rootCmd.Flags().StringSliceVar(&OTLPExport, "otlp-export", []string{"flows", "metrics"}, "Signals to export over OTLP (flows, metrics)")
+	rootCmd.Flags().DurationVar(&OTLPMetricsInterval, "otlp-metrics-interval", otlp.DefaultMetricsInterval, "Interval between OTLP metric exports")
+	rootCmd.Flags().StringSliceVar(&ESURLs, "es-url", nil, "Elasticsearch/OpenSearch node URLs to index flows to (disabled if empty)")
+	rootCmd.Flags().StringVar(&ESUsername, "es-username", "", "Elasticsearch basic auth username")
+	rootCmd.Flags().StringVar(&ESPassword, "es-password", "", "Elasticsearch basic auth password")
+	rootCmd.Flags().StringVar(&ESAPIKey, "es-api-key", "", "Elasticsearch API key")
+	rootCmd.Flags().StringVar(&ESIndexPrefix, "es-index-prefix", elasticsearch.DefaultIndexPrefix, "Prefix of the daily flow indices")
+	rootCmd.Flags().IntVar(&ESBulkActions, "es-bulk-actions", elasticsearch.DefaultBulkActions, "Number of documents per bulk request")
+	rootCmd.Flags().IntVar(&ESBulkBytes, "es-bulk-bytes", elasticsearch.DefaultBulkBytes, "Maximum size of a bulk request in bytes")
+	rootCmd.Flags().IntVar(&ESMaxRetries, "es-max-retries", elasticsearch.DefaultMaxRetries, "Number of retries of documents rejected with a retryable status")
+	rootCmd.Flags().BoolVar(&ESInsecureSkipVerify, "es-insecure-skip-verify", false, "Disable TLS certificate verification towards Elasticsearch")
+	rootCmd.Flags().StringVar(&ClickhouseURL, "clickhouse-url", "", "ClickHouse HTTP interface to insert flows to, e.g. http://clickhouse:8123 (disabled if empty)")
+	rootCmd.Flags().StringVar(&ClickhouseUsername, "clickhouse-username", "", "ClickHouse user")
+	rootCmd.Flags().StringVar(&ClickhousePassword, "clickhouse-password", "", "ClickHouse password")
+	rootCmd.Flags().StringVar(&ClickhouseDatabase, "clickhouse-database", clickhouse.DefaultDatabase, "Database of the flow table")
+	rootCmd.Flags().StringVar(&ClickhouseTable, "clickhouse-table", clickhouse.DefaultTable, "Name of the flow table")
+	rootCmd.Flags().StringVar(&ClickhouseEventsTable, "clickhouse-events-table", clickhouse.DefaultEventsTable, "Name of the security event table")
+	rootCmd.Flags().IntVar(&ClickhouseBatchSize, "clickhouse-batch-size", clickhouse.DefaultBatchSize, "Number of rows per insert")
+	rootCmd.Flags().BoolVar(&Accounting, "accounting", false, "Accumulate bytes and packets per namespace in Redis for usage queries")
+	rootCmd.Flags().StringVar(&AlertRules, "alert-rules", "", "File with traffic alert rules (YAML or JSON)")
+	rootCmd.Flags().StringVar(&AlertWebhookURL, "alert-webhook-url", "", "Webhook alerts are posted to as JSON")
+	rootCmd.Flags().StringVar(&AlertmanagerURL, "alertmanager-url", "", "Alertmanager alerts are sent to, e.g. http://alertmanager:9093")
+	rootCmd.Flags().DurationVar(&AlertEvaluationInterval, "alert-evaluation-interval", alert.DefaultEvaluationInterval, "Interval between alert rule evaluations")
+	rootCmd.Flags().BoolVar(&Detect, "detect", false, "Detect port scans, SYN floods and UDP amplification and emit security events")
+	rootCmd.Flags().DurationVar(&DetectWindow, "detect-window", detect.DefaultWindow, "Time range packets are counted over by the detections")
+	rootCmd.Flags().DurationVar(&DetectCooldown, "detect-cooldown", detect.DefaultCooldown, "Minimum time between two events of the same type for the same source or destination")
+	rootCmd.Flags().IntVar(&DetectPortScanPorts, "detect-port-scan-ports", detect.DefaultPortScanPorts, "Number of distinct ports a source has to touch within the window to be reported as a port scan")
+	rootCmd.Flags().IntVar(&DetectPortScanHosts, "detect-port-scan-hosts", detect.DefaultPortScanHosts, "Number of distinct hosts a source has to touch within the window to be reported as a port scan")
+	rootCmd.Flags().IntVar(&DetectSYNFloodHalfOpen, "detect-syn-flood-half-open", detect.DefaultSYNFloodHalfOpen, "Number of half-open connections to a destination within the window to be reported as a SYN flood")
+	rootCmd.Flags().Int64Var(&DetectAmplificationBytes, "detect-amplification-bytes", detect.DefaultAmplificationBytes, "Minimum of reflected bytes within the window to be reported as UDP amplification")
+	rootCmd.Flags().Float64Var(&DetectAmplificationRatio, "detect-amplification-ratio", detect.DefaultAmplificationRatio, "Minimum ratio of reflected to requested bytes to be reported as UDP amplification")
+	rootCmd.Flags().StringToStringVar(&Blocklists, "blocklist", nil, "Threat intelligence lists to tag flows with, as name=[format:]path with format plain, csv or stix (repeatable)")
+	rootCmd.Flags().DurationVar(&BlocklistReloadInterval, "blocklist-reload-interval", blocklist.DefaultReloadInterval, "Interval between checks of the blocklist files for changes")
+	rootCmd.Flags().DurationVar(&BlocklistCooldown, "blocklist-cooldown", blocklist.DefaultCooldown, "Minimum time between two events for the same remote endpoint and namespace")
+	rootCmd.Flags().BoolVar(&Top, "top", true, "Maintain top talkers rankings served on /api/v1/top")
+	rootCmd.Flags().DurationSliceVar(&TopWindows, "top-windows", top.DefaultWindows, "Time ranges of the top talkers rankings")
+	rootCmd.Flags().IntVar(&TopCapacity, "top-capacity", top.DefaultCapacity, "Number of keys tracked per top talkers ranking and bucket, higher values are more accurate")
+	rootCmd.Flags().IntVar(&TopMetricsLimit, "top-metrics-limit", top.DefaultMetricsLimit, "Number of entries per top talkers ranking exported as gauges (0 disables the gauges)")
+	rootCmd.Flags().DurationVar(&ShutdownTimeout, "shutdown-timeout", sink.DefaultShutdownTimeout, "Time given to flush the open flows and drain the sinks on SIGTERM")
+	rootCmd.Flags().IntVar(&FlowsBuffer, "flows-buffer", flowapi.DefaultCapacity, "Number of recent flows served on /api/v1/flows (0 disables the flows API)")
+	rootCmd.Flags().DurationVar(&AccountingRollupInterval, "accounting-rollup-interval", accounting.DefaultRollupInterval, "Interval between rollups of hourly usage into daily buckets")
 }
 
 func Execute() {
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/alert"
+	"github.com/highscaleco/netlog/pkg/clickhouse"
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/elasticsearch"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/sink"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/spf13/pflag"
+)
+
+// configWatchInterval is how often the configuration file is checked for changes
+const configWatchInterval = 5 * time.Second
+
+// pipeline holds the parts of the flow processing that follow configuration
+// reloads: the filters, the alert rules and the sinks of the sinks section.
+// The other sinks are fixed for the lifetime of the process.
+type pipeline struct {
+	recorder *metrics.Recorder
+	static   []sink.Sink
+	alerts   *alert.Engine
+	out      *sink.Multi
+	filter   atomic.Pointer[config.Filter]
+	closed   sync.Once
+
+	mu    sync.Mutex
+	cfg   *config.Config
+	sinks []builtSink
+}
+
+// sinkSpec describes a sink of the sinks section
+type sinkSpec struct {
+	name string
+	// cfg holds the settings of the sink, the sink is rebuilt when they change
+	cfg   any
+	build func() (sink.Sink, error)
+	// batchSize is the batch size of network sinks, which are batched and
+	// spooled by the pipeline, and 0 for the others
+	batchSize int
+	spool     config.Spool
+}
+
+// builtSink is a sink created from a spec
+type builtSink struct {
+	name  string
+	cfg   any
+	spool config.Spool
+	sink  sink.Sink
+}
+
+// newPipeline creates a pipeline writing to the static sinks and the sinks
+// of cfg
+func newPipeline(cfg *config.Config, static []sink.Sink, alerts *alert.Engine, recorder *metrics.Recorder) (*pipeline, error) {
+	p := &pipeline{
+		recorder: recorder,
+		static:   static,
+		alerts:   alerts,
+		out:      sink.NewMulti(recorder, static...),
+	}
+	if err := p.apply(cfg); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Match reports whether a flow passes the filters
+func (p *pipeline) Match(flow types.AggregatedInfo) bool {
+	return p.filter.Load().Match(flow)
+}
+
+// Close closes every sink, flushing the buffered records. Only the first
+// call closes the sinks.
+func (p *pipeline) Close() error {
+	var err error
+	p.closed.Do(func() { err = p.out.Close() })
+	return err
+}
+
+// config returns the configuration applied last
+func (p *pipeline) config() *config.Config {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.cfg
+}
+
+// apply switches to the filters, alert rules and sinks of cfg. Sinks whose
+// settings are unchanged are kept. Changed sinks are built before the old
+// ones are swapped out and closed, and a sink that fails to build keeps its
+// old version. The spool of a changed sink is opened once the old version
+// closed it, as they share the spool directory.
+func (p *pipeline) apply(cfg *config.Config) error {
+	filter, err := config.NewFilter(cfg.Filters)
+	if err != nil {
+		return fmt.Errorf("invalid filters: %v", err)
+	}
+	rules, err := cfg.Alerts.LoadRules()
+	if err != nil {
+		return fmt.Errorf("failed to load alert rules: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	previous := make(map[string]builtSink, len(p.sinks))
+	for _, s := range p.sinks {
+		previous[s.name] = s
+	}
+	// unspooled are built sinks waiting for the spool of their old version
+	type unspooled struct {
+		built builtSink
+		spec  sinkSpec
+	}
+	var sinks, stale []builtSink
+	var waiting []unspooled
+	var errs []error
+	for _, spec := range sinkSpecs(cfg) {
+		old, exists := previous[spec.name]
+		delete(previous, spec.name)
+		if exists && reflect.DeepEqual(old.cfg, spec.cfg) {
+			sinks = append(sinks, old)
+			continue
+		}
+
+		built := builtSink{name: spec.name, cfg: spec.cfg, spool: spec.spool}
+		built.sink, err = spec.build()
+		if err == nil && spec.batchSize > 0 {
+			if exists && spec.spool.Dir != "" && spec.spool.Dir == old.spool.Dir {
+				waiting = append(waiting, unspooled{built: built, spec: spec})
+				stale = append(stale, old)
+				continue
+			}
+			built.sink, err = p.networkSink(built.sink, spec)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			if exists {
+				sinks = append(sinks, old)
+			}
+			continue
+		}
+		sinks = append(sinks, built)
+		if exists {
+			stale = append(stale, old)
+		}
+	}
+	for _, s := range previous {
+		stale = append(stale, s)
+	}
+
+	// Stop writing to the stale sinks before closing them
+	p.out.Swap(p.sinkList(sinks)...)
+	for _, s := range stale {
+		if err := s.sink.Close(); err != nil {
+			log.Printf("config: failed to close %s sink: %v", s.name, err)
+		}
+	}
+	if len(waiting) > 0 {
+		for _, w := range waiting {
+			s, err := p.networkSink(w.built.sink, w.spec)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			w.built.sink = s
+			sinks = append(sinks, w.built)
+		}
+		p.out.Swap(p.sinkList(sinks)...)
+	}
+	p.sinks = sinks
+
+	p.filter.Store(filter)
+	p.alerts.SetRules(context.Background(), rules, newNotifiers(cfg.Alerts))
+	p.cfg = cfg
+	return errors.Join(errs...)
+}
+
+// networkSink batches and spools s as configured by spec, s is closed when
+// the spool fails to open
+func (p *pipeline) networkSink(s sink.Sink, spec sinkSpec) (sink.Sink, error) {
+	ns, err := newNetworkSink(s, spec.batchSize, spec.spool, p.recorder)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return ns, nil
+}
+
+// sinkList returns the static sinks followed by built
+func (p *pipeline) sinkList(built []builtSink) []sink.Sink {
+	sinks := append([]sink.Sink(nil), p.static...)
+	for _, s := range built {
+		sinks = append(sinks, s.sink)
+	}
+	return sinks
+}
+
+// reload loads the configuration again and applies it. Invalid
+// configurations are rejected as a whole.
+func (p *pipeline) reload(filename string, flags *pflag.FlagSet) {
+	cfg, err := config.Load(filename, flags)
+	if err != nil {
+		p.recorder.ConfigReloaded(err)
+		log.Printf("config: keeping the current configuration, failed to reload %s: %v", filename, err)
+		return
+	}
+
+	p.mu.Lock()
+	sections := restartRequired(p.cfg, cfg)
+	p.mu.Unlock()
+	if len(sections) > 0 {
+		log.Printf("config: changes to %s are applied on restart", strings.Join(sections, ", "))
+	}
+
+	err = p.apply(cfg)
+	p.recorder.ConfigReloaded(err)
+	if err != nil {
+		log.Printf("config: failed to apply %s: %v", filename, err)
+		return
+	}
+	log.Printf("config: reloaded %s", filename)
+}
+
+// watch reloads the configuration file on SIGHUP and whenever it changes
+// until ctx is cancelled
+func (p *pipeline) watch(ctx context.Context, filename string, flags *pflag.FlagSet) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	last := statConfig(filename)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+			if statConfig(filename) == last {
+				continue
+			}
+		}
+		last = statConfig(filename)
+		p.reload(filename, flags)
+	}
+}
+
+// configStat identifies a version of the configuration file
+type configStat struct {
+	modTime time.Time
+	size    int64
+}
+
+// statConfig returns the version of the configuration file. Symlinks are
+// followed, so updates of mounted ConfigMaps are noticed.
+func statConfig(filename string) configStat {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return configStat{}
+	}
+	return configStat{modTime: info.ModTime(), size: info.Size()}
+}
+
+// restartRequired returns the sections that differ between old and updated
+// and are only applied on restart
+func restartRequired(old, updated *config.Config) []string {
+	sections := map[string][2]any{
+		"capture":    {old.Capture, updated.Capture},
+		"resolvers":  {old.Resolvers, updated.Resolvers},
+		"metrics":    {old.Metrics, updated.Metrics},
+		"otlp":       {old.OTLP, updated.OTLP},
+		"accounting": {old.Accounting, updated.Accounting},
+		"detect":     {old.Detect, updated.Detect},
+		"blocklists": {old.Blocklists, updated.Blocklists},
+		"top":        {old.Top, updated.Top},
+		"flows":      {old.Flows, updated.Flows},
+	}
+	var changed []string
+	for name, values := range sections {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// sinkSpecs returns the sinks of the sinks section of cfg
+func sinkSpecs(cfg *config.Config) []sinkSpec {
+	c := cfg.Sinks
+	specs := []sinkSpec{{
+		name: "stdout",
+		cfg:  c.Format,
+		build: func() (sink.Sink, error) {
+			return sink.NewWriter(os.Stdout, c.Format), nil
+		},
+	}}
+
+	if c.HTTP.URL != "" {
+		specs = append(specs, sinkSpec{
+			name: "http",
+			cfg:  [2]any{c.HTTP, c.Spool},
+			build: func() (sink.Sink, error) {
+				return sink.NewHTTP(c.HTTP.URL, sink.DefaultHTTPTimeout), nil
+			},
+			batchSize: sink.DefaultBatchSize,
+			spool:     c.Spool,
+		})
+	}
+
+	if len(c.Elasticsearch.URLs) > 0 {
+		specs = append(specs, sinkSpec{
+			name: "elasticsearch",
+			cfg:  [2]any{c.Elasticsearch, c.Spool},
+			build: func() (sink.Sink, error) {
+				es, err := elasticsearch.New(elasticsearch.Options{
+					URLs:               c.Elasticsearch.URLs,
+					Username:           c.Elasticsearch.Username,
+					Password:           c.Elasticsearch.Password,
+					APIKey:             c.Elasticsearch.APIKey,
+					IndexPrefix:        c.Elasticsearch.IndexPrefix,
+					BulkBytes:          c.Elasticsearch.BulkBytes,
+					MaxRetries:         c.Elasticsearch.MaxRetries,
+					InsecureSkipVerify: c.Elasticsearch.InsecureSkipVerify,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("failed to create elasticsearch sink: %v", err)
+				}
+				return es, nil
+			},
+			batchSize: c.Elasticsearch.BulkActions,
+			spool:     c.Spool,
+		})
+	}
+
+	if c.ClickHouse.URL != "" {
+		specs = append(specs, sinkSpec{
+			name: "clickhouse",
+			cfg:  [2]any{c.ClickHouse, c.Spool},
+			build: func() (sink.Sink, error) {
+				ch, err := clickhouse.New(clickhouse.Options{
+					URL:         c.ClickHouse.URL,
+					Username:    c.ClickHouse.Username,
+					Password:    c.ClickHouse.Password,
+					Database:    c.ClickHouse.Database,
+					Table:       c.ClickHouse.Table,
+					EventsTable: c.ClickHouse.EventsTable,
+				})
+				if err != nil {
+					return nil, fmt.Errorf("failed to create clickhouse sink: %v", err)
+				}
+				return ch, nil
+			},
+			batchSize: c.ClickHouse.BatchSize,
+			spool:     c.Spool,
+		})
+	}
+
+	return specs
+}
+
+// newNotifiers creates the receivers of the alerts
+func newNotifiers(cfg config.Alerts) []alert.Notifier {
+	var notifiers []alert.Notifier
+	if cfg.WebhookURL != "" {
+		notifiers = append(notifiers, alert.NewWebhook(cfg.WebhookURL, alert.DefaultNotifyTimeout))
+	}
+	if cfg.AlertmanagerURL != "" {
+		notifiers = append(notifiers, alert.NewAlertmanager(cfg.AlertmanagerURL, alert.DefaultNotifyTimeout))
+	}
+	return notifiers
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/alert"
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/sink"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipelineReload(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+	}
+	write("sinks:\n  http:\n    url: http://collector:8080\n")
+
+	cfg, err := config.Load(filename, nil)
+	require.NoError(t, err)
+	alerts := alert.NewEngine(nil, nil, time.Minute, nil)
+	p, err := newPipeline(cfg, []sink.Sink{alerts}, alerts, nil)
+	require.NoError(t, err)
+	defer p.Close()
+
+	sinks := func() map[string]sink.Sink {
+		m := make(map[string]sink.Sink)
+		for _, s := range p.sinks {
+			m[s.name] = s.sink
+		}
+		return m
+	}
+	before := sinks()
+	require.Len(t, before, 2)
+	assert.True(t, p.Match(types.AggregatedInfo{Namespace: "kube-system"}))
+
+	// Unchanged sinks are kept, changed ones are recreated
+	write(`
+filters:
+  excludeNamespaces: ["kube-*"]
+sinks:
+  format: json
+  http:
+    url: http://collector:8080
+alerts:
+  rules:
+  - name: egress
+    maxBytes: 1Gi
+`)
+	p.reload(filename, nil)
+	after := sinks()
+	require.Len(t, after, 2)
+	assert.Same(t, before["http"], after["http"])
+	assert.NotSame(t, before["stdout"], after["stdout"])
+	assert.False(t, p.Match(types.AggregatedInfo{Namespace: "kube-system"}))
+	assert.Equal(t, "json", p.cfg.Sinks.Format)
+
+	// Invalid configurations are rejected as a whole
+	write("sinks:\n  format: xml\nfilters:\n  excludeNamespaces: []\n")
+	p.reload(filename, nil)
+	assert.Equal(t, "json", p.cfg.Sinks.Format)
+	assert.False(t, p.Match(types.AggregatedInfo{Namespace: "kube-system"}))
+
+	// A sink that fails to build keeps its old version
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0o644))
+	write("sinks:\n  format: json\n  http:\n    url: http://collector:9090\n  spool:\n    dir: " + file + "\n")
+	p.reload(filename, nil)
+	assert.Same(t, after["http"], sinks()["http"])
+
+	// A changed sink takes over the spool of its old version
+	dir := t.TempDir()
+	write("sinks:\n  format: json\n  http:\n    url: http://collector:8080\n  spool:\n    dir: " + dir + "\n")
+	p.reload(filename, nil)
+	spooled := sinks()["http"]
+	write("sinks:\n  format: json\n  http:\n    url: http://collector:9090\n  spool:\n    dir: " + dir + "\n")
+	p.reload(filename, nil)
+	require.Len(t, sinks(), 2)
+	assert.NotSame(t, spooled, sinks()["http"])
+	assert.Equal(t, "http://collector:9090", p.cfg.Sinks.HTTP.URL)
+
+	// Removed sinks are closed and dropped
+	write("sinks:\n  format: json\n")
+	p.reload(filename, nil)
+	assert.Len(t, sinks(), 1)
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := config.Default()
+	updated := config.Default()
+	updated.Sinks.Format = "json"
+	updated.Filters.Namespaces = []string{"default"}
+	assert.Empty(t, restartRequired(old, updated))
+
+	updated.Capture.Interface = "eth1"
+	updated.Top.Capacity = 10
+	assert.Equal(t, []string{"capture", "top"}, restartRequired(old, updated))
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/highscaleco/netlog/pkg/clickhouse"
+	"github.com/highscaleco/netlog/pkg/elasticsearch"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print storage schemas for the flow sinks",
+}
+
+var schemaElasticsearchCmd = &cobra.Command{
+	Use:   "elasticsearch",
+	Short: "Print the index template for the Elasticsearch/OpenSearch sink",
+	Long: `Print a composable index template for the daily indices written by the
+Elasticsearch/OpenSearch sink. Install it before the first flows are indexed:
+
+  netlog schema elasticsearch | curl -XPUT -H 'Content-Type: application/json' \
+    http://localhost:9200/_index_template/netlog-flows -d @-`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		template, err := elasticsearch.IndexTemplate(ESIndexPrefix)
+		if err != nil {
+			return fmt.Errorf("failed to generate index template: %v", err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(template))
+		return nil
+	},
+}
+
+// clickhouseTTLDays specifies the retention of the generated tables
+var clickhouseTTLDays = 0
+
+var schemaClickhouseCmd = &cobra.Command{
+	Use:   "clickhouse",
+	Short: "Print the table DDL for the ClickHouse sink",
+	Long: `Print the recommended DDL of the flow and event tables written by the
+ClickHouse sink. The tables are partitioned by day and ordered by namespace and
+time:
+
+  netlog schema clickhouse --clickhouse-ttl-days 365 | clickhouse-client --multiquery`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Fprint(cmd.OutOrStdout(), clickhouse.Schema(clickhouse.SchemaOptions{
+			Database:    ClickhouseDatabase,
+			Table:       ClickhouseTable,
+			EventsTable: ClickhouseEventsTable,
+			TTLDays:     clickhouseTTLDays,
+		}))
+		return nil
+	},
+}
+
+func init() {
+	schemaElasticsearchCmd.Flags().StringVar(&ESIndexPrefix, "es-index-prefix", elasticsearch.DefaultIndexPrefix, "Prefix of the daily indices")
+	schemaClickhouseCmd.Flags().StringVar(&ClickhouseDatabase, "clickhouse-database", clickhouse.DefaultDatabase, "Database of the flow table")
+	schemaClickhouseCmd.Flags().StringVar(&ClickhouseTable, "clickhouse-table", clickhouse.DefaultTable, "Name of the flow table")
+	schemaClickhouseCmd.Flags().StringVar(&ClickhouseEventsTable, "clickhouse-events-table", clickhouse.DefaultEventsTable, "Name of the security event table")
+	schemaClickhouseCmd.Flags().IntVar(&clickhouseTTLDays, "clickhouse-ttl-days", 0, "Drop flows and events older than the given number of days (0 keeps them forever)")
+	schemaCmd.AddCommand(schemaElasticsearchCmd)
+	schemaCmd.AddCommand(schemaClickhouseCmd)
+	rootCmd.AddCommand(schemaCmd)
+}
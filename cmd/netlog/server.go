@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// servers holds the handlers of the HTTP servers by address
+type servers map[string]*http.ServeMux
+
+// handle registers handler for pattern on the server of addr, nothing is
+// registered if addr is empty
+func (s servers) handle(addr, pattern string, handler http.Handler) {
+	if addr == "" {
+		return
+	}
+	mux, ok := s[addr]
+	if !ok {
+		mux = http.NewServeMux()
+		s[addr] = mux
+	}
+	mux.Handle(pattern, handler)
+}
+
+// handlePprof registers the profiling handlers on the server of addr
+func (s servers) handlePprof(addr string) {
+	s.handle(addr, "/debug/pprof/", http.HandlerFunc(pprof.Index))
+	s.handle(addr, "/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))
+	s.handle(addr, "/debug/pprof/profile", http.HandlerFunc(pprof.Profile))
+	s.handle(addr, "/debug/pprof/symbol", http.HandlerFunc(pprof.Symbol))
+	s.handle(addr, "/debug/pprof/trace", http.HandlerFunc(pprof.Trace))
+}
+
+// start serves every address in the background. The contexts of the
+// requests are cancelled with ctx.
+func (s servers) start(ctx context.Context) []*http.Server {
+	var started []*http.Server
+	for addr, mux := range s {
+		srv := &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+			BaseContext:       func(net.Listener) context.Context { return ctx },
+		}
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fmt.Printf("Error starting server on %s: %v\n", srv.Addr, err)
+			}
+		}()
+		started = append(started, srv)
+	}
+	return started
+}
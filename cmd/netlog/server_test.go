@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServers(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	srv := servers{}
+	srv.handle(":9090", "/metrics", ok)
+	srv.handle(":8080", "/healthz", ok)
+	srv.handle("", "/readyz", ok)
+	srv.handlePprof("")
+	require.Len(t, srv, 2)
+
+	status := func(addr, path string) int {
+		rec := httptest.NewRecorder()
+		srv[addr].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, status(":9090", "/metrics"))
+	assert.Equal(t, http.StatusNotFound, status(":9090", "/healthz"))
+	assert.Equal(t, http.StatusNotFound, status(":9090", "/debug/pprof/"))
+
+	// Profiling is opt-in and may share a server
+	srv.handlePprof(":9090")
+	require.Len(t, srv, 2)
+	assert.Equal(t, http.StatusOK, status(":9090", "/debug/pprof/"))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// stopper is the part of the capture stopped on shutdown
+type stopper interface {
+	Stop()
+}
+
+// shutdown stops the capture, which emits the open flows as final records,
+// waits until processed is closed once they are written and closes the
+// sinks, flushing their buffers. The background jobs are cancelled before
+// the sinks are closed and the servers are shut down last. Steps that don't
+// complete within timeout are abandoned.
+func shutdown(capture stopper, processed <-chan struct{}, cancel context.CancelFunc, pipe *pipeline, servers []*http.Server, timeout time.Duration) error {
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), timeout)
+	defer cancelTimeout()
+	defer cancel()
+
+	var errs []error
+	wait := func(done <-chan struct{}, step string) {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("timed out waiting for %s", step))
+		}
+	}
+
+	log.Printf("shutdown: stopping capture")
+	stopped := make(chan struct{})
+	go func() {
+		capture.Stop()
+		close(stopped)
+	}()
+	wait(stopped, "the capture to stop")
+	wait(processed, "the flows to be processed")
+
+	// Stop the background jobs and abort writes still in progress
+	cancel()
+
+	log.Printf("shutdown: draining sinks")
+	closed := make(chan struct{})
+	go func() {
+		if err := pipe.Close(); err != nil {
+			log.Printf("shutdown: failed to close sinks: %v", err)
+		}
+		close(closed)
+	}()
+	wait(closed, "the sinks to be drained")
+
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+			errs = append(errs, fmt.Errorf("failed to shut down server on %s: %w", srv.Addr, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/alert"
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/sink"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stopFunc stops a fake capture
+type stopFunc func()
+
+func (f stopFunc) Stop() { f() }
+
+// recordingSink keeps the flows written to it
+type recordingSink struct {
+	mu    sync.Mutex
+	flows []types.AggregatedInfo
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flows = append(s.flows, flows...)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+func TestShutdown(t *testing.T) {
+	newPipe := func(s sink.Sink) *pipeline {
+		alerts := alert.NewEngine(nil, nil, time.Minute, nil)
+		p, err := newPipeline(config.Default(), []sink.Sink{alerts, s}, alerts, nil)
+		require.NoError(t, err)
+		return p
+	}
+
+	// The open flows emitted on stop end up in the sinks
+	recording := &recordingSink{}
+	batcher := sink.NewBatcher(recording, 100, time.Hour, nil)
+	pipe := newPipe(batcher)
+	ctx, cancel := context.WithCancel(context.Background())
+	packets := make(chan types.AggregatedInfo, 1)
+	processed := make(chan struct{})
+	go func() {
+		defer close(processed)
+		for flow := range packets {
+			assert.NoError(t, pipe.out.Write(ctx, []types.AggregatedInfo{flow}))
+		}
+	}()
+	capture := stopFunc(func() {
+		packets <- types.AggregatedInfo{Namespace: "default"}
+		close(packets)
+	})
+
+	require.NoError(t, shutdown(capture, processed, cancel, pipe, nil, time.Second))
+	assert.Len(t, recording.flows, 1)
+	assert.Error(t, ctx.Err())
+
+	// Steps that don't complete are abandoned after the timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	start := time.Now()
+	err := shutdown(stopFunc(func() {}), make(chan struct{}), cancel, newPipe(&recordingSink{}), nil, 50*time.Millisecond)
+	assert.ErrorContains(t, err, "timed out waiting for the flows to be processed")
+	assert.Error(t, ctx.Err())
+	assert.Less(t, time.Since(start), time.Second)
+}
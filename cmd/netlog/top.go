@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/highscaleco/netlog/pkg/top"
+	"github.com/highscaleco/netlog/pkg/tui"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// topAttach specifies the netlog instance to attach to
+	topAttach = ""
+	// topInterface specifies the interface of the local capture
+	topInterface = "eth0"
+	// topWindow specifies the time range of the table
+	topWindow time.Duration
+	// topRefresh specifies the interval between screen updates
+	topRefresh = tui.DefaultRefresh
+	// topView specifies the initial view
+	topView = tui.ViewFlows
+	// topSort specifies the initial sort order
+	topSort = tui.SortBytes
+	// topFilter specifies the initial filter
+	topFilter = ""
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show a live table of the current traffic",
+	Long: `Show a live table of the current flows and the traffic by namespace, owner,
+remote IP and port. By default packets are captured locally, which needs the
+same privileges as netlog itself. With --attach the top talkers rankings of a
+running netlog instance are shown instead, starting with the owner view:
+
+  netlog top --interface eth0
+  netlog top --attach http://node-1:9090 --view remote
+
+Keys: q quit, space pause, tab or 1-5 switch view, s cycle the sort order
+(b bytes, k packets, r rate), / filter, esc clear the filter.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		var source tui.Source
+		if topAttach != "" {
+			if topView == tui.ViewFlows && !cmd.Flags().Changed("view") {
+				topView = top.DimensionOwner
+			}
+			source = tui.NewRemoteSource(topAttach, topWindow, 0)
+		} else {
+			capture, err := newCapture(topInterface)
+			if err != nil {
+				return err
+			}
+			resolver, err := newTopResolver(ctx, cmd)
+			if err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "Flows are not attributed to workloads: %v\n", err)
+			} else {
+				capture.SetResolver(resolver)
+			}
+			if err := capture.Start(ctx); err != nil {
+				return fmt.Errorf("failed to start capture: %v", err)
+			}
+			defer capture.Stop()
+
+			local := tui.NewLocalSource("capture on "+topInterface, topWindow)
+			go local.Consume(ctx, capture.Packets())
+			source = local
+		}
+
+		model, err := tui.NewModel(source.Name(), topView, topSort, topFilter)
+		if err != nil {
+			return err
+		}
+
+		// Log output would tear the screen apart
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+
+		return tui.Run(ctx, model, source, os.Stdin, os.Stdout, topRefresh)
+	},
+}
+
+// newTopResolver creates the resolver of the local capture from the
+// configuration file and the connection flags
+func newTopResolver(ctx context.Context, cmd *cobra.Command) (types.Resolver, error) {
+	cfg, err := config.Load(ConfigFile, cmd.Flags())
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+	cache, err := newRedis(cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	kube, err := newKubernetes(cfg, nil)
+	if err != nil {
+		cache.Close()
+		return nil, err
+	}
+	resolver, _, err := newResolver(ctx, cfg, cache, kube, nil)
+	if err != nil {
+		cache.Close()
+		return nil, err
+	}
+	return resolver, nil
+}
+
+func init() {
+	topCmd.Flags().StringVar(&topAttach, "attach", "", "Metrics address of a running netlog instance to show, e.g. http://node-1:9090")
+	topCmd.Flags().StringVarP(&topInterface, "interface", "i", "eth0", "Network interface to capture from when not attached")
+	topCmd.Flags().DurationVar(&topWindow, "window", 0, "Time range of the table (default 1m for a local capture, the shortest window of an attached instance)")
+	topCmd.Flags().DurationVar(&topRefresh, "refresh", tui.DefaultRefresh, "Interval between screen updates")
+	topCmd.Flags().StringVar(&topView, "view", tui.ViewFlows, "Initial view (flows, namespace, owner, remote or port)")
+	topCmd.Flags().StringVar(&topSort, "sort", tui.SortBytes, "Initial sort order (bytes, packets or rate)")
+	topCmd.Flags().StringVar(&topFilter, "filter", "", "Only show rows containing this text")
+	rootCmd.AddCommand(topCmd)
+}
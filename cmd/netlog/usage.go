@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/accounting"
+	"github.com/highscaleco/netlog/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// usageNamespace specifies the namespace to report usage for
+	usageNamespace = ""
+	// usageFrom specifies the start of the reported period
+	usageFrom = ""
+	// usageTo specifies the end of the reported period
+	usageTo = ""
+	// usageJSON prints the usage as JSON
+	usageJSON = false
+)
+
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Print the traffic accounted to a namespace",
+	Long: `Print the bytes and packets accounted to a namespace with --accounting,
+per owner, direction and traffic zone. The period defaults to the current
+month. Times are RFC 3339 timestamps or dates, both in UTC:
+
+  netlog usage --namespace default --from 2024-02-01 --to 2024-03-01`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if usageNamespace == "" {
+			return fmt.Errorf("--namespace is required")
+		}
+
+		now := time.Now().UTC()
+		from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		to := now
+		var err error
+		if usageFrom != "" {
+			if from, err = parseUsageTime(usageFrom); err != nil {
+				return err
+			}
+		}
+		if usageTo != "" {
+			if to, err = parseUsageTime(usageTo); err != nil {
+				return err
+			}
+		}
+
+		cfg, err := config.Load(ConfigFile, cmd.Flags())
+		if err != nil {
+			return fmt.Errorf("invalid configuration: %v", err)
+		}
+		cache, err := newRedis(cfg, nil)
+		if err != nil {
+			return err
+		}
+		defer cache.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		usage, err := accounting.New(cache.Redis(), accounting.Options{}).Usage(ctx, usageNamespace, from, to)
+		if err != nil {
+			return err
+		}
+
+		if usageJSON {
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			return enc.Encode(usage)
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "OWNER\tDIRECTION\tZONE\tBYTES\tPACKETS")
+		var bytes, packets int64
+		for _, u := range usage {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", u.Owner, u.Direction, u.Zone, u.Bytes, u.Packets)
+			bytes += u.Bytes
+			packets += u.Packets
+		}
+		fmt.Fprintf(w, "TOTAL\t\t\t%d\t%d\n", bytes, packets)
+		return w.Flush()
+	},
+}
+
+// parseUsageTime parses an RFC 3339 timestamp or a date
+func parseUsageTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or YYYY-MM-DD", value)
+	}
+	return t, nil
+}
+
+func init() {
+	usageCmd.Flags().StringVarP(&usageNamespace, "namespace", "n", "", "Namespace to report usage for")
+	usageCmd.Flags().StringVar(&usageFrom, "from", "", "Start of the period (default: start of the current month)")
+	usageCmd.Flags().StringVar(&usageTo, "to", "", "End of the period (default: now)")
+	usageCmd.Flags().BoolVar(&usageJSON, "json", false, "Print the usage as JSON")
+	rootCmd.AddCommand(usageCmd)
+}
@@ -0,0 +1,394 @@
+package accounting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// ZonePublic is the traffic zone of flows whose remote endpoint is a public IP
+	ZonePublic = "public"
+	// ZonePrivate is the traffic zone of flows whose remote endpoint is a private IP
+	ZonePrivate = "private"
+
+	// DefaultKeyPrefix is the default prefix of all accounting keys
+	DefaultKeyPrefix = "netlog:usage"
+	// DefaultHourRetention is the default time hourly buckets are kept
+	DefaultHourRetention = 14 * 24 * time.Hour
+	// DefaultDayRetention is the default time daily buckets are kept
+	DefaultDayRetention = 400 * 24 * time.Hour
+	// DefaultRollupInterval is the default interval of the rollup job
+	DefaultRollupInterval = 15 * time.Minute
+
+	hourLayout = "2006010215"
+	dayLayout  = "20060102"
+)
+
+// Options configures the accountant
+type Options struct {
+	// KeyPrefix is the prefix of all keys written to Redis
+	KeyPrefix string
+	// HourRetention is the time hourly buckets are kept
+	HourRetention time.Duration
+	// DayRetention is the time daily buckets are kept
+	DayRetention time.Duration
+}
+
+// Usage is the traffic of a namespace for an owner, direction and zone
+type Usage struct {
+	Namespace string `json:"namespace"`
+	Owner     string `json:"owner"`
+	Direction string `json:"direction"`
+	Zone      string `json:"zone"`
+	Bytes     int64  `json:"bytes"`
+	Packets   int64  `json:"packets"`
+}
+
+// Accountant accumulates bytes and packets per namespace in Redis hashes
+// bucketed by hour, which the rollup job sums into daily buckets. Unlike
+// Prometheus counters the totals survive restarts of netlog.
+//
+// Every bucket is a hash named <prefix>:<hour|day>:<bucket>:<namespace> with
+// the fields <owner>|<direction>|<zone>|bytes and <owner>|<direction>|<zone>|packets.
+// The namespaces with traffic are kept in the set <prefix>:namespaces and the
+// last day rolled up in <prefix>:rollup.
+type Accountant struct {
+	client redis.Cmdable
+	opts   Options
+}
+
+// New creates an accountant writing to client
+func New(client redis.Cmdable, opts Options) *Accountant {
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = DefaultKeyPrefix
+	}
+	if opts.HourRetention <= 0 {
+		opts.HourRetention = DefaultHourRetention
+	}
+	if opts.DayRetention <= 0 {
+		opts.DayRetention = DefaultDayRetention
+	}
+	return &Accountant{client: client, opts: opts}
+}
+
+// Name returns the name of the sink
+func (a *Accountant) Name() string {
+	return "accounting"
+}
+
+// Write adds the traffic of the flows to their hourly buckets. All
+// increments of a batch are applied in a single transaction.
+func (a *Accountant) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	increments := aggregate(flows)
+	if len(increments) == 0 {
+		return nil
+	}
+
+	_, err := a.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		namespaces := make(map[string]bool)
+		for key, inc := range increments {
+			hashKey := a.hourKey(key.hour, key.namespace)
+			field := usageField(key.owner, key.direction, key.zone)
+			pipe.HIncrBy(ctx, hashKey, field+"|bytes", inc.bytes)
+			pipe.HIncrBy(ctx, hashKey, field+"|packets", inc.packets)
+			pipe.Expire(ctx, hashKey, a.opts.HourRetention)
+			namespaces[key.namespace] = true
+		}
+		for namespace := range namespaces {
+			pipe.SAdd(ctx, a.namespacesKey(), namespace)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update usage: %w", err)
+	}
+	return nil
+}
+
+// Close does nothing, the Redis client is owned by the caller
+func (a *Accountant) Close() error {
+	return nil
+}
+
+// Rollup sums the hourly buckets of the day containing t into its daily
+// bucket for every namespace. Rolling up a day again overwrites the daily
+// bucket, so the job can run repeatedly while the day is in progress.
+func (a *Accountant) Rollup(ctx context.Context, t time.Time) error {
+	namespaces, err := a.client.SMembers(ctx, a.namespacesKey()).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list namespaces: %w", err)
+	}
+
+	day := t.UTC().Truncate(24 * time.Hour)
+	for _, namespace := range namespaces {
+		totals := make(map[string]int64)
+		for hour := day; hour.Before(day.Add(24 * time.Hour)); hour = hour.Add(time.Hour) {
+			fields, err := a.client.HGetAll(ctx, a.hourKey(hour, namespace)).Result()
+			if err != nil {
+				return fmt.Errorf("failed to read hourly usage: %w", err)
+			}
+			addFields(totals, fields)
+		}
+		if len(totals) == 0 {
+			continue
+		}
+
+		values := make(map[string]interface{}, len(totals))
+		for field, n := range totals {
+			values[field] = n
+		}
+		dayKey := a.dayKey(day, namespace)
+		_, err := a.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, dayKey)
+			pipe.HSet(ctx, dayKey, values)
+			pipe.Expire(ctx, dayKey, a.opts.DayRetention)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write daily usage: %w", err)
+		}
+	}
+	return nil
+}
+
+// RollupPending rolls up every day from the last one rolled up through the
+// day of now and records the progress in Redis, so the days missed while
+// netlog was not running are caught up. Without a record it starts with the
+// oldest hourly buckets kept.
+func (a *Accountant) RollupPending(ctx context.Context, now time.Time) error {
+	today := now.UTC().Truncate(24 * time.Hour)
+	day := now.Add(-a.opts.HourRetention).UTC().Truncate(24 * time.Hour)
+	last, err := a.client.Get(ctx, a.rollupKey()).Result()
+	switch {
+	case err == nil:
+		if t, err := time.Parse(dayLayout, last); err == nil && t.After(day) {
+			day = t
+		}
+	case !errors.Is(err, redis.Nil):
+		return fmt.Errorf("failed to read last rollup: %w", err)
+	}
+
+	for ; !day.After(today); day = day.Add(24 * time.Hour) {
+		if err := a.Rollup(ctx, day); err != nil {
+			return err
+		}
+		// The day is rolled up again next time, it may still be in progress
+		if err := a.client.Set(ctx, a.rollupKey(), day.Format(dayLayout), 0).Err(); err != nil {
+			return fmt.Errorf("failed to record rollup: %w", err)
+		}
+	}
+	return nil
+}
+
+// RunRollup rolls up the pending days right away and then every interval
+// until ctx is cancelled
+func (a *Accountant) RunRollup(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRollupInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.RollupPending(ctx, time.Now()); err != nil {
+			log.Printf("accounting: rollup failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Usage returns the traffic of namespace between from and to. Whole days
+// are read from the daily buckets when they were rolled up, the remainder
+// from the hourly buckets. from is rounded down and to up to the full hour.
+func (a *Accountant) Usage(ctx context.Context, namespace string, from, to time.Time) ([]Usage, error) {
+	totals := make(map[string]int64)
+	for _, b := range planBuckets(from, to) {
+		key := a.hourKey(b.start, namespace)
+		if b.day {
+			key = a.dayKey(b.start, namespace)
+		}
+		fields, err := a.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read usage: %w", err)
+		}
+
+		if b.day && len(fields) == 0 {
+			// The day was not rolled up, fall back to its hours
+			for hour := b.start; hour.Before(b.start.Add(24 * time.Hour)); hour = hour.Add(time.Hour) {
+				hourFields, err := a.client.HGetAll(ctx, a.hourKey(hour, namespace)).Result()
+				if err != nil {
+					return nil, fmt.Errorf("failed to read usage: %w", err)
+				}
+				addFields(totals, hourFields)
+			}
+			continue
+		}
+		addFields(totals, fields)
+	}
+	return parseUsage(namespace, totals), nil
+}
+
+// bucket is a time range read from a single hash
+type bucket struct {
+	start time.Time
+	day   bool
+}
+
+// planBuckets splits [from, to) into daily buckets for whole days and hourly
+// buckets for the rest
+func planBuckets(from, to time.Time) []bucket {
+	var buckets []bucket
+	t := from.UTC().Truncate(time.Hour)
+	end := to.UTC().Truncate(time.Hour)
+	if end.Before(to) {
+		end = end.Add(time.Hour)
+	}
+	for t.Before(end) {
+		if t.Equal(t.Truncate(24*time.Hour)) && !t.Add(24*time.Hour).After(end) {
+			buckets = append(buckets, bucket{start: t, day: true})
+			t = t.Add(24 * time.Hour)
+			continue
+		}
+		buckets = append(buckets, bucket{start: t})
+		t = t.Add(time.Hour)
+	}
+	return buckets
+}
+
+func (a *Accountant) hourKey(t time.Time, namespace string) string {
+	return a.opts.KeyPrefix + ":hour:" + t.UTC().Format(hourLayout) + ":" + namespace
+}
+
+func (a *Accountant) dayKey(t time.Time, namespace string) string {
+	return a.opts.KeyPrefix + ":day:" + t.UTC().Format(dayLayout) + ":" + namespace
+}
+
+func (a *Accountant) namespacesKey() string {
+	return a.opts.KeyPrefix + ":namespaces"
+}
+
+func (a *Accountant) rollupKey() string {
+	return a.opts.KeyPrefix + ":rollup"
+}
+
+// incrementKey identifies the hash field a flow is accounted to
+type incrementKey struct {
+	hour      time.Time
+	namespace string
+	owner     string
+	direction string
+	zone      string
+}
+
+type increment struct {
+	bytes   int64
+	packets int64
+}
+
+// aggregate sums the flows per hourly bucket and field. Flows without a
+// namespace are skipped.
+func aggregate(flows []types.AggregatedInfo) map[incrementKey]*increment {
+	increments := make(map[incrementKey]*increment)
+	for _, flow := range flows {
+		if flow.Namespace == "" {
+			continue
+		}
+		key := incrementKey{
+			hour:      flow.StartTime.UTC().Truncate(time.Hour),
+			namespace: flow.Namespace,
+			owner:     flow.Name,
+			direction: flow.Direction,
+			zone:      Zone(flow),
+		}
+		inc, ok := increments[key]
+		if !ok {
+			inc = &increment{}
+			increments[key] = inc
+		}
+		inc.bytes += flow.TotalBytes
+		inc.packets += flow.Packets
+	}
+	return increments
+}
+
+// Zone returns the traffic zone of the remote endpoint of a flow, which is
+// the destination of outbound and the source of inbound flows
+func Zone(flow types.AggregatedInfo) string {
+	remote := flow.Destination
+	if flow.Direction == "inbound" {
+		remote = flow.Source
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return ZonePrivate
+	}
+	return ZonePublic
+}
+
+func usageField(owner, direction, zone string) string {
+	return owner + "|" + direction + "|" + zone
+}
+
+// addFields adds the counters of a bucket to totals
+func addFields(totals map[string]int64, fields map[string]string) {
+	for field, value := range fields {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		totals[field] += n
+	}
+}
+
+// parseUsage converts summed hash fields into usage records sorted by
+// owner, direction and zone
+func parseUsage(namespace string, totals map[string]int64) []Usage {
+	records := make(map[string]*Usage)
+	for field, n := range totals {
+		parts := strings.Split(field, "|")
+		if len(parts) != 4 {
+			continue
+		}
+		key := strings.Join(parts[:3], "|")
+		u, ok := records[key]
+		if !ok {
+			u = &Usage{Namespace: namespace, Owner: parts[0], Direction: parts[1], Zone: parts[2]}
+			records[key] = u
+		}
+		switch parts[3] {
+		case "bytes":
+			u.Bytes += n
+		case "packets":
+			u.Packets += n
+		}
+	}
+
+	usage := make([]Usage, 0, len(records))
+	for _, u := range records {
+		usage = append(usage, *u)
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Owner != usage[j].Owner {
+			return usage[i].Owner < usage[j].Owner
+		}
+		if usage[i].Direction != usage[j].Direction {
+			return usage[i].Direction < usage[j].Direction
+		}
+		return usage[i].Zone < usage[j].Zone
+	})
+	return usage
+}
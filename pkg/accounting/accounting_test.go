@@ -0,0 +1,168 @@
+package accounting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZone(t *testing.T) {
+	assert.Equal(t, ZonePublic, Zone(types.AggregatedInfo{Direction: "outbound", Source: "10.0.0.1", Destination: "8.8.8.8"}))
+	assert.Equal(t, ZonePrivate, Zone(types.AggregatedInfo{Direction: "outbound", Source: "8.8.8.8", Destination: "10.0.0.1"}))
+	assert.Equal(t, ZonePublic, Zone(types.AggregatedInfo{Direction: "inbound", Source: "1.1.1.1", Destination: "10.0.0.1"}))
+	assert.Equal(t, ZonePrivate, Zone(types.AggregatedInfo{Direction: "inbound", Source: "192.168.1.1", Destination: "8.8.8.8"}))
+}
+
+func TestAggregate(t *testing.T) {
+	start := time.Date(2024, 2, 14, 12, 30, 0, 0, time.UTC)
+	flows := []types.AggregatedInfo{
+		{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "8.8.8.8", StartTime: start, TotalBytes: 100, Packets: 2},
+		{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "1.1.1.1", StartTime: start.Add(10 * time.Minute), TotalBytes: 50, Packets: 1},
+		{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "8.8.8.8", StartTime: start.Add(time.Hour), TotalBytes: 10, Packets: 1},
+		{Namespace: "", Name: "", Direction: "", TotalBytes: 1000, Packets: 10},
+	}
+
+	increments := aggregate(flows)
+	assert.Len(t, increments, 2)
+
+	key := incrementKey{hour: start.Truncate(time.Hour), namespace: "default", owner: "nginx", direction: "outbound", zone: ZonePublic}
+	assert.Equal(t, &increment{bytes: 150, packets: 3}, increments[key])
+}
+
+func TestPlanBuckets(t *testing.T) {
+	from := time.Date(2024, 2, 13, 22, 15, 0, 0, time.UTC)
+	to := time.Date(2024, 2, 15, 1, 30, 0, 0, time.UTC)
+
+	buckets := planBuckets(from, to)
+	assert.Equal(t, []bucket{
+		{start: time.Date(2024, 2, 13, 22, 0, 0, 0, time.UTC)},
+		{start: time.Date(2024, 2, 13, 23, 0, 0, 0, time.UTC)},
+		{start: time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC), day: true},
+		{start: time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)},
+		{start: time.Date(2024, 2, 15, 1, 0, 0, 0, time.UTC)},
+	}, buckets)
+
+	assert.Empty(t, planBuckets(to, from))
+}
+
+func TestParseUsage(t *testing.T) {
+	usage := parseUsage("default", map[string]int64{
+		"nginx|outbound|public|bytes":   100,
+		"nginx|outbound|public|packets": 2,
+		"api|inbound|private|bytes":     10,
+		"api|inbound|private|packets":   1,
+		"invalid":                       5,
+	})
+	assert.Equal(t, []Usage{
+		{Namespace: "default", Owner: "api", Direction: "inbound", Zone: ZonePrivate, Bytes: 10, Packets: 1},
+		{Namespace: "default", Owner: "nginx", Direction: "outbound", Zone: ZonePublic, Bytes: 100, Packets: 2},
+	}, usage)
+}
+
+func TestKeys(t *testing.T) {
+	a := New(nil, Options{})
+	ts := time.Date(2024, 2, 14, 12, 30, 0, 0, time.UTC)
+	assert.Equal(t, "netlog:usage:hour:2024021412:default", a.hourKey(ts, "default"))
+	assert.Equal(t, "netlog:usage:day:20240214:default", a.dayKey(ts, "default"))
+	assert.Equal(t, "netlog:usage:namespaces", a.namespacesKey())
+}
+
+func newTestAccountant(t *testing.T) (*Accountant, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return New(client, Options{}), server
+}
+
+func TestWrite(t *testing.T) {
+	a, server := newTestAccountant(t)
+	ctx := context.Background()
+	start := time.Date(2024, 2, 14, 12, 30, 0, 0, time.UTC)
+	require.NoError(t, a.Write(ctx, []types.AggregatedInfo{
+		{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "8.8.8.8", StartTime: start, TotalBytes: 100, Packets: 2},
+		{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "8.8.8.8", StartTime: start, TotalBytes: 50, Packets: 1},
+		{Namespace: "kube-system", Name: "dns", Direction: "inbound", Source: "10.0.0.5", StartTime: start, TotalBytes: 10, Packets: 1},
+		{TotalBytes: 1000, Packets: 10},
+	}))
+	require.NoError(t, a.Write(ctx, nil))
+
+	key := "netlog:usage:hour:2024021412:default"
+	assert.Equal(t, "150", server.HGet(key, "nginx|outbound|public|bytes"))
+	assert.Equal(t, "3", server.HGet(key, "nginx|outbound|public|packets"))
+	assert.Equal(t, DefaultHourRetention, server.TTL(key))
+	members, err := server.SMembers("netlog:usage:namespaces")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default", "kube-system"}, members)
+
+	server.SetError("LOADING")
+	assert.Error(t, a.Write(ctx, []types.AggregatedInfo{{Namespace: "default", Name: "nginx", StartTime: start, TotalBytes: 1}}))
+}
+
+func TestRollup(t *testing.T) {
+	a, server := newTestAccountant(t)
+	ctx := context.Background()
+	day := time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC)
+	flow := types.AggregatedInfo{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "8.8.8.8", TotalBytes: 100, Packets: 1}
+	var flows []types.AggregatedInfo
+	for _, offset := range []time.Duration{-time.Hour, time.Hour, 5 * time.Hour, 23 * time.Hour, 49 * time.Hour} {
+		flow.StartTime = day.Add(offset)
+		flows = append(flows, flow)
+	}
+	require.NoError(t, a.Write(ctx, flows))
+
+	// Only the hours of the day are summed, rolling up again overwrites
+	require.NoError(t, a.Rollup(ctx, day.Add(12*time.Hour)))
+	require.NoError(t, a.Rollup(ctx, day))
+	assert.Equal(t, "300", server.HGet("netlog:usage:day:20240214:default", "nginx|outbound|public|bytes"))
+	assert.Equal(t, DefaultDayRetention, server.TTL("netlog:usage:day:20240214:default"))
+
+	// The pending days are caught up from the last one rolled up
+	now := day.Add(50 * time.Hour)
+	require.NoError(t, a.RollupPending(ctx, now))
+	assert.Equal(t, "100", server.HGet("netlog:usage:day:20240213:default", "nginx|outbound|public|bytes"))
+	assert.Equal(t, "100", server.HGet("netlog:usage:day:20240216:default", "nginx|outbound|public|bytes"))
+	assert.False(t, server.Exists("netlog:usage:day:20240215:default"))
+	last, err := server.Get("netlog:usage:rollup")
+	require.NoError(t, err)
+	assert.Equal(t, "20240216", last)
+
+	// Days before the last rollup are left alone
+	server.Del("netlog:usage:day:20240213:default")
+	require.NoError(t, a.RollupPending(ctx, now))
+	assert.False(t, server.Exists("netlog:usage:day:20240213:default"))
+}
+
+func TestUsage(t *testing.T) {
+	a, server := newTestAccountant(t)
+	ctx := context.Background()
+	day := time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC)
+	flow := types.AggregatedInfo{Namespace: "default", Name: "nginx", Direction: "outbound", Destination: "8.8.8.8", TotalBytes: 100, Packets: 1}
+	var flows []types.AggregatedInfo
+	for _, offset := range []time.Duration{-time.Hour, 3 * time.Hour, 25 * time.Hour, 30 * time.Hour} {
+		flow.StartTime = day.Add(offset)
+		flows = append(flows, flow)
+	}
+	require.NoError(t, a.Write(ctx, flows))
+	require.NoError(t, a.Rollup(ctx, day))
+
+	// Whole days come from the daily buckets, which win over the hours
+	server.HSet("netlog:usage:day:20240214:default", "nginx|outbound|public|bytes", "1000")
+	usage, err := a.Usage(ctx, "default", day.Add(-time.Hour), day.Add(26*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []Usage{{Namespace: "default", Owner: "nginx", Direction: "outbound", Zone: ZonePublic, Bytes: 1200, Packets: 3}}, usage)
+
+	// Days that were not rolled up are read from their hours
+	usage, err = a.Usage(ctx, "default", day.Add(24*time.Hour), day.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []Usage{{Namespace: "default", Owner: "nginx", Direction: "outbound", Zone: ZonePublic, Bytes: 200, Packets: 2}}, usage)
+
+	usage, err = a.Usage(ctx, "other", day, day.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+}
@@ -0,0 +1,228 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `
+rules:
+- name: public-egress
+  namespaces: ["tenant-*"]
+  direction: outbound
+  zone: public
+  window: 1h
+  maxBytes: 1Ki
+  labels:
+    severity: warning
+- name: egress-rate
+  maxRate: 10
+`
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(testRules))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+
+	assert.Equal(t, "public-egress", rules[0].Name)
+	assert.Equal(t, Duration(time.Hour), rules[0].Window)
+	assert.Equal(t, int64(1024), rules[0].MaxBytes.Value())
+	assert.Equal(t, Duration(DefaultWindow), rules[1].Window)
+
+	for _, invalid := range []string{
+		"rules:\n- name: a\n",
+		"rules:\n- maxBytes: 1\n",
+		"rules:\n- name: a\n  maxBytes: 1\n  direction: sideways\n",
+		"rules:\n- name: a\n  maxBytes: 1\n- name: a\n  maxBytes: 2\n",
+		"rules:\n- name: a\n  maxBytes: 1\n  window: 1\n",
+		"rules:\n- name: a\n  maxBytes: 1\n  unknown: true\n",
+	} {
+		_, err := ParseRules([]byte(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	rules, err := ParseRules([]byte(testRules))
+	require.NoError(t, err)
+	rule := rules[0]
+
+	assert.True(t, rule.Matches(types.AggregatedInfo{Namespace: "tenant-a", Direction: "outbound", Destination: "8.8.8.8"}))
+	assert.False(t, rule.Matches(types.AggregatedInfo{Namespace: "system", Direction: "outbound", Destination: "8.8.8.8"}))
+	assert.False(t, rule.Matches(types.AggregatedInfo{Namespace: "tenant-a", Direction: "inbound", Source: "8.8.8.8"}))
+	assert.False(t, rule.Matches(types.AggregatedInfo{Namespace: "tenant-a", Direction: "outbound", Destination: "10.0.0.1"}))
+}
+
+// fakeNotifier records the alerts it receives
+type fakeNotifier struct {
+	name     string
+	resend   time.Duration
+	received [][]Alert
+}
+
+func (f *fakeNotifier) Name() string                  { return f.name }
+func (f *fakeNotifier) ResendInterval() time.Duration { return f.resend }
+func (f *fakeNotifier) Notify(ctx context.Context, alerts []Alert) error {
+	f.received = append(f.received, alerts)
+	return nil
+}
+
+func TestEngineFireAndResolve(t *testing.T) {
+	rules, err := ParseRules([]byte(testRules))
+	require.NoError(t, err)
+
+	webhook := &fakeNotifier{name: "webhook"}
+	am := &fakeNotifier{name: "alertmanager", resend: time.Minute}
+	e := NewEngine(rules[:1], []Notifier{webhook, am}, time.Second, nil)
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+
+	flow := types.AggregatedInfo{Namespace: "tenant-a", Direction: "outbound", Destination: "8.8.8.8", TotalBytes: 600}
+	require.NoError(t, e.Write(context.Background(), []types.AggregatedInfo{flow}))
+	e.Evaluate(context.Background())
+	assert.Empty(t, webhook.received)
+	assert.Empty(t, e.Alerts())
+
+	// Exceeding the limit fires the alert once
+	now = now.Add(10 * time.Minute)
+	require.NoError(t, e.Write(context.Background(), []types.AggregatedInfo{flow}))
+	e.Evaluate(context.Background())
+	require.Len(t, webhook.received, 1)
+	alert := webhook.received[0][0]
+	assert.Equal(t, StatusFiring, alert.Status)
+	assert.Equal(t, int64(1200), alert.Bytes)
+	assert.Equal(t, map[string]string{
+		"alertname": "public-egress",
+		"namespace": "tenant-a",
+		"severity":  "warning",
+		"direction": "outbound",
+		"zone":      "public",
+	}, alert.Labels)
+	assert.Len(t, e.Alerts(), 1)
+
+	// Firing alerts are deduplicated for the webhook but resent to Alertmanager
+	now = now.Add(2 * time.Minute)
+	e.Evaluate(context.Background())
+	assert.Len(t, webhook.received, 1)
+	assert.Len(t, am.received, 2)
+
+	// Once the traffic left the window the alert is resolved
+	now = now.Add(time.Hour)
+	e.Evaluate(context.Background())
+	require.Len(t, webhook.received, 2)
+	assert.Equal(t, StatusResolved, webhook.received[1][0].Status)
+	assert.Equal(t, now, webhook.received[1][0].EndsAt)
+	assert.Empty(t, e.Alerts())
+	assert.Empty(t, e.windows)
+}
+
+func TestEngineSetRules(t *testing.T) {
+	rules, err := ParseRules([]byte(testRules))
+	require.NoError(t, err)
+
+	webhook := &fakeNotifier{name: "webhook"}
+	e := NewEngine(rules, []Notifier{webhook}, time.Second, nil)
+	flow := types.AggregatedInfo{Namespace: "tenant-a", Direction: "outbound", Destination: "8.8.8.8", TotalBytes: 4096}
+	require.NoError(t, e.Write(context.Background(), []types.AggregatedInfo{flow}))
+	e.Evaluate(context.Background())
+	require.Len(t, e.Alerts(), 2)
+	require.Len(t, webhook.received, 1)
+
+	// Unchanged rules keep their state, the alerts of removed rules are
+	// resolved with the previous notifiers
+	am := &fakeNotifier{name: "alertmanager"}
+	e.SetRules(context.Background(), rules[:1], []Notifier{am})
+	alerts := e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "public-egress", alerts[0].Rule)
+	assert.Len(t, e.windows, 1)
+	require.Len(t, webhook.received, 2)
+	require.Len(t, webhook.received[1], 1)
+	assert.Equal(t, rules[1].Name, webhook.received[1][0].Rule)
+	assert.Equal(t, StatusResolved, webhook.received[1][0].Status)
+	assert.Empty(t, am.received)
+
+	// A changed window starts over
+	changed := []Rule{rules[0]}
+	changed[0].Window = Duration(2 * time.Hour)
+	e.SetRules(context.Background(), changed, nil)
+	assert.Empty(t, e.Alerts())
+	assert.Empty(t, e.windows)
+	require.Len(t, am.received, 1)
+	assert.Equal(t, "public-egress", am.received[0][0].Rule)
+	assert.Equal(t, StatusResolved, am.received[0][0].Status)
+}
+
+func TestWindow(t *testing.T) {
+	w := newWindow(time.Minute)
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	w.add(now, 10)
+	w.add(now.Add(30*time.Second), 20)
+	assert.Equal(t, int64(30), w.sum(now.Add(30*time.Second)))
+	assert.Equal(t, int64(20), w.sum(now.Add(70*time.Second)))
+	assert.Equal(t, int64(0), w.sum(now.Add(2*time.Minute)))
+	assert.Empty(t, w.buckets)
+}
+
+func TestWindowRange(t *testing.T) {
+	w := newWindow(time.Minute)
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+
+	// A flow lasting two minutes only counts with the part within the
+	// window, up to a bucket
+	w.addRange(now, now.Add(-2*time.Minute), now, 1200)
+	assert.InDelta(t, 600, w.sum(now), 10)
+	assert.InDelta(t, 300, w.sum(now.Add(30*time.Second)), 10)
+
+	// The parts add up to the bytes of the flow
+	w = newWindow(time.Hour)
+	w.addRange(now, now.Add(-10*time.Minute), now.Add(-10*time.Second), 1001)
+	assert.Equal(t, int64(1001), w.sum(now))
+	assert.Greater(t, len(w.buckets), 1)
+
+	// Flows without times and short flows are added at once
+	w = newWindow(time.Minute)
+	w.addRange(now.Add(500*time.Millisecond), time.Time{}, time.Time{}, 10)
+	w.addRange(now.Add(500*time.Millisecond), now.Add(100*time.Millisecond), now.Add(time.Hour), 5)
+	assert.Equal(t, map[int64]int64{now.UnixNano() / int64(time.Second): 15}, w.buckets)
+}
+
+func TestAlertmanagerNotify(t *testing.T) {
+	var received []postableAlert
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/alerts", r.URL.Path)
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer server.Close()
+
+	start := time.Now().Add(-time.Minute)
+	am := NewAlertmanager(server.URL+"/", time.Second)
+	err := am.Notify(context.Background(), []Alert{{
+		Rule:     "public-egress",
+		Status:   StatusFiring,
+		Labels:   map[string]string{"alertname": "public-egress"},
+		StartsAt: start,
+	}})
+	require.NoError(t, err)
+	require.Len(t, received, 1)
+	assert.Equal(t, "public-egress", received[0].Labels["alertname"])
+	assert.True(t, received[0].EndsAt.After(time.Now()))
+}
+
+func TestWebhookNotifyError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL, time.Second).Notify(context.Background(), []Alert{{Rule: "a"}})
+	assert.Error(t, err)
+}
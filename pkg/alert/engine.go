@@ -0,0 +1,396 @@
+package alert
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// StatusFiring is the status of alerts whose limit is exceeded
+	StatusFiring = "firing"
+	// StatusResolved is the status of alerts that stopped firing
+	StatusResolved = "resolved"
+
+	// DefaultEvaluationInterval is the default interval between rule evaluations
+	DefaultEvaluationInterval = 30 * time.Second
+	// windowBuckets is the number of buckets a rule window is split into
+	windowBuckets = 60
+)
+
+// Alert is the state of a rule for a namespace
+type Alert struct {
+	Rule      string `json:"rule"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status"`
+	// Bytes is the traffic within the window of the rule
+	Bytes int64 `json:"bytes"`
+	// Rate is the average bytes per second within the window of the rule
+	Rate        float64           `json:"rate"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt,omitempty"`
+
+	// lastSent is when the alert was last delivered to each notifier
+	lastSent map[string]time.Time
+}
+
+// alertKey identifies the alert of a rule for a namespace
+type alertKey struct {
+	rule      string
+	namespace string
+}
+
+// Engine evaluates rules against the emitted flows. It is a sink, flows
+// written to it are added to the sliding windows of the matching rules, and
+// Run evaluates the windows periodically and delivers alerts on changes.
+type Engine struct {
+	rules     []Rule
+	notifiers []Notifier
+	recorder  *metrics.Recorder
+	interval  time.Duration
+	now       func() time.Time
+
+	mu      sync.Mutex
+	windows map[alertKey]*window
+	alerts  map[alertKey]*Alert
+}
+
+// NewEngine creates an engine evaluating rules every interval
+func NewEngine(rules []Rule, notifiers []Notifier, interval time.Duration, rec *metrics.Recorder) *Engine {
+	if interval <= 0 {
+		interval = DefaultEvaluationInterval
+	}
+	return &Engine{
+		rules:     rules,
+		notifiers: notifiers,
+		recorder:  rec,
+		interval:  interval,
+		now:       time.Now,
+		windows:   make(map[alertKey]*window),
+		alerts:    make(map[alertKey]*Alert),
+	}
+}
+
+// Name returns the name of the sink
+func (e *Engine) Name() string {
+	return "alert"
+}
+
+// Write adds the flows to the windows of the rules they match
+func (e *Engine) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	for _, flow := range flows {
+		for i := range e.rules {
+			rule := &e.rules[i]
+			if !rule.Matches(flow) {
+				continue
+			}
+			key := alertKey{rule: rule.Name, namespace: flow.Namespace}
+			w, ok := e.windows[key]
+			if !ok {
+				w = newWindow(time.Duration(rule.Window))
+				e.windows[key] = w
+			}
+			w.addRange(now, flow.StartTime, flow.EndTime, flow.TotalBytes)
+		}
+	}
+	return nil
+}
+
+// Close does nothing, pending alerts are not resolved on shutdown
+func (e *Engine) Close() error {
+	return nil
+}
+
+// SetRules replaces the rules and notifiers. The windows and alerts of rules
+// that were removed or whose window changed are discarded, their firing
+// alerts are sent as resolved to the previous notifiers.
+func (e *Engine) SetRules(ctx context.Context, rules []Rule, notifiers []Notifier) {
+	e.mu.Lock()
+	kept := make(map[string]bool)
+	previous := make(map[string]Duration, len(e.rules))
+	for _, rule := range e.rules {
+		previous[rule.Name] = rule.Window
+	}
+	for _, rule := range rules {
+		if window, ok := previous[rule.Name]; ok && window == rule.Window {
+			kept[rule.Name] = true
+		}
+	}
+
+	now := e.now()
+	var resolved []Alert
+	for key := range e.windows {
+		if !kept[key.rule] {
+			delete(e.windows, key)
+		}
+	}
+	for key, alert := range e.alerts {
+		if !kept[key.rule] {
+			alert.Status = StatusResolved
+			alert.EndsAt = now
+			resolved = append(resolved, *alert)
+			delete(e.alerts, key)
+			e.recorder.SetAlertFiring(key.rule, key.namespace, false)
+		}
+	}
+	previousNotifiers := e.notifiers
+	e.rules = rules
+	e.notifiers = notifiers
+	e.mu.Unlock()
+
+	if len(resolved) == 0 {
+		return
+	}
+	batches := make(map[string][]Alert, len(previousNotifiers))
+	for _, n := range previousNotifiers {
+		batches[n.Name()] = append([]Alert(nil), resolved...)
+	}
+	e.notify(ctx, previousNotifiers, batches)
+}
+
+// Run evaluates the rules every interval until ctx is cancelled
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.Evaluate(ctx)
+		}
+	}
+}
+
+// Alerts returns the alerts that are currently firing
+func (e *Engine) Alerts() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	alerts := make([]Alert, 0, len(e.alerts))
+	for _, alert := range e.alerts {
+		alerts = append(alerts, *alert)
+	}
+	sortAlerts(alerts)
+	return alerts
+}
+
+// Evaluate checks every window against its rule and notifies the receivers
+// of alerts that started firing, were resolved or are due to be resent
+func (e *Engine) Evaluate(ctx context.Context) {
+	e.mu.Lock()
+	now := e.now()
+	var changed []Alert
+	due := make(map[string][]Alert)
+
+	for i := range e.rules {
+		rule := &e.rules[i]
+		for key, w := range e.windows {
+			if key.rule != rule.Name {
+				continue
+			}
+			bytes := w.sum(now)
+			alert, firing := e.alerts[key]
+
+			switch {
+			case rule.exceeded(bytes) && !firing:
+				alert = newAlert(rule, key.namespace, now)
+				alert.Bytes, alert.Rate = bytes, rule.rate(bytes)
+				e.alerts[key] = alert
+				e.recorder.SetAlertFiring(rule.Name, key.namespace, true)
+				changed = append(changed, *alert)
+			case rule.exceeded(bytes):
+				alert.Bytes, alert.Rate = bytes, rule.rate(bytes)
+				for _, n := range e.notifiers {
+					if resend := n.ResendInterval(); resend > 0 && now.Sub(alert.lastSent[n.Name()]) >= resend {
+						due[n.Name()] = append(due[n.Name()], *alert)
+					}
+				}
+			case firing:
+				alert.Status = StatusResolved
+				alert.Bytes, alert.Rate = bytes, rule.rate(bytes)
+				alert.EndsAt = now
+				delete(e.alerts, key)
+				e.recorder.SetAlertFiring(rule.Name, key.namespace, false)
+				changed = append(changed, *alert)
+			}
+
+			if bytes == 0 {
+				// Nothing left in the window, the alert is resolved by now
+				delete(e.windows, key)
+			}
+		}
+	}
+
+	// Every notifier receives the changes and its own resends
+	notifiers := e.notifiers
+	batches := make(map[string][]Alert)
+	for _, n := range notifiers {
+		batches[n.Name()] = append(append([]Alert(nil), changed...), due[n.Name()]...)
+		for _, alert := range batches[n.Name()] {
+			if a, ok := e.alerts[alertKey{rule: alert.Rule, namespace: alert.Namespace}]; ok {
+				a.lastSent[n.Name()] = now
+			}
+		}
+	}
+	e.mu.Unlock()
+
+	e.notify(ctx, notifiers, batches)
+}
+
+// notify delivers to every notifier its batch of alerts. It must be called
+// without holding e.mu.
+func (e *Engine) notify(ctx context.Context, notifiers []Notifier, batches map[string][]Alert) {
+	for _, n := range notifiers {
+		alerts := batches[n.Name()]
+		if len(alerts) == 0 {
+			continue
+		}
+		sortAlerts(alerts)
+		notifyCtx, cancel := context.WithTimeout(ctx, DefaultNotifyTimeout)
+		err := n.Notify(notifyCtx, alerts)
+		cancel()
+		e.recorder.AlertNotified(n.Name(), err)
+		if err != nil {
+			log.Printf("alert: failed to notify %s of %d alerts: %v", n.Name(), len(alerts), err)
+		}
+	}
+}
+
+// newAlert creates a firing alert of rule for namespace
+func newAlert(rule *Rule, namespace string, now time.Time) *Alert {
+	labels := map[string]string{
+		"alertname": rule.Name,
+		"namespace": namespace,
+	}
+	for k, v := range rule.Labels {
+		labels[k] = v
+	}
+	if rule.Direction != "" {
+		labels["direction"] = rule.Direction
+	}
+	if rule.Zone != "" {
+		labels["zone"] = rule.Zone
+	}
+
+	var limit string
+	switch {
+	case rule.MaxBytes != nil && rule.MaxRate != nil:
+		limit = fmt.Sprintf("%s bytes or %s bytes/s", rule.MaxBytes, rule.MaxRate)
+	case rule.MaxBytes != nil:
+		limit = fmt.Sprintf("%s bytes", rule.MaxBytes)
+	default:
+		limit = fmt.Sprintf("%s bytes/s", rule.MaxRate)
+	}
+
+	return &Alert{
+		Rule:      rule.Name,
+		Namespace: namespace,
+		Status:    StatusFiring,
+		Labels:    labels,
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("Traffic of namespace %s exceeds %s within %s", namespace, limit, time.Duration(rule.Window)),
+		},
+		StartsAt: now,
+		lastSent: make(map[string]time.Time),
+	}
+}
+
+func sortAlerts(alerts []Alert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Namespace < alerts[j].Namespace
+	})
+}
+
+// window sums bytes over a sliding time range using fixed-size buckets
+type window struct {
+	size    time.Duration
+	step    time.Duration
+	buckets map[int64]int64
+}
+
+func newWindow(size time.Duration) *window {
+	step := size / windowBuckets
+	if step < time.Second {
+		step = time.Second
+	}
+	return &window{size: size, step: step, buckets: make(map[int64]int64)}
+}
+
+// add adds bytes at time t
+func (w *window) add(t time.Time, bytes int64) {
+	w.buckets[t.UnixNano()/int64(w.step)] += bytes
+}
+
+// addRange spreads bytes evenly over the time range from start to end, so
+// long flows emitted at once don't look like a burst. The range is clamped to
+// now and the parts before the window ending at now are left out. Flows
+// without times are added at now.
+func (w *window) addRange(now, start, end time.Time, bytes int64) {
+	if end.IsZero() || end.After(now) {
+		end = now
+	}
+	if start.IsZero() || start.After(end) {
+		start = end
+	}
+	duration := end.Sub(start)
+	if end.UnixNano()/int64(w.step) == start.UnixNano()/int64(w.step) {
+		w.add(end, bytes)
+		return
+	}
+
+	// Each bucket gets the bytes up to its end minus the bytes up to its
+	// start, so the parts add up to bytes
+	upTo := func(t time.Time) int64 {
+		return int64(math.Round(float64(bytes) * float64(t.Sub(start)) / float64(duration)))
+	}
+	from := start
+	if oldest := now.Add(-w.size); from.Before(oldest) {
+		from = oldest
+	}
+	for idx := from.UnixNano() / int64(w.step); idx <= end.UnixNano()/int64(w.step); idx++ {
+		bucketStart := time.Unix(0, idx*int64(w.step))
+		bucketEnd := bucketStart.Add(w.step)
+		if bucketStart.Before(start) {
+			bucketStart = start
+		}
+		if bucketEnd.After(end) {
+			bucketEnd = end
+		}
+		if part := upTo(bucketEnd) - upTo(bucketStart); part > 0 {
+			w.buckets[idx] += part
+		}
+	}
+}
+
+// sum returns the bytes within the window ending at now and drops the
+// buckets that fell out of it
+func (w *window) sum(now time.Time) int64 {
+	oldest := now.Add(-w.size).UnixNano() / int64(w.step)
+	var total int64
+	for idx, bytes := range w.buckets {
+		if idx <= oldest {
+			delete(w.buckets, idx)
+			continue
+		}
+		total += bytes
+	}
+	return total
+}
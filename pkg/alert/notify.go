@@ -0,0 +1,148 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultNotifyTimeout is the default timeout of a single notification
+	DefaultNotifyTimeout = 10 * time.Second
+	// alertmanagerResend is how often firing alerts are sent to Alertmanager again
+	alertmanagerResend = time.Minute
+)
+
+// Notifier delivers alerts to a receiver
+type Notifier interface {
+	// Name identifies the receiver in logs and metrics
+	Name() string
+	// Notify delivers a set of firing or resolved alerts
+	Notify(ctx context.Context, alerts []Alert) error
+	// ResendInterval is how often firing alerts are delivered again, 0 only
+	// delivers them when their state changes
+	ResendInterval() time.Duration
+}
+
+// Webhook posts alerts as JSON to a URL. Alerts are only delivered when
+// they start firing and when they are resolved.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a notifier posting to url
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	if timeout <= 0 {
+		timeout = DefaultNotifyTimeout
+	}
+	return &Webhook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// Name returns the name of the receiver
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+// ResendInterval returns 0, webhooks are only notified of state changes
+func (w *Webhook) ResendInterval() time.Duration {
+	return 0
+}
+
+// webhookPayload is the body posted to webhooks
+type webhookPayload struct {
+	Alerts []Alert `json:"alerts"`
+}
+
+// Notify posts the alerts
+func (w *Webhook) Notify(ctx context.Context, alerts []Alert) error {
+	return post(ctx, w.client, w.url, webhookPayload{Alerts: alerts})
+}
+
+// Alertmanager sends alerts to the v2 API of Prometheus Alertmanager or a
+// compatible receiver. Firing alerts are sent again every minute, so
+// Alertmanager keeps them active, and deduplicated by their labels there.
+type Alertmanager struct {
+	url    string
+	client *http.Client
+}
+
+// NewAlertmanager creates a notifier for the Alertmanager at baseURL
+func NewAlertmanager(baseURL string, timeout time.Duration) *Alertmanager {
+	if timeout <= 0 {
+		timeout = DefaultNotifyTimeout
+	}
+	return &Alertmanager{
+		url:    strings.TrimSuffix(baseURL, "/") + "/api/v2/alerts",
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Name returns the name of the receiver
+func (a *Alertmanager) Name() string {
+	return "alertmanager"
+}
+
+// ResendInterval returns how often firing alerts are sent again
+func (a *Alertmanager) ResendInterval() time.Duration {
+	return alertmanagerResend
+}
+
+// postableAlert is an alert of the Alertmanager v2 API
+type postableAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// Notify sends the alerts. Firing alerts end a few resend intervals in the
+// future unless they are sent again, so they resolve on their own if
+// netlog goes away.
+func (a *Alertmanager) Notify(ctx context.Context, alerts []Alert) error {
+	postable := make([]postableAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		endsAt := alert.EndsAt
+		if alert.Status == StatusFiring {
+			endsAt = time.Now().Add(4 * alertmanagerResend)
+		}
+		postable = append(postable, postableAlert{
+			Labels:      alert.Labels,
+			Annotations: alert.Annotations,
+			StartsAt:    alert.StartsAt,
+			EndsAt:      endsAt,
+		})
+	}
+	return post(ctx, a.client, a.url, postable)
+}
+
+// post sends body as JSON to url
+func post(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode alerts: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send alerts: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
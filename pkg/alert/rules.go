@@ -0,0 +1,180 @@
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/accounting"
+	"github.com/highscaleco/netlog/pkg/types"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+)
+
+// DefaultWindow is the window of rules that don't set one
+const DefaultWindow = 5 * time.Minute
+
+// Duration is a time.Duration read from strings such as "1h30m"
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1h\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule describes a traffic limit of a set of namespaces. A rule fires for a
+// namespace once the bytes of its matching flows within Window exceed
+// MaxBytes, or their average rate over Window exceeds MaxRate.
+type Rule struct {
+	// Name identifies the rule, it is the alertname of its alerts
+	Name string `json:"name"`
+	// Namespaces are shell patterns of the namespaces the rule applies to,
+	// all namespaces when empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Direction limits the rule to inbound or outbound flows
+	Direction string `json:"direction,omitempty"`
+	// Zone limits the rule to flows with a public or private remote endpoint
+	Zone string `json:"zone,omitempty"`
+	// Window is the time range the traffic is summed over
+	Window Duration `json:"window,omitempty"`
+	// MaxBytes is the limit of the bytes within the window, e.g. 10Gi
+	MaxBytes *resource.Quantity `json:"maxBytes,omitempty"`
+	// MaxRate is the limit of the average bytes per second, e.g. 5Mi
+	MaxRate *resource.Quantity `json:"maxRate,omitempty"`
+	// Labels are added to the alerts of the rule, e.g. the severity
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// RuleFile is the content of a rule file
+type RuleFile struct {
+	Rules []Rule `json:"rules"`
+}
+
+// LoadRules reads and validates the rules of a YAML or JSON file
+func LoadRules(filename string) ([]Rule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file: %w", err)
+	}
+	return ParseRules(data)
+}
+
+// ParseRules parses and validates YAML or JSON encoded rules
+func ParseRules(data []byte) ([]Rule, error) {
+	var file RuleFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+	if err := ValidateRules(file.Rules); err != nil {
+		return nil, err
+	}
+	return file.Rules, nil
+}
+
+// ValidateRules validates rules and sets the default window of rules that
+// don't set one
+func ValidateRules(rules []Rule) error {
+	names := make(map[string]bool)
+	for i := range rules {
+		rule := &rules[i]
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %d (%s): %w", i, rule.Name, err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %d: duplicate name %s", i, rule.Name)
+		}
+		names[rule.Name] = true
+		if rule.Window == 0 {
+			rule.Window = Duration(DefaultWindow)
+		}
+	}
+	return nil
+}
+
+func (r *Rule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	for _, pattern := range r.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+	switch r.Direction {
+	case "", "inbound", "outbound":
+	default:
+		return fmt.Errorf("unknown direction: %s", r.Direction)
+	}
+	switch r.Zone {
+	case "", accounting.ZonePublic, accounting.ZonePrivate:
+	default:
+		return fmt.Errorf("unknown zone: %s", r.Zone)
+	}
+	if r.Window < 0 {
+		return fmt.Errorf("window cannot be negative")
+	}
+	if r.MaxBytes == nil && r.MaxRate == nil {
+		return fmt.Errorf("either maxBytes or maxRate must be set")
+	}
+	if r.MaxBytes != nil && r.MaxBytes.Sign() <= 0 {
+		return fmt.Errorf("maxBytes must be positive")
+	}
+	if r.MaxRate != nil && r.MaxRate.Sign() <= 0 {
+		return fmt.Errorf("maxRate must be positive")
+	}
+	return nil
+}
+
+// Matches reports whether the flow is counted by the rule
+func (r *Rule) Matches(flow types.AggregatedInfo) bool {
+	if flow.Namespace == "" {
+		return false
+	}
+	if r.Direction != "" && flow.Direction != r.Direction {
+		return false
+	}
+	if r.Zone != "" && accounting.Zone(flow) != r.Zone {
+		return false
+	}
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range r.Namespaces {
+		if ok, _ := path.Match(pattern, flow.Namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exceeded reports whether bytes within the window break the limits of the rule
+func (r *Rule) exceeded(bytes int64) bool {
+	if r.MaxBytes != nil && bytes > r.MaxBytes.Value() {
+		return true
+	}
+	if r.MaxRate != nil && r.rate(bytes) > r.MaxRate.AsApproximateFloat64() {
+		return true
+	}
+	return false
+}
+
+// rate returns the average bytes per second of bytes within the window
+func (r *Rule) rate(bytes int64) float64 {
+	return float64(bytes) / time.Duration(r.Window).Seconds()
+}
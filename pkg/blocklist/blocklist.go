@@ -0,0 +1,340 @@
+// Package blocklist matches the remote endpoints of flows against threat
+// intelligence lists of IP addresses and networks
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/iptrie"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// EventBlocklistMatch is raised when a flow has a listed remote endpoint
+	EventBlocklistMatch = "blocklist_match"
+	// SeverityCritical is the severity of blocklist matches
+	SeverityCritical = "critical"
+
+	// DefaultReloadInterval is the default interval between checks for
+	// changed list files
+	DefaultReloadInterval = 5 * time.Minute
+	// DefaultCooldown is the default minimum time between two events for the
+	// same remote endpoint and namespace
+	DefaultCooldown = 15 * time.Minute
+)
+
+// List is a blocklist file
+type List struct {
+	// Name is the tag of flows matching the list
+	Name string
+	// Path is the file the list is read from
+	Path string
+	// Format is plain, csv or stix
+	Format string
+}
+
+// ParseList creates a list from a spec of the form [format:]path. Without
+// a format, .csv files are read as CSV, .json files as STIX bundles and
+// anything else as plain lists.
+func ParseList(name, spec string) (List, error) {
+	if name == "" {
+		return List{}, fmt.Errorf("list name cannot be empty")
+	}
+	list := List{Name: name, Path: spec}
+	if format, path, ok := strings.Cut(spec, ":"); ok {
+		switch format {
+		case FormatPlain, FormatCSV, FormatSTIX:
+			list.Format, list.Path = format, path
+		}
+	}
+	if list.Path == "" {
+		return List{}, fmt.Errorf("list %s: path cannot be empty", name)
+	}
+	if list.Format == "" {
+		switch strings.ToLower(filepath.Ext(list.Path)) {
+		case ".csv":
+			list.Format = FormatCSV
+		case ".json":
+			list.Format = FormatSTIX
+		default:
+			list.Format = FormatPlain
+		}
+	}
+	return list, nil
+}
+
+// Options configures a matcher
+type Options struct {
+	// Cooldown is the minimum time between two events for the same remote
+	// endpoint and namespace
+	Cooldown time.Duration
+}
+
+// Matcher tags flows whose remote endpoint is on one of its lists and
+// raises an event for them. Lists are held in a prefix trie which is
+// swapped atomically on reload, so lookups never wait for a reload.
+type Matcher struct {
+	lists    []List
+	cooldown time.Duration
+	recorder *metrics.Recorder
+	events   chan types.Event
+	now      func() time.Time
+
+	trie atomic.Pointer[iptrie.Trie[[]string]]
+
+	mu        sync.Mutex
+	loaded    map[string]*loadedList
+	lastEvent map[string]time.Time
+}
+
+// loadedList holds the entries of a list and the file state they were read from
+type loadedList struct {
+	modTime  time.Time
+	size     int64
+	prefixes []netip.Prefix
+}
+
+// New creates a matcher for lists, Load has to be called before use
+func New(lists []List, opts Options, rec *metrics.Recorder) *Matcher {
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	m := &Matcher{
+		lists:     lists,
+		cooldown:  opts.Cooldown,
+		recorder:  rec,
+		events:    make(chan types.Event, 100),
+		now:       time.Now,
+		loaded:    make(map[string]*loadedList),
+		lastEvent: make(map[string]time.Time),
+	}
+	m.trie.Store(iptrie.New[[]string]())
+	return m
+}
+
+// Events returns the channel blocklist events are delivered on. Events are
+// dropped when it is full.
+func (m *Matcher) Events() <-chan types.Event {
+	return m.events
+}
+
+// Load reads the lists whose file changed since the last load and rebuilds
+// the trie. Lists that fail to load keep their previous entries.
+func (m *Matcher) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	changed := false
+	for _, list := range m.lists {
+		info, err := os.Stat(list.Path)
+		if err != nil {
+			m.recorder.BlocklistReloadFailed(list.Name)
+			errs = append(errs, fmt.Errorf("%s: %w", list.Name, err))
+			continue
+		}
+		if prev, ok := m.loaded[list.Name]; ok && prev.modTime.Equal(info.ModTime()) && prev.size == info.Size() {
+			continue
+		}
+
+		prefixes, err := m.read(list)
+		if err != nil {
+			m.recorder.BlocklistReloadFailed(list.Name)
+			errs = append(errs, fmt.Errorf("%s: %w", list.Name, err))
+			continue
+		}
+		m.loaded[list.Name] = &loadedList{modTime: info.ModTime(), size: info.Size(), prefixes: prefixes}
+		m.recorder.SetBlocklistEntries(list.Name, len(prefixes))
+		changed = true
+	}
+
+	if changed {
+		m.trie.Store(m.build())
+	}
+	return errors.Join(errs...)
+}
+
+func (m *Matcher) read(list List) ([]netip.Prefix, error) {
+	f, err := os.Open(list.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, list.Format, m.now())
+}
+
+// build creates a trie of the loaded lists, the value of each prefix is the
+// names of the lists containing it
+func (m *Matcher) build() *iptrie.Trie[[]string] {
+	trie := iptrie.New[[]string]()
+	for _, list := range m.lists {
+		loaded, ok := m.loaded[list.Name]
+		if !ok {
+			continue
+		}
+		for _, p := range loaded.prefixes {
+			names, _ := trie.Get(p)
+			if len(names) == 0 || names[len(names)-1] != list.Name {
+				trie.Insert(p, append(names, list.Name))
+			}
+		}
+	}
+	return trie
+}
+
+// Run reloads changed lists every interval until ctx is cancelled
+func (m *Matcher) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Load(); err != nil {
+				log.Printf("blocklist: failed to reload lists: %v", err)
+			}
+			m.expireCooldowns()
+		}
+	}
+}
+
+// Match returns the names of the lists containing ip
+func (m *Matcher) Match(ip string) []string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, listNames := range m.trie.Load().Matches(addr) {
+		for _, name := range listNames {
+			if !contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tag adds the names of the lists containing the remote endpoint of flow to
+// its tags and raises an event for it
+func (m *Matcher) Tag(flow types.AggregatedInfo) types.AggregatedInfo {
+	remote := remoteEndpoints(flow)
+	for _, ip := range remote {
+		names := m.Match(ip)
+		if len(names) == 0 {
+			continue
+		}
+		for _, name := range names {
+			if !contains(flow.Tags, name) {
+				flow.Tags = append(flow.Tags, name)
+			}
+		}
+		m.emit(flow, ip, names)
+	}
+	return flow
+}
+
+// remoteEndpoints returns the endpoint of flow that is not owned by the
+// workload, or both endpoints when the direction is unknown
+func remoteEndpoints(flow types.AggregatedInfo) []string {
+	switch flow.Direction {
+	case "outbound":
+		return []string{flow.Destination}
+	case "inbound":
+		return []string{flow.Source}
+	default:
+		return []string{flow.Source, flow.Destination}
+	}
+}
+
+// emit delivers an event unless one was raised for the remote endpoint and
+// namespace within the cooldown
+func (m *Matcher) emit(flow types.AggregatedInfo, remote string, names []string) {
+	now := m.now()
+	key := remote + "|" + flow.Namespace
+
+	m.mu.Lock()
+	if last, ok := m.lastEvent[key]; ok && now.Sub(last) < m.cooldown {
+		m.mu.Unlock()
+		return
+	}
+	m.lastEvent[key] = now
+	m.mu.Unlock()
+
+	owner := flow.Namespace
+	if flow.Name != "" {
+		owner += "/" + flow.Name
+	}
+	if owner == "" {
+		owner = "unknown workload"
+	}
+
+	event := types.Event{
+		Time:        flow.EndTime,
+		Type:        EventBlocklistMatch,
+		Severity:    SeverityCritical,
+		Namespace:   flow.Namespace,
+		Name:        flow.Name,
+		Source:      flow.Source,
+		Destination: flow.Destination,
+		Protocol:    flow.Protocol,
+		Message:     fmt.Sprintf("%s exchanged traffic with %s listed in %s", owner, remote, strings.Join(names, ", ")),
+		Attributes: map[string]string{
+			"lists":     strings.Join(names, ","),
+			"remote":    remote,
+			"direction": flow.Direction,
+			"port":      flow.Port,
+		},
+	}
+	if event.Time.IsZero() {
+		event.Time = now
+	}
+
+	m.recorder.SecurityEvent(event.Type, event.Namespace)
+	select {
+	case m.events <- event:
+	default:
+		m.recorder.SecurityEventDropped()
+	}
+}
+
+// expireCooldowns drops cooldowns that ended
+func (m *Matcher) expireCooldowns() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for key, last := range m.lastEvent {
+		if now.Sub(last) >= m.cooldown {
+			delete(m.lastEvent, key)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+package blocklist
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/iptrie"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSTIX = `{
+  "type": "bundle",
+  "id": "bundle--1",
+  "objects": [
+    {"type": "indicator", "pattern_type": "stix", "pattern": "[ipv4-addr:value = '198.51.100.1' OR ipv4-addr:value = '203.0.113.0/24']"},
+    {"type": "indicator", "pattern_type": "stix", "pattern": "[ipv6-addr:value = '2001:db8::1']"},
+    {"type": "indicator", "pattern_type": "stix", "pattern": "[ipv4-addr:value = '192.0.2.1']", "revoked": true},
+    {"type": "indicator", "pattern_type": "stix", "pattern": "[ipv4-addr:value = '192.0.2.2']", "valid_until": "2020-01-01T00:00:00Z"},
+    {"type": "indicator", "pattern_type": "snort", "pattern": "alert ip 192.0.2.3 any -> any any"},
+    {"type": "ipv4-addr", "value": "192.0.2.4"},
+    {"type": "domain-name", "value": "example.com"}
+  ]
+}`
+
+func prefixes(t *testing.T, values ...string) []netip.Prefix {
+	var result []netip.Prefix
+	for _, v := range values {
+		p, err := iptrie.ParsePrefix(v)
+		require.NoError(t, err)
+		result = append(result, p)
+	}
+	return result
+}
+
+func TestParse(t *testing.T) {
+	now := time.Date(2024, 2, 14, 0, 0, 0, 0, time.UTC)
+
+	plain := "# tor exit nodes\n198.51.100.1\n\n203.0.113.0/24 ; range\n  2001:db8::1  # host\n"
+	got, err := Parse(strings.NewReader(plain), FormatPlain, now)
+	require.NoError(t, err)
+	assert.Equal(t, prefixes(t, "198.51.100.1", "203.0.113.0/24", "2001:db8::1"), got)
+
+	_, err = Parse(strings.NewReader("198.51.100.1\nnot-an-ip\n"), FormatPlain, now)
+	assert.ErrorContains(t, err, "line 2")
+
+	csv := "first_seen,ip,port,malware\n# comment\n2024-01-01,198.51.100.1,443,emotet\n2024-01-02, 203.0.113.7 ,8080,qakbot\n"
+	got, err = Parse(strings.NewReader(csv), FormatCSV, now)
+	require.NoError(t, err)
+	assert.Equal(t, prefixes(t, "198.51.100.1", "203.0.113.7"), got)
+
+	got, err = Parse(strings.NewReader(testSTIX), FormatSTIX, now)
+	require.NoError(t, err)
+	assert.Equal(t, prefixes(t, "198.51.100.1", "203.0.113.0/24", "2001:db8::1", "192.0.2.4"), got)
+
+	_, err = Parse(strings.NewReader(""), "xml", now)
+	assert.Error(t, err)
+}
+
+func TestParseList(t *testing.T) {
+	for spec, want := range map[string]List{
+		"/etc/netlog/tor.txt":      {Name: "l", Path: "/etc/netlog/tor.txt", Format: FormatPlain},
+		"/etc/netlog/feed.CSV":     {Name: "l", Path: "/etc/netlog/feed.CSV", Format: FormatCSV},
+		"/etc/netlog/bundle.json":  {Name: "l", Path: "/etc/netlog/bundle.json", Format: FormatSTIX},
+		"csv:/etc/netlog/feed.txt": {Name: "l", Path: "/etc/netlog/feed.txt", Format: FormatCSV},
+	} {
+		list, err := ParseList("l", spec)
+		require.NoError(t, err, spec)
+		assert.Equal(t, want, list, spec)
+	}
+
+	_, err := ParseList("", "/etc/netlog/tor.txt")
+	assert.Error(t, err)
+	_, err = ParseList("l", "stix:")
+	assert.Error(t, err)
+}
+
+func TestMatcher(t *testing.T) {
+	dir := t.TempDir()
+	tor := filepath.Join(dir, "tor.txt")
+	feed := filepath.Join(dir, "feed.json")
+	require.NoError(t, os.WriteFile(tor, []byte("198.51.100.1\n203.0.113.0/24\n"), 0o644))
+	require.NoError(t, os.WriteFile(feed, []byte(testSTIX), 0o644))
+
+	m := New([]List{
+		{Name: "tor", Path: tor, Format: FormatPlain},
+		{Name: "feed", Path: feed, Format: FormatSTIX},
+	}, Options{}, nil)
+	require.NoError(t, m.Load())
+
+	assert.Equal(t, []string{"feed", "tor"}, m.Match("198.51.100.1"))
+	assert.Equal(t, []string{"feed", "tor"}, m.Match("203.0.113.9"))
+	assert.Equal(t, []string{"feed"}, m.Match("2001:db8::1"))
+	assert.Empty(t, m.Match("8.8.8.8"))
+	assert.Empty(t, m.Match("invalid"))
+
+	// Changed files are picked up, broken ones keep their previous entries
+	require.NoError(t, os.WriteFile(tor, []byte("8.8.8.8\n"), 0o644))
+	require.NoError(t, os.WriteFile(feed, []byte("{"), 0o644))
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(tor, future, future))
+	require.NoError(t, os.Chtimes(feed, future, future))
+	assert.ErrorContains(t, m.Load(), "feed")
+
+	assert.Equal(t, []string{"tor"}, m.Match("8.8.8.8"))
+	assert.Equal(t, []string{"feed"}, m.Match("198.51.100.1"))
+}
+
+func TestTag(t *testing.T) {
+	dir := t.TempDir()
+	tor := filepath.Join(dir, "tor.txt")
+	require.NoError(t, os.WriteFile(tor, []byte("198.51.100.1\n"), 0o644))
+
+	m := New([]List{{Name: "tor", Path: tor}}, Options{Cooldown: time.Minute}, nil)
+	require.NoError(t, m.Load())
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	flow := types.AggregatedInfo{
+		Namespace:   "tenant-a",
+		Name:        "web",
+		Direction:   "outbound",
+		Source:      "1.1.1.1",
+		Destination: "198.51.100.1",
+		Protocol:    "TCP",
+		Port:        "443",
+		EndTime:     now,
+	}
+	tagged := m.Tag(flow)
+	assert.Equal(t, []string{"tor"}, tagged.Tags)
+	assert.Nil(t, flow.Tags)
+
+	require.Len(t, m.events, 1)
+	event := <-m.events
+	assert.Equal(t, EventBlocklistMatch, event.Type)
+	assert.Equal(t, SeverityCritical, event.Severity)
+	assert.Equal(t, "tenant-a", event.Namespace)
+	assert.Equal(t, "198.51.100.1", event.Attributes["remote"])
+	assert.Equal(t, "tor", event.Attributes["lists"])
+
+	// The listed IP is the local side of inbound flows
+	inbound := flow
+	inbound.Direction = "inbound"
+	inbound.Source, inbound.Destination = "198.51.100.1", "1.1.1.1"
+	assert.Equal(t, []string{"tor"}, m.Tag(inbound).Tags)
+	inbound.Source, inbound.Destination = "1.1.1.1", "198.51.100.1"
+	assert.Empty(t, m.Tag(inbound).Tags)
+
+	// Events for the same remote and namespace wait for the cooldown
+	assert.Empty(t, m.events)
+	now = now.Add(2 * time.Minute)
+	m.expireCooldowns()
+	assert.Empty(t, m.lastEvent)
+	m.Tag(flow)
+	assert.Len(t, m.events, 1)
+}
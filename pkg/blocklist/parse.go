@@ -0,0 +1,141 @@
+package blocklist
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/iptrie"
+)
+
+const (
+	// FormatPlain is a list with one IP address or CIDR per line, text after
+	// # or ; is ignored
+	FormatPlain = "plain"
+	// FormatCSV is a CSV feed, the first column holding an IP address or CIDR
+	// of each record is used
+	FormatCSV = "csv"
+	// FormatSTIX is a STIX 2.x JSON bundle, the IP addresses of indicator
+	// patterns and of ipv4-addr and ipv6-addr objects are used
+	FormatSTIX = "stix"
+)
+
+// Parse reads the entries of a list in the given format
+func Parse(r io.Reader, format string, now time.Time) ([]netip.Prefix, error) {
+	switch format {
+	case FormatPlain, "":
+		return parsePlain(r)
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatSTIX:
+		return parseSTIX(r, now)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+func parsePlain(r io.Reader) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if i := strings.IndexAny(text, "#;"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		p, err := iptrie.ParsePrefix(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read list: %w", err)
+	}
+	return prefixes, nil
+}
+
+func parseCSV(r io.Reader) ([]netip.Prefix, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var prefixes []netip.Prefix
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+		// Headers and records without an address are skipped
+		for _, field := range record {
+			if p, err := iptrie.ParsePrefix(strings.TrimSpace(field)); err == nil {
+				prefixes = append(prefixes, p)
+				break
+			}
+		}
+	}
+	return prefixes, nil
+}
+
+// stixPattern matches the address comparisons of a STIX pattern such as
+// [ipv4-addr:value = '198.51.100.1' OR ipv4-addr:value = '203.0.113.0/24']
+var stixPattern = regexp.MustCompile(`ipv[46]-addr:value\s*=\s*'([^']+)'`)
+
+type stixBundle struct {
+	Objects []stixObject `json:"objects"`
+}
+
+type stixObject struct {
+	Type        string     `json:"type"`
+	Pattern     string     `json:"pattern"`
+	PatternType string     `json:"pattern_type"`
+	Value       string     `json:"value"`
+	Revoked     bool       `json:"revoked"`
+	ValidUntil  *time.Time `json:"valid_until"`
+}
+
+func parseSTIX(r io.Reader, now time.Time) ([]netip.Prefix, error) {
+	var bundle stixBundle
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("failed to decode stix bundle: %w", err)
+	}
+
+	var prefixes []netip.Prefix
+	for _, obj := range bundle.Objects {
+		switch obj.Type {
+		case "indicator":
+			if obj.Revoked || (obj.ValidUntil != nil && obj.ValidUntil.Before(now)) {
+				continue
+			}
+			if obj.PatternType != "" && obj.PatternType != "stix" {
+				continue
+			}
+			for _, match := range stixPattern.FindAllStringSubmatch(obj.Pattern, -1) {
+				if p, err := iptrie.ParsePrefix(match[1]); err == nil {
+					prefixes = append(prefixes, p)
+				}
+			}
+		case "ipv4-addr", "ipv6-addr":
+			if p, err := iptrie.ParsePrefix(obj.Value); err == nil {
+				prefixes = append(prefixes, p)
+			}
+		}
+	}
+	return prefixes, nil
+}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
+	"github.com/highscaleco/netlog/pkg/metrics"
 	"github.com/highscaleco/netlog/pkg/types"
 )
 
@@ -50,9 +52,43 @@ type Capture struct {
 	maxConnections int
 	packets        chan types.AggregatedInfo
 	stop           chan struct{}
+	stopOnce       sync.Once
+	started        atomic.Bool
+	done           chan struct{}
 	handle         *pcap.Handle
+	openErr        error
 	mu             sync.RWMutex
 	aggregatedInfo map[string]*types.AggregatedInfo
+	connections    map[string]*connection
+	recorder       *metrics.Recorder
+	resolver       types.Resolver
+	observers      []PacketObserver
+}
+
+// PacketObserver is notified of every decoded TCP or UDP packet
+type PacketObserver interface {
+	ObservePacket(pkt types.Packet)
+}
+
+// connection is an entry of the connection table. Closed TCP connections
+// are kept until closedConnectionTimeout, so the last ACKs of the close
+// don't open them again.
+type connection struct {
+	namespace string
+	name      string
+	protocol  string
+	lastSeen  time.Time
+	// finFrom is the endpoint that sent the first FIN
+	finFrom string
+	closed  bool
+}
+
+// ConnectionCount is the number of open connections of a workload
+type ConnectionCount struct {
+	Namespace string
+	Name      string
+	Protocol  string
+	Count     int
 }
 
 const (
@@ -72,8 +108,13 @@ const (
 	DefaultMaxConnections = 10000
 	// DefaultConnectionTimeout is the default timeout for connections
 	DefaultConnectionTimeout = 5 * time.Minute
+	// DefaultUDPConnectionTimeout is the idle time after which a UDP connection is considered closed
+	DefaultUDPConnectionTimeout = 30 * time.Second
 	// DefaultCleanupInterval is the default interval for cleaning up old connections
 	DefaultCleanupInterval = 1 * time.Minute
+	// closedConnectionTimeout is how long packets of a closed TCP connection
+	// are ignored, covering TIME_WAIT and retransmitted FINs
+	closedConnectionTimeout = time.Minute
 )
 
 // NewCapture creates a new packet capture session
@@ -88,10 +129,39 @@ func NewCapture(iface string, bufferSize int, promiscuous bool, timeout time.Dur
 		maxConnections: maxConnections,
 		packets:        make(chan types.AggregatedInfo, 1000),
 		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
 		aggregatedInfo: make(map[string]*types.AggregatedInfo),
+		connections:    make(map[string]*connection),
 	}
 }
 
+// SetRecorder sets the recorder of the capture metrics, it must be called
+// before Start
+func (c *Capture) SetRecorder(r *metrics.Recorder) {
+	c.recorder = r
+}
+
+// SetResolver sets the resolver of the owners of the captured addresses, it
+// must be called before Start. Flows are not attributed without a resolver.
+func (c *Capture) SetResolver(r types.Resolver) {
+	c.resolver = r
+}
+
+// resolve returns the owner of ip, passing the port of the flow at ip to
+// resolvers that take it
+func (c *Capture) resolve(ip, port string) (*types.OFIP, error) {
+	if r, ok := c.resolver.(types.PortResolver); ok {
+		return r.ResolvePort(ip, port)
+	}
+	return c.resolver.Resolve(ip)
+}
+
+// AddObserver registers an observer of the captured packets, it must be
+// called before Start
+func (c *Capture) AddObserver(o PacketObserver) {
+	c.observers = append(c.observers, o)
+}
+
 // IsPublicIP checks if an IP address is public
 func IsPublicIP(ip net.IP) bool {
 	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
@@ -129,10 +199,22 @@ func (c *Capture) Start(ctx context.Context) error {
 	go c.cleanupLoop(ctx)
 
 	// Start packet processing goroutine
+	c.started.Store(true)
 	go c.processPackets(ctx)
 
-	// Start packet capture
-	_ = c.handle.LinkType() // Ignore the return value as it's not an error
+	return nil
+}
+
+// Healthy returns an error unless the capture handle is open
+func (c *Capture) Healthy() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.openErr != nil {
+		return c.openErr
+	}
+	if c.handle == nil {
+		return fmt.Errorf("capture handle on %s is not open", c.iface)
+	}
 	return nil
 }
 
@@ -162,25 +244,148 @@ func (c *Capture) cleanup() {
 			delete(c.aggregatedInfo, key)
 		}
 	}
+	for key, conn := range c.connections {
+		if conn.expired(now) {
+			delete(c.connections, key)
+		}
+	}
+}
+
+// connectionKey returns the key of a connection, which is the same for the
+// packets of both directions
+func connectionKey(protocol, src, srcPort, dst, dstPort string) string {
+	a := net.JoinHostPort(src, srcPort)
+	b := net.JoinHostPort(dst, dstPort)
+	if a > b {
+		a, b = b, a
+	}
+	return protocol + ":" + a + "-" + b
+}
+
+// expired reports whether the connection has been idle for longer than the
+// timeout of its protocol, or closed for longer than closedConnectionTimeout
+func (conn *connection) expired(now time.Time) bool {
+	timeout := DefaultConnectionTimeout
+	switch {
+	case conn.closed:
+		timeout = closedConnectionTimeout
+	case conn.protocol == layers.LayerTypeUDP.String():
+		timeout = DefaultUDPConnectionTimeout
+	}
+	return now.Sub(conn.lastSeen) > timeout
+}
+
+// trackConnection updates the connection table with a packet of agg sent
+// from the endpoint src. TCP connections are closed by a RST or by a FIN of
+// both endpoints, all others once they are idle. Must be called with c.mu
+// held.
+func (c *Capture) trackConnection(key, src string, agg *types.AggregatedInfo, transportLayer gopacket.TransportLayer, now time.Time) {
+	conn, exists := c.connections[key]
+	if tcp, ok := transportLayer.(*layers.TCP); ok {
+		switch {
+		case exists && conn.closed && tcp.SYN && !tcp.ACK:
+			// The ports are reused for a new connection
+			delete(c.connections, key)
+			exists = false
+		case exists && conn.closed:
+			return
+		case tcp.RST, tcp.FIN && exists && conn.finFrom != "" && conn.finFrom != src:
+			if exists {
+				conn.closed = true
+				conn.lastSeen = now
+			}
+			return
+		case tcp.FIN && exists:
+			conn.finFrom = src
+		case tcp.FIN:
+			// The start of the connection was missed, don't track its close
+			return
+		}
+	}
+
+	if !exists {
+		if !agg.Owned() || (c.maxConnections > 0 && len(c.connections) >= c.maxConnections) {
+			return
+		}
+		conn = &connection{
+			namespace: agg.Namespace,
+			name:      agg.Name,
+			protocol:  agg.Protocol,
+		}
+		c.connections[key] = conn
+	}
+	conn.lastSeen = now
+}
+
+// ActiveConnections returns the number of open connections per namespace,
+// name and protocol
+func (c *Capture) ActiveConnections() []ConnectionCount {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	counts := make(map[connection]int)
+	for _, conn := range c.connections {
+		if conn.closed || conn.expired(now) {
+			continue
+		}
+		counts[connection{namespace: conn.namespace, name: conn.name, protocol: conn.protocol}]++
+	}
+
+	result := make([]ConnectionCount, 0, len(counts))
+	for conn, count := range counts {
+		result = append(result, ConnectionCount{
+			Namespace: conn.namespace,
+			Name:      conn.name,
+			Protocol:  conn.protocol,
+			Count:     count,
+		})
+	}
+	return result
 }
 
 // processPackets processes packets and updates the aggregated info map
+// until the capture is stopped or ctx is cancelled. The open flows are then
+// emitted and the packets channel is closed.
 func (c *Capture) processPackets(ctx context.Context) {
+	defer close(c.done)
+	defer close(c.packets)
+
 	handle, err := pcap.OpenLive(c.iface, 65536, true, pcap.BlockForever)
 	if err != nil {
 		fmt.Printf("Error opening interface: %v\n", err)
+		c.mu.Lock()
+		c.openErr = fmt.Errorf("failed to open %s: %w", c.iface, err)
+		c.mu.Unlock()
 		return
 	}
-	defer handle.Close()
+	c.mu.Lock()
+	c.handle = handle
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.handle = nil
+		c.mu.Unlock()
+		handle.Close()
+	}()
+	defer c.flushAll()
 
 	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
 	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-c.stop:
 			return
-		case packet := <-packetSource.Packets():
+		case <-ctx.Done():
+			return
+		case packet, ok := <-packetSource.Packets():
+			if !ok {
+				return
+			}
+			c.recorder.PacketDecoded()
+
 			// Process packet
 			ipLayer := packet.NetworkLayer()
 			if ipLayer == nil {
@@ -210,33 +415,41 @@ func (c *Capture) processPackets(ctx context.Context) {
 			c.mu.Lock()
 			agg, exists := c.aggregatedInfo[key]
 			if !exists {
-				// Try to get namespace and name from source IP first
-				ofipSrc, errSrc := types.GetNamespaceAndNameByIPv4(ip.SrcIP.String())
-				ofipDst, errDst := types.GetNamespaceAndNameByIPv4(ip.DstIP.String())
-
 				// Set namespace, name, and direction based on which IP is in our cluster
-				var namespace, name, direction string
-				if errSrc == nil && ofipSrc != nil && ofipSrc.Namespace != "" {
-					namespace = ofipSrc.Namespace
-					name = ofipSrc.Name
-					direction = "outbound"
-				} else if errDst == nil && ofipDst != nil && ofipDst.Namespace != "" {
-					namespace = ofipDst.Namespace
-					name = ofipDst.Name
-					direction = "inbound"
+				var owner types.OFIP
+				var direction string
+				if c.resolver != nil {
+					// Try to get the owner of the source IP first
+					ofipSrc, errSrc := c.resolve(ip.SrcIP.String(), transportLayer.TransportFlow().Src().String())
+					ofipDst, errDst := c.resolve(ip.DstIP.String(), transportLayer.TransportFlow().Dst().String())
+
+					if errSrc == nil && ofipSrc != nil && ofipSrc.Name != "" {
+						owner = *ofipSrc
+						direction = "outbound"
+					} else if errDst == nil && ofipDst != nil && ofipDst.Name != "" {
+						owner = *ofipDst
+						direction = "inbound"
+					}
 				}
 
 				agg = &types.AggregatedInfo{
-					StartTime:   packet.Metadata().Timestamp,
-					EndTime:     packet.Metadata().Timestamp,
-					Source:      ip.SrcIP.String(),
-					Destination: ip.DstIP.String(),
-					Protocol:    transportLayer.LayerType().String(),
-					Port:        transportLayer.TransportFlow().Src().String(),
-					Namespace:   namespace,
-					Name:        name,
-					Direction:   direction,
-					LastSeen:    time.Now(),
+					StartTime:       packet.Metadata().Timestamp,
+					EndTime:         packet.Metadata().Timestamp,
+					Source:          ip.SrcIP.String(),
+					Destination:     ip.DstIP.String(),
+					Protocol:        transportLayer.LayerType().String(),
+					Port:            transportLayer.TransportFlow().Src().String(),
+					DestinationPort: transportLayer.TransportFlow().Dst().String(),
+					Namespace:       owner.Namespace,
+					Name:            owner.Name,
+					Kind:            owner.Kind,
+					WorkloadKind:    owner.WorkloadKind,
+					Workload:        owner.Workload,
+					Labels:          owner.Labels,
+					NamespaceLabels: owner.NamespaceLabels,
+					OwnerTags:       owner.Tags,
+					Direction:       direction,
+					LastSeen:        time.Now(),
 				}
 				c.aggregatedInfo[key] = agg
 			} else {
@@ -245,8 +458,20 @@ func (c *Capture) processPackets(ctx context.Context) {
 				agg.Packets++
 				agg.LastSeen = time.Now()
 			}
+			flow := transportLayer.TransportFlow()
+			connKey := connectionKey(agg.Protocol, ip.SrcIP.String(), flow.Src().String(), ip.DstIP.String(), flow.Dst().String())
+			c.trackConnection(connKey, net.JoinHostPort(ip.SrcIP.String(), flow.Src().String()), agg, transportLayer, time.Now())
+			namespace, name, direction := agg.Namespace, agg.Name, agg.Direction
 			c.mu.Unlock()
 
+			if len(c.observers) > 0 {
+				pkt := newPacket(packet, ip, transportLayer)
+				pkt.Namespace, pkt.Name, pkt.Direction = namespace, name, direction
+				for _, o := range c.observers {
+					o.ObservePacket(pkt)
+				}
+			}
+
 		case <-ticker.C:
 			// Send aggregated packets
 			c.mu.Lock()
@@ -257,17 +482,59 @@ func (c *Capture) processPackets(ctx context.Context) {
 					if duration >= windowSize.Seconds() {
 						c.packets <- *agg
 						delete(c.aggregatedInfo, key)
+						c.recorder.FlowsFlushed(1)
 					}
 				}
 			}
+			c.recorder.SetTableSizes(len(c.aggregatedInfo), len(c.connections))
+			c.recorder.SetQueueLength("capture", len(c.packets))
 			c.mu.Unlock()
 		}
 	}
 }
 
-// Stop stops the packet capture
+// flushAll emits every open flow as a final record
+func (c *Capture) flushAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	flushed := 0
+	for key, agg := range c.aggregatedInfo {
+		c.packets <- *agg
+		delete(c.aggregatedInfo, key)
+		flushed++
+	}
+	c.recorder.FlowsFlushed(flushed)
+	c.recorder.SetTableSizes(0, len(c.connections))
+}
+
+// newPacket extracts the headers observers are interested in
+func newPacket(packet gopacket.Packet, ip *layers.IPv4, transportLayer gopacket.TransportLayer) types.Packet {
+	pkt := types.Packet{
+		Timestamp:   packet.Metadata().Timestamp,
+		Source:      ip.SrcIP,
+		Destination: ip.DstIP,
+		Protocol:    transportLayer.LayerType().String(),
+		Length:      len(packet.Data()),
+	}
+	switch t := transportLayer.(type) {
+	case *layers.TCP:
+		pkt.SourcePort, pkt.DestinationPort = uint16(t.SrcPort), uint16(t.DstPort)
+		pkt.SYN, pkt.ACK, pkt.FIN, pkt.RST = t.SYN, t.ACK, t.FIN, t.RST
+	case *layers.UDP:
+		pkt.SourcePort, pkt.DestinationPort = uint16(t.SrcPort), uint16(t.DstPort)
+	}
+	return pkt
+}
+
+// Stop stops the packet capture and waits until the open flows have been
+// emitted and the packets channel is closed. The flows must be consumed
+// meanwhile. Stop may be called more than once.
 func (c *Capture) Stop() {
-	close(c.stop)
+	c.stopOnce.Do(func() { close(c.stop) })
+	if c.started.Load() {
+		<-c.done
+	}
 }
 
 // processPacket extracts relevant information from a packet
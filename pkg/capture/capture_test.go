@@ -115,6 +115,74 @@ func TestNewCapture(t *testing.T) {
 	assert.NotNil(t, capture.aggregatedInfo)
 }
 
+func TestConnectionTable(t *testing.T) {
+	c := NewCapture("lo", 65536, false, time.Second, "", 65536, 2)
+	agg := &types.AggregatedInfo{Namespace: "default", Name: "nginx", Protocol: "TCP"}
+	now := time.Now()
+
+	// Both directions of a connection share the same key
+	out := connectionKey("TCP", "10.0.0.1", "40000", "8.8.8.8", "443")
+	in := connectionKey("TCP", "8.8.8.8", "443", "10.0.0.1", "40000")
+	assert.Equal(t, out, in)
+
+	const client, server = "10.0.0.1:40000", "8.8.8.8:443"
+	c.trackConnection(out, client, agg, &layers.TCP{SYN: true}, now)
+	c.trackConnection(in, server, agg, &layers.TCP{SYN: true, ACK: true}, now)
+	second := connectionKey("TCP", "10.0.0.1", "40001", "8.8.8.8", "443")
+	c.trackConnection(second, "10.0.0.1:40001", agg, &layers.TCP{SYN: true}, now)
+	// The table is full
+	c.trackConnection(connectionKey("TCP", "10.0.0.1", "40002", "8.8.8.8", "443"), "10.0.0.1:40002", agg, &layers.TCP{SYN: true}, now)
+	// Flows without a namespace are not tracked
+	c.trackConnection(connectionKey("UDP", "10.0.0.2", "53", "8.8.8.8", "53"), "10.0.0.2:53", &types.AggregatedInfo{Protocol: "UDP"}, &layers.UDP{}, now)
+
+	assert.Equal(t, []ConnectionCount{{Namespace: "default", Name: "nginx", Protocol: "TCP", Count: 2}}, c.ActiveConnections())
+
+	// A half-closed connection is still open
+	c.trackConnection(in, server, agg, &layers.TCP{FIN: true, ACK: true}, now)
+	c.trackConnection(out, client, agg, &layers.TCP{ACK: true}, now)
+	c.trackConnection(in, server, agg, &layers.TCP{FIN: true, ACK: true}, now)
+	assert.Equal(t, 2, c.ActiveConnections()[0].Count)
+
+	// The FIN of the peer closes it, and the last ACK doesn't open it again
+	c.trackConnection(out, client, agg, &layers.TCP{FIN: true, ACK: true}, now)
+	c.trackConnection(in, server, agg, &layers.TCP{ACK: true}, now)
+	assert.Equal(t, 1, c.ActiveConnections()[0].Count)
+
+	// RST closes the other connection, a new one on the same ports is tracked
+	c.trackConnection(second, "8.8.8.8:443", agg, &layers.TCP{RST: true}, now)
+	assert.Empty(t, c.ActiveConnections())
+	c.trackConnection(out, client, agg, &layers.TCP{SYN: true}, now)
+	assert.Equal(t, 1, c.ActiveConnections()[0].Count)
+
+	// Closed connections are removed once their packets are gone
+	c.connections[second].lastSeen = now.Add(-2 * closedConnectionTimeout)
+	c.cleanup()
+	assert.Len(t, c.connections, 1)
+
+	// Idle connections are not reported and removed on cleanup
+	for _, conn := range c.connections {
+		conn.lastSeen = now.Add(-2 * DefaultConnectionTimeout)
+	}
+	assert.Empty(t, c.ActiveConnections())
+	c.cleanup()
+	assert.Empty(t, c.connections)
+}
+
+func TestFlushAll(t *testing.T) {
+	c := NewCapture("lo", 65536, false, time.Second, "", 65536, 10)
+	c.aggregatedInfo["a"] = &types.AggregatedInfo{Source: "10.0.0.1", Destination: "8.8.8.8", TotalBytes: 100}
+	c.aggregatedInfo["b"] = &types.AggregatedInfo{Source: "10.0.0.2", Destination: "1.1.1.1", TotalBytes: 200}
+
+	// Open flows are emitted however short they are
+	c.flushAll()
+	assert.Empty(t, c.aggregatedInfo)
+	assert.Len(t, c.Packets(), 2)
+
+	// Stopping a capture that was not started does not block
+	c.Stop()
+	c.Stop()
+}
+
 func TestCaptureStartStop(t *testing.T) {
 	capture := NewCapture(
 		"eth0",
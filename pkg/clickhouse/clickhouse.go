@@ -0,0 +1,248 @@
+package clickhouse
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// DefaultDatabase is the default database of the flow table
+	DefaultDatabase = "netlog"
+	// DefaultTable is the default name of the flow table
+	DefaultTable = "flows"
+	// DefaultEventsTable is the default name of the security event table
+	DefaultEventsTable = "events"
+	// DefaultBatchSize is the default number of rows per insert
+	DefaultBatchSize = 10000
+	// DefaultTimeout is the default timeout of a single insert
+	DefaultTimeout = 30 * time.Second
+	// timeFormat is the format of DateTime64(3) values
+	timeFormat = "2006-01-02 15:04:05.000"
+)
+
+// Options configures the ClickHouse sink
+type Options struct {
+	// URL of the ClickHouse HTTP interface, e.g. http://clickhouse:8123
+	URL string
+	// Username and Password authenticate the inserts
+	Username string
+	Password string
+	// Database and Table name the flow table
+	Database string
+	Table    string
+	// EventsTable names the security event table in Database
+	EventsTable string
+	// Timeout bounds a single insert
+	Timeout time.Duration
+}
+
+// Sink inserts flow records and security events into ClickHouse tables over
+// the HTTP interface
+type Sink struct {
+	opts        Options
+	client      *http.Client
+	query       string
+	eventsQuery string
+	hostname    string
+}
+
+// New creates a ClickHouse sink
+func New(opts Options) (*Sink, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("clickhouse url cannot be empty")
+	}
+	if opts.Database == "" {
+		opts.Database = DefaultDatabase
+	}
+	if opts.Table == "" {
+		opts.Table = DefaultTable
+	}
+	if opts.EventsTable == "" {
+		opts.EventsTable = DefaultEventsTable
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	opts.URL = strings.TrimSuffix(opts.URL, "/")
+	hostname, _ := os.Hostname()
+
+	return &Sink{
+		opts:        opts,
+		client:      &http.Client{Timeout: opts.Timeout},
+		query:       insertQuery(opts.Database, opts.Table),
+		eventsQuery: insertQuery(opts.Database, opts.EventsTable),
+		hostname:    hostname,
+	}, nil
+}
+
+// Name returns the name of the sink
+func (s *Sink) Name() string {
+	return "clickhouse"
+}
+
+// row is a flow record as stored in the flow table
+type row struct {
+	StartTime       string            `json:"start_time"`
+	EndTime         string            `json:"end_time"`
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	Kind            string            `json:"kind"`
+	WorkloadKind    string            `json:"workload_kind"`
+	Workload        string            `json:"workload"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	NamespaceLabels map[string]string `json:"namespace_labels,omitempty"`
+	Direction       string            `json:"direction"`
+	Source          string            `json:"source"`
+	Destination     string            `json:"destination"`
+	Protocol        string            `json:"protocol"`
+	Port            uint16            `json:"port"`
+	TotalBytes      int64             `json:"total_bytes"`
+	Packets         int64             `json:"packets"`
+	Node            string            `json:"node"`
+	Tags            []string          `json:"tags,omitempty"`
+	OwnerTags       []string          `json:"owner_tags,omitempty"`
+}
+
+// eventRow is a security event as stored in the event table
+type eventRow struct {
+	Time        string            `json:"time"`
+	Type        string            `json:"type"`
+	Severity    string            `json:"severity"`
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination"`
+	Protocol    string            `json:"protocol"`
+	Message     string            `json:"message"`
+	Node        string            `json:"node"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// Write inserts the batch with a single INSERT statement
+func (s *Sink) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, flow := range flows {
+		if !flow.Owned() {
+			continue
+		}
+		port, _ := strconv.ParseUint(flow.Port, 10, 16)
+		if err := encoder.Encode(row{
+			StartTime:       flow.StartTime.UTC().Format(timeFormat),
+			EndTime:         flow.EndTime.UTC().Format(timeFormat),
+			Namespace:       flow.Namespace,
+			Name:            flow.Name,
+			Kind:            flow.Kind,
+			WorkloadKind:    flow.WorkloadKind,
+			Workload:        flow.Workload,
+			Labels:          flow.Labels,
+			NamespaceLabels: flow.NamespaceLabels,
+			Direction:       flow.Direction,
+			Source:          flow.Source,
+			Destination:     flow.Destination,
+			Protocol:        flow.Protocol,
+			Port:            uint16(port),
+			TotalBytes:      flow.TotalBytes,
+			Packets:         flow.Packets,
+			Node:            s.hostname,
+			Tags:            flow.Tags,
+			OwnerTags:       flow.OwnerTags,
+		}); err != nil {
+			return fmt.Errorf("failed to encode row: %w", err)
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	if err := s.insert(ctx, s.query, &body); err != nil {
+		return fmt.Errorf("failed to insert flows: %w", err)
+	}
+	return nil
+}
+
+// WriteEvents inserts the events into the event table with a single INSERT
+// statement
+func (s *Sink) WriteEvents(ctx context.Context, events []types.Event) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, e := range events {
+		if err := encoder.Encode(eventRow{
+			Time:        e.Time.UTC().Format(timeFormat),
+			Type:        e.Type,
+			Severity:    e.Severity,
+			Namespace:   e.Namespace,
+			Name:        e.Name,
+			Source:      e.Source,
+			Destination: e.Destination,
+			Protocol:    e.Protocol,
+			Message:     e.Message,
+			Node:        s.hostname,
+			Attributes:  e.Attributes,
+		}); err != nil {
+			return fmt.Errorf("failed to encode event: %w", err)
+		}
+	}
+	if body.Len() == 0 {
+		return nil
+	}
+	if err := s.insert(ctx, s.eventsQuery, &body); err != nil {
+		return fmt.Errorf("failed to insert events: %w", err)
+	}
+	return nil
+}
+
+// insert posts the JSONEachRow rows of body with query
+func (s *Sink) insert(ctx context.Context, query string, body io.Reader) error {
+	params := url.Values{}
+	params.Set("query", query)
+	// Tables created before a column was added keep accepting inserts
+	params.Set("input_format_skip_unknown_fields", "1")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.opts.URL+"/?"+params.Encode(), body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if s.opts.Username != "" {
+		req.Header.Set("X-ClickHouse-User", s.opts.Username)
+		req.Header.Set("X-ClickHouse-Key", s.opts.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Close releases idle connections
+func (s *Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// insertQuery returns the INSERT statement of a table
+func insertQuery(database, table string) string {
+	return fmt.Sprintf("INSERT INTO %s.%s FORMAT JSONEachRow", quoteIdentifier(database), quoteIdentifier(table))
+}
+
+// quoteIdentifier quotes a database or table name
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "\\`") + "`"
+}
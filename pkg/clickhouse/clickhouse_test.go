@@ -0,0 +1,95 @@
+package clickhouse
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	var query string
+	var rows []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		assert.Equal(t, "netlog", r.Header.Get("X-ClickHouse-User"))
+
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+			rows = append(rows, row)
+		}
+	}))
+	defer server.Close()
+
+	s, err := New(Options{URL: server.URL, Username: "netlog", Password: "secret"})
+	require.NoError(t, err)
+
+	start := time.Date(2024, 2, 14, 12, 34, 56, 789000000, time.UTC)
+	flows := []types.AggregatedInfo{
+		{Namespace: "default", Name: "nginx", StartTime: start, EndTime: start, Source: "10.0.0.1", Destination: "8.8.8.8", Protocol: "TCP", Port: "443", Direction: "outbound", TotalBytes: 100, Packets: 1,
+			Kind: "Pod", WorkloadKind: "Deployment", Workload: "nginx", Labels: map[string]string{"app.kubernetes.io/name": "nginx"}, NamespaceLabels: map[string]string{"team": "web"}},
+		{Source: "10.0.0.2", Destination: "8.8.4.4"},
+	}
+	require.NoError(t, s.Write(context.Background(), flows))
+
+	assert.Equal(t, "INSERT INTO `netlog`.`flows` FORMAT JSONEachRow", query)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "2024-02-14 12:34:56.789", rows[0]["start_time"])
+	assert.Equal(t, 443.0, rows[0]["port"])
+	assert.Equal(t, "Pod", rows[0]["kind"])
+	assert.Equal(t, "Deployment", rows[0]["workload_kind"])
+	assert.Equal(t, "nginx", rows[0]["workload"])
+	assert.Equal(t, map[string]interface{}{"app.kubernetes.io/name": "nginx"}, rows[0]["labels"])
+	assert.Equal(t, map[string]interface{}{"team": "web"}, rows[0]["namespace_labels"])
+}
+
+func TestWriteEvents(t *testing.T) {
+	var query string
+	var rows []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var row map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+			rows = append(rows, row)
+		}
+	}))
+	defer server.Close()
+
+	s, err := New(Options{URL: server.URL, EventsTable: "security_events"})
+	require.NoError(t, err)
+	require.NoError(t, s.WriteEvents(context.Background(), nil))
+	assert.Empty(t, query)
+
+	at := time.Date(2024, 2, 14, 12, 34, 56, 0, time.UTC)
+	require.NoError(t, s.WriteEvents(context.Background(), []types.Event{
+		{Time: at, Type: "syn_flood", Severity: "high", Namespace: "default", Name: "nginx", Destination: "1.1.1.1", Protocol: "TCP", Attributes: map[string]string{"half_open": "1000"}},
+	}))
+	assert.Equal(t, "INSERT INTO `netlog`.`security_events` FORMAT JSONEachRow", query)
+	require.Len(t, rows, 1)
+	assert.Equal(t, "2024-02-14 12:34:56.000", rows[0]["time"])
+	assert.Equal(t, "syn_flood", rows[0]["type"])
+	assert.Equal(t, "", rows[0]["source"])
+	assert.Equal(t, map[string]interface{}{"half_open": "1000"}, rows[0]["attributes"])
+}
+
+func TestSchema(t *testing.T) {
+	ddl := Schema(SchemaOptions{Database: "netlog", Table: "flows", TTLDays: 90})
+	assert.True(t, strings.Contains(ddl, "PARTITION BY toDate(start_time)"))
+	assert.True(t, strings.Contains(ddl, "ORDER BY (namespace, start_time)"))
+	assert.True(t, strings.Contains(ddl, "INTERVAL 90 DAY"))
+	assert.True(t, strings.Contains(ddl, "labels           Map(LowCardinality(String), String)"))
+	assert.True(t, strings.Contains(ddl, "CREATE TABLE IF NOT EXISTS `netlog`.`events`"))
+	assert.True(t, strings.Contains(ddl, "TTL toDate(time) + INTERVAL 90 DAY"))
+}
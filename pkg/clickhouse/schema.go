@@ -0,0 +1,90 @@
+package clickhouse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SchemaOptions configures the generated table DDL
+type SchemaOptions struct {
+	Database    string
+	Table       string
+	EventsTable string
+	// TTLDays drops partitions older than the given number of days (0 keeps
+	// flows and events forever)
+	TTLDays int
+}
+
+// Schema returns the recommended DDL of the flow and event tables. Rows are
+// partitioned by day so old data can be dropped cheaply and ordered by
+// namespace and time, which serves the common per-tenant range queries.
+func Schema(opts SchemaOptions) string {
+	if opts.Database == "" {
+		opts.Database = DefaultDatabase
+	}
+	if opts.Table == "" {
+		opts.Table = DefaultTable
+	}
+	if opts.EventsTable == "" {
+		opts.EventsTable = DefaultEventsTable
+	}
+	database := quoteIdentifier(opts.Database)
+	table := database + "." + quoteIdentifier(opts.Table)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CREATE DATABASE IF NOT EXISTS %s;\n\n", database)
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s\n", table)
+	b.WriteString(`(
+    start_time       DateTime64(3, 'UTC') CODEC(DoubleDelta, ZSTD),
+    end_time         DateTime64(3, 'UTC') CODEC(DoubleDelta, ZSTD),
+    namespace        LowCardinality(String),
+    name             LowCardinality(String),
+    direction        LowCardinality(String),
+    source           IPv4,
+    destination      IPv4,
+    protocol         LowCardinality(String),
+    port             UInt16,
+    total_bytes      UInt64 CODEC(T64, ZSTD),
+    packets          UInt64 CODEC(T64, ZSTD),
+    node             LowCardinality(String),
+    tags             Array(LowCardinality(String)),
+    owner_tags       Array(LowCardinality(String)),
+    kind             LowCardinality(String),
+    workload_kind    LowCardinality(String),
+    workload         LowCardinality(String),
+    labels           Map(LowCardinality(String), String),
+    namespace_labels Map(LowCardinality(String), String)
+)
+ENGINE = MergeTree
+PARTITION BY toDate(start_time)
+ORDER BY (namespace, start_time)
+`)
+	if opts.TTLDays > 0 {
+		fmt.Fprintf(&b, "TTL toDate(start_time) + INTERVAL %d DAY DELETE\n", opts.TTLDays)
+	}
+	b.WriteString("SETTINGS ttl_only_drop_parts = 1;\n\n")
+
+	fmt.Fprintf(&b, "CREATE TABLE IF NOT EXISTS %s.%s\n", database, quoteIdentifier(opts.EventsTable))
+	b.WriteString(`(
+    time        DateTime64(3, 'UTC'),
+    type        LowCardinality(String),
+    severity    LowCardinality(String),
+    namespace   LowCardinality(String),
+    name        LowCardinality(String),
+    source      String,
+    destination String,
+    protocol    LowCardinality(String),
+    message     String,
+    node        LowCardinality(String),
+    attributes  Map(String, String)
+)
+ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (namespace, time)
+`)
+	if opts.TTLDays > 0 {
+		fmt.Fprintf(&b, "TTL toDate(time) + INTERVAL %d DAY DELETE\n", opts.TTLDays)
+	}
+	b.WriteString("SETTINGS ttl_only_drop_parts = 1;\n")
+	return b.String()
+}
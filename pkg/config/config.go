@@ -0,0 +1,380 @@
+// Package config reads the netlog configuration from a YAML or JSON file,
+// environment variables and command line flags
+package config
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/accounting"
+	"github.com/highscaleco/netlog/pkg/alert"
+	"github.com/highscaleco/netlog/pkg/blocklist"
+	"github.com/highscaleco/netlog/pkg/clickhouse"
+	"github.com/highscaleco/netlog/pkg/detect"
+	"github.com/highscaleco/netlog/pkg/elasticsearch"
+	"github.com/highscaleco/netlog/pkg/flowapi"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/otlp"
+	"github.com/highscaleco/netlog/pkg/ownerfile"
+	"github.com/highscaleco/netlog/pkg/sink"
+	"github.com/highscaleco/netlog/pkg/spool"
+	"github.com/highscaleco/netlog/pkg/top"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/yaml"
+)
+
+// Duration is a duration written as a string such as "5m"
+type Duration = alert.Duration
+
+// Config is the configuration of netlog. Settings are read in this order,
+// later sources override earlier ones: defaults, the configuration file,
+// environment variables and command line flags.
+//
+// Each setting can be overridden by an environment variable named after its
+// path, e.g. NETLOG_SINKS_ELASTICSEARCH_PASSWORD for sinks.elasticsearch.password,
+// and by the command line flag in its flag tag.
+type Config struct {
+	Capture    Capture    `json:"capture"`
+	Resolvers  Resolvers  `json:"resolvers"`
+	Metrics    Metrics    `json:"metrics"`
+	Filters    Filters    `json:"filters"`
+	Sinks      Sinks      `json:"sinks"`
+	OTLP       OTLP       `json:"otlp"`
+	Accounting Accounting `json:"accounting"`
+	Alerts     Alerts     `json:"alerts"`
+	Detect     Detect     `json:"detect"`
+	Blocklists Blocklists `json:"blocklists"`
+	Top        Top        `json:"top"`
+	Flows      Flows      `json:"flows"`
+}
+
+// Capture configures the packet capture
+type Capture struct {
+	// Interface is the network interface to capture from
+	Interface string `json:"interface" flag:"interface"`
+}
+
+// Resolvers configures how IP addresses are attributed to workloads
+type Resolvers struct {
+	// Order lists the resolvers tried for an IP until one knows its owner:
+	// informers, static for the owner file, redis for the cached owners and
+	// kubernetes for the OVN floating IPs. Resolvers left out are not used.
+	Order      []string   `json:"order" flag:"resolver-order"`
+	Redis      Redis      `json:"redis"`
+	Kubernetes Kubernetes `json:"kubernetes"`
+	Static     Static     `json:"static"`
+}
+
+// Redis configures the Redis cache of IP owners
+type Redis struct {
+	Addr string `json:"addr" flag:"redis-addr" env:"REDIS_HOST"`
+	// Username selects the ACL user, the default user if empty
+	Username string   `json:"username" flag:"redis-username"`
+	Password string   `json:"password" flag:"redis-password" env:"REDIS_PASSWORD"`
+	DB       int      `json:"db" flag:"redis-db" env:"REDIS_DB"`
+	TLS      RedisTLS `json:"tls"`
+}
+
+// RedisTLS configures TLS towards Redis
+type RedisTLS struct {
+	Enabled bool `json:"enabled" flag:"redis-tls"`
+	// CAFile verifies the server instead of the system roots
+	CAFile string `json:"caFile" flag:"redis-tls-ca-file"`
+	// CertFile and KeyFile authenticate netlog with a client certificate
+	CertFile           string `json:"certFile" flag:"redis-tls-cert-file"`
+	KeyFile            string `json:"keyFile" flag:"redis-tls-key-file"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" flag:"redis-tls-insecure-skip-verify"`
+}
+
+// Kubernetes configures the connection to the Kubernetes API. By default
+// the kubeconfig of KUBECONFIG or ~/.kube/config is used, falling back to
+// the in-cluster configuration.
+type Kubernetes struct {
+	Kubeconfig string `json:"kubeconfig" flag:"kubeconfig"`
+	// Context overrides the current context of the kubeconfig
+	Context string `json:"context" flag:"kube-context"`
+	// InCluster uses the service account of the pod
+	InCluster bool `json:"inCluster" flag:"in-cluster"`
+	// Informers attributes the IPs of Pods, Services and Nodes. It needs
+	// permission to list and watch them.
+	Informers bool `json:"informers" flag:"kube-informers"`
+	// Precedence orders the kinds preferred when an IP belongs to several
+	// objects: pod, service, node and the kube-ovn ovnfip, iptablesfiprule,
+	// ovndnatrule, ovnsnatrule, iptableseip and ovneip. Kinds are case
+	// insensitive, kinds left out are not watched.
+	Precedence []string `json:"precedence" flag:"kube-precedence"`
+	// Workloads walks the owner references of pods up to their workload,
+	// e.g. a Deployment. It needs permission to list and watch ReplicaSets
+	// and KubeVirt VirtualMachineInstances.
+	Workloads bool `json:"workloads" flag:"kube-workloads"`
+	// Labels lists the labels of the owning objects added to flows
+	Labels []string `json:"labels" flag:"kube-labels"`
+	// NamespaceLabels lists the labels of the namespaces of the owners added
+	// to flows. It needs permission to list and watch namespaces.
+	NamespaceLabels []string `json:"namespaceLabels" flag:"kube-namespace-labels"`
+}
+
+// Static configures the owner file, which maps IP addresses and CIDRs to
+// owners without Kubernetes objects
+type Static struct {
+	// File is read as CSV if it ends in .csv and as YAML otherwise,
+	// disabled if empty
+	File           string   `json:"file" flag:"static-owners-file"`
+	ReloadInterval Duration `json:"reloadInterval" flag:"static-owners-reload-interval"`
+}
+
+// Metrics configures the Prometheus metrics and the HTTP API
+type Metrics struct {
+	// Addr is the address of the metrics and API server, disabled if empty
+	Addr             string            `json:"addr" flag:"metrics-addr"`
+	LabelProfile     string            `json:"labelProfile" flag:"metrics-label-profile"`
+	Labels           []string          `json:"labels" flag:"metrics-labels"`
+	RemoteCIDRPrefix int               `json:"remoteCIDRPrefix" flag:"metrics-remote-cidr-prefix"`
+	MaxSeries        int               `json:"maxSeries" flag:"metrics-max-series"`
+	ConstLabels      map[string]string `json:"constLabels" flag:"metrics-const-labels"`
+	// HealthAddr is the address of /healthz and /readyz, the metrics address
+	// if empty
+	HealthAddr string `json:"healthAddr" flag:"health-addr"`
+	// PprofAddr is the address of /debug/pprof, disabled if empty. It may
+	// be the metrics or health address.
+	PprofAddr string `json:"pprofAddr" flag:"pprof-addr"`
+}
+
+// Filters select the flows that are processed. Namespaces are shell
+// patterns, flows without an owner are dropped when Namespaces is set.
+type Filters struct {
+	Namespaces        []string `json:"namespaces"`
+	ExcludeNamespaces []string `json:"excludeNamespaces"`
+	// ExcludeCIDRs drops flows whose source or destination is within one of
+	// the prefixes
+	ExcludeCIDRs []string `json:"excludeCIDRs"`
+}
+
+// Sinks configures where flows are written to
+type Sinks struct {
+	// Format is the format of the standard output (text, json or proto)
+	Format        string        `json:"format" flag:"format"`
+	HTTP          HTTPSink      `json:"http"`
+	Spool         Spool         `json:"spool"`
+	Elasticsearch Elasticsearch `json:"elasticsearch"`
+	ClickHouse    ClickHouse    `json:"clickhouse"`
+	// ShutdownTimeout is how long the open flows are drained into the sinks
+	// on shutdown
+	ShutdownTimeout Duration `json:"shutdownTimeout" flag:"shutdown-timeout"`
+}
+
+// HTTPSink configures the HTTP sink
+type HTTPSink struct {
+	URL string `json:"url" flag:"sink-http-url"`
+}
+
+// Spool configures the disk spool of the network sinks
+type Spool struct {
+	Dir      string `json:"dir" flag:"spool-dir"`
+	MaxBytes int64  `json:"maxBytes" flag:"spool-max-bytes"`
+}
+
+// Elasticsearch configures the Elasticsearch and OpenSearch sink
+type Elasticsearch struct {
+	URLs               []string `json:"urls" flag:"es-url"`
+	Username           string   `json:"username" flag:"es-username"`
+	Password           string   `json:"password" flag:"es-password"`
+	APIKey             string   `json:"apiKey" flag:"es-api-key"`
+	IndexPrefix        string   `json:"indexPrefix" flag:"es-index-prefix"`
+	BulkActions        int      `json:"bulkActions" flag:"es-bulk-actions"`
+	BulkBytes          int      `json:"bulkBytes" flag:"es-bulk-bytes"`
+	MaxRetries         int      `json:"maxRetries" flag:"es-max-retries"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify" flag:"es-insecure-skip-verify"`
+}
+
+// ClickHouse configures the ClickHouse sink
+type ClickHouse struct {
+	URL         string `json:"url" flag:"clickhouse-url"`
+	Username    string `json:"username" flag:"clickhouse-username"`
+	Password    string `json:"password" flag:"clickhouse-password"`
+	Database    string `json:"database" flag:"clickhouse-database"`
+	Table       string `json:"table" flag:"clickhouse-table"`
+	EventsTable string `json:"eventsTable" flag:"clickhouse-events-table"`
+	BatchSize   int    `json:"batchSize" flag:"clickhouse-batch-size"`
+}
+
+// OTLP configures the OpenTelemetry export
+type OTLP struct {
+	Endpoint string `json:"endpoint" flag:"otlp-endpoint"`
+	Protocol string `json:"protocol" flag:"otlp-protocol"`
+	Insecure bool   `json:"insecure" flag:"otlp-insecure"`
+	// Headers are sent with every export, e.g. the token of an
+	// authenticating collector
+	Headers         map[string]string `json:"headers" flag:"otlp-headers"`
+	Export          []string          `json:"export" flag:"otlp-export"`
+	MetricsInterval Duration          `json:"metricsInterval" flag:"otlp-metrics-interval"`
+}
+
+// Accounting configures the usage accounting
+type Accounting struct {
+	Enabled        bool     `json:"enabled" flag:"accounting"`
+	RollupInterval Duration `json:"rollupInterval" flag:"accounting-rollup-interval"`
+}
+
+// Alerts configures the traffic alerts. Rules are read from RulesFile in
+// addition to the inline rules.
+type Alerts struct {
+	RulesFile          string       `json:"rulesFile" flag:"alert-rules"`
+	Rules              []alert.Rule `json:"rules"`
+	WebhookURL         string       `json:"webhookURL" flag:"alert-webhook-url"`
+	AlertmanagerURL    string       `json:"alertmanagerURL" flag:"alertmanager-url"`
+	EvaluationInterval Duration     `json:"evaluationInterval" flag:"alert-evaluation-interval"`
+}
+
+// Detect configures the security detections
+type Detect struct {
+	Enabled            bool     `json:"enabled" flag:"detect"`
+	Window             Duration `json:"window" flag:"detect-window"`
+	Cooldown           Duration `json:"cooldown" flag:"detect-cooldown"`
+	PortScanPorts      int      `json:"portScanPorts" flag:"detect-port-scan-ports"`
+	PortScanHosts      int      `json:"portScanHosts" flag:"detect-port-scan-hosts"`
+	SYNFloodHalfOpen   int      `json:"synFloodHalfOpen" flag:"detect-syn-flood-half-open"`
+	AmplificationBytes int64    `json:"amplificationBytes" flag:"detect-amplification-bytes"`
+	AmplificationRatio float64  `json:"amplificationRatio" flag:"detect-amplification-ratio"`
+}
+
+// Blocklists configures the threat intelligence lists
+type Blocklists struct {
+	// Lists are the lists by name, as [format:]path
+	Lists          map[string]string `json:"lists" flag:"blocklist"`
+	ReloadInterval Duration          `json:"reloadInterval" flag:"blocklist-reload-interval"`
+	Cooldown       Duration          `json:"cooldown" flag:"blocklist-cooldown"`
+}
+
+// Top configures the top talkers rankings
+type Top struct {
+	Enabled      bool       `json:"enabled" flag:"top"`
+	Windows      []Duration `json:"windows" flag:"top-windows"`
+	Capacity     int        `json:"capacity" flag:"top-capacity"`
+	MetricsLimit int        `json:"metricsLimit" flag:"top-metrics-limit"`
+}
+
+// Flows configures the flows API
+type Flows struct {
+	// Buffer is the number of recent flows kept, 0 disables the API
+	Buffer int `json:"buffer" flag:"flows-buffer"`
+}
+
+// Default returns the default configuration
+func Default() *Config {
+	windows := make([]Duration, len(top.DefaultWindows))
+	for i, w := range top.DefaultWindows {
+		windows[i] = Duration(w)
+	}
+	return &Config{
+		Capture: Capture{Interface: "eth0"},
+		Resolvers: Resolvers{
+			Order: []string{"informers", "static", "redis", "kubernetes"},
+			Redis: Redis{Addr: "localhost:6379"},
+			Kubernetes: Kubernetes{
+				Informers:  true,
+				Precedence: []string{"pod", "service", "node", "ovnfip", "iptablesfiprule", "ovndnatrule", "ovnsnatrule", "iptableseip", "ovneip"},
+				Workloads:  true,
+			},
+			Static: Static{ReloadInterval: Duration(ownerfile.DefaultReloadInterval)},
+		},
+		Metrics: Metrics{
+			Addr:             ":9090",
+			LabelProfile:     metrics.ProfileFull,
+			RemoteCIDRPrefix: metrics.DefaultRemoteCIDRPrefix,
+			MaxSeries:        metrics.DefaultMaxSeries,
+		},
+		Sinks: Sinks{
+			Format:          "text",
+			ShutdownTimeout: Duration(sink.DefaultShutdownTimeout),
+			Spool:           Spool{MaxBytes: spool.DefaultMaxBytes},
+			Elasticsearch: Elasticsearch{
+				IndexPrefix: elasticsearch.DefaultIndexPrefix,
+				BulkActions: elasticsearch.DefaultBulkActions,
+				BulkBytes:   elasticsearch.DefaultBulkBytes,
+				MaxRetries:  elasticsearch.DefaultMaxRetries,
+			},
+			ClickHouse: ClickHouse{
+				Database:    clickhouse.DefaultDatabase,
+				Table:       clickhouse.DefaultTable,
+				EventsTable: clickhouse.DefaultEventsTable,
+				BatchSize:   clickhouse.DefaultBatchSize,
+			},
+		},
+		OTLP: OTLP{
+			Protocol:        otlp.ProtocolGRPC,
+			Export:          []string{"flows", "metrics"},
+			MetricsInterval: Duration(otlp.DefaultMetricsInterval),
+		},
+		Accounting: Accounting{RollupInterval: Duration(accounting.DefaultRollupInterval)},
+		Alerts:     Alerts{EvaluationInterval: Duration(alert.DefaultEvaluationInterval)},
+		Detect: Detect{
+			Window:             Duration(detect.DefaultWindow),
+			Cooldown:           Duration(detect.DefaultCooldown),
+			PortScanPorts:      detect.DefaultPortScanPorts,
+			PortScanHosts:      detect.DefaultPortScanHosts,
+			SYNFloodHalfOpen:   detect.DefaultSYNFloodHalfOpen,
+			AmplificationBytes: detect.DefaultAmplificationBytes,
+			AmplificationRatio: detect.DefaultAmplificationRatio,
+		},
+		Blocklists: Blocklists{
+			ReloadInterval: Duration(blocklist.DefaultReloadInterval),
+			Cooldown:       Duration(blocklist.DefaultCooldown),
+		},
+		Top: Top{
+			Enabled:      true,
+			Windows:      windows,
+			Capacity:     top.DefaultCapacity,
+			MetricsLimit: top.DefaultMetricsLimit,
+		},
+		Flows: Flows{Buffer: flowapi.DefaultCapacity},
+	}
+}
+
+// Parse reads a YAML or JSON configuration over the defaults. Unknown
+// settings are rejected.
+func Parse(data []byte) (*Config, error) {
+	cfg := Default()
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Load reads the configuration from filename, or the defaults if filename
+// is empty, applies the environment variables and the flags set on the
+// command line and validates the result. flags may be nil.
+func Load(filename string, flags *pflag.FlagSet) (*Config, error) {
+	cfg := Default()
+	if filename != "" {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if cfg, err = Parse(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if flags != nil {
+		if err := cfg.ApplyFlags(flags); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Durations converts durations read from a configuration
+func Durations(ds []Duration) []time.Duration {
+	out := make([]time.Duration, len(ds))
+	for i, d := range ds {
+		out[i] = time.Duration(d)
+	}
+	return out
+}
@@ -0,0 +1,230 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+capture:
+  interface: ens5
+resolvers:
+  redis:
+    addr: redis:6379
+    tls:
+      enabled: true
+  kubernetes:
+    context: prod
+    precedence: [service, pod]
+metrics:
+  labelProfile: workload
+  constLabels:
+    cluster: prod
+filters:
+  excludeNamespaces: ["kube-*"]
+  excludeCIDRs: ["169.254.0.0/16"]
+sinks:
+  format: json
+  elasticsearch:
+    urls: ["http://es:9200"]
+alerts:
+  rules:
+  - name: egress
+    maxBytes: 1Gi
+top:
+  windows: [30s, 10m]
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse([]byte(testConfig))
+	require.NoError(t, err)
+	require.NoError(t, cfg.Validate())
+
+	assert.Equal(t, "ens5", cfg.Capture.Interface)
+	assert.Equal(t, "redis:6379", cfg.Resolvers.Redis.Addr)
+	assert.True(t, cfg.Resolvers.Redis.TLS.Enabled)
+	assert.Equal(t, "prod", cfg.Resolvers.Kubernetes.Context)
+	assert.True(t, cfg.Resolvers.Kubernetes.Informers)
+	assert.Equal(t, []string{"service", "pod"}, cfg.Resolvers.Kubernetes.Precedence)
+	assert.Equal(t, map[string]string{"cluster": "prod"}, cfg.Metrics.ConstLabels)
+	assert.Equal(t, []string{"http://es:9200"}, cfg.Sinks.Elasticsearch.URLs)
+	assert.Equal(t, []time.Duration{30 * time.Second, 10 * time.Minute}, Durations(cfg.Top.Windows))
+
+	// Settings missing from the file keep their defaults
+	assert.Equal(t, ":9090", cfg.Metrics.Addr)
+	assert.Equal(t, Default().Sinks.Elasticsearch.BulkActions, cfg.Sinks.Elasticsearch.BulkActions)
+
+	rules, err := cfg.Alerts.LoadRules()
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	assert.Equal(t, Duration(5*time.Minute), rules[0].Window)
+
+	_, err = Parse([]byte("metrics:\n  adress: :9091\n"))
+	assert.ErrorContains(t, err, "adress")
+	_, err = Parse([]byte("top:\n  windows: [5]\n"))
+	assert.Error(t, err)
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"NETLOG_METRICS_REMOTE_CIDR_PREFIX":  "16",
+		"NETLOG_SINKS_ELASTICSEARCH_API_KEY": "secret",
+		"NETLOG_METRICS_CONST_LABELS":        "cluster=prod,node=a",
+		"NETLOG_TOP_WINDOWS":                 "1m, 1h",
+		"NETLOG_DETECT_ENABLED":              "true",
+		"NETLOG_RESOLVERS_REDIS_TLS_CA_FILE": "/etc/redis/ca.pem",
+		"REDIS_HOST":                         "legacy:6379",
+		"REDIS_DB":                           "2",
+	}
+	cfg := Default()
+	require.NoError(t, cfg.ApplyEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}))
+	assert.Equal(t, 16, cfg.Metrics.RemoteCIDRPrefix)
+	assert.Equal(t, "secret", cfg.Sinks.Elasticsearch.APIKey)
+	assert.Equal(t, map[string]string{"cluster": "prod", "node": "a"}, cfg.Metrics.ConstLabels)
+	assert.Equal(t, []time.Duration{time.Minute, time.Hour}, Durations(cfg.Top.Windows))
+	assert.True(t, cfg.Detect.Enabled)
+	assert.Equal(t, "/etc/redis/ca.pem", cfg.Resolvers.Redis.TLS.CAFile)
+	assert.Equal(t, "legacy:6379", cfg.Resolvers.Redis.Addr)
+	assert.Equal(t, 2, cfg.Resolvers.Redis.DB)
+
+	// The prefixed variable takes precedence over the legacy one
+	env["NETLOG_RESOLVERS_REDIS_ADDR"] = "redis:6379"
+	require.NoError(t, cfg.ApplyEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}))
+	assert.Equal(t, "redis:6379", cfg.Resolvers.Redis.Addr)
+
+	err := Default().ApplyEnv(func(name string) (string, bool) {
+		return "x", name == "NETLOG_FLOWS_BUFFER"
+	})
+	assert.ErrorContains(t, err, "NETLOG_FLOWS_BUFFER")
+}
+
+func TestApplyFlags(t *testing.T) {
+	flags := pflag.NewFlagSet("netlog", pflag.ContinueOnError)
+	flags.String("format", "text", "")
+	flags.String("metrics-addr", ":9090", "")
+	flags.DurationSlice("top-windows", nil, "")
+	flags.StringToString("blocklist", nil, "")
+	flags.Duration("detect-window", 0, "")
+	flags.Bool("redis-tls", false, "")
+	flags.Bool("in-cluster", false, "")
+	require.NoError(t, flags.Parse([]string{"--format", "proto", "--top-windows", "2m", "--blocklist", "spamhaus=drop.txt", "--detect-window", "30s", "--redis-tls", "--in-cluster"}))
+
+	cfg, err := Parse([]byte("metrics:\n  addr: :9191\nsinks:\n  format: json\n"))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyFlags(flags))
+
+	// Only flags set on the command line override the file
+	assert.Equal(t, "proto", cfg.Sinks.Format)
+	assert.Equal(t, ":9191", cfg.Metrics.Addr)
+	assert.Equal(t, []time.Duration{2 * time.Minute}, Durations(cfg.Top.Windows))
+	assert.Equal(t, map[string]string{"spamhaus": "drop.txt"}, cfg.Blocklists.Lists)
+	assert.Equal(t, Duration(30*time.Second), cfg.Detect.Window)
+	assert.True(t, cfg.Resolvers.Redis.TLS.Enabled)
+	assert.True(t, cfg.Resolvers.Kubernetes.InCluster)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(filename, []byte(testConfig), 0o644))
+	t.Setenv("NETLOG_CAPTURE_INTERFACE", "eth1")
+
+	cfg, err := Load(filename, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "eth1", cfg.Capture.Interface)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"), nil)
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Default().Validate())
+
+	cfg := Default()
+	cfg.Sinks.Format = "xml"
+	cfg.Sinks.HTTP.URL = "collector:8080"
+	cfg.Metrics.LabelProfile = "everything"
+	cfg.Filters.ExcludeCIDRs = []string{"10.0.0.0/33"}
+	cfg.Alerts.RulesFile = filepath.Join(t.TempDir(), "missing.yaml")
+	cfg.Blocklists.Lists = map[string]string{"empty": ""}
+	cfg.Top.Windows = nil
+	cfg.Resolvers.Redis.TLS.CertFile = "client.pem"
+	cfg.Resolvers.Kubernetes = Kubernetes{InCluster: true, Kubeconfig: "admin.conf", Informers: true, Precedence: []string{"pod", "pod", "deployment"}}
+	cfg.Resolvers.Order = []string{"static", "redis", "static", "fip"}
+
+	err := cfg.Validate()
+	require.Error(t, err)
+	for _, setting := range []string{"resolvers.order", "resolvers.redis.tls", "resolvers.kubernetes.inCluster", "resolvers.kubernetes.precedence", "sinks.format", "sinks.http.url", "metrics.labelProfile", "filters", "alerts", "blocklists.lists", "top.windows"} {
+		assert.Contains(t, err.Error(), setting+":")
+	}
+	// The certificate misses its key and TLS is not enabled, the order and
+	// the precedence repeat an entry and have an unknown one
+	assert.Len(t, strings.Split(err.Error(), "\n"), 14)
+
+	cfg = Default()
+	cfg.Metrics.Labels = []string{"namespace", "label_team"}
+	assert.ErrorContains(t, cfg.Validate(), "metrics.labels: label_team is not a label")
+	cfg.Metrics.Labels = []string{"namespace", "workload", "label_app_kubernetes_io_name", "namespace_label_team"}
+	cfg.Resolvers.Kubernetes.Labels = []string{"app.kubernetes.io/name"}
+	cfg.Resolvers.Kubernetes.NamespaceLabels = []string{"team"}
+	cfg.Resolvers.Kubernetes.Precedence = []string{"Pod", "OvnSnatRule", "iptableseip"}
+	cfg.Resolvers.Order = []string{"static", "informers"}
+	assert.NoError(t, cfg.Validate())
+
+	// Events are only written to stdout as text or JSON
+	cfg.Sinks.Format = "proto"
+	assert.NoError(t, cfg.Validate())
+	cfg.Detect.Enabled = true
+	assert.ErrorContains(t, cfg.Validate(), "sinks.format: the proto format can't carry the events")
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter(Filters{
+		Namespaces:        []string{"tenant-*"},
+		ExcludeNamespaces: []string{"tenant-test"},
+		ExcludeCIDRs:      []string{"169.254.169.254/32"},
+	})
+	require.NoError(t, err)
+
+	flow := types.AggregatedInfo{Namespace: "tenant-a", Source: "10.0.0.1", Destination: "8.8.8.8"}
+	assert.True(t, filter.Match(flow))
+	flow.Destination = "169.254.169.254"
+	assert.False(t, filter.Match(flow))
+	assert.False(t, filter.Match(types.AggregatedInfo{Namespace: "tenant-test"}))
+	assert.False(t, filter.Match(types.AggregatedInfo{Namespace: "default"}))
+	assert.False(t, filter.Match(types.AggregatedInfo{}))
+
+	all, err := NewFilter(Filters{})
+	require.NoError(t, err)
+	assert.True(t, all.Match(types.AggregatedInfo{}))
+
+	_, err = NewFilter(Filters{Namespaces: []string{"["}})
+	assert.Error(t, err)
+}
+
+func TestEnvName(t *testing.T) {
+	for name, want := range map[string]string{
+		"addr":             "ADDR",
+		"remoteCIDRPrefix": "REMOTE_CIDR_PREFIX",
+		"apiKey":           "API_KEY",
+		"synFloodHalfOpen": "SYN_FLOOD_HALF_OPEN",
+		"webhookURL":       "WEBHOOK_URL",
+		"excludeCIDRs":     "EXCLUDE_CIDRS",
+	} {
+		assert.Equal(t, want, envName(name), name)
+	}
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/spf13/pflag"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding settings
+const EnvPrefix = "NETLOG_"
+
+var (
+	durationType      = reflect.TypeOf(Duration(0))
+	durationSliceType = reflect.TypeOf([]Duration(nil))
+	stringSliceType   = reflect.TypeOf([]string(nil))
+	stringMapType     = reflect.TypeOf(map[string]string(nil))
+)
+
+// setting is a field of the configuration that can be set from a string
+type setting struct {
+	// path is the path of the field in the file, e.g. sinks.http.url
+	path  string
+	field reflect.StructField
+	value reflect.Value
+}
+
+// env returns the environment variables of the setting, the variable named
+// after its path and the one in its env tag
+func (s setting) env() []string {
+	name := EnvPrefix
+	for i, part := range strings.Split(s.path, ".") {
+		if i > 0 {
+			name += "_"
+		}
+		name += envName(part)
+	}
+	names := []string{name}
+	if alias := s.field.Tag.Get("env"); alias != "" {
+		names = append(names, alias)
+	}
+	return names
+}
+
+// envName converts a camel case name to upper snake case, e.g.
+// remoteCIDRPrefix to REMOTE_CIDR_PREFIX and excludeCIDRs to EXCLUDE_CIDRS
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prevLower := unicode.IsLower(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// The s of plural acronyms such as CIDRs is not a new word
+			plural := nextLower && runes[i+1] == 's' && (i+2 == len(runes) || unicode.IsUpper(runes[i+2]))
+			if prevLower || (nextLower && !plural && unicode.IsUpper(runes[i-1])) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// settings returns the fields of cfg that can be set from a string
+func (c *Config) settings() []setting {
+	var settings []setting
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			path := prefix + name
+			switch {
+			case field.Type.Kind() == reflect.Struct:
+				walk(v.Field(i), path+".")
+			case settable(field.Type):
+				settings = append(settings, setting{path: path, field: field, value: v.Field(i)})
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), "")
+	return settings
+}
+
+func settable(t reflect.Type) bool {
+	switch t {
+	case durationType, durationSliceType, stringSliceType, stringMapType:
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// ApplyEnv overrides the settings with the environment variables returned
+// by lookup. Lists are separated by commas and maps written as k=v,k2=v2.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	for _, s := range c.settings() {
+		for _, name := range s.env() {
+			value, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := setString(s.value, value); err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// ApplyFlags overrides the settings with the flags set on the command line
+func (c *Config) ApplyFlags(flags *pflag.FlagSet) error {
+	for _, s := range c.settings() {
+		name := s.field.Tag.Get("flag")
+		if name == "" {
+			continue
+		}
+		flag := flags.Lookup(name)
+		if flag == nil || !flag.Changed {
+			continue
+		}
+		if err := setFlag(s.value, flags, name); err != nil {
+			return fmt.Errorf("invalid --%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// setFlag sets v to the value of the flag name
+func setFlag(v reflect.Value, flags *pflag.FlagSet, name string) error {
+	var value any
+	var err error
+	switch v.Type() {
+	case durationType:
+		var d time.Duration
+		d, err = flags.GetDuration(name)
+		value = Duration(d)
+	case durationSliceType:
+		var ds []time.Duration
+		ds, err = flags.GetDurationSlice(name)
+		windows := make([]Duration, len(ds))
+		for i, d := range ds {
+			windows[i] = Duration(d)
+		}
+		value = windows
+	case stringSliceType:
+		value, err = flags.GetStringSlice(name)
+	case stringMapType:
+		value, err = flags.GetStringToString(name)
+	default:
+		switch v.Kind() {
+		case reflect.String:
+			value, err = flags.GetString(name)
+		case reflect.Bool:
+			value, err = flags.GetBool(name)
+		case reflect.Int:
+			value, err = flags.GetInt(name)
+		case reflect.Int64:
+			value, err = flags.GetInt64(name)
+		case reflect.Float64:
+			value, err = flags.GetFloat64(name)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(value))
+	return nil
+}
+
+// setString parses s into v
+func setString(v reflect.Value, s string) error {
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case durationSliceType:
+		var ds []Duration
+		for _, part := range splitList(s) {
+			d, err := time.ParseDuration(part)
+			if err != nil {
+				return err
+			}
+			ds = append(ds, Duration(d))
+		}
+		v.Set(reflect.ValueOf(ds))
+		return nil
+	case stringSliceType:
+		v.Set(reflect.ValueOf(splitList(s)))
+		return nil
+	case stringMapType:
+		m := make(map[string]string)
+		for _, part := range splitList(s) {
+			key, value, ok := strings.Cut(part, "=")
+			if !ok {
+				return fmt.Errorf("%q must be formatted as key=value", part)
+			}
+			m[key] = value
+		}
+		v.Set(reflect.ValueOf(m))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list, ignoring empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// Filter selects flows according to the filters of a configuration
+type Filter struct {
+	namespaces        []string
+	excludeNamespaces []string
+	excludeCIDRs      []netip.Prefix
+}
+
+// NewFilter creates a filter, an empty configuration selects every flow
+func NewFilter(f Filters) (*Filter, error) {
+	for _, pattern := range append(append([]string(nil), f.Namespaces...), f.ExcludeNamespaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern %q: %w", pattern, err)
+		}
+	}
+	filter := &Filter{namespaces: f.Namespaces, excludeNamespaces: f.ExcludeNamespaces}
+	for _, cidr := range f.ExcludeCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		filter.excludeCIDRs = append(filter.excludeCIDRs, prefix.Masked())
+	}
+	return filter, nil
+}
+
+// Match reports whether the flow is selected
+func (f *Filter) Match(flow types.AggregatedInfo) bool {
+	if len(f.namespaces) > 0 && !matchAny(f.namespaces, flow.Namespace) {
+		return false
+	}
+	if matchAny(f.excludeNamespaces, flow.Namespace) {
+		return false
+	}
+	for _, ip := range []string{flow.Source, flow.Destination} {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			continue
+		}
+		for _, prefix := range f.excludeCIDRs {
+			if prefix.Contains(addr.Unmap()) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchAny reports whether namespace matches one of the patterns, flows
+// without an owner match none
+func matchAny(patterns []string, namespace string) bool {
+	if namespace == "" {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
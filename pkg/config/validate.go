@@ -0,0 +1,184 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/highscaleco/netlog/pkg/alert"
+	"github.com/highscaleco/netlog/pkg/blocklist"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/otlp"
+)
+
+// Validate checks the configuration and returns every problem found. The
+// rule file and the inline rules of the alerts are validated as well.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, setting, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(c.Capture.Interface != "", "capture.interface", "cannot be empty")
+
+	check(len(c.Resolvers.Order) > 0, "resolvers.order", "cannot be empty")
+	seenResolvers := make(map[string]bool)
+	for _, name := range c.Resolvers.Order {
+		switch name {
+		case "informers", "static", "redis", "kubernetes":
+			check(!seenResolvers[name], "resolvers.order", "duplicate resolver %s", name)
+			seenResolvers[name] = true
+		default:
+			check(false, "resolvers.order", "unknown resolver %s", name)
+		}
+	}
+	check(c.Resolvers.Static.ReloadInterval > 0, "resolvers.static.reloadInterval", "must be positive")
+
+	check(c.Resolvers.Redis.Addr != "", "resolvers.redis.addr", "cannot be empty")
+	check(c.Resolvers.Redis.DB >= 0, "resolvers.redis.db", "cannot be negative")
+	redisTLS := c.Resolvers.Redis.TLS
+	check((redisTLS.CertFile == "") == (redisTLS.KeyFile == ""), "resolvers.redis.tls", "certFile and keyFile must be set together")
+	check(redisTLS.Enabled || (redisTLS.CAFile == "" && redisTLS.CertFile == "" && !redisTLS.InsecureSkipVerify), "resolvers.redis.tls", "caFile, certFile and insecureSkipVerify require enabled")
+	check(!c.Resolvers.Kubernetes.InCluster || (c.Resolvers.Kubernetes.Kubeconfig == "" && c.Resolvers.Kubernetes.Context == ""), "resolvers.kubernetes.inCluster", "cannot be combined with kubeconfig or context")
+	if c.Resolvers.Kubernetes.Informers {
+		check(len(c.Resolvers.Kubernetes.Precedence) > 0, "resolvers.kubernetes.precedence", "cannot be empty")
+		seen := make(map[string]bool)
+		for _, kind := range c.Resolvers.Kubernetes.Precedence {
+			switch strings.ToLower(kind) {
+			case "pod", "service", "node", "ovnfip", "iptablesfiprule", "ovndnatrule", "ovnsnatrule", "iptableseip", "ovneip":
+				check(!seen[strings.ToLower(kind)], "resolvers.kubernetes.precedence", "duplicate kind %s", kind)
+				seen[strings.ToLower(kind)] = true
+			default:
+				check(false, "resolvers.kubernetes.precedence", "unknown kind %s", kind)
+			}
+		}
+	}
+
+	if len(c.Metrics.Labels) == 0 {
+		_, err := metrics.ProfileLabels(c.Metrics.LabelProfile)
+		check(err == nil, "metrics.labelProfile", "unknown profile %s", c.Metrics.LabelProfile)
+	}
+	kubeLabels := make(map[string]bool)
+	for _, key := range c.Resolvers.Kubernetes.Labels {
+		kubeLabels[metrics.KubernetesLabel(key)] = true
+	}
+	for _, key := range c.Resolvers.Kubernetes.NamespaceLabels {
+		kubeLabels[metrics.NamespaceLabel(key)] = true
+	}
+	for _, label := range c.Metrics.Labels {
+		if strings.HasPrefix(label, "label_") || strings.HasPrefix(label, "namespace_label_") {
+			check(kubeLabels[label], "metrics.labels", "%s is not a label of resolvers.kubernetes.labels or namespaceLabels", label)
+		}
+	}
+	check(c.Metrics.RemoteCIDRPrefix > 0 && c.Metrics.RemoteCIDRPrefix <= 32, "metrics.remoteCIDRPrefix", "must be between 1 and 32")
+	check(c.Metrics.MaxSeries >= 0, "metrics.maxSeries", "cannot be negative")
+
+	if _, err := NewFilter(c.Filters); err != nil {
+		errs = append(errs, fmt.Errorf("filters: %w", err))
+	}
+
+	switch c.Sinks.Format {
+	case "text", "json":
+	case "proto":
+		// The protobuf stream only holds flows
+		check(!c.Detect.Enabled && len(c.Blocklists.Lists) == 0, "sinks.format", "the proto format can't carry the events of detect and blocklists")
+	default:
+		check(false, "sinks.format", "unknown format %s", c.Sinks.Format)
+	}
+	checkURL := func(setting, s string) {
+		if s == "" {
+			return
+		}
+		u, err := url.Parse(s)
+		check(err == nil && u.Scheme != "" && u.Host != "", setting, "invalid URL %s", s)
+	}
+	checkURL("sinks.http.url", c.Sinks.HTTP.URL)
+	check(c.Sinks.Spool.MaxBytes > 0, "sinks.spool.maxBytes", "must be positive")
+	check(c.Sinks.ShutdownTimeout > 0, "sinks.shutdownTimeout", "must be positive")
+	for _, u := range c.Sinks.Elasticsearch.URLs {
+		checkURL("sinks.elasticsearch.urls", u)
+	}
+	check(c.Sinks.Elasticsearch.BulkActions > 0, "sinks.elasticsearch.bulkActions", "must be positive")
+	check(c.Sinks.Elasticsearch.BulkBytes > 0, "sinks.elasticsearch.bulkBytes", "must be positive")
+	check(c.Sinks.Elasticsearch.MaxRetries >= 0, "sinks.elasticsearch.maxRetries", "cannot be negative")
+	checkURL("sinks.clickhouse.url", c.Sinks.ClickHouse.URL)
+	check(c.Sinks.ClickHouse.BatchSize > 0, "sinks.clickhouse.batchSize", "must be positive")
+
+	switch c.OTLP.Protocol {
+	case otlp.ProtocolGRPC, otlp.ProtocolHTTP:
+	default:
+		check(false, "otlp.protocol", "unknown protocol %s", c.OTLP.Protocol)
+	}
+	for _, signal := range c.OTLP.Export {
+		check(signal == "flows" || signal == "metrics", "otlp.export", "unknown signal %s", signal)
+	}
+	check(c.OTLP.MetricsInterval > 0, "otlp.metricsInterval", "must be positive")
+
+	check(c.Accounting.RollupInterval > 0, "accounting.rollupInterval", "must be positive")
+
+	if _, err := c.Alerts.LoadRules(); err != nil {
+		errs = append(errs, fmt.Errorf("alerts: %w", err))
+	}
+	checkURL("alerts.webhookURL", c.Alerts.WebhookURL)
+	checkURL("alerts.alertmanagerURL", c.Alerts.AlertmanagerURL)
+	check(c.Alerts.EvaluationInterval > 0, "alerts.evaluationInterval", "must be positive")
+
+	check(c.Detect.Window > 0, "detect.window", "must be positive")
+	check(c.Detect.Cooldown >= 0, "detect.cooldown", "cannot be negative")
+	check(c.Detect.PortScanPorts > 0, "detect.portScanPorts", "must be positive")
+	check(c.Detect.PortScanHosts > 0, "detect.portScanHosts", "must be positive")
+	check(c.Detect.SYNFloodHalfOpen > 0, "detect.synFloodHalfOpen", "must be positive")
+	check(c.Detect.AmplificationBytes > 0, "detect.amplificationBytes", "must be positive")
+	check(c.Detect.AmplificationRatio > 0, "detect.amplificationRatio", "must be positive")
+
+	if _, err := c.Blocklists.ParseLists(); err != nil {
+		errs = append(errs, fmt.Errorf("blocklists.lists: %w", err))
+	}
+	check(c.Blocklists.ReloadInterval > 0, "blocklists.reloadInterval", "must be positive")
+	check(c.Blocklists.Cooldown >= 0, "blocklists.cooldown", "cannot be negative")
+
+	if c.Top.Enabled {
+		check(len(c.Top.Windows) > 0, "top.windows", "cannot be empty")
+		for _, w := range c.Top.Windows {
+			check(w > 0, "top.windows", "must be positive")
+		}
+		check(c.Top.Capacity > 0, "top.capacity", "must be positive")
+		check(c.Top.MetricsLimit >= 0, "top.metricsLimit", "cannot be negative")
+	}
+
+	check(c.Flows.Buffer >= 0, "flows.buffer", "cannot be negative")
+
+	return errors.Join(errs...)
+}
+
+// LoadRules returns the rules of the rule file followed by the inline rules
+func (a Alerts) LoadRules() ([]alert.Rule, error) {
+	var rules []alert.Rule
+	if a.RulesFile != "" {
+		var err error
+		if rules, err = alert.LoadRules(a.RulesFile); err != nil {
+			return nil, err
+		}
+	}
+	rules = append(rules, a.Rules...)
+	if err := alert.ValidateRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ParseLists returns the lists
+func (b Blocklists) ParseLists() ([]blocklist.List, error) {
+	var lists []blocklist.List
+	for name, spec := range b.Lists {
+		list, err := blocklist.ParseList(name, spec)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
@@ -0,0 +1,451 @@
+package detect
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// EventPortScan is raised when a source touches many ports or hosts
+	EventPortScan = "port_scan"
+	// EventSYNFlood is raised when a destination has many half-open connections
+	EventSYNFlood = "syn_flood"
+	// EventUDPAmplification is raised when a UDP service reflects far more
+	// traffic than it receives, or a destination is flooded by reflected traffic
+	EventUDPAmplification = "udp_amplification"
+
+	// SeverityMedium is the severity of suspicious activity
+	SeverityMedium = "medium"
+	// SeverityHigh is the severity of ongoing attacks
+	SeverityHigh = "high"
+
+	// DefaultWindow is the default time range packets are counted over
+	DefaultWindow = time.Minute
+	// DefaultCooldown is the default minimum time between two events of the
+	// same type for the same source or destination
+	DefaultCooldown = 5 * time.Minute
+	// DefaultPortScanPorts is the default number of distinct ports of a port scan
+	DefaultPortScanPorts = 100
+	// DefaultPortScanHosts is the default number of distinct hosts of a port scan
+	DefaultPortScanHosts = 50
+	// DefaultSYNFloodHalfOpen is the default number of half-open connections of a SYN flood
+	DefaultSYNFloodHalfOpen = 1000
+	// DefaultAmplificationBytes is the default minimum of reflected bytes of an amplification
+	DefaultAmplificationBytes = 10 << 20
+	// DefaultAmplificationRatio is the default minimum ratio of reflected to requested bytes
+	DefaultAmplificationRatio = 10
+	// DefaultMaxTracked is the default maximum number of sources or destinations tracked per detection
+	DefaultMaxTracked = 100000
+
+	// ephemeralPortStart is the first port considered a client port, UDP
+	// packets to client ports are replies and don't count towards scans
+	ephemeralPortStart = 32768
+)
+
+// amplificationPorts are UDP services commonly abused for reflection
+var amplificationPorts = map[uint16]string{
+	19:    "chargen",
+	53:    "dns",
+	111:   "portmap",
+	123:   "ntp",
+	161:   "snmp",
+	389:   "cldap",
+	1900:  "ssdp",
+	5353:  "mdns",
+	11211: "memcached",
+}
+
+// Options configures the thresholds of the detections
+type Options struct {
+	// Window is the time range packets are counted over
+	Window time.Duration
+	// Cooldown is the minimum time between two events of the same type for
+	// the same source or destination
+	Cooldown time.Duration
+	// PortScanPorts is the number of distinct ports a source has to touch
+	PortScanPorts int
+	// PortScanHosts is the number of distinct hosts a source has to touch
+	PortScanHosts int
+	// SYNFloodHalfOpen is the number of half-open connections to a destination
+	SYNFloodHalfOpen int
+	// AmplificationBytes is the minimum of reflected bytes
+	AmplificationBytes int64
+	// AmplificationRatio is the minimum ratio of reflected to requested bytes
+	AmplificationRatio float64
+	// MaxTracked bounds the memory used by each detection
+	MaxTracked int
+}
+
+// Detector inspects captured packets for port scans, SYN floods and UDP
+// amplification. It is a capture packet observer, events are delivered on
+// the channel returned by Events.
+type Detector struct {
+	opts     Options
+	recorder *metrics.Recorder
+	events   chan types.Event
+
+	mu        sync.Mutex
+	scans     map[string]*scanState
+	floods    map[string]*floodState
+	amps      map[ampKey]*ampState
+	lastEvent map[string]time.Time
+}
+
+// owner is the namespace and name of the local side of a packet
+type owner struct {
+	namespace string
+	name      string
+}
+
+// scanState counts the targets of a source
+type scanState struct {
+	start time.Time
+	ports map[uint16]struct{}
+	hosts map[string]struct{}
+	owner owner
+}
+
+// floodState tracks the half-open connections of a destination
+type floodState struct {
+	start    time.Time
+	halfOpen map[string]struct{}
+	owner    owner
+}
+
+// ampKey identifies a UDP service port of a local IP
+type ampKey struct {
+	ip   string
+	port uint16
+}
+
+// ampState counts the traffic of an amplification port of a local IP. As
+// a reflector the local IP serves the port, as a victim it receives replies
+// from remote servers on the port.
+type ampState struct {
+	start        time.Time
+	reflectorOut int64
+	reflectorIn  int64
+	victimIn     int64
+	victimOut    int64
+	owner        owner
+	lastRemote   string
+}
+
+// New creates a detector, thresholds that are not set use the defaults
+func New(opts Options, rec *metrics.Recorder) *Detector {
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = DefaultCooldown
+	}
+	if opts.PortScanPorts <= 0 {
+		opts.PortScanPorts = DefaultPortScanPorts
+	}
+	if opts.PortScanHosts <= 0 {
+		opts.PortScanHosts = DefaultPortScanHosts
+	}
+	if opts.SYNFloodHalfOpen <= 0 {
+		opts.SYNFloodHalfOpen = DefaultSYNFloodHalfOpen
+	}
+	if opts.AmplificationBytes <= 0 {
+		opts.AmplificationBytes = DefaultAmplificationBytes
+	}
+	if opts.AmplificationRatio <= 0 {
+		opts.AmplificationRatio = DefaultAmplificationRatio
+	}
+	if opts.MaxTracked <= 0 {
+		opts.MaxTracked = DefaultMaxTracked
+	}
+	return &Detector{
+		opts:      opts,
+		recorder:  rec,
+		events:    make(chan types.Event, 100),
+		scans:     make(map[string]*scanState),
+		floods:    make(map[string]*floodState),
+		amps:      make(map[ampKey]*ampState),
+		lastEvent: make(map[string]time.Time),
+	}
+}
+
+// Events returns the channel security events are delivered on. Events are
+// dropped when it is full.
+func (d *Detector) Events() <-chan types.Event {
+	return d.events
+}
+
+// ObservePacket counts a captured packet towards the detections
+func (d *Detector) ObservePacket(pkt types.Packet) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch pkt.Protocol {
+	case "TCP":
+		if pkt.SYN && !pkt.ACK {
+			d.observeScan(pkt)
+		}
+		d.observeFlood(pkt)
+	case "UDP":
+		if pkt.DestinationPort < ephemeralPortStart {
+			d.observeScan(pkt)
+		}
+		d.observeAmplification(pkt)
+	}
+}
+
+// Run drops expired state every window until ctx is cancelled
+func (d *Detector) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.opts.Window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			d.cleanup(time.Now())
+		}
+	}
+}
+
+// cleanup removes state older than the window and expired cooldowns
+func (d *Detector) cleanup(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, s := range d.scans {
+		if now.Sub(s.start) > d.opts.Window {
+			delete(d.scans, key)
+		}
+	}
+	for key, s := range d.floods {
+		if now.Sub(s.start) > d.opts.Window {
+			delete(d.floods, key)
+		}
+	}
+	for key, s := range d.amps {
+		if now.Sub(s.start) > d.opts.Window {
+			delete(d.amps, key)
+		}
+	}
+	for key, t := range d.lastEvent {
+		if now.Sub(t) > d.opts.Cooldown {
+			delete(d.lastEvent, key)
+		}
+	}
+}
+
+// observeScan counts the target of a connection attempt towards a scan by
+// its source. Only inbound attempts count, busy clients in the cluster such
+// as proxies and crawlers reach many hosts and ports too.
+func (d *Detector) observeScan(pkt types.Packet) {
+	if pkt.Direction != "inbound" {
+		return
+	}
+	source := pkt.Source.String()
+	s, ok := d.scans[source]
+	if !ok || pkt.Timestamp.Sub(s.start) > d.opts.Window {
+		if !ok && len(d.scans) >= d.opts.MaxTracked {
+			return
+		}
+		s = &scanState{
+			start: pkt.Timestamp,
+			ports: make(map[uint16]struct{}),
+			hosts: make(map[string]struct{}),
+		}
+		d.scans[source] = s
+	}
+	if s.owner.namespace == "" {
+		s.owner = owner{namespace: pkt.Namespace, name: pkt.Name}
+	}
+
+	s.ports[pkt.DestinationPort] = struct{}{}
+	s.hosts[pkt.Destination.String()] = struct{}{}
+	if len(s.ports) < d.opts.PortScanPorts && len(s.hosts) < d.opts.PortScanHosts {
+		return
+	}
+
+	d.emit(EventPortScan, source, types.Event{
+		Time:      pkt.Timestamp,
+		Type:      EventPortScan,
+		Severity:  SeverityMedium,
+		Namespace: s.owner.namespace,
+		Name:      s.owner.name,
+		Source:    source,
+		Message:   fmt.Sprintf("%s touched %d ports on %d hosts within %s", source, len(s.ports), len(s.hosts), d.opts.Window),
+		Attributes: map[string]string{
+			"ports": strconv.Itoa(len(s.ports)),
+			"hosts": strconv.Itoa(len(s.hosts)),
+		},
+	})
+	delete(d.scans, source)
+}
+
+// observeFlood tracks the TCP handshakes of the destination of a packet.
+// Only inbound handshakes count, the flood targets an owned address while
+// clients of the cluster opening many connections are no victim.
+func (d *Detector) observeFlood(pkt types.Packet) {
+	if pkt.Direction != "inbound" {
+		return
+	}
+	switch {
+	case pkt.SYN && !pkt.ACK:
+		target := pkt.Destination.String()
+		s, ok := d.floods[target]
+		if !ok || pkt.Timestamp.Sub(s.start) > d.opts.Window {
+			if !ok && len(d.floods) >= d.opts.MaxTracked {
+				return
+			}
+			s = &floodState{start: pkt.Timestamp, halfOpen: make(map[string]struct{})}
+			d.floods[target] = s
+		}
+		if s.owner.namespace == "" {
+			s.owner = owner{namespace: pkt.Namespace, name: pkt.Name}
+		}
+
+		s.halfOpen[net.JoinHostPort(pkt.Source.String(), strconv.Itoa(int(pkt.SourcePort)))] = struct{}{}
+		if len(s.halfOpen) < d.opts.SYNFloodHalfOpen {
+			return
+		}
+
+		d.emit(EventSYNFlood, target, types.Event{
+			Time:        pkt.Timestamp,
+			Type:        EventSYNFlood,
+			Severity:    SeverityHigh,
+			Namespace:   s.owner.namespace,
+			Name:        s.owner.name,
+			Destination: target,
+			Protocol:    "TCP",
+			Message:     fmt.Sprintf("%s has %d half-open connections within %s", target, len(s.halfOpen), d.opts.Window),
+			Attributes: map[string]string{
+				"half_open": strconv.Itoa(len(s.halfOpen)),
+			},
+		})
+		delete(d.floods, target)
+
+	case pkt.ACK && !pkt.SYN, pkt.RST:
+		// The client completed or aborted the handshake
+		if s, ok := d.floods[pkt.Destination.String()]; ok {
+			delete(s.halfOpen, net.JoinHostPort(pkt.Source.String(), strconv.Itoa(int(pkt.SourcePort))))
+		}
+	}
+}
+
+// observeAmplification counts UDP traffic of amplification ports per local IP
+func (d *Detector) observeAmplification(pkt types.Packet) {
+	var key ampKey
+	var remote string
+	switch pkt.Direction {
+	case "outbound":
+		if _, ok := amplificationPorts[pkt.SourcePort]; ok {
+			key = ampKey{ip: pkt.Source.String(), port: pkt.SourcePort}
+		} else if _, ok := amplificationPorts[pkt.DestinationPort]; ok {
+			key = ampKey{ip: pkt.Source.String(), port: pkt.DestinationPort}
+		} else {
+			return
+		}
+		remote = pkt.Destination.String()
+	case "inbound":
+		if _, ok := amplificationPorts[pkt.DestinationPort]; ok {
+			key = ampKey{ip: pkt.Destination.String(), port: pkt.DestinationPort}
+		} else if _, ok := amplificationPorts[pkt.SourcePort]; ok {
+			key = ampKey{ip: pkt.Destination.String(), port: pkt.SourcePort}
+		} else {
+			return
+		}
+		remote = pkt.Source.String()
+	default:
+		return
+	}
+
+	s, ok := d.amps[key]
+	if !ok || pkt.Timestamp.Sub(s.start) > d.opts.Window {
+		if !ok && len(d.amps) >= d.opts.MaxTracked {
+			return
+		}
+		s = &ampState{start: pkt.Timestamp}
+		d.amps[key] = s
+	}
+	if s.owner.namespace == "" {
+		s.owner = owner{namespace: pkt.Namespace, name: pkt.Name}
+	}
+
+	size := int64(pkt.Length)
+	switch {
+	case pkt.Direction == "outbound" && pkt.SourcePort == key.port:
+		s.reflectorOut += size
+		s.lastRemote = remote
+	case pkt.Direction == "inbound" && pkt.DestinationPort == key.port:
+		s.reflectorIn += size
+	case pkt.Direction == "inbound" && pkt.SourcePort == key.port:
+		s.victimIn += size
+		s.lastRemote = remote
+	case pkt.Direction == "outbound" && pkt.DestinationPort == key.port:
+		s.victimOut += size
+	}
+
+	role, amplified, requested := "", int64(0), int64(0)
+	switch {
+	case d.amplified(s.reflectorOut, s.reflectorIn):
+		role, amplified, requested = "reflector", s.reflectorOut, s.reflectorIn
+	case d.amplified(s.victimIn, s.victimOut):
+		role, amplified, requested = "victim", s.victimIn, s.victimOut
+	default:
+		return
+	}
+
+	service := amplificationPorts[key.port]
+	event := types.Event{
+		Time:      pkt.Timestamp,
+		Type:      EventUDPAmplification,
+		Severity:  SeverityHigh,
+		Namespace: s.owner.namespace,
+		Name:      s.owner.name,
+		Protocol:  "UDP",
+		Attributes: map[string]string{
+			"role":            role,
+			"service":         service,
+			"port":            strconv.Itoa(int(key.port)),
+			"amplified_bytes": strconv.FormatInt(amplified, 10),
+			"requested_bytes": strconv.FormatInt(requested, 10),
+		},
+	}
+	if role == "reflector" {
+		event.Source, event.Destination = key.ip, s.lastRemote
+		event.Message = fmt.Sprintf("%s sent %d bytes of %s replies for %d bytes of requests within %s", key.ip, amplified, service, requested, d.opts.Window)
+	} else {
+		event.Source, event.Destination = s.lastRemote, key.ip
+		event.Message = fmt.Sprintf("%s received %d bytes of %s replies for %d bytes of requests within %s", key.ip, amplified, service, requested, d.opts.Window)
+	}
+	d.emit(EventUDPAmplification, key.ip+"/"+role, event)
+	delete(d.amps, key)
+}
+
+// amplified reports whether replies exceed the amplification thresholds
+func (d *Detector) amplified(replies, requests int64) bool {
+	return replies >= d.opts.AmplificationBytes && float64(replies) >= d.opts.AmplificationRatio*float64(requests)
+}
+
+// emit delivers an event unless one of the same type was raised for key
+// within the cooldown
+func (d *Detector) emit(eventType, key string, event types.Event) {
+	cooldownKey := eventType + "|" + key
+	if last, ok := d.lastEvent[cooldownKey]; ok && event.Time.Sub(last) < d.opts.Cooldown {
+		return
+	}
+	d.lastEvent[cooldownKey] = event.Time
+
+	d.recorder.SecurityEvent(event.Type, event.Namespace)
+	select {
+	case d.events <- event:
+	default:
+		d.recorder.SecurityEventDropped()
+	}
+}
@@ -0,0 +1,292 @@
+package detect
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// drain returns the events delivered so far
+func drain(d *Detector) []types.Event {
+	var events []types.Event
+	for {
+		select {
+		case event := <-d.events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func TestPortScan(t *testing.T) {
+	d := New(Options{PortScanPorts: 10, PortScanHosts: 5}, nil)
+	now := time.Now()
+
+	for port := 1; port < 10; port++ {
+		d.ObservePacket(types.Packet{
+			Timestamp:       now,
+			Source:          net.ParseIP("203.0.113.7"),
+			Destination:     net.ParseIP("1.1.1.1"),
+			DestinationPort: uint16(port),
+			Protocol:        "TCP",
+			SYN:             true,
+			Namespace:       "tenant-a",
+			Name:            "web",
+			Direction:       "inbound",
+		})
+	}
+	assert.Empty(t, drain(d))
+
+	d.ObservePacket(types.Packet{
+		Timestamp:       now,
+		Source:          net.ParseIP("203.0.113.7"),
+		Destination:     net.ParseIP("1.1.1.1"),
+		DestinationPort: 10,
+		Protocol:        "TCP",
+		SYN:             true,
+		Namespace:       "tenant-a",
+		Name:            "web",
+		Direction:       "inbound",
+	})
+	events := drain(d)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventPortScan, events[0].Type)
+	assert.Equal(t, "tenant-a", events[0].Namespace)
+	assert.Equal(t, "web", events[0].Name)
+	assert.Equal(t, "203.0.113.7", events[0].Source)
+	assert.Equal(t, "10", events[0].Attributes["ports"])
+
+	// Replies to ephemeral ports are not connection attempts
+	for port := 0; port < 20; port++ {
+		d.ObservePacket(types.Packet{
+			Timestamp:       now,
+			Source:          net.ParseIP("8.8.8.8"),
+			Destination:     net.ParseIP("1.1.1.1"),
+			SourcePort:      53,
+			DestinationPort: uint16(40000 + port),
+			Protocol:        "UDP",
+			Direction:       "inbound",
+		})
+	}
+	assert.Empty(t, drain(d))
+
+	// Busy clients of the cluster connecting to many remote hosts are not
+	// scanning
+	for host := 0; host < 20; host++ {
+		d.ObservePacket(types.Packet{
+			Timestamp:       now,
+			Source:          net.ParseIP("10.0.0.5"),
+			Destination:     net.IPv4(198, 51, 100, byte(host)),
+			DestinationPort: 443,
+			Protocol:        "TCP",
+			SYN:             true,
+			Namespace:       "tenant-a",
+			Name:            "crawler",
+			Direction:       "outbound",
+		})
+	}
+	assert.Empty(t, drain(d))
+	assert.Empty(t, d.scans)
+}
+
+func TestSYNFlood(t *testing.T) {
+	d := New(Options{SYNFloodHalfOpen: 3}, nil)
+	now := time.Now()
+
+	packet := func(port uint16, syn, ack bool) types.Packet {
+		return types.Packet{
+			Timestamp:       now,
+			Source:          net.ParseIP("203.0.113.7"),
+			Destination:     net.ParseIP("1.1.1.1"),
+			SourcePort:      port,
+			DestinationPort: 443,
+			Protocol:        "TCP",
+			SYN:             syn,
+			ACK:             ack,
+			Namespace:       "tenant-a",
+			Name:            "web",
+			Direction:       "inbound",
+		}
+	}
+
+	// Completed handshakes are not half-open
+	d.ObservePacket(packet(1000, true, false))
+	d.ObservePacket(packet(1000, false, true))
+	d.ObservePacket(packet(1001, true, false))
+	d.ObservePacket(packet(1001, false, true))
+	d.ObservePacket(packet(1002, true, false))
+	assert.Empty(t, drain(d))
+
+	d.ObservePacket(packet(1003, true, false))
+	d.ObservePacket(packet(1004, true, false))
+	events := drain(d)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventSYNFlood, events[0].Type)
+	assert.Equal(t, SeverityHigh, events[0].Severity)
+	assert.Equal(t, "1.1.1.1", events[0].Destination)
+	assert.Equal(t, "tenant-a", events[0].Namespace)
+
+	// Outbound SYN bursts of clients in the cluster are no flood
+	for port := uint16(2000); port < 2010; port++ {
+		d.ObservePacket(types.Packet{
+			Timestamp:       now,
+			Source:          net.ParseIP("1.1.1.1"),
+			Destination:     net.ParseIP("198.51.100.1"),
+			SourcePort:      port,
+			DestinationPort: 443,
+			Protocol:        "TCP",
+			SYN:             true,
+			Namespace:       "tenant-a",
+			Name:            "crawler",
+			Direction:       "outbound",
+		})
+	}
+	assert.Empty(t, drain(d))
+	assert.Empty(t, d.floods)
+}
+
+func TestUDPAmplification(t *testing.T) {
+	d := New(Options{AmplificationBytes: 1000, AmplificationRatio: 10}, nil)
+	now := time.Now()
+
+	// A local DNS server answering small requests with large replies
+	d.ObservePacket(types.Packet{
+		Timestamp:       now,
+		Source:          net.ParseIP("198.51.100.1"),
+		Destination:     net.ParseIP("1.1.1.1"),
+		SourcePort:      40000,
+		DestinationPort: 53,
+		Protocol:        "UDP",
+		Length:          60,
+		Namespace:       "tenant-a",
+		Name:            "dns",
+		Direction:       "inbound",
+	})
+	d.ObservePacket(types.Packet{
+		Timestamp:       now,
+		Source:          net.ParseIP("1.1.1.1"),
+		Destination:     net.ParseIP("198.51.100.1"),
+		SourcePort:      53,
+		DestinationPort: 40000,
+		Protocol:        "UDP",
+		Length:          500,
+		Namespace:       "tenant-a",
+		Name:            "dns",
+		Direction:       "outbound",
+	})
+	assert.Empty(t, drain(d))
+
+	d.ObservePacket(types.Packet{
+		Timestamp:       now,
+		Source:          net.ParseIP("1.1.1.1"),
+		Destination:     net.ParseIP("198.51.100.1"),
+		SourcePort:      53,
+		DestinationPort: 40000,
+		Protocol:        "UDP",
+		Length:          700,
+		Namespace:       "tenant-a",
+		Name:            "dns",
+		Direction:       "outbound",
+	})
+	events := drain(d)
+	require.Len(t, events, 1)
+	assert.Equal(t, EventUDPAmplification, events[0].Type)
+	assert.Equal(t, "reflector", events[0].Attributes["role"])
+	assert.Equal(t, "dns", events[0].Attributes["service"])
+	assert.Equal(t, "1.1.1.1", events[0].Source)
+	assert.Equal(t, "198.51.100.1", events[0].Destination)
+
+	// A local IP flooded with unsolicited NTP replies
+	for i := 0; i < 3; i++ {
+		d.ObservePacket(types.Packet{
+			Timestamp:       now,
+			Source:          net.ParseIP("198.51.100.2"),
+			Destination:     net.ParseIP("2.2.2.2"),
+			SourcePort:      123,
+			DestinationPort: 80,
+			Protocol:        "UDP",
+			Length:          468,
+			Namespace:       "tenant-b",
+			Name:            "api",
+			Direction:       "inbound",
+		})
+	}
+	events = drain(d)
+	require.Len(t, events, 1)
+	assert.Equal(t, "victim", events[0].Attributes["role"])
+	assert.Equal(t, "tenant-b", events[0].Namespace)
+	assert.Equal(t, "2.2.2.2", events[0].Destination)
+}
+
+func TestCooldown(t *testing.T) {
+	d := New(Options{PortScanPorts: 2, Cooldown: time.Minute}, nil)
+	now := time.Now()
+
+	scan := func(at time.Time) {
+		for port := uint16(1); port <= 2; port++ {
+			d.ObservePacket(types.Packet{
+				Timestamp:       at,
+				Source:          net.ParseIP("203.0.113.7"),
+				Destination:     net.ParseIP("1.1.1.1"),
+				DestinationPort: port,
+				Protocol:        "TCP",
+				SYN:             true,
+				Direction:       "inbound",
+			})
+		}
+	}
+
+	scan(now)
+	assert.Len(t, drain(d), 1)
+	scan(now.Add(30 * time.Second))
+	assert.Empty(t, drain(d))
+	scan(now.Add(2 * time.Minute))
+	assert.Len(t, drain(d), 1)
+}
+
+func TestCleanup(t *testing.T) {
+	d := New(Options{Window: time.Minute, Cooldown: time.Minute}, nil)
+	now := time.Now()
+
+	d.ObservePacket(types.Packet{
+		Timestamp:       now,
+		Source:          net.ParseIP("203.0.113.7"),
+		Destination:     net.ParseIP("1.1.1.1"),
+		DestinationPort: 22,
+		Protocol:        "TCP",
+		SYN:             true,
+		Direction:       "inbound",
+	})
+	assert.Len(t, d.scans, 1)
+	assert.Len(t, d.floods, 1)
+
+	d.cleanup(now.Add(30 * time.Second))
+	assert.Len(t, d.scans, 1)
+
+	d.cleanup(now.Add(2 * time.Minute))
+	assert.Empty(t, d.scans)
+	assert.Empty(t, d.floods)
+}
+
+func TestDroppedEvents(t *testing.T) {
+	d := New(Options{PortScanPorts: 1, Cooldown: time.Nanosecond}, nil)
+	now := time.Now()
+
+	for i := 0; i < cap(d.events)+10; i++ {
+		d.ObservePacket(types.Packet{
+			Timestamp:       now.Add(time.Duration(i) * time.Second),
+			Source:          net.ParseIP("203.0.113.7"),
+			Destination:     net.ParseIP("1.1.1.1"),
+			DestinationPort: 22,
+			Protocol:        "TCP",
+			SYN:             true,
+			Direction:       "inbound",
+		})
+	}
+	assert.Len(t, drain(d), cap(d.events))
+}
@@ -0,0 +1,517 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// DefaultIndexPrefix is the default prefix of the daily indices
+	DefaultIndexPrefix = "netlog-flows"
+	// DefaultBulkActions is the default number of documents per bulk request
+	DefaultBulkActions = 500
+	// DefaultBulkBytes is the default maximum size of a bulk request body
+	DefaultBulkBytes = 5 << 20
+	// DefaultMaxRetries is the default number of retries of failed documents
+	DefaultMaxRetries = 3
+	// DefaultTimeout is the default timeout of a single bulk request
+	DefaultTimeout = 30 * time.Second
+	// retryBackoff is the initial delay before failed documents are retried
+	retryBackoff = 500 * time.Millisecond
+)
+
+// Options configures the Elasticsearch or OpenSearch sink
+type Options struct {
+	// URLs of the cluster nodes, requests fail over between them
+	URLs []string
+	// Username and Password enable basic authentication
+	Username string
+	Password string
+	// APIKey enables API key authentication (Elasticsearch only)
+	APIKey string
+	// IndexPrefix is the prefix of the daily indices, e.g. netlog-flows-2024.02.14
+	IndexPrefix string
+	// BulkBytes is the maximum size of a bulk request body
+	BulkBytes int
+	// MaxRetries is the number of times documents rejected with a
+	// retryable status are sent again
+	MaxRetries int
+	// InsecureSkipVerify disables TLS certificate verification
+	InsecureSkipVerify bool
+	// Timeout bounds a single bulk request
+	Timeout time.Duration
+}
+
+// Sink bulk-indexes flow records using Elastic Common Schema field names.
+// Every document gets an ID derived from its content and is indexed with the
+// create action, so replaying a batch after a partial failure never
+// duplicates documents.
+type Sink struct {
+	opts     Options
+	client   *http.Client
+	hostname string
+	next     atomic.Uint32
+}
+
+// New creates an Elasticsearch sink
+func New(opts Options) (*Sink, error) {
+	if len(opts.URLs) == 0 {
+		return nil, fmt.Errorf("elasticsearch url cannot be empty")
+	}
+	if opts.IndexPrefix == "" {
+		opts.IndexPrefix = DefaultIndexPrefix
+	}
+	if opts.BulkBytes <= 0 {
+		opts.BulkBytes = DefaultBulkBytes
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	for i, u := range opts.URLs {
+		opts.URLs[i] = strings.TrimSuffix(u, "/")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.InsecureSkipVerify {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	hostname, _ := os.Hostname()
+
+	return &Sink{
+		opts:     opts,
+		client:   &http.Client{Timeout: opts.Timeout, Transport: transport},
+		hostname: hostname,
+	}, nil
+}
+
+// Name returns the name of the sink
+func (s *Sink) Name() string {
+	return "elasticsearch"
+}
+
+// action is a single document of a bulk request
+type action struct {
+	meta []byte
+	doc  []byte
+}
+
+// Write indexes the batch, splitting it into bulk requests of at most
+// BulkBytes and retrying documents rejected with a retryable status
+func (s *Sink) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	actions := make([]action, 0, len(flows))
+	for _, flow := range flows {
+		if !flow.Owned() {
+			continue
+		}
+		a, err := s.newAction(newDocument(flow, s.hostname), flow.StartTime)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, a)
+	}
+	return s.index(ctx, actions)
+}
+
+// WriteEvents indexes the events into the daily indices of the flows, told
+// apart by event.kind alert
+func (s *Sink) WriteEvents(ctx context.Context, events []types.Event) error {
+	actions := make([]action, 0, len(events))
+	for _, e := range events {
+		a, err := s.newAction(newEventDocument(e, s.hostname), e.Time)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, a)
+	}
+	return s.index(ctx, actions)
+}
+
+// index sends the actions in bulk requests of at most BulkBytes
+func (s *Sink) index(ctx context.Context, actions []action) error {
+	for len(actions) > 0 {
+		size := 0
+		n := 0
+		for n < len(actions) {
+			size += len(actions[n].meta) + len(actions[n].doc) + 2
+			if n > 0 && size > s.opts.BulkBytes {
+				break
+			}
+			n++
+		}
+		if err := s.bulkWithRetry(ctx, actions[:n]); err != nil {
+			return err
+		}
+		actions = actions[n:]
+	}
+	return nil
+}
+
+// bulkWithRetry sends actions and resends those rejected with a retryable
+// status until they succeed or the retries are exhausted
+func (s *Sink) bulkWithRetry(ctx context.Context, actions []action) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.bulk(ctx, actions)
+		if err != nil {
+			return err
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+		if attempt >= s.opts.MaxRetries {
+			return fmt.Errorf("%d documents still rejected after %d retries", len(retry), attempt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		actions = retry
+	}
+}
+
+// bulkResponse is the relevant part of a bulk API response
+type bulkResponse struct {
+	Errors bool                                `json:"errors"`
+	Items  []map[string]bulkResponseItemResult `json:"items"`
+}
+
+type bulkResponseItemResult struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// bulk sends a single bulk request and returns the actions that should be retried
+func (s *Sink) bulk(ctx context.Context, actions []action) ([]action, error) {
+	var body bytes.Buffer
+	for _, a := range actions {
+		body.Write(a.meta)
+		body.WriteByte('\n')
+		body.Write(a.doc)
+		body.WriteByte('\n')
+	}
+
+	data, err := s.post(ctx, "/_bulk", body.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	var resp bulkResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if !resp.Errors {
+		return nil, nil
+	}
+	if len(resp.Items) != len(actions) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(resp.Items), len(actions))
+	}
+
+	var retry []action
+	for i, item := range resp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status < 300:
+			case result.Status == http.StatusConflict:
+				// The document was already indexed by an earlier attempt
+			case result.Status == http.StatusTooManyRequests || result.Status >= 500:
+				retry = append(retry, actions[i])
+			default:
+				log.Printf("elasticsearch: dropping rejected document (status %d): %s", result.Status, result.Error)
+			}
+		}
+	}
+	return retry, nil
+}
+
+// post sends a request to the cluster, failing over between the nodes
+func (s *Sink) post(ctx context.Context, path string, body []byte) ([]byte, error) {
+	var lastErr error
+	for range s.opts.URLs {
+		url := s.opts.URLs[int(s.next.Load())%len(s.opts.URLs)]
+
+		data, status, err := s.do(ctx, url+path, body)
+		if err == nil && status < 500 && status != http.StatusTooManyRequests {
+			if status >= 300 {
+				return nil, fmt.Errorf("unexpected status code %d: %s", status, truncate(data, 512))
+			}
+			return data, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("unexpected status code %d: %s", status, truncate(data, 512))
+		}
+		lastErr = err
+		s.next.Add(1)
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
+
+func (s *Sink) do(ctx context.Context, url string, body []byte) ([]byte, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case s.opts.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.opts.APIKey)
+	case s.opts.Username != "":
+		req.SetBasicAuth(s.opts.Username, s.opts.Password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read bulk response: %w", err)
+	}
+	return data, resp.StatusCode, nil
+}
+
+// Close releases idle connections
+func (s *Sink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// newAction creates the bulk action indexing a document into the daily
+// index of t
+func (s *Sink) newAction(document any, t time.Time) (action, error) {
+	doc, err := json.Marshal(document)
+	if err != nil {
+		return action{}, fmt.Errorf("failed to encode document: %w", err)
+	}
+
+	sum := sha1.Sum(doc)
+	meta, err := json.Marshal(map[string]map[string]string{
+		"create": {
+			"_index": IndexName(s.opts.IndexPrefix, t),
+			"_id":    hex.EncodeToString(sum[:]),
+		},
+	})
+	if err != nil {
+		return action{}, fmt.Errorf("failed to encode bulk action: %w", err)
+	}
+	return action{meta: meta, doc: doc}, nil
+}
+
+// IndexName returns the daily index a record with timestamp t is written to
+func IndexName(prefix string, t time.Time) string {
+	return prefix + "-" + t.UTC().Format("2006.01.02")
+}
+
+// document is a flow record using Elastic Common Schema field names
+type document struct {
+	Timestamp    time.Time         `json:"@timestamp"`
+	Event        event             `json:"event"`
+	Source       endpoint          `json:"source"`
+	Destination  endpoint          `json:"destination"`
+	Network      network           `json:"network"`
+	Orchestrator orchestrator      `json:"orchestrator"`
+	Observer     observer          `json:"observer"`
+	Tags         []string          `json:"tags,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Netlog       *netlogInfo       `json:"netlog,omitempty"`
+}
+
+// netlogInfo holds the fields of a flow without an ECS field
+type netlogInfo struct {
+	WorkloadKind    string            `json:"workload_kind,omitempty"`
+	Workload        string            `json:"workload,omitempty"`
+	NamespaceLabels map[string]string `json:"namespace_labels,omitempty"`
+	OwnerTags       []string          `json:"owner_tags,omitempty"`
+}
+
+type event struct {
+	Kind     string    `json:"kind"`
+	Category []string  `json:"category"`
+	Type     []string  `json:"type"`
+	Dataset  string    `json:"dataset"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration int64     `json:"duration"`
+}
+
+// eventDocument is a security event using Elastic Common Schema field names
+type eventDocument struct {
+	Timestamp    time.Time         `json:"@timestamp"`
+	Event        alertEvent        `json:"event"`
+	Log          logLevel          `json:"log"`
+	Message      string            `json:"message"`
+	Source       *endpoint         `json:"source,omitempty"`
+	Destination  *endpoint         `json:"destination,omitempty"`
+	Network      *transport        `json:"network,omitempty"`
+	Orchestrator orchestrator      `json:"orchestrator"`
+	Observer     observer          `json:"observer"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+type alertEvent struct {
+	Kind     string   `json:"kind"`
+	Category []string `json:"category"`
+	Type     []string `json:"type"`
+	Dataset  string   `json:"dataset"`
+	Action   string   `json:"action"`
+}
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+type transport struct {
+	Transport string `json:"transport"`
+}
+
+type endpoint struct {
+	IP   string `json:"ip"`
+	Port int    `json:"port,omitempty"`
+}
+
+type network struct {
+	Transport string `json:"transport"`
+	Type      string `json:"type"`
+	Direction string `json:"direction"`
+	Bytes     int64  `json:"bytes"`
+	Packets   int64  `json:"packets"`
+}
+
+type orchestrator struct {
+	Type      string   `json:"type"`
+	Namespace string   `json:"namespace"`
+	Resource  resource `json:"resource"`
+}
+
+type resource struct {
+	Type string `json:"type,omitempty"`
+	Name string `json:"name"`
+}
+
+type observer struct {
+	Hostname string `json:"hostname,omitempty"`
+	Product  string `json:"product"`
+	Type     string `json:"type"`
+}
+
+// newDocument maps a flow to ECS fields. The port of a flow is the port of
+// its source endpoint whatever its direction, the destination port is that
+// of its first packet.
+func newDocument(flow types.AggregatedInfo, hostname string) document {
+	port, _ := strconv.Atoi(flow.Port)
+	destinationPort, _ := strconv.Atoi(flow.DestinationPort)
+	doc := document{
+		Timestamp: flow.StartTime.UTC(),
+		Event: event{
+			Kind:     "event",
+			Category: []string{"network"},
+			Type:     []string{"connection"},
+			Dataset:  "netlog.flow",
+			Start:    flow.StartTime.UTC(),
+			End:      flow.EndTime.UTC(),
+			Duration: flow.EndTime.Sub(flow.StartTime).Nanoseconds(),
+		},
+		Source:      endpoint{IP: flow.Source, Port: port},
+		Destination: endpoint{IP: flow.Destination, Port: destinationPort},
+		Network: network{
+			Transport: strings.ToLower(flow.Protocol),
+			Type:      "ipv4",
+			Direction: flow.Direction,
+			Bytes:     flow.TotalBytes,
+			Packets:   flow.Packets,
+		},
+		Orchestrator: orchestrator{
+			Type:      "kubernetes",
+			Namespace: flow.Namespace,
+			Resource:  resource{Type: flow.Kind, Name: flow.Name},
+		},
+		Observer: observer{
+			Hostname: hostname,
+			Product:  "netlog",
+			Type:     "sensor",
+		},
+		Tags:   flow.Tags,
+		Labels: flow.Labels,
+	}
+	info := netlogInfo{
+		WorkloadKind:    flow.WorkloadKind,
+		Workload:        flow.Workload,
+		NamespaceLabels: flow.NamespaceLabels,
+		OwnerTags:       flow.OwnerTags,
+	}
+	if info.WorkloadKind != "" || info.Workload != "" || len(info.NamespaceLabels) > 0 || len(info.OwnerTags) > 0 {
+		doc.Netlog = &info
+	}
+	return doc
+}
+
+// newEventDocument maps a security event to ECS fields. The type of the
+// event is event.action, its severity log.level and its attributes labels.
+func newEventDocument(e types.Event, hostname string) eventDocument {
+	doc := eventDocument{
+		Timestamp: e.Time.UTC(),
+		Event: alertEvent{
+			Kind:     "alert",
+			Category: []string{"network", "intrusion_detection"},
+			Type:     []string{"info"},
+			Dataset:  "netlog.event",
+			Action:   e.Type,
+		},
+		Log:     logLevel{Level: e.Severity},
+		Message: e.Message,
+		Orchestrator: orchestrator{
+			Type:      "kubernetes",
+			Namespace: e.Namespace,
+			Resource:  resource{Name: e.Name},
+		},
+		Observer: observer{
+			Hostname: hostname,
+			Product:  "netlog",
+			Type:     "sensor",
+		},
+		Labels: e.Attributes,
+	}
+	if e.Source != "" {
+		doc.Source = &endpoint{IP: e.Source}
+	}
+	if e.Destination != "" {
+		doc.Destination = &endpoint{IP: e.Destination}
+	}
+	if e.Protocol != "" {
+		doc.Network = &transport{Transport: strings.ToLower(e.Protocol)}
+	}
+	return doc
+}
+
+func truncate(data []byte, n int) string {
+	if len(data) > n {
+		return string(data[:n]) + "..."
+	}
+	return string(data)
+}
@@ -0,0 +1,175 @@
+package elasticsearch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testFlow(port string) types.AggregatedInfo {
+	start := time.Date(2024, 2, 14, 23, 59, 59, 0, time.UTC)
+	return types.AggregatedInfo{
+		Namespace:   "default",
+		Name:        "nginx",
+		StartTime:   start,
+		EndTime:     start.Add(2 * time.Second),
+		Source:      "10.0.0.1",
+		Destination: "8.8.8.8",
+		Protocol:    "TCP",
+		Port:        port,
+		Direction:   "outbound",
+		TotalBytes:  1000,
+		Packets:     10,
+	}
+}
+
+func TestIndexName(t *testing.T) {
+	ts := time.Date(2024, 2, 14, 23, 59, 59, 0, time.FixedZone("UTC-1", -3600))
+	assert.Equal(t, "netlog-flows-2024.02.15", IndexName("netlog-flows", ts))
+}
+
+func TestNewDocument(t *testing.T) {
+	doc := newDocument(testFlow("443"), "node-1")
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, "10.0.0.1", fields["source"].(map[string]interface{})["ip"])
+	assert.Equal(t, 443.0, fields["source"].(map[string]interface{})["port"])
+	assert.NotContains(t, fields["destination"], "port")
+	assert.Equal(t, "tcp", fields["network"].(map[string]interface{})["transport"])
+	assert.Equal(t, "default", fields["orchestrator"].(map[string]interface{})["namespace"])
+	assert.Equal(t, 2e9, fields["event"].(map[string]interface{})["duration"])
+	assert.NotContains(t, fields, "netlog")
+
+	// The port of an inbound flow is the port of the remote client, the
+	// port of the service is the destination port
+	flow := testFlow("51234")
+	flow.Source, flow.Destination, flow.DestinationPort, flow.Direction = "8.8.8.8", "10.0.0.1", "443", "inbound"
+	flow.Tags, flow.OwnerTags = []string{"tor"}, []string{"pci"}
+	flow.Kind, flow.WorkloadKind, flow.Workload = "Pod", "Deployment", "nginx"
+	flow.Labels, flow.NamespaceLabels = map[string]string{"app.kubernetes.io/name": "nginx"}, map[string]string{"team": "web"}
+	data, err = json.Marshal(newDocument(flow, "node-1"))
+	require.NoError(t, err)
+	fields = nil
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, map[string]interface{}{"ip": "8.8.8.8", "port": 51234.0}, fields["source"])
+	assert.Equal(t, map[string]interface{}{"ip": "10.0.0.1", "port": 443.0}, fields["destination"])
+	assert.Equal(t, []interface{}{"tor"}, fields["tags"])
+	assert.Equal(t, map[string]interface{}{"type": "Pod", "name": "nginx"}, fields["orchestrator"].(map[string]interface{})["resource"])
+	assert.Equal(t, map[string]interface{}{"app.kubernetes.io/name": "nginx"}, fields["labels"])
+	assert.Equal(t, map[string]interface{}{
+		"workload_kind":    "Deployment",
+		"workload":         "nginx",
+		"namespace_labels": map[string]interface{}{"team": "web"},
+		"owner_tags":       []interface{}{"pci"},
+	}, fields["netlog"])
+}
+
+func TestWriteEvents(t *testing.T) {
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		fmt.Fprint(w, `{"errors":false,"items":[]}`)
+	}))
+	defer server.Close()
+
+	s, err := New(Options{URLs: []string{server.URL}})
+	require.NoError(t, err)
+	event := types.Event{
+		Time:       time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC),
+		Type:       "port_scan",
+		Severity:   "medium",
+		Namespace:  "tenant-a",
+		Name:       "web",
+		Source:     "203.0.113.7",
+		Message:    "203.0.113.7 touched 100 ports on 1 hosts within 1m0s",
+		Attributes: map[string]string{"ports": "100"},
+	}
+	require.NoError(t, s.WriteEvents(context.Background(), []types.Event{event}))
+
+	// Events go to the daily index of the flows
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"_index":"netlog-flows-2024.02.14"`)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &fields))
+	assert.Equal(t, "alert", fields["event"].(map[string]interface{})["kind"])
+	assert.Equal(t, "port_scan", fields["event"].(map[string]interface{})["action"])
+	assert.Equal(t, map[string]interface{}{"level": "medium"}, fields["log"])
+	assert.Equal(t, map[string]interface{}{"ip": "203.0.113.7"}, fields["source"])
+	assert.NotContains(t, fields, "destination")
+	assert.Equal(t, map[string]interface{}{"ports": "100"}, fields["labels"])
+	assert.Equal(t, "tenant-a", fields["orchestrator"].(map[string]interface{})["namespace"])
+}
+
+func TestWriteRetriesPartialFailures(t *testing.T) {
+	var mu sync.Mutex
+	var requests [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+
+		var ids []string
+		scanner := bufio.NewScanner(r.Body)
+		for i := 0; scanner.Scan(); i++ {
+			if i%2 == 0 {
+				var meta map[string]map[string]string
+				require.NoError(t, json.Unmarshal(scanner.Bytes(), &meta))
+				ids = append(ids, meta["create"]["_id"])
+			}
+		}
+
+		mu.Lock()
+		requests = append(requests, ids)
+		first := len(requests) == 1
+		mu.Unlock()
+
+		// Reject the second document once, then accept everything
+		var items []string
+		for i := range ids {
+			status := 201
+			if first && i == 1 {
+				status = 429
+			}
+			if first && i == 2 {
+				status = 409
+			}
+			items = append(items, fmt.Sprintf(`{"create":{"status":%d}}`, status))
+		}
+		fmt.Fprintf(w, `{"errors":%t,"items":[%s]}`, first, strings.Join(items, ","))
+	}))
+	defer server.Close()
+
+	s, err := New(Options{URLs: []string{server.URL}, MaxRetries: 1})
+	require.NoError(t, err)
+
+	flows := []types.AggregatedInfo{testFlow("1"), testFlow("2"), testFlow("3")}
+	require.NoError(t, s.Write(context.Background(), flows))
+
+	require.Len(t, requests, 2)
+	assert.Len(t, requests[0], 3)
+	assert.Equal(t, []string{requests[0][1]}, requests[1])
+}
+
+func TestIndexTemplate(t *testing.T) {
+	data, err := IndexTemplate("flows")
+	require.NoError(t, err)
+
+	var template map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &template))
+	assert.Equal(t, []interface{}{"flows-*"}, template["index_patterns"])
+}
@@ -0,0 +1,99 @@
+package elasticsearch
+
+import (
+	"encoding/json"
+)
+
+// field returns a mapping of a single field
+func field(typ string) map[string]interface{} {
+	return map[string]interface{}{"type": typ}
+}
+
+// object returns a mapping of an object with the given properties
+func object(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"properties": properties}
+}
+
+// IndexTemplate returns a composable index template matching the daily
+// indices written with prefix. It works with Elasticsearch 7.8+ and OpenSearch
+// and is installed with PUT _index_template/<prefix>.
+func IndexTemplate(prefix string) ([]byte, error) {
+	if prefix == "" {
+		prefix = DefaultIndexPrefix
+	}
+
+	endpoint := object(map[string]interface{}{
+		"ip":   field("ip"),
+		"port": field("long"),
+	})
+	mappings := map[string]interface{}{
+		"dynamic": "false",
+		"properties": map[string]interface{}{
+			"@timestamp": field("date"),
+			"event": object(map[string]interface{}{
+				"kind":     field("keyword"),
+				"category": field("keyword"),
+				"type":     field("keyword"),
+				"dataset":  field("keyword"),
+				"start":    field("date"),
+				"end":      field("date"),
+				"duration": field("long"),
+				"action":   field("keyword"),
+			}),
+			"log": object(map[string]interface{}{
+				"level": field("keyword"),
+			}),
+			"message":     field("text"),
+			"source":      endpoint,
+			"destination": endpoint,
+			"network": object(map[string]interface{}{
+				"transport": field("keyword"),
+				"type":      field("keyword"),
+				"direction": field("keyword"),
+				"bytes":     field("long"),
+				"packets":   field("long"),
+			}),
+			"orchestrator": object(map[string]interface{}{
+				"type":      field("keyword"),
+				"namespace": field("keyword"),
+				"resource": object(map[string]interface{}{
+					"type": field("keyword"),
+					"name": field("keyword"),
+				}),
+			}),
+			"observer": object(map[string]interface{}{
+				"hostname": field("keyword"),
+				"product":  field("keyword"),
+				"type":     field("keyword"),
+			}),
+			"tags": field("keyword"),
+			"netlog": object(map[string]interface{}{
+				"workload_kind":    field("keyword"),
+				"workload":         field("keyword"),
+				"namespace_labels": field("flattened"),
+				"owner_tags":       field("keyword"),
+			}),
+			// The Kubernetes labels of flows and the attributes of events,
+			// flattened as label keys contain dots
+			"labels": field("flattened"),
+		},
+	}
+
+	template := map[string]interface{}{
+		"index_patterns": []string{prefix + "-*"},
+		"priority":       200,
+		"template": map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index": map[string]interface{}{
+					"number_of_shards": 1,
+					"refresh_interval": "30s",
+				},
+			},
+			"mappings": mappings,
+		},
+		"_meta": map[string]interface{}{
+			"description": "Flow records and security events written by netlog using Elastic Common Schema field names",
+		},
+	}
+	return json.MarshalIndent(template, "", "  ")
+}
@@ -0,0 +1,120 @@
+package flowapi
+
+import (
+	"fmt"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// Filter selects flows. Empty fields match every flow.
+type Filter struct {
+	Namespace string
+	// Owner is the name of the owning workload, or namespace/name
+	Owner string
+	// IP matches flows with a source or destination within the prefix
+	IP netip.Prefix
+	// Port matches flows with a source or destination port of Port
+	Port      string
+	Protocol  string
+	Direction string
+	// From and To select flows active within the time range
+	From time.Time
+	To   time.Time
+}
+
+// ParseFilter reads a filter from the query parameters namespace, owner,
+// ip (an address or CIDR), port, protocol, direction, from and to (RFC 3339
+// timestamps or durations before now such as 15m)
+func ParseFilter(query url.Values, now time.Time) (Filter, error) {
+	f := Filter{
+		Namespace: query.Get("namespace"),
+		Owner:     query.Get("owner"),
+		Port:      query.Get("port"),
+		Protocol:  strings.ToUpper(query.Get("protocol")),
+		Direction: query.Get("direction"),
+	}
+
+	if s := query.Get("ip"); s != "" {
+		var err error
+		if strings.Contains(s, "/") {
+			f.IP, err = netip.ParsePrefix(s)
+			f.IP = f.IP.Masked()
+		} else {
+			var addr netip.Addr
+			addr, err = netip.ParseAddr(s)
+			f.IP = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		if err != nil {
+			return Filter{}, fmt.Errorf("invalid ip: %w", err)
+		}
+	}
+	if f.Port != "" {
+		if _, err := strconv.ParseUint(f.Port, 10, 16); err != nil {
+			return Filter{}, fmt.Errorf("invalid port: %s", f.Port)
+		}
+	}
+	switch f.Direction {
+	case "", "inbound", "outbound":
+	default:
+		return Filter{}, fmt.Errorf("invalid direction: %s", f.Direction)
+	}
+
+	var err error
+	if f.From, err = parseTime(query.Get("from"), now); err != nil {
+		return Filter{}, fmt.Errorf("invalid from: %w", err)
+	}
+	if f.To, err = parseTime(query.Get("to"), now); err != nil {
+		return Filter{}, fmt.Errorf("invalid to: %w", err)
+	}
+	return f, nil
+}
+
+// parseTime parses an RFC 3339 timestamp or a duration before now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Match reports whether the flow is selected by the filter
+func (f Filter) Match(flow types.AggregatedInfo) bool {
+	if f.Namespace != "" && flow.Namespace != f.Namespace {
+		return false
+	}
+	if f.Owner != "" && flow.Name != f.Owner && flow.Namespace+"/"+flow.Name != f.Owner {
+		return false
+	}
+	if f.Port != "" && flow.Port != f.Port && flow.DestinationPort != f.Port {
+		return false
+	}
+	if f.Protocol != "" && flow.Protocol != f.Protocol {
+		return false
+	}
+	if f.Direction != "" && flow.Direction != f.Direction {
+		return false
+	}
+	if !f.From.IsZero() && flow.EndTime.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && flow.StartTime.After(f.To) {
+		return false
+	}
+	if f.IP.IsValid() && !f.containsIP(flow.Source) && !f.containsIP(flow.Destination) {
+		return false
+	}
+	return true
+}
+
+func (f Filter) containsIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && f.IP.Contains(addr.Unmap())
+}
@@ -0,0 +1,259 @@
+package flowapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+
+func flow(i int) types.AggregatedInfo {
+	namespace := "default"
+	if i%2 == 1 {
+		namespace = "kube-system"
+	}
+	return types.AggregatedInfo{
+		StartTime:       start.Add(time.Duration(i) * time.Minute),
+		EndTime:         start.Add(time.Duration(i)*time.Minute + 10*time.Second),
+		Namespace:       namespace,
+		Name:            fmt.Sprintf("pod-%d", i),
+		Direction:       "outbound",
+		Source:          fmt.Sprintf("10.0.0.%d", i),
+		Destination:     "192.168.1.1",
+		Protocol:        "TCP",
+		Port:            fmt.Sprintf("5123%d", i),
+		DestinationPort: "443",
+		TotalBytes:      int64(100 * i),
+		Packets:         int64(i),
+	}
+}
+
+func writeFlows(t *testing.T, r *Ring, from, to int) {
+	var flows []types.AggregatedInfo
+	for i := from; i <= to; i++ {
+		flows = append(flows, flow(i))
+	}
+	require.NoError(t, r.Write(context.Background(), flows))
+}
+
+func ids(entries []Entry) []uint64 {
+	var ids []uint64
+	for _, e := range entries {
+		ids = append(ids, e.ID)
+	}
+	return ids
+}
+
+func TestRing(t *testing.T) {
+	r := NewRing(4)
+	assert.Equal(t, 0, r.Len())
+	entries, more := r.Query(Filter{}, 0, 10)
+	assert.Empty(t, entries)
+	assert.False(t, more)
+
+	// Flows without an owner are skipped
+	require.NoError(t, r.Write(context.Background(), []types.AggregatedInfo{{Source: "10.0.0.1"}}))
+	assert.Equal(t, 0, r.Len())
+
+	writeFlows(t, r, 1, 6)
+	assert.Equal(t, 4, r.Len())
+
+	// The oldest flows are overwritten
+	entries, more = r.Query(Filter{}, 0, 10)
+	assert.Equal(t, []uint64{6, 5, 4, 3}, ids(entries))
+	assert.False(t, more)
+
+	// Pagination continues below the last returned flow
+	entries, more = r.Query(Filter{}, 0, 2)
+	assert.Equal(t, []uint64{6, 5}, ids(entries))
+	assert.True(t, more)
+	entries, more = r.Query(Filter{}, 5, 2)
+	assert.Equal(t, []uint64{4, 3}, ids(entries))
+	assert.False(t, more)
+
+	entries, _ = r.Query(Filter{Namespace: "default"}, 0, 10)
+	assert.Equal(t, []uint64{6, 4}, ids(entries))
+
+	assert.Equal(t, []uint64{5, 6}, ids(r.Since(Filter{}, 4)))
+	assert.Equal(t, []uint64{3, 4, 5, 6}, ids(r.Since(Filter{}, 0)))
+	assert.Empty(t, r.Since(Filter{}, 6))
+}
+
+func TestParseFilter(t *testing.T) {
+	now := start.Add(time.Hour)
+	f, err := ParseFilter(url.Values{
+		"namespace": {"default"},
+		"owner":     {"default/api"},
+		"ip":        {"10.0.0.0/8"},
+		"port":      {"443"},
+		"protocol":  {"tcp"},
+		"direction": {"outbound"},
+		"from":      {"15m"},
+		"to":        {"2024-02-14T13:00:00Z"},
+	}, now)
+	require.NoError(t, err)
+	assert.Equal(t, "TCP", f.Protocol)
+	assert.Equal(t, "10.0.0.0/8", f.IP.String())
+	assert.Equal(t, now.Add(-15*time.Minute), f.From)
+	assert.Equal(t, now, f.To)
+
+	f, err = ParseFilter(url.Values{"ip": {"10.0.0.1"}}, now)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1/32", f.IP.String())
+
+	for _, query := range []url.Values{
+		{"ip": {"10.0.0"}},
+		{"port": {"http"}},
+		{"direction": {"sideways"}},
+		{"from": {"yesterday"}},
+	} {
+		_, err := ParseFilter(query, now)
+		assert.Error(t, err, query.Encode())
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	f := flow(2)
+	for _, tc := range []struct {
+		filter Filter
+		match  bool
+	}{
+		{Filter{}, true},
+		{Filter{Namespace: "default"}, true},
+		{Filter{Namespace: "kube-system"}, false},
+		{Filter{Owner: "pod-2"}, true},
+		{Filter{Owner: "default/pod-2"}, true},
+		{Filter{Owner: "kube-system/pod-2"}, false},
+		{Filter{Port: "80"}, false},
+		// The port matches the source and the destination port
+		{Filter{Port: "443"}, true},
+		{Filter{Port: "51232"}, true},
+		{Filter{Protocol: "UDP"}, false},
+		{Filter{Direction: "inbound"}, false},
+		{Filter{From: start.Add(3 * time.Minute)}, false},
+		{Filter{To: start.Add(time.Minute)}, false},
+		{Filter{From: start, To: start.Add(3 * time.Minute)}, true},
+	} {
+		assert.Equal(t, tc.match, tc.filter.Match(f), "%+v", tc.filter)
+	}
+
+	for query, match := range map[string]bool{
+		"10.0.0.2":       true,
+		"192.168.0.0/16": true,
+		"10.1.0.0/16":    false,
+	} {
+		filter, err := ParseFilter(url.Values{"ip": {query}}, start)
+		require.NoError(t, err)
+		assert.Equal(t, match, filter.Match(f), query)
+	}
+}
+
+func TestListHandler(t *testing.T) {
+	r := NewRing(100)
+	writeFlows(t, r, 1, 10)
+	handler := r.ListHandler()
+
+	get := func(query string) (int, Page) {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/flows?"+query, nil))
+		var page Page
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		}
+		return rec.Code, page
+	}
+
+	code, page := get("namespace=default&limit=3")
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, page.Flows, 3)
+	assert.Equal(t, uint64(10), page.Flows[0].ID)
+	assert.Equal(t, "pod-10", page.Flows[0].Name)
+	assert.Equal(t, int64(1000), page.Flows[0].Bytes)
+	assert.Equal(t, "6", page.Next)
+
+	code, page = get("namespace=default&limit=3&before=" + page.Next)
+	require.Equal(t, http.StatusOK, code)
+	require.Len(t, page.Flows, 2)
+	assert.Equal(t, uint64(4), page.Flows[0].ID)
+	assert.Empty(t, page.Next)
+
+	code, page = get("port=80")
+	require.Equal(t, http.StatusOK, code)
+	assert.NotNil(t, page.Flows)
+	assert.Empty(t, page.Flows)
+
+	for _, query := range []string{"limit=0", "before=x", "direction=up"} {
+		code, _ := get(query)
+		assert.Equal(t, http.StatusBadRequest, code, query)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	r := NewRing(100)
+	writeFlows(t, r, 1, 4)
+	server := httptest.NewServer(r.StreamHandler())
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"?namespace=default", nil)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	events := make(chan Flow)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+			if !ok {
+				continue
+			}
+			var f Flow
+			if json.Unmarshal([]byte(data), &f) == nil {
+				events <- f
+			}
+		}
+	}()
+
+	next := func() Flow {
+		select {
+		case f := <-events:
+			return f
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for event")
+			return Flow{}
+		}
+	}
+
+	// Missed flows are replayed, then new flows are streamed
+	assert.Equal(t, uint64(2), next().ID)
+	assert.Equal(t, uint64(4), next().ID)
+	writeFlows(t, r, 5, 6)
+	assert.Equal(t, uint64(6), next().ID)
+
+	// The subscription ends with the request
+	cancel()
+	assert.Eventually(t, func() bool {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		return len(r.subscribers) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
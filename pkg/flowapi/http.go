@@ -0,0 +1,215 @@
+package flowapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultLimit is the default page size
+	DefaultLimit = 100
+	// MaxLimit is the largest page size
+	MaxLimit = 1000
+	// heartbeatInterval is how often idle streams send a comment, so
+	// proxies don't close them
+	heartbeatInterval = 15 * time.Second
+)
+
+// Flow is a flow as served by the API
+type Flow struct {
+	ID              uint64            `json:"id"`
+	StartTime       time.Time         `json:"start_time"`
+	EndTime         time.Time         `json:"end_time"`
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	Kind            string            `json:"kind,omitempty"`
+	WorkloadKind    string            `json:"workload_kind,omitempty"`
+	Workload        string            `json:"workload,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	NamespaceLabels map[string]string `json:"namespace_labels,omitempty"`
+	Direction       string            `json:"direction"`
+	Source          string            `json:"source"`
+	Destination     string            `json:"destination"`
+	Protocol        string            `json:"protocol"`
+	Port            string            `json:"port"`
+	DestinationPort string            `json:"destination_port,omitempty"`
+	Bytes           int64             `json:"bytes"`
+	Packets         int64             `json:"packets"`
+	Tags            []string          `json:"tags,omitempty"`
+	OwnerTags       []string          `json:"owner_tags,omitempty"`
+}
+
+// Page is the response of the list endpoint
+type Page struct {
+	Flows []Flow `json:"flows"`
+	// Next is the before parameter of the next page, empty on the last page
+	Next string `json:"next,omitempty"`
+}
+
+func newFlow(entry Entry) Flow {
+	f := entry.Flow
+	return Flow{
+		ID:              entry.ID,
+		StartTime:       f.StartTime,
+		EndTime:         f.EndTime,
+		Namespace:       f.Namespace,
+		Name:            f.Name,
+		Kind:            f.Kind,
+		WorkloadKind:    f.WorkloadKind,
+		Workload:        f.Workload,
+		Labels:          f.Labels,
+		NamespaceLabels: f.NamespaceLabels,
+		Direction:       f.Direction,
+		Source:          f.Source,
+		Destination:     f.Destination,
+		Protocol:        f.Protocol,
+		Port:            f.Port,
+		DestinationPort: f.DestinationPort,
+		Bytes:           f.TotalBytes,
+		Packets:         f.Packets,
+		Tags:            f.Tags,
+		OwnerTags:       f.OwnerTags,
+	}
+}
+
+// ListHandler serves the flows matching the filter parameters newest first.
+// limit sets the page size and before, taken from next of the previous
+// page, continues with older flows.
+func (r *Ring) ListHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := req.URL.Query()
+		filter, err := ParseFilter(query, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		limit := DefaultLimit
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit: "+s, http.StatusBadRequest)
+				return
+			}
+			limit = min(n, MaxLimit)
+		}
+		var before uint64
+		if s := query.Get("before"); s != "" {
+			if before, err = strconv.ParseUint(s, 10, 64); err != nil {
+				http.Error(w, "invalid before: "+s, http.StatusBadRequest)
+				return
+			}
+		}
+
+		entries, more := r.Query(filter, before, limit)
+		page := Page{Flows: make([]Flow, 0, len(entries))}
+		for _, entry := range entries {
+			page.Flows = append(page.Flows, newFlow(entry))
+		}
+		if more {
+			page.Next = strconv.FormatUint(entries[len(entries)-1].ID, 10)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(page)
+	})
+}
+
+// StreamHandler streams the flows matching the filter parameters as
+// server-sent events as they are emitted. Each event carries the sequence
+// number of its flow as id, clients reconnecting with Last-Event-ID or the
+// after parameter first receive the flows they missed that are still in the
+// ring. Flows a slow client could not keep up with are reported in a
+// dropped event.
+func (r *Ring) StreamHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming not supported", http.StatusInternalServerError)
+			return
+		}
+
+		query := req.URL.Query()
+		filter, err := ParseFilter(query, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resume := req.Header.Get("Last-Event-ID")
+		if s := query.Get("after"); s != "" {
+			resume = s
+		}
+		var after uint64
+		if resume != "" {
+			if after, err = strconv.ParseUint(resume, 10, 64); err != nil {
+				http.Error(w, "invalid after: "+resume, http.StatusBadRequest)
+				return
+			}
+		}
+
+		sub, last := r.subscribe()
+		defer r.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if resume != "" {
+			for _, entry := range r.Since(filter, after) {
+				if entry.ID > last {
+					break
+				}
+				if err := writeEvent(w, entry); err != nil {
+					return
+				}
+			}
+		}
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(heartbeatInterval)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			case entry := <-sub.ch:
+				if dropped := sub.dropped.Swap(0); dropped > 0 {
+					fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+				}
+				if !filter.Match(entry.Flow) {
+					continue
+				}
+				if err := writeEvent(w, entry); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// writeEvent writes a flow as a server-sent event
+func writeEvent(w http.ResponseWriter, entry Entry) error {
+	data, err := json.Marshal(newFlow(entry))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", entry.ID, data)
+	return err
+}
@@ -0,0 +1,170 @@
+// Package flowapi keeps the recently emitted flows in memory and serves
+// them over HTTP
+package flowapi
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// DefaultCapacity is the default number of flows kept
+	DefaultCapacity = 10000
+	// subscriberBuffer is the number of flows queued for each stream
+	subscriberBuffer = 256
+)
+
+// Entry is a flow with its sequence number. Sequence numbers start at 1
+// and increase with every flow written.
+type Entry struct {
+	ID   uint64
+	Flow types.AggregatedInfo
+}
+
+// Ring is a sink keeping the last flows with an owner in a fixed-size ring
+// buffer. Older flows are overwritten once the ring is full.
+type Ring struct {
+	mu      sync.RWMutex
+	entries []Entry
+	// last is the sequence number of the newest flow, 0 when empty
+	last        uint64
+	subscribers map[*subscriber]struct{}
+}
+
+// subscriber receives the flows written after it subscribed
+type subscriber struct {
+	ch      chan Entry
+	dropped atomic.Int64
+}
+
+// NewRing creates a ring keeping capacity flows
+func NewRing(capacity int) *Ring {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Ring{
+		entries:     make([]Entry, capacity),
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+// Name returns the name of the sink
+func (r *Ring) Name() string {
+	return "flowapi"
+}
+
+// Write adds the flows to the ring and passes them to the subscribers
+func (r *Ring) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, flow := range flows {
+		if !flow.Owned() {
+			continue
+		}
+		r.last++
+		entry := Entry{ID: r.last, Flow: flow}
+		r.entries[r.last%uint64(len(r.entries))] = entry
+
+		for s := range r.subscribers {
+			select {
+			case s.ch <- entry:
+			default:
+				// Slow readers lose flows instead of stalling the sinks
+				s.dropped.Add(1)
+			}
+		}
+	}
+	return nil
+}
+
+// Close does nothing
+func (r *Ring) Close() error {
+	return nil
+}
+
+// Len returns the number of flows in the ring
+func (r *Ring) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.last == 0 {
+		return 0
+	}
+	return int(r.last - r.first() + 1)
+}
+
+// first returns the sequence number of the oldest flow in the ring
+func (r *Ring) first() uint64 {
+	if r.last < uint64(len(r.entries)) {
+		return 1
+	}
+	return r.last - uint64(len(r.entries)) + 1
+}
+
+// Query returns up to limit flows matching filter, newest first. Only flows
+// with a sequence number below before are returned unless before is 0. more
+// is set when older matching flows may exist.
+func (r *Ring) Query(filter Filter, before uint64, limit int) (entries []Entry, more bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.last == 0 {
+		return nil, false
+	}
+	id := r.last
+	if before > 0 && before <= id {
+		id = before - 1
+	}
+	first := r.first()
+	for ; id >= first && id > 0; id-- {
+		entry := r.entries[id%uint64(len(r.entries))]
+		if !filter.Match(entry.Flow) {
+			continue
+		}
+		if len(entries) == limit {
+			return entries, true
+		}
+		entries = append(entries, entry)
+	}
+	return entries, false
+}
+
+// Since returns the flows matching filter written after the flow with
+// sequence number after, oldest first
+func (r *Ring) Since(filter Filter, after uint64) []Entry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var entries []Entry
+	id := after + 1
+	if first := r.first(); id < first {
+		id = first
+	}
+	for ; id <= r.last; id++ {
+		entry := r.entries[id%uint64(len(r.entries))]
+		if filter.Match(entry.Flow) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// subscribe registers a subscriber for the flows written from now on. The
+// returned sequence number is the newest flow written before subscribing.
+func (r *Ring) subscribe() (*subscriber, uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &subscriber{ch: make(chan Entry, subscriberBuffer)}
+	r.subscribers[s] = struct{}{}
+	return s, r.last
+}
+
+func (r *Ring) unsubscribe(s *subscriber) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.subscribers, s)
+}
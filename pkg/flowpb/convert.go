@@ -0,0 +1,110 @@
+package flowpb
+
+//go:generate protoc -I ../../proto --go_out=../.. --go_opt=module=github.com/highscaleco/netlog netlog/flow/v1/flow.proto
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// FromAggregatedInfo converts an aggregated flow into its protobuf representation
+func FromAggregatedInfo(a types.AggregatedInfo) *Flow {
+	port, _ := strconv.ParseUint(a.Port, 10, 16)
+	return &Flow{
+		StartTime:       timestamppb.New(a.StartTime),
+		EndTime:         timestamppb.New(a.EndTime),
+		Duration:        durationpb.New(a.EndTime.Sub(a.StartTime)),
+		Namespace:       a.Namespace,
+		Name:            a.Name,
+		Direction:       ParseDirection(a.Direction),
+		Source:          a.Source,
+		Destination:     a.Destination,
+		Protocol:        ParseProtocol(a.Protocol),
+		Port:            uint32(port),
+		TotalBytes:      uint64(a.TotalBytes),
+		Packets:         uint64(a.Packets),
+		Tags:            a.Tags,
+		OwnerTags:       a.OwnerTags,
+		Kind:            a.Kind,
+		WorkloadKind:    a.WorkloadKind,
+		Workload:        a.Workload,
+		Labels:          a.Labels,
+		NamespaceLabels: a.NamespaceLabels,
+	}
+}
+
+// ToAggregatedInfo converts a protobuf flow back into an aggregated flow
+func (f *Flow) ToAggregatedInfo() types.AggregatedInfo {
+	return types.AggregatedInfo{
+		Namespace:       f.GetNamespace(),
+		Name:            f.GetName(),
+		StartTime:       f.GetStartTime().AsTime(),
+		EndTime:         f.GetEndTime().AsTime(),
+		Source:          f.GetSource(),
+		Destination:     f.GetDestination(),
+		Protocol:        f.GetProtocol().Name(),
+		Port:            strconv.FormatUint(uint64(f.GetPort()), 10),
+		Direction:       f.GetDirection().Name(),
+		TotalBytes:      int64(f.GetTotalBytes()),
+		Packets:         int64(f.GetPackets()),
+		Tags:            f.GetTags(),
+		OwnerTags:       f.GetOwnerTags(),
+		Kind:            f.GetKind(),
+		WorkloadKind:    f.GetWorkloadKind(),
+		Workload:        f.GetWorkload(),
+		Labels:          f.GetLabels(),
+		NamespaceLabels: f.GetNamespaceLabels(),
+	}
+}
+
+// ParseDirection converts the direction used by types.AggregatedInfo
+func ParseDirection(direction string) Direction {
+	switch direction {
+	case "inbound":
+		return Direction_DIRECTION_INBOUND
+	case "outbound":
+		return Direction_DIRECTION_OUTBOUND
+	default:
+		return Direction_DIRECTION_UNSPECIFIED
+	}
+}
+
+// Name returns the direction as used by types.AggregatedInfo
+func (d Direction) Name() string {
+	switch d {
+	case Direction_DIRECTION_INBOUND:
+		return "inbound"
+	case Direction_DIRECTION_OUTBOUND:
+		return "outbound"
+	default:
+		return ""
+	}
+}
+
+// ParseProtocol converts the protocol used by types.AggregatedInfo
+func ParseProtocol(protocol string) Protocol {
+	switch strings.ToUpper(protocol) {
+	case "TCP":
+		return Protocol_PROTOCOL_TCP
+	case "UDP":
+		return Protocol_PROTOCOL_UDP
+	default:
+		return Protocol_PROTOCOL_UNSPECIFIED
+	}
+}
+
+// Name returns the protocol as used by types.AggregatedInfo
+func (p Protocol) Name() string {
+	switch p {
+	case Protocol_PROTOCOL_TCP:
+		return "TCP"
+	case Protocol_PROTOCOL_UDP:
+		return "UDP"
+	default:
+		return ""
+	}
+}
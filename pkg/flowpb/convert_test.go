@@ -0,0 +1,56 @@
+package flowpb
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+func TestAggregatedInfoRoundTrip(t *testing.T) {
+	start := time.Date(2024, 2, 14, 12, 34, 56, 0, time.UTC)
+	agg := types.AggregatedInfo{
+		Namespace:       "default",
+		Name:            "nginx",
+		StartTime:       start,
+		EndTime:         start.Add(1500 * time.Millisecond),
+		Source:          "10.0.0.1",
+		Destination:     "8.8.8.8",
+		Protocol:        "TCP",
+		Port:            "443",
+		Direction:       "outbound",
+		TotalBytes:      1234,
+		Packets:         10,
+		Tags:            []string{"tor"},
+		OwnerTags:       []string{"pci"},
+		Kind:            "Pod",
+		WorkloadKind:    "Deployment",
+		Workload:        "nginx",
+		Labels:          map[string]string{"app.kubernetes.io/name": "nginx"},
+		NamespaceLabels: map[string]string{"team": "web"},
+	}
+
+	flow := FromAggregatedInfo(agg)
+	assert.Equal(t, Protocol_PROTOCOL_TCP, flow.GetProtocol())
+	assert.Equal(t, Direction_DIRECTION_OUTBOUND, flow.GetDirection())
+	assert.Equal(t, uint32(443), flow.GetPort())
+	assert.Equal(t, 1500*time.Millisecond, flow.GetDuration().AsDuration())
+
+	// Encode as a length-delimited stream and read it back
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		_, err := protodelim.MarshalTo(&buf, flow)
+		require.NoError(t, err)
+	}
+	r := bufio.NewReader(&buf)
+	for i := 0; i < 2; i++ {
+		var decoded Flow
+		require.NoError(t, protodelim.UnmarshalFrom(r, &decoded))
+		assert.Equal(t, agg, decoded.ToAggregatedInfo())
+	}
+}
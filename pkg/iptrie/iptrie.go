@@ -0,0 +1,191 @@
+// Package iptrie implements a path-compressed binary trie of IP prefixes
+package iptrie
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// Trie maps IPv4 and IPv6 prefixes to values. Lookups walk at most one node
+// per distinct prefix length on the path of the address, so checking an
+// address against hundreds of thousands of prefixes stays cheap. A Trie is
+// not safe for concurrent modification, but concurrent lookups are fine once
+// it is built.
+type Trie[V any] struct {
+	v4  *node[V]
+	v6  *node[V]
+	len int
+}
+
+type node[V any] struct {
+	prefix   netip.Prefix
+	value    V
+	set      bool
+	children [2]*node[V]
+}
+
+// New creates an empty trie
+func New[V any]() *Trie[V] {
+	return &Trie[V]{}
+}
+
+// Len returns the number of prefixes in the trie
+func (t *Trie[V]) Len() int {
+	return t.len
+}
+
+// Insert sets the value of prefix p, replacing any previous value. IPv4
+// mapped IPv6 prefixes are stored as IPv4.
+func (t *Trie[V]) Insert(p netip.Prefix, v V) {
+	p = normalize(p)
+	if !p.IsValid() {
+		return
+	}
+
+	n := t.root(p.Addr())
+	for {
+		if *n == nil {
+			*n = &node[V]{prefix: p, value: v, set: true}
+			t.len++
+			return
+		}
+
+		cur := *n
+		common := commonBits(cur.prefix, p)
+		switch {
+		case common == cur.prefix.Bits() && common == p.Bits():
+			if !cur.set {
+				t.len++
+			}
+			cur.value, cur.set = v, true
+			return
+		case common == cur.prefix.Bits():
+			// p lies below cur
+			n = &cur.children[bitAt(p.Addr(), common)]
+			continue
+		}
+
+		// Split cur at the first differing bit
+		split := &node[V]{prefix: netip.PrefixFrom(p.Addr(), common).Masked()}
+		split.children[bitAt(cur.prefix.Addr(), common)] = cur
+		if common == p.Bits() {
+			split.value, split.set = v, true
+		} else {
+			split.children[bitAt(p.Addr(), common)] = &node[V]{prefix: p, value: v, set: true}
+		}
+		*n = split
+		t.len++
+		return
+	}
+}
+
+// Get returns the value of exactly prefix p
+func (t *Trie[V]) Get(p netip.Prefix) (V, bool) {
+	p = normalize(p)
+	var zero V
+	if !p.IsValid() {
+		return zero, false
+	}
+
+	n := *t.root(p.Addr())
+	for n != nil && n.prefix.Bits() <= p.Bits() && n.prefix.Contains(p.Addr()) {
+		if n.prefix.Bits() == p.Bits() {
+			if n.set {
+				return n.value, true
+			}
+			break
+		}
+		n = n.children[bitAt(p.Addr(), n.prefix.Bits())]
+	}
+	return zero, false
+}
+
+// Lookup returns the value of the longest prefix containing addr
+func (t *Trie[V]) Lookup(addr netip.Addr) (V, bool) {
+	var value V
+	var found bool
+	t.walk(addr, func(v V) {
+		value, found = v, true
+	})
+	return value, found
+}
+
+// Matches returns the values of all prefixes containing addr, from the
+// shortest to the longest prefix
+func (t *Trie[V]) Matches(addr netip.Addr) []V {
+	var values []V
+	t.walk(addr, func(v V) {
+		values = append(values, v)
+	})
+	return values
+}
+
+// walk calls fn with the values of the prefixes containing addr
+func (t *Trie[V]) walk(addr netip.Addr, fn func(V)) {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return
+	}
+
+	n := *t.root(addr)
+	for n != nil && n.prefix.Contains(addr) {
+		if n.set {
+			fn(n.value)
+		}
+		if n.prefix.Bits() == addr.BitLen() {
+			return
+		}
+		n = n.children[bitAt(addr, n.prefix.Bits())]
+	}
+}
+
+func (t *Trie[V]) root(addr netip.Addr) **node[V] {
+	if addr.Is4() {
+		return &t.v4
+	}
+	return &t.v6
+}
+
+// normalize unmaps IPv4 mapped prefixes and clears the host bits
+func normalize(p netip.Prefix) netip.Prefix {
+	if !p.IsValid() {
+		return p
+	}
+	if addr := p.Addr(); addr.Is4In6() {
+		bits := p.Bits() - 96
+		if bits < 0 {
+			return netip.Prefix{}
+		}
+		p = netip.PrefixFrom(addr.Unmap(), bits)
+	}
+	return p.Masked()
+}
+
+// bitAt returns bit i of addr, counting from the most significant bit
+func bitAt(addr netip.Addr, i int) int {
+	b := addr.As16()
+	if addr.Is4() {
+		i += 96
+	}
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits returns the length of the common prefix of a and b
+func commonBits(a, b netip.Prefix) int {
+	limit := min(a.Bits(), b.Bits())
+	x, y := a.Addr().As16(), b.Addr().As16()
+	offset := 0
+	if a.Addr().Is4() {
+		offset = 96
+	}
+
+	n := 0
+	for i := offset / 8; i < 16 && n < limit; i++ {
+		if diff := x[i] ^ y[i]; diff != 0 {
+			n += bits.LeadingZeros8(diff)
+			break
+		}
+		n += 8
+	}
+	return min(n, limit)
+}
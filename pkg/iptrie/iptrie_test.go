@@ -0,0 +1,135 @@
+package iptrie
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrie(t *testing.T) {
+	trie := New[string]()
+	for _, p := range []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "192.168.0.0/24", "2001:db8::/32", "0.0.0.0/0"} {
+		trie.Insert(netip.MustParsePrefix(p), p)
+	}
+	assert.Equal(t, 6, trie.Len())
+
+	for addr, want := range map[string]string{
+		"10.1.2.3":         "10.1.2.3/32",
+		"10.1.2.4":         "10.1.0.0/16",
+		"10.2.0.1":         "10.0.0.0/8",
+		"192.168.0.255":    "192.168.0.0/24",
+		"192.168.1.1":      "0.0.0.0/0",
+		"::ffff:10.1.2.3":  "10.1.2.3/32",
+		"2001:db8:1::1":    "2001:db8::/32",
+		"2001:db9::1":      "",
+		"fe80::1%eth0":     "",
+		"255.255.255.255":  "0.0.0.0/0",
+		"2001:db8:ffff::1": "2001:db8::/32",
+	} {
+		got, ok := trie.Lookup(netip.MustParseAddr(addr))
+		assert.Equal(t, want != "", ok, addr)
+		assert.Equal(t, want, got, addr)
+	}
+
+	assert.Equal(t, []string{"0.0.0.0/0", "10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32"}, trie.Matches(netip.MustParseAddr("10.1.2.3")))
+	assert.Empty(t, trie.Matches(netip.Addr{}))
+}
+
+func TestTrieInsert(t *testing.T) {
+	trie := New[int]()
+
+	// Host bits are cleared and existing prefixes are replaced
+	trie.Insert(netip.MustParsePrefix("10.1.2.3/16"), 1)
+	trie.Insert(netip.MustParsePrefix("10.1.0.0/16"), 2)
+	assert.Equal(t, 1, trie.Len())
+
+	v, ok := trie.Get(netip.MustParsePrefix("10.1.0.0/16"))
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	// Inserting a prefix that splits an edge
+	trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), 3)
+	trie.Insert(netip.MustParsePrefix("10.128.0.0/9"), 4)
+	assert.Equal(t, 3, trie.Len())
+
+	_, ok = trie.Get(netip.MustParsePrefix("10.0.0.0/9"))
+	assert.False(t, ok)
+	v, ok = trie.Get(netip.MustParsePrefix("10.0.0.0/8"))
+	assert.True(t, ok)
+	assert.Equal(t, 3, v)
+
+	v, _ = trie.Lookup(netip.MustParseAddr("10.200.0.1"))
+	assert.Equal(t, 4, v)
+	v, _ = trie.Lookup(netip.MustParseAddr("10.1.200.1"))
+	assert.Equal(t, 2, v)
+}
+
+func TestTrieRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	trie := New[netip.Prefix]()
+	var prefixes []netip.Prefix
+	for i := 0; i < 2000; i++ {
+		var b [4]byte
+		rnd.Read(b[:])
+		p := netip.PrefixFrom(netip.AddrFrom4(b), 8+rnd.Intn(25)).Masked()
+		prefixes = append(prefixes, p)
+		trie.Insert(p, p)
+	}
+
+	for i := 0; i < 2000; i++ {
+		var b [4]byte
+		rnd.Read(b[:])
+		addr := netip.AddrFrom4(b)
+
+		var want netip.Prefix
+		for _, p := range prefixes {
+			if p.Contains(addr) && (!want.IsValid() || p.Bits() > want.Bits()) {
+				want = p
+			}
+		}
+		got, ok := trie.Lookup(addr)
+		require.Equal(t, want.IsValid(), ok, addr)
+		require.Equal(t, want, got, addr)
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	trie := New[struct{}]()
+	for i := 0; i < 100000; i++ {
+		var ip [4]byte
+		rnd.Read(ip[:])
+		trie.Insert(netip.PrefixFrom(netip.AddrFrom4(ip), 32), struct{}{})
+	}
+
+	addrs := make([]netip.Addr, 1024)
+	for i := range addrs {
+		addrs[i] = netip.MustParseAddr(fmt.Sprintf("10.%d.%d.%d", rnd.Intn(256), rnd.Intn(256), rnd.Intn(256)))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Lookup(addrs[i%len(addrs)])
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	for s, want := range map[string]string{
+		"198.51.100.7":        "198.51.100.7/32",
+		"198.51.100.7/24":     "198.51.100.0/24",
+		"::ffff:198.51.100.7": "198.51.100.7/32",
+		"2001:db8::1":         "2001:db8::1/128",
+	} {
+		p, err := ParsePrefix(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, want, p.String(), s)
+	}
+	for _, s := range []string{"", "198.51.100.300", "198.51.100.0/33", "example.com"} {
+		_, err := ParsePrefix(s)
+		assert.Error(t, err, s)
+	}
+}
@@ -0,0 +1,24 @@
+package iptrie
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// ParsePrefix parses an IP address or a CIDR. Addresses become single host
+// prefixes, the host bits of CIDRs are cleared.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,513 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Kinds of the objects in the index
+const (
+	KindPod     = "Pod"
+	KindService = "Service"
+	KindNode    = "Node"
+
+	KindOvnFip          = "OvnFip"
+	KindIptablesFIPRule = "IptablesFIPRule"
+	KindOvnDnatRule     = "OvnDnatRule"
+	KindOvnSnatRule     = "OvnSnatRule"
+	KindIptablesEIP     = "IptablesEIP"
+	KindOvnEip          = "OvnEip"
+)
+
+// DefaultPrecedence is the order in which the kinds of the index are
+// preferred when an IP address belongs to objects of several kinds. The
+// kube-ovn rules binding a public IP to one workload come before the SNAT
+// rules sharing it, and these before the bare EIPs.
+var DefaultPrecedence = []string{
+	KindPod, KindService, KindNode,
+	KindOvnFip, KindIptablesFIPRule, KindOvnDnatRule, KindOvnSnatRule, KindIptablesEIP, KindOvnEip,
+}
+
+// natResources are the kube-ovn resources binding public IPs. They are
+// indexed by the EIP label kube-ovn sets on them and watched when the API
+// server serves them. Only the metadata is watched, except for the DNAT
+// rules whose external port is in their spec.
+var natResources = map[string]schema.GroupVersionResource{
+	KindOvnFip:          {Group: "kubeovn.io", Version: "v1", Resource: "ovn-fips"},
+	KindIptablesFIPRule: {Group: "kubeovn.io", Version: "v1", Resource: "iptables-fip-rules"},
+	KindOvnDnatRule:     {Group: "kubeovn.io", Version: "v1", Resource: "ovn-dnat-rules"},
+	KindOvnSnatRule:     {Group: "kubeovn.io", Version: "v1", Resource: "ovn-snat-rules"},
+	KindIptablesEIP:     {Group: "kubeovn.io", Version: "v1", Resource: "iptables-eips"},
+	KindOvnEip:          {Group: "kubeovn.io", Version: "v1", Resource: "ovn-eips"},
+}
+
+// SplitName returns the namespace and name of the owner of a kube-ovn NAT
+// object named namespace-name
+func SplitName(name string) (namespace, owner string, ok bool) {
+	return strings.Cut(name, "-")
+}
+
+// ipIndex is the name of the informer index by IP address
+const ipIndex = "ip"
+
+// portIndex is the name of the informer index of DNAT rules by IP address
+// and external port, see dnatPorts
+const portIndex = "port"
+
+// maxOwnerDepth limits the owner references followed from a pod
+const maxOwnerDepth = 10
+
+// ownerResources are the controllers that are walked up to the workload
+// owning them, e.g. from a ReplicaSet to its Deployment. Their metadata is
+// watched when workloads are resolved and the API server serves them.
+var ownerResources = map[schema.GroupKind]schema.GroupVersionResource{
+	{Group: "apps", Kind: "ReplicaSet"}:                    {Group: "apps", Version: "v1", Resource: "replicasets"},
+	{Group: "kubevirt.io", Kind: "VirtualMachineInstance"}: {Group: "kubevirt.io", Version: "v1", Resource: "virtualmachineinstances"},
+}
+
+// IndexOptions configures an index
+type IndexOptions struct {
+	// Precedence orders the kinds preferred when an IP address belongs to
+	// objects of several kinds. Kinds are case insensitive, kinds left out
+	// are not watched.
+	Precedence []string
+	// Workloads resolves the controllers owning pods, such as the
+	// Deployment of their ReplicaSet
+	Workloads bool
+	// Labels are the labels of the objects copied to their owners
+	Labels []string
+	// NamespaceLabels are the labels of the namespaces copied to the owners
+	NamespaceLabels []string
+}
+
+// Owner is the object an IP address belongs to. The namespace of nodes is
+// empty. The namespace and name of kube-ovn NAT objects are taken from
+// their names, see SplitName.
+type Owner struct {
+	Kind      string
+	Namespace string
+	Name      string
+	// WorkloadKind and Workload name the controller at the top of the owner
+	// references of a pod, or the pod itself when it has none. They are
+	// empty for other kinds and when workloads are not resolved.
+	WorkloadKind string
+	Workload     string
+	// Labels and NamespaceLabels are the labels of the allow-lists found on
+	// the object and its namespace
+	Labels          map[string]string
+	NamespaceLabels map[string]string
+}
+
+// namedInformer is an informer and the name it is reported by
+type namedInformer struct {
+	name     string
+	informer cache.SharedIndexInformer
+}
+
+// Index maps the IP addresses of Pods, Services, Nodes and kube-ovn NAT
+// objects to their objects. It is kept up to date by informers once started.
+type Index struct {
+	factory         informers.SharedInformerFactory
+	metadataFactory metadatainformer.SharedInformerFactory
+	dynamicFactory  dynamicinformer.DynamicSharedInformerFactory
+	precedence      []string
+	informers       map[string]cache.SharedIndexInformer
+	owners          map[schema.GroupKind]cache.SharedIndexInformer
+	namespaces      cache.SharedIndexInformer
+	labels          []string
+	namespaceLabels []string
+	workloads       bool
+	all             []namedInformer
+}
+
+// NewIndex creates an index. kube-ovn kinds whose resources are not served
+// are left out. Resolving workloads needs permission to list and watch
+// ReplicaSets and, when installed, KubeVirt VirtualMachineInstances,
+// copying namespace labels to list and watch Namespaces.
+func (c *Client) NewIndex(opts IndexOptions) (*Index, error) {
+	return newIndex(c.clientset, c.metadata, c.dynamic, opts)
+}
+
+func newIndex(clientset kubernetes.Interface, metadataClient metadata.Interface, dynamicClient dynamic.Interface, opts IndexOptions) (*Index, error) {
+	if len(opts.Precedence) == 0 {
+		return nil, errors.New("index needs at least one kind")
+	}
+	i := &Index{
+		factory:         informers.NewSharedInformerFactory(clientset, 0),
+		metadataFactory: metadatainformer.NewSharedInformerFactory(metadataClient, 0),
+		dynamicFactory:  dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0),
+		informers:       make(map[string]cache.SharedIndexInformer),
+		owners:          make(map[schema.GroupKind]cache.SharedIndexInformer),
+		labels:          opts.Labels,
+		namespaceLabels: opts.NamespaceLabels,
+	}
+	for _, name := range opts.Precedence {
+		var kind string
+		var informer cache.SharedIndexInformer
+		var indexFunc cache.IndexFunc
+		switch {
+		case strings.EqualFold(name, KindPod):
+			kind, informer, indexFunc = KindPod, i.factory.Core().V1().Pods().Informer(), podIPs
+		case strings.EqualFold(name, KindService):
+			kind, informer, indexFunc = KindService, i.factory.Core().V1().Services().Informer(), serviceIPs
+		case strings.EqualFold(name, KindNode):
+			kind, informer, indexFunc = KindNode, i.factory.Core().V1().Nodes().Informer(), nodeIPs
+		default:
+			for natKind, resource := range natResources {
+				if !strings.EqualFold(name, natKind) {
+					continue
+				}
+				served, err := serves(clientset, resource)
+				if err != nil {
+					return nil, err
+				}
+				kind, indexFunc = natKind, natIPs
+				if served && natKind == KindOvnDnatRule {
+					informer = i.dynamicFactory.ForResource(resource).Informer()
+				} else if served {
+					informer = i.metadataFactory.ForResource(resource).Informer()
+				}
+			}
+			if kind == "" {
+				return nil, fmt.Errorf("unknown kind %s", name)
+			}
+		}
+		if _, ok := i.informers[kind]; ok {
+			return nil, fmt.Errorf("duplicate kind %s", kind)
+		}
+		if informer == nil {
+			// kube-ovn is not installed or lacks the resource
+			i.informers[kind] = nil
+			continue
+		}
+		if err := informer.SetTransform(stripObject); err != nil {
+			return nil, fmt.Errorf("failed to set %s transform: %w", kind, err)
+		}
+		indexers := cache.Indexers{ipIndex: indexFunc}
+		if kind == KindOvnDnatRule {
+			indexers[portIndex] = dnatPorts
+		}
+		if err := informer.AddIndexers(indexers); err != nil {
+			return nil, fmt.Errorf("failed to add %s index: %w", kind, err)
+		}
+		i.precedence = append(i.precedence, kind)
+		i.informers[kind] = informer
+		i.all = append(i.all, namedInformer{name: kind, informer: informer})
+	}
+
+	if opts.Workloads && i.informers[KindPod] != nil {
+		i.workloads = true
+		groupKinds := make([]schema.GroupKind, 0, len(ownerResources))
+		for groupKind := range ownerResources {
+			groupKinds = append(groupKinds, groupKind)
+		}
+		sort.Slice(groupKinds, func(a, b int) bool { return groupKinds[a].Kind < groupKinds[b].Kind })
+		for _, groupKind := range groupKinds {
+			resource := ownerResources[groupKind]
+			served, err := serves(clientset, resource)
+			if err != nil {
+				return nil, err
+			}
+			if !served {
+				continue
+			}
+			informer := i.metadataFactory.ForResource(resource).Informer()
+			if err := informer.SetTransform(stripObject); err != nil {
+				return nil, fmt.Errorf("failed to set %s transform: %w", groupKind.Kind, err)
+			}
+			i.owners[groupKind] = informer
+			i.all = append(i.all, namedInformer{name: groupKind.Kind, informer: informer})
+		}
+	}
+
+	if len(opts.NamespaceLabels) > 0 {
+		i.namespaces = i.factory.Core().V1().Namespaces().Informer()
+		if err := i.namespaces.SetTransform(stripObject); err != nil {
+			return nil, fmt.Errorf("failed to set Namespace transform: %w", err)
+		}
+		i.all = append(i.all, namedInformer{name: "Namespace", informer: i.namespaces})
+	}
+	return i, nil
+}
+
+// serves reports whether the API server serves resource
+func serves(clientset kubernetes.Interface, resource schema.GroupVersionResource) (bool, error) {
+	list, err := clientset.Discovery().ServerResourcesForGroupVersion(resource.GroupVersion().String())
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to discover %s: %w", resource.GroupVersion(), err)
+	}
+	for _, r := range list.APIResources {
+		if r.Name == resource.Resource {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Start starts the informers, they stop when ctx is done
+func (i *Index) Start(ctx context.Context) {
+	i.factory.Start(ctx.Done())
+	i.metadataFactory.Start(ctx.Done())
+	i.dynamicFactory.Start(ctx.Done())
+}
+
+// WaitForSync blocks until the informers listed every object or ctx is done
+func (i *Index) WaitForSync(ctx context.Context) error {
+	synced := make([]cache.InformerSynced, 0, len(i.all))
+	for _, n := range i.all {
+		synced = append(synced, n.informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync informers: %w", ctx.Err())
+	}
+	return nil
+}
+
+// Ready returns an error until every informer is synced
+func (i *Index) Ready(ctx context.Context) error {
+	var pending []string
+	for _, n := range i.all {
+		if !n.informer.HasSynced() {
+			pending = append(pending, n.name)
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("informers not synced: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Lookup returns the preferred owner of ip
+func (i *Index) Lookup(ip string) (Owner, bool) {
+	owners := i.LookupAll(ip)
+	if len(owners) == 0 {
+		return Owner{}, false
+	}
+	return owners[0], true
+}
+
+// LookupAll returns every owner of ip, ordered by the precedence of their
+// kinds and then by namespace and name
+func (i *Index) LookupAll(ip string) []Owner {
+	return i.LookupPort(ip, "")
+}
+
+// LookupPort returns the owners of ip like LookupAll, but only the DNAT
+// rules whose external port is port. All DNAT rules of ip are returned when
+// port is empty.
+func (i *Index) LookupPort(ip, port string) []Owner {
+	var owners []Owner
+	for _, kind := range i.precedence {
+		index, key := ipIndex, ip
+		if kind == KindOvnDnatRule && port != "" {
+			index, key = portIndex, net.JoinHostPort(ip, port)
+		}
+		objs, err := i.informers[kind].GetIndexer().ByIndex(index, key)
+		if err != nil {
+			continue
+		}
+		start := len(owners)
+		for _, obj := range objs {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				continue
+			}
+			owners = append(owners, i.owner(kind, accessor))
+		}
+		sort.Slice(owners[start:], func(a, b int) bool {
+			oa, ob := owners[start+a], owners[start+b]
+			if oa.Namespace != ob.Namespace {
+				return oa.Namespace < ob.Namespace
+			}
+			return oa.Name < ob.Name
+		})
+	}
+	return owners
+}
+
+// owner returns the owner of an object of kind
+func (i *Index) owner(kind string, obj metav1.Object) Owner {
+	owner := Owner{
+		Kind:      kind,
+		Namespace: obj.GetNamespace(),
+		Name:      obj.GetName(),
+		Labels:    selectLabels(obj.GetLabels(), i.labels),
+	}
+	if _, ok := natResources[kind]; ok {
+		if namespace, name, ok := SplitName(owner.Name); ok {
+			owner.Namespace, owner.Name = namespace, name
+		}
+	}
+	if kind == KindPod && i.workloads {
+		owner.WorkloadKind, owner.Workload = i.workload(kind, obj)
+	}
+	if i.namespaces != nil && owner.Namespace != "" {
+		if item, exists, err := i.namespaces.GetIndexer().GetByKey(owner.Namespace); err == nil && exists {
+			if ns, err := meta.Accessor(item); err == nil {
+				owner.NamespaceLabels = selectLabels(ns.GetLabels(), i.namespaceLabels)
+			}
+		}
+	}
+	return owner
+}
+
+// workload follows the controller references of obj of kind as long as the
+// controllers are watched, and returns the last one
+func (i *Index) workload(kind string, obj metav1.Object) (string, string) {
+	name := obj.GetName()
+	for depth := 0; depth < maxOwnerDepth; depth++ {
+		ref := metav1.GetControllerOfNoCopy(obj)
+		if ref == nil {
+			break
+		}
+		kind, name = ref.Kind, ref.Name
+		gv, err := schema.ParseGroupVersion(ref.APIVersion)
+		if err != nil {
+			break
+		}
+		informer, ok := i.owners[schema.GroupKind{Group: gv.Group, Kind: ref.Kind}]
+		if !ok {
+			break
+		}
+		item, exists, err := informer.GetIndexer().GetByKey(obj.GetNamespace() + "/" + ref.Name)
+		if err != nil || !exists {
+			break
+		}
+		if obj, err = meta.Accessor(item); err != nil {
+			break
+		}
+	}
+	return kind, name
+}
+
+// selectLabels returns the labels of keys, nil if none is set
+func selectLabels(labels map[string]string, keys []string) map[string]string {
+	var selected map[string]string
+	for _, key := range keys {
+		if value, ok := labels[key]; ok {
+			if selected == nil {
+				selected = make(map[string]string, len(keys))
+			}
+			selected[key] = value
+		}
+	}
+	return selected
+}
+
+// natIPs indexes kube-ovn NAT objects by the IPv4 address of their EIP
+func natIPs(obj any) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, nil
+	}
+	if ip := accessor.GetLabels()[eipLabel]; ip != "" {
+		return []string{ip}, nil
+	}
+	return nil, nil
+}
+
+// dnatPorts indexes kube-ovn DNAT rules by the IPv4 address of their EIP
+// and their external port, joined as host:port
+func dnatPorts(obj any) ([]string, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	ip := u.GetLabels()[eipLabel]
+	port, _, _ := unstructured.NestedString(u.Object, "spec", "externalPort")
+	if ip == "" || port == "" {
+		return nil, nil
+	}
+	return []string{net.JoinHostPort(ip, port)}, nil
+}
+
+// stripObject drops the fields the index doesn't need to save memory
+func stripObject(obj any) (any, error) {
+	if accessor, err := meta.Accessor(obj); err == nil {
+		accessor.SetManagedFields(nil)
+		accessor.SetAnnotations(nil)
+	}
+	return obj, nil
+}
+
+// podIPs indexes the IPs of running pods. Host network pods share the IPs
+// of their node and are left to the node.
+func podIPs(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || pod.Spec.HostNetwork {
+		return nil, nil
+	}
+	// The IPs of completed pods are reused by new pods
+	if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return nil, nil
+	}
+	var ips []string
+	for _, ip := range pod.Status.PodIPs {
+		ips = append(ips, ip.IP)
+	}
+	if len(ips) == 0 && pod.Status.PodIP != "" {
+		ips = append(ips, pod.Status.PodIP)
+	}
+	return ips, nil
+}
+
+// serviceIPs indexes the cluster IPs, external IPs and load balancer
+// ingress IPs of services
+func serviceIPs(obj any) ([]string, error) {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, ip := range svc.Spec.ClusterIPs {
+		if ip != "" && ip != corev1.ClusterIPNone {
+			ips = append(ips, ip)
+		}
+	}
+	if len(svc.Spec.ClusterIPs) == 0 && svc.Spec.ClusterIP != "" && svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		ips = append(ips, svc.Spec.ClusterIP)
+	}
+	ips = append(ips, svc.Spec.ExternalIPs...)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		if ingress.IP != "" {
+			ips = append(ips, ingress.IP)
+		}
+	}
+	return ips, nil
+}
+
+// nodeIPs indexes the internal and external addresses of nodes
+func nodeIPs(obj any) ([]string, error) {
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return nil, nil
+	}
+	var ips []string
+	for _, addr := range node.Status.Addresses {
+		if addr.Type == corev1.NodeInternalIP || addr.Type == corev1.NodeExternalIP {
+			ips = append(ips, addr.Address)
+		}
+	}
+	return ips, nil
+}
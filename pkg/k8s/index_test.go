@@ -0,0 +1,313 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/utils/ptr"
+)
+
+func newFakeMetadata(objects ...runtime.Object) *metadatafake.FakeMetadataClient {
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	return metadatafake.NewSimpleMetadataClient(scheme, objects...)
+}
+
+func newFakeDynamic() *fakedynamic.FakeDynamicClient {
+	return fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{natResources[KindOvnDnatRule]: "OvnDnatRuleList"})
+}
+
+func TestIndex(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "nginx"},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIPs: []corev1.PodIP{{IP: "10.244.0.5"}}},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "job-done"},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded, PodIP: "10.244.0.6"},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "kube-proxy"},
+			Spec:       corev1.PodSpec{HostNetwork: true},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "192.168.1.10"},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"},
+			Spec:       corev1.ServiceSpec{ClusterIPs: []string{"10.96.0.10"}, ExternalIPs: []string{"203.0.113.7"}},
+			Status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{{IP: "192.168.1.10"}, {Hostname: "lb.example.com"}},
+			}},
+		},
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "headless"},
+			Spec:       corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "worker-1"},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.1.10"},
+				{Type: corev1.NodeHostName, Address: "worker-1"},
+			}},
+		},
+	)
+
+	index, err := newIndex(clientset, newFakeMetadata(), newFakeDynamic(), IndexOptions{Precedence: []string{"pod", "service", "node"}})
+	require.NoError(t, err)
+	assert.Error(t, index.Ready(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index.Start(ctx)
+	require.NoError(t, index.WaitForSync(ctx))
+	assert.NoError(t, index.Ready(ctx))
+
+	owner, ok := index.Lookup("10.244.0.5")
+	assert.True(t, ok)
+	assert.Equal(t, Owner{Kind: KindPod, Namespace: "default", Name: "nginx"}, owner)
+
+	owner, ok = index.Lookup("203.0.113.7")
+	assert.True(t, ok)
+	assert.Equal(t, Owner{Kind: KindService, Namespace: "default", Name: "web"}, owner)
+
+	// The load balancer uses the node address, the host network pod is left out
+	assert.Equal(t, []Owner{
+		{Kind: KindService, Namespace: "default", Name: "web"},
+		{Kind: KindNode, Name: "worker-1"},
+	}, index.LookupAll("192.168.1.10"))
+
+	for _, ip := range []string{"10.244.0.6", "None", "worker-1", "8.8.8.8"} {
+		_, ok := index.Lookup(ip)
+		assert.False(t, ok, ip)
+	}
+
+	// Nodes take precedence over services
+	index, err = newIndex(clientset, newFakeMetadata(), newFakeDynamic(), IndexOptions{Precedence: []string{"Node", "Service"}})
+	require.NoError(t, err)
+	index.Start(ctx)
+	require.NoError(t, index.WaitForSync(ctx))
+	owner, ok = index.Lookup("192.168.1.10")
+	assert.True(t, ok)
+	assert.Equal(t, Owner{Kind: KindNode, Name: "worker-1"}, owner)
+	_, ok = index.Lookup("10.244.0.5")
+	assert.False(t, ok)
+}
+
+func TestNewIndex(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	_, err := newIndex(clientset, newFakeMetadata(), newFakeDynamic(), IndexOptions{})
+	assert.Error(t, err)
+	_, err = newIndex(clientset, newFakeMetadata(), newFakeDynamic(), IndexOptions{Precedence: []string{"pod", "deployment"}})
+	assert.Error(t, err)
+	_, err = newIndex(clientset, newFakeMetadata(), newFakeDynamic(), IndexOptions{Precedence: []string{"pod", "Pod"}})
+	assert.Error(t, err)
+}
+
+func controlledBy(apiVersion, kind, name string) []metav1.OwnerReference {
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, Controller: ptr.To(true)}}
+}
+
+func TestIndexWorkloads(t *testing.T) {
+	pod := func(name, ip string, owners []metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:       "shop",
+				Name:            name,
+				Labels:          map[string]string{"app.kubernetes.io/name": name, "pod-template-hash": "7f9f9f9f9f"},
+				OwnerReferences: owners,
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		pod("web-7f9f9f9f9f-x2x4z", "10.244.0.10", controlledBy("apps/v1", "ReplicaSet", "web-7f9f9f9f9f")),
+		pod("db-0", "10.244.0.11", controlledBy("apps/v1", "StatefulSet", "db")),
+		pod("virt-launcher-vm1-abcde", "10.244.0.12", controlledBy("kubevirt.io/v1", "VirtualMachineInstance", "vm1")),
+		pod("orphan-5d4c-qwert", "10.244.0.13", controlledBy("apps/v1", "ReplicaSet", "orphan-5d4c")),
+		pod("debug", "10.244.0.14", nil),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"team": "payments", "env": "prod"}}},
+	)
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{GroupVersion: "apps/v1", APIResources: []metav1.APIResource{{Name: "replicasets", Namespaced: true, Kind: "ReplicaSet"}}},
+		{GroupVersion: "kubevirt.io/v1", APIResources: []metav1.APIResource{{Name: "virtualmachineinstances", Namespaced: true, Kind: "VirtualMachineInstance"}}},
+	}
+	metadataClient := newFakeMetadata(
+		&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "ReplicaSet"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "web-7f9f9f9f9f", OwnerReferences: controlledBy("apps/v1", "Deployment", "web")},
+		},
+		&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "kubevirt.io/v1", Kind: "VirtualMachineInstance"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "shop", Name: "vm1", OwnerReferences: controlledBy("kubevirt.io/v1", "VirtualMachine", "vm1")},
+		},
+	)
+
+	index, err := newIndex(clientset, metadataClient, newFakeDynamic(), IndexOptions{
+		Precedence:      []string{"pod"},
+		Workloads:       true,
+		Labels:          []string{"app.kubernetes.io/name", "version"},
+		NamespaceLabels: []string{"team"},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index.Start(ctx)
+	require.NoError(t, index.WaitForSync(ctx))
+
+	for ip, workload := range map[string][2]string{
+		"10.244.0.10": {"Deployment", "web"},
+		"10.244.0.11": {"StatefulSet", "db"},
+		"10.244.0.12": {"VirtualMachine", "vm1"},
+		// The ReplicaSet is gone, it is the last controller known
+		"10.244.0.13": {"ReplicaSet", "orphan-5d4c"},
+		"10.244.0.14": {"Pod", "debug"},
+	} {
+		owner, ok := index.Lookup(ip)
+		require.True(t, ok, ip)
+		assert.Equal(t, workload, [2]string{owner.WorkloadKind, owner.Workload}, ip)
+	}
+
+	owner, _ := index.Lookup("10.244.0.10")
+	assert.Equal(t, map[string]string{"app.kubernetes.io/name": "web-7f9f9f9f9f-x2x4z"}, owner.Labels)
+	assert.Equal(t, map[string]string{"team": "payments"}, owner.NamespaceLabels)
+
+	// KubeVirt is not installed
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources[:1]
+	index, err = newIndex(clientset, metadataClient, newFakeDynamic(), IndexOptions{Precedence: []string{"pod"}, Workloads: true})
+	require.NoError(t, err)
+	index.Start(ctx)
+	require.NoError(t, index.WaitForSync(ctx))
+	owner, _ = index.Lookup("10.244.0.12")
+	assert.Equal(t, [2]string{"VirtualMachineInstance", "vm1"}, [2]string{owner.WorkloadKind, owner.Workload})
+	assert.Nil(t, owner.NamespaceLabels)
+}
+
+func TestIndexNAT(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// ovn-dnat-rules are not served
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{GroupVersion: "kubeovn.io/v1", APIResources: []metav1.APIResource{
+			{Name: "ovn-fips", Kind: "OvnFip"},
+			{Name: "ovn-snat-rules", Kind: "OvnSnatRule"},
+			{Name: "ovn-eips", Kind: "OvnEip"},
+			{Name: "iptables-eips", Kind: "IptablesEIP"},
+			{Name: "iptables-fip-rules", Kind: "IptablesFIPRule"},
+		}},
+	}
+	nat := func(kind, name, ip string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "kubeovn.io/v1", Kind: kind},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{eipLabel: ip}},
+		}
+	}
+	scheme := metadatafake.NewTestScheme()
+	metav1.AddMetaToScheme(scheme)
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme)
+	for _, obj := range []struct {
+		resource string
+		object   *metav1.PartialObjectMetadata
+	}{
+		{"ovn-eips", nat(KindOvnEip, "shop-gw", "203.0.113.20")},
+		{"ovn-snat-rules", nat(KindOvnSnatRule, "shop-subnet1", "203.0.113.20")},
+		{"ovn-snat-rules", nat(KindOvnSnatRule, "shop-subnet2", "203.0.113.20")},
+		{"iptables-eips", nat(KindIptablesEIP, "crm-eip1", "203.0.113.21")},
+		{"iptables-fip-rules", nat(KindIptablesFIPRule, "crm-vm1", "203.0.113.21")},
+		{"iptables-eips", nat(KindIptablesEIP, "unassigned", "203.0.113.22")},
+	} {
+		resource := natResources[obj.object.Kind]
+		require.Equal(t, obj.resource, resource.Resource)
+		_, err := metadataClient.Resource(resource).(metadatafake.MetadataClient).CreateFake(obj.object, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	index, err := newIndex(clientset, metadataClient, newFakeDynamic(), IndexOptions{Precedence: DefaultPrecedence})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index.Start(ctx)
+	require.NoError(t, index.WaitForSync(ctx))
+
+	// Many-to-one SNAT, the rules win over their EIP
+	assert.Equal(t, []Owner{
+		{Kind: KindOvnSnatRule, Namespace: "shop", Name: "subnet1"},
+		{Kind: KindOvnSnatRule, Namespace: "shop", Name: "subnet2"},
+		{Kind: KindOvnEip, Namespace: "shop", Name: "gw"},
+	}, index.LookupAll("203.0.113.20"))
+
+	owner, ok := index.Lookup("203.0.113.21")
+	assert.True(t, ok)
+	assert.Equal(t, Owner{Kind: KindIptablesFIPRule, Namespace: "crm", Name: "vm1"}, owner)
+
+	// Names without a namespace are kept
+	owner, ok = index.Lookup("203.0.113.22")
+	assert.True(t, ok)
+	assert.Equal(t, Owner{Kind: KindIptablesEIP, Name: "unassigned"}, owner)
+}
+
+func TestIndexDNAT(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	clientset.Discovery().(*fakediscovery.FakeDiscovery).Resources = []*metav1.APIResourceList{
+		{GroupVersion: "kubeovn.io/v1", APIResources: []metav1.APIResource{
+			{Name: "ovn-dnat-rules", Kind: "OvnDnatRule"},
+			{Name: "ovn-eips", Kind: "OvnEip"},
+		}},
+	}
+	dnat := func(name, ip, port string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]any{"spec": map[string]any{"externalPort": port, "protocol": "tcp"}}}
+		u.SetAPIVersion("kubeovn.io/v1")
+		u.SetKind(KindOvnDnatRule)
+		u.SetName(name)
+		u.SetLabels(map[string]string{eipLabel: ip})
+		return u
+	}
+	metadataClient := newFakeMetadata()
+	_, err := metadataClient.Resource(natResources[KindOvnEip]).(metadatafake.MetadataClient).CreateFake(&metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "kubeovn.io/v1", Kind: KindOvnEip},
+		ObjectMeta: metav1.ObjectMeta{Name: "shop-gw", Labels: map[string]string{eipLabel: "203.0.113.30"}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	dynamicClient := newFakeDynamic()
+	for _, rule := range []*unstructured.Unstructured{
+		dnat("shop-web", "203.0.113.30", "80"),
+		dnat("crm-ssh", "203.0.113.30", "22"),
+	} {
+		_, err := dynamicClient.Resource(natResources[KindOvnDnatRule]).Create(context.Background(), rule, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	index, err := newIndex(clientset, metadataClient, dynamicClient, IndexOptions{Precedence: DefaultPrecedence})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	index.Start(ctx)
+	require.NoError(t, index.WaitForSync(ctx))
+
+	// DNAT rules sharing an EIP are told apart by their external port
+	assert.Equal(t, []Owner{
+		{Kind: KindOvnDnatRule, Namespace: "crm", Name: "ssh"},
+		{Kind: KindOvnEip, Namespace: "shop", Name: "gw"},
+	}, index.LookupPort("203.0.113.30", "22"))
+	owner, _ := index.Lookup("203.0.113.30")
+	assert.Equal(t, KindOvnDnatRule, owner.Kind)
+	assert.Equal(t, []Owner{
+		{Kind: KindOvnDnatRule, Namespace: "shop", Name: "web"},
+		{Kind: KindOvnEip, Namespace: "shop", Name: "gw"},
+	}, index.LookupPort("203.0.113.30", "80"))
+
+	// Ports without a rule fall back to the EIP
+	assert.Equal(t, []Owner{{Kind: KindOvnEip, Namespace: "shop", Name: "gw"}}, index.LookupPort("203.0.113.30", "443"))
+	assert.Len(t, index.LookupAll("203.0.113.30"), 3)
+}
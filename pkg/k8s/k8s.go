@@ -2,22 +2,34 @@ package k8s
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
-var dynamicClient *dynamic.DynamicClient
+// DefaultTimeout is the timeout of a single API request
+const DefaultTimeout = 5 * time.Second
+
+// eipLabel is the label of the OVN floating IPs holding their IPv4 address
+const eipLabel = "ovn.kubernetes.io/eip_v4_ip"
+
+// listLimit is the number of OVN floating IPs requested per page
+const listLimit = 500
+
+// ErrNotFound is returned when no OVN floating IP has an address
+var ErrNotFound = errors.New("no ofip found")
 
 var ofipResource = schema.GroupVersionResource{
 	Group:    "kubeovn.io",
@@ -25,43 +37,115 @@ var ofipResource = schema.GroupVersionResource{
 	Resource: "ovn-fips",
 }
 
-func CreateDynamicClient() *dynamic.DynamicClient {
-	// singleton
-	if dynamicClient != nil {
-		return dynamicClient
-	}
+// Options selects the cluster to connect to
+type Options struct {
+	// Kubeconfig is the path of the kubeconfig file. When empty the
+	// KUBECONFIG environment variable and ~/.kube/config are tried before
+	// the in-cluster configuration.
+	Kubeconfig string
+	// Context overrides the current context of the kubeconfig
+	Context string
+	// InCluster uses the service account of the pod and ignores kubeconfig
+	// files
+	InCluster bool
+}
 
-	config, err := createConfig()
+// Client looks up resources in the Kubernetes API
+type Client struct {
+	dynamic   dynamic.Interface
+	clientset kubernetes.Interface
+	metadata  metadata.Interface
+	recorder  *metrics.Recorder
+}
+
+// New creates a client recording the API requests with rec, which may be nil
+func New(opts Options, rec *metrics.Recorder) (*Client, error) {
+	config, err := NewConfig(opts)
 	if err != nil {
-		log.Fatalf("Error creating config: %v", err)
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes clientset: %w", err)
+	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes metadata client: %w", err)
+	}
+	return &Client{dynamic: dynamicClient, clientset: clientset, metadata: metadataClient, recorder: rec}, nil
+}
+
+// NewConfig returns the REST configuration selected by opts
+func NewConfig(opts Options) (*rest.Config, error) {
+	if opts.InCluster {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load in-cluster kubernetes config: %w", err)
+		}
+		return config, nil
 	}
 
-	dynamicClient, err = dynamic.NewForConfig(config)
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: opts.Context}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
 	if err != nil {
-		log.Fatalf("Error creating dynamicClient: %v", err)
+		return nil, fmt.Errorf("failed to load kubernetes config: %w", err)
 	}
-	return dynamicClient
+	return config, nil
 }
 
-func createConfig() (*rest.Config, error) {
-	configFile := filepath.Join(homedir.HomeDir(), ".kube", "config")
-	_, err := os.Stat(configFile)
+// Ping checks that the API server is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := c.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error()
+	c.recorder.ObserveKubernetes("version", start, err)
 	if err != nil {
-		return rest.InClusterConfig()
+		return fmt.Errorf("failed to reach kubernetes API: %w", err)
 	}
-	return clientcmd.BuildConfigFromFlags("", configFile)
+	return nil
 }
 
-func GetOFIPByIPv4(ipv4 string) (string, error) {
-	clientset := CreateDynamicClient()
-	ofip, err := clientset.Resource(ofipResource).List(context.TODO(), metav1.ListOptions{
-		LabelSelector: "ovn.kubernetes.io/eip_v4_ip=" + ipv4,
+// GetOFIPByIPv4 returns the name of the OVN floating IP of ipv4
+func (c *Client) GetOFIPByIPv4(ipv4 string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	start := time.Now()
+	ofip, err := c.dynamic.Resource(ofipResource).List(ctx, metav1.ListOptions{
+		LabelSelector: eipLabel + "=" + ipv4,
 	})
+	c.recorder.ObserveKubernetes("list_ovn_fips", start, err)
 	if err != nil {
 		return "", err
 	}
 	if len(ofip.Items) == 0 {
-		return "", fmt.Errorf("no ofip found for ipv4: %s", ipv4)
+		return "", fmt.Errorf("%w for ipv4: %s", ErrNotFound, ipv4)
 	}
 	return ofip.Items[0].GetName(), nil
 }
+
+// ListOFIPs returns the names of all OVN floating IPs by IPv4 address
+func (c *Client) ListOFIPs(ctx context.Context) (map[string]string, error) {
+	names := make(map[string]string)
+	opts := metav1.ListOptions{LabelSelector: eipLabel, Limit: listLimit}
+	for {
+		start := time.Now()
+		list, err := c.dynamic.Resource(ofipResource).List(ctx, opts)
+		c.recorder.ObserveKubernetes("list_ovn_fips", start, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ovn-fips: %w", err)
+		}
+		for _, item := range list.Items {
+			names[item.GetLabels()[eipLabel]] = item.GetName()
+		}
+		if list.GetContinue() == "" {
+			return names, nil
+		}
+		opts.Continue = list.GetContinue()
+	}
+}
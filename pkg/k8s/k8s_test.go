@@ -0,0 +1,102 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster:
+    server: https://dev.example.com:6443
+- name: prod
+  cluster:
+    server: https://prod.example.com:6443
+contexts:
+- name: dev
+  context:
+    cluster: dev
+    user: admin
+- name: prod
+  context:
+    cluster: prod
+    user: admin
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestNewConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "kubeconfig")
+	require.NoError(t, os.WriteFile(filename, []byte(testKubeconfig), 0o600))
+
+	config, err := NewConfig(Options{Kubeconfig: filename})
+	require.NoError(t, err)
+	assert.Equal(t, "https://dev.example.com:6443", config.Host)
+
+	config, err = NewConfig(Options{Kubeconfig: filename, Context: "prod"})
+	require.NoError(t, err)
+	assert.Equal(t, "https://prod.example.com:6443", config.Host)
+
+	_, err = NewConfig(Options{Kubeconfig: filename, Context: "staging"})
+	assert.Error(t, err)
+	_, err = NewConfig(Options{Kubeconfig: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+
+	// Outside of a pod the in-cluster configuration is not available
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	_, err = New(Options{InCluster: true}, nil)
+	assert.Error(t, err)
+}
+
+func newOFIP(name, ip string) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetAPIVersion("kubeovn.io/v1")
+	u.SetKind("OvnFip")
+	u.SetName(name)
+	if ip != "" {
+		u.SetLabels(map[string]string{eipLabel: ip})
+	}
+	return u
+}
+
+func TestOFIPs(t *testing.T) {
+	scheme := runtime.NewScheme()
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(scheme,
+		map[schema.GroupVersionResource]string{ofipResource: "OvnFipList"})
+	for _, ofip := range []*unstructured.Unstructured{
+		newOFIP("default-nginx", "203.0.113.10"),
+		newOFIP("tenant-a-web", "203.0.113.11"),
+		newOFIP("pending", ""),
+	} {
+		_, err := dynamicClient.Resource(ofipResource).Create(context.Background(), ofip, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	c := &Client{dynamic: dynamicClient}
+
+	name, err := c.GetOFIPByIPv4("203.0.113.11")
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a-web", name)
+	_, err = c.GetOFIPByIPv4("203.0.113.12")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	names, err := c.ListOFIPs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"203.0.113.10": "default-nginx", "203.0.113.11": "tenant-a-web"}, names)
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConnectionCount is the number of open connections of a workload
+type ConnectionCount struct {
+	Namespace string
+	Name      string
+	Protocol  string
+	Count     int
+}
+
+// ConnectionSource reports the connections that are currently open
+type ConnectionSource interface {
+	ActiveConnections() []ConnectionCount
+}
+
+// ConnectionSourceFunc adapts a function to a ConnectionSource
+type ConnectionSourceFunc func() []ConnectionCount
+
+// ActiveConnections implements ConnectionSource
+func (f ConnectionSourceFunc) ActiveConnections() []ConnectionCount {
+	return f()
+}
+
+// connectionsCollector computes the active connections gauge from the
+// connection table of the capture at scrape time
+type connectionsCollector struct {
+	source ConnectionSource
+	desc   *prometheus.Desc
+}
+
+// RegisterConnections registers the active connections gauge for source
+func (r *Recorder) RegisterConnections(source ConnectionSource) error {
+	if r == nil {
+		return nil
+	}
+	collector := &connectionsCollector{
+		source: source,
+		desc: prometheus.NewDesc(
+			r.metricName("network_connections_active"),
+			"Number of currently open connections",
+			[]string{"namespace", "name", "protocol"},
+			r.constLabels,
+		),
+	}
+	if err := r.registry.Register(collector); err != nil {
+		return fmt.Errorf("failed to register connections collector: %w", err)
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector
+func (c *connectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *connectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, count := range c.source.ActiveConnections() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count.Count), count.Namespace, count.Name, count.Protocol)
+	}
+}
@@ -2,139 +2,480 @@ package metrics
 
 import (
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-	// NetworkBytesTotal is a counter for the total number of bytes transferred
-	NetworkBytesTotal = prometheus.NewCounterVec(
+const (
+	// ProfileFull labels series with the raw source, destination and port
+	ProfileFull = "full"
+	// ProfileWorkload labels series with the owning workload only
+	ProfileWorkload = "workload"
+	// ProfileRemoteCIDR replaces the raw endpoints with a bucket of the remote IP
+	ProfileRemoteCIDR = "remote-cidr"
+
+	// DefaultNamespace is the default prefix of all metric names
+	DefaultNamespace = "netlog"
+	// DefaultRemoteCIDRPrefix is the default prefix length of remote_cidr buckets
+	DefaultRemoteCIDRPrefix = 24
+	// DefaultMaxSeries is the default maximum number of series per metric
+	DefaultMaxSeries = 10000
+	// OverflowValue is the label value of the series samples are folded into
+	// once a metric reached its series limit
+	OverflowValue = "_overflow"
+	// seriesTTL is the time after which series that are not updated are removed
+	seriesTTL = 5 * time.Minute
+)
+
+// profiles maps the label profile names to their label sets
+var profiles = map[string][]string{
+	ProfileFull:       {"namespace", "name", "source", "destination", "protocol", "port", "direction"},
+	ProfileWorkload:   {"namespace", "name", "protocol", "direction"},
+	ProfileRemoteCIDR: {"namespace", "name", "protocol", "port", "direction", "remote_cidr"},
+}
+
+// knownLabels are the labels that can be used in a custom label set, besides
+// the Kubernetes labels of the owners, see KubernetesLabel
+var knownLabels = map[string]bool{
+	"namespace":     true,
+	"name":          true,
+	"kind":          true,
+	"workload":      true,
+	"workload_kind": true,
+	"source":        true,
+	"destination":   true,
+	"protocol":      true,
+	"port":          true,
+	"direction":     true,
+	"remote_ip":     true,
+	"remote_cidr":   true,
+}
+
+const (
+	// labelPrefix prefixes the metric labels of the labels of owners
+	labelPrefix = "label_"
+	// namespaceLabelPrefix prefixes the metric labels of the labels of the
+	// namespaces of owners
+	namespaceLabelPrefix = "namespace_label_"
+)
+
+// KubernetesLabel returns the metric label of a label of the owners, e.g.
+// label_app_kubernetes_io_name for app.kubernetes.io/name
+func KubernetesLabel(key string) string {
+	return labelPrefix + sanitizeLabel(key)
+}
+
+// NamespaceLabel returns the metric label of a label of the namespaces of
+// the owners, e.g. namespace_label_team for team
+func NamespaceLabel(key string) string {
+	return namespaceLabelPrefix + sanitizeLabel(key)
+}
+
+// sanitizeLabel replaces the characters that are invalid in metric label
+// names with underscores
+func sanitizeLabel(key string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// validLabel reports whether label can be used in a custom label set
+func validLabel(label string) bool {
+	if knownLabels[label] {
+		return true
+	}
+	for _, prefix := range []string{labelPrefix, namespaceLabelPrefix} {
+		if key, ok := strings.CutPrefix(label, prefix); ok && key != "" && sanitizeLabel(key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configures a Recorder
+type Options struct {
+	// Namespace is the prefix of all metric names, DefaultNamespace when empty
+	Namespace string
+	// Subsystem is added between the namespace and the metric names
+	Subsystem string
+	// ConstLabels are added to every series, e.g. the node name or cluster
+	ConstLabels prometheus.Labels
+	// Profile selects a predefined label set
+	Profile string
+	// Labels overrides the label set of the profile when not empty
+	Labels []string
+	// RemoteCIDRPrefix is the prefix length of the remote_cidr label
+	RemoteCIDRPrefix int
+	// MaxSeries caps the number of series per metric, additional label
+	// combinations are folded into a single overflow series (0 disables the cap)
+	MaxSeries int
+}
+
+// Recorder owns the metrics of a netlog instance and the registry they are
+// registered with. All methods are safe to call on a nil Recorder, which
+// records nothing.
+type Recorder struct {
+	registry    *prometheus.Registry
+	namespace   string
+	subsystem   string
+	constLabels prometheus.Labels
+
+	// Traffic metrics
+	networkBytesTotal         *prometheus.CounterVec
+	networkPacketsTotal       *prometheus.CounterVec
+	networkConnectionDuration *prometheus.HistogramVec
+
+	// Series limit of the traffic metrics
+	metricsSeries              *prometheus.GaugeVec
+	metricsSeriesOverflowTotal *prometheus.CounterVec
+
+	self selfMetrics
+
+	// Track active series for cleanup and the series limit
+	flowSeries     *seriesTracker
+	connSeries     *seriesTracker
+	remoteCIDRMask net.IPMask
+	trackersLock   sync.Mutex
+}
+
+// ProfileLabels returns the label set of a profile
+func ProfileLabels(profile string) ([]string, error) {
+	labels, ok := profiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown metrics label profile: %s", profile)
+	}
+	return labels, nil
+}
+
+// NewRecorder creates a recorder with its own registry and registers all metrics
+func NewRecorder(opts Options) (*Recorder, error) {
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
+	}
+	if opts.Profile == "" {
+		opts.Profile = ProfileFull
+	}
+	if opts.RemoteCIDRPrefix <= 0 || opts.RemoteCIDRPrefix > 32 {
+		opts.RemoteCIDRPrefix = DefaultRemoteCIDRPrefix
+	}
+
+	labels := opts.Labels
+	if len(labels) == 0 {
+		var err error
+		if labels, err = ProfileLabels(opts.Profile); err != nil {
+			return nil, err
+		}
+	}
+	for _, label := range labels {
+		if !validLabel(label) {
+			return nil, fmt.Errorf("unknown metrics label: %s", label)
+		}
+	}
+
+	// The connection duration is not split by direction
+	var connLabels []string
+	for _, label := range labels {
+		if label != "direction" {
+			connLabels = append(connLabels, label)
+		}
+	}
+
+	r := &Recorder{
+		registry:       prometheus.NewRegistry(),
+		namespace:      opts.Namespace,
+		subsystem:      opts.Subsystem,
+		constLabels:    opts.ConstLabels,
+		flowSeries:     newSeriesTracker(labels, opts.MaxSeries),
+		connSeries:     newSeriesTracker(connLabels, opts.MaxSeries),
+		remoteCIDRMask: net.CIDRMask(opts.RemoteCIDRPrefix, 32),
+	}
+
+	r.networkBytesTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "netlog_network_bytes_total",
-			Help: "Total number of bytes transferred",
+			Namespace:   r.namespace,
+			Subsystem:   r.subsystem,
+			Name:        "network_bytes_total",
+			Help:        "Total number of bytes transferred",
+			ConstLabels: r.constLabels,
 		},
-		[]string{"namespace", "name", "source", "destination", "protocol", "port", "direction"},
+		labels,
 	)
-
-	// NetworkPacketsTotal is a counter for the total number of packets
-	NetworkPacketsTotal = prometheus.NewCounterVec(
+	r.networkPacketsTotal = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: "netlog_network_packets_total",
-			Help: "Total number of packets",
+			Namespace:   r.namespace,
+			Subsystem:   r.subsystem,
+			Name:        "network_packets_total",
+			Help:        "Total number of packets",
+			ConstLabels: r.constLabels,
 		},
-		[]string{"namespace", "name", "source", "destination", "protocol", "port", "direction"},
+		labels,
 	)
-
-	// NetworkConnectionsActive is a gauge for the number of active connections
-	NetworkConnectionsActive = prometheus.NewGaugeVec(
+	r.networkConnectionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   r.namespace,
+			Subsystem:   r.subsystem,
+			Name:        "network_connection_duration_seconds",
+			Help:        "Duration of network connections in seconds",
+			ConstLabels: r.constLabels,
+			Buckets:     prometheus.DefBuckets,
+		},
+		connLabels,
+	)
+	r.metricsSeries = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: "netlog_network_connections_active",
-			Help: "Number of active connections",
+			Namespace:   r.namespace,
+			Subsystem:   r.subsystem,
+			Name:        "metrics_series",
+			Help:        "Number of series of each traffic metric",
+			ConstLabels: r.constLabels,
 		},
-		[]string{"namespace", "name", "source", "destination", "protocol", "port"},
+		[]string{"metric"},
 	)
-
-	// NetworkConnectionDuration is a histogram for the duration of network connections
-	NetworkConnectionDuration = prometheus.NewHistogramVec(
-		prometheus.HistogramOpts{
-			Name:    "netlog_network_connection_duration_seconds",
-			Help:    "Duration of network connections in seconds",
-			Buckets: prometheus.DefBuckets,
+	r.metricsSeriesOverflowTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   r.namespace,
+			Subsystem:   r.subsystem,
+			Name:        "metrics_series_overflow_total",
+			Help:        "Total number of updates folded into the overflow series because of the series limit",
+			ConstLabels: r.constLabels,
 		},
-		[]string{"namespace", "name", "source", "destination", "protocol", "port"},
+		[]string{"metric"},
 	)
+	r.self = newSelfMetrics(r.namespace, r.subsystem, r.constLabels)
 
-	// Track active metrics for cleanup
-	activeMetrics     = make(map[string]time.Time)
-	activeMetricsLock sync.RWMutex
-)
+	collectors := []prometheus.Collector{
+		r.networkBytesTotal,
+		r.networkPacketsTotal,
+		r.networkConnectionDuration,
+		r.metricsSeries,
+		r.metricsSeriesOverflowTotal,
+	}
+	for _, c := range append(collectors, r.self.collectors()...) {
+		if err := r.registry.Register(c); err != nil {
+			return nil, fmt.Errorf("failed to register metric: %w", err)
+		}
+	}
+	return r, nil
+}
+
+// Registry returns the registry of the recorder, e.g. to add collectors
+func (r *Recorder) Registry() *prometheus.Registry {
+	if r == nil {
+		return nil
+	}
+	return r.registry
+}
+
+// Handler returns an http.Handler serving the metrics of the recorder
+func (r *Recorder) Handler() http.Handler {
+	if r == nil {
+		return http.NotFoundHandler()
+	}
+	return promhttp.HandlerFor(r.registry, promhttp.HandlerOpts{Registry: r.registry})
+}
 
-// Init initializes all metrics
-func Init() {
-	prometheus.MustRegister(NetworkBytesTotal)
-	prometheus.MustRegister(NetworkPacketsTotal)
-	prometheus.MustRegister(NetworkConnectionsActive)
-	prometheus.MustRegister(NetworkConnectionDuration)
+// metricName returns the full name of a metric of the recorder
+func (r *Recorder) metricName(name string) string {
+	return prometheus.BuildFQName(r.namespace, r.subsystem, name)
+}
+
+// FlowLabels are the label values of a flow
+type FlowLabels struct {
+	Namespace    string
+	Name         string
+	Kind         string
+	Workload     string
+	WorkloadKind string
+	Source       string
+	Destination  string
+	Protocol     string
+	Port         string
+	Direction    string
+	// Labels and NamespaceLabels are the Kubernetes labels of the owner and
+	// its namespace, see KubernetesLabel and NamespaceLabel
+	Labels          map[string]string
+	NamespaceLabels map[string]string
 }
 
 // UpdateMetrics updates all metrics based on the aggregated info
-func UpdateMetrics(namespace, name, source, destination, protocol, port, direction string, bytes, packets int64, duration float64) {
-	labels := prometheus.Labels{
-		"namespace":   namespace,
-		"name":        name,
-		"source":      source,
-		"destination": destination,
-		"protocol":    protocol,
-		"port":        port,
-		"direction":   direction,
+func (r *Recorder) UpdateMetrics(namespace, name, source, destination, protocol, port, direction string, bytes, packets int64, duration float64) {
+	r.UpdateFlowMetrics(FlowLabels{
+		Namespace:   namespace,
+		Name:        name,
+		Source:      source,
+		Destination: destination,
+		Protocol:    protocol,
+		Port:        port,
+		Direction:   direction,
+	}, bytes, packets, duration)
+}
+
+// UpdateFlowMetrics updates all metrics with a flow labelled by labels
+func (r *Recorder) UpdateFlowMetrics(labels FlowLabels, bytes, packets int64, duration float64) {
+	if r == nil {
+		return
 	}
 
-	// Update counters
-	NetworkBytesTotal.With(labels).Add(float64(bytes))
-	NetworkPacketsTotal.With(labels).Add(float64(packets))
+	values := map[string]string{
+		"namespace":     labels.Namespace,
+		"name":          labels.Name,
+		"kind":          labels.Kind,
+		"workload":      labels.Workload,
+		"workload_kind": labels.WorkloadKind,
+		"source":        labels.Source,
+		"destination":   labels.Destination,
+		"protocol":      labels.Protocol,
+		"port":          labels.Port,
+		"direction":     labels.Direction,
+	}
+	remote := labels.Destination
+	if labels.Direction == "inbound" {
+		remote = labels.Source
+	}
+	values["remote_ip"] = remote
+	values["remote_cidr"] = r.remoteCIDR(remote)
+	for key, value := range labels.Labels {
+		values[KubernetesLabel(key)] = value
+	}
+	for key, value := range labels.NamespaceLabels {
+		values[NamespaceLabel(key)] = value
+	}
 
-	// Update connection metrics
-	connLabels := prometheus.Labels{
-		"namespace":   namespace,
-		"name":        name,
-		"source":      source,
-		"destination": destination,
-		"protocol":    protocol,
-		"port":        port,
+	r.trackersLock.Lock()
+	now := time.Now()
+	flowValues, flowOverflow := r.flowSeries.track(values, now)
+	connValues, connOverflow := r.connSeries.track(values, now)
+	r.updateSeriesCounts()
+	r.trackersLock.Unlock()
+
+	if flowOverflow {
+		r.metricsSeriesOverflowTotal.WithLabelValues(r.metricName("network_bytes_total")).Inc()
+		r.metricsSeriesOverflowTotal.WithLabelValues(r.metricName("network_packets_total")).Inc()
+	}
+	if connOverflow {
+		r.metricsSeriesOverflowTotal.WithLabelValues(r.metricName("network_connection_duration_seconds")).Inc()
 	}
 
-	NetworkConnectionsActive.With(connLabels).Inc()
-	NetworkConnectionDuration.With(connLabels).Observe(duration)
+	// Update counters
+	r.networkBytesTotal.WithLabelValues(flowValues...).Add(float64(bytes))
+	r.networkPacketsTotal.WithLabelValues(flowValues...).Add(float64(packets))
 
-	// Track metric for cleanup
-	metricKey := fmt.Sprintf("%s:%s:%s:%s:%s:%s:%s", namespace, name, source, destination, protocol, port, direction)
-	activeMetricsLock.Lock()
-	activeMetrics[metricKey] = time.Now()
-	activeMetricsLock.Unlock()
+	// Update connection duration
+	r.networkConnectionDuration.WithLabelValues(connValues...).Observe(duration)
 }
 
 // CleanupMetrics removes metrics that haven't been updated recently
-func CleanupMetrics() {
-	activeMetricsLock.Lock()
-	defer activeMetricsLock.Unlock()
+func (r *Recorder) CleanupMetrics() {
+	if r == nil {
+		return
+	}
 
-	now := time.Now()
-	for key, lastUpdate := range activeMetrics {
-		if now.Sub(lastUpdate) > 5*time.Minute {
-			// Parse the key to get labels
-			parts := strings.Split(key, ":")
-			if len(parts) == 7 {
-				labels := prometheus.Labels{
-					"namespace":   parts[0],
-					"name":        parts[1],
-					"source":      parts[2],
-					"destination": parts[3],
-					"protocol":    parts[4],
-					"port":        parts[5],
-					"direction":   parts[6],
-				}
-
-				// Remove metrics
-				NetworkBytesTotal.Delete(labels)
-				NetworkPacketsTotal.Delete(labels)
-
-				connLabels := prometheus.Labels{
-					"namespace":   parts[0],
-					"name":        parts[1],
-					"source":      parts[2],
-					"destination": parts[3],
-					"protocol":    parts[4],
-					"port":        parts[5],
-				}
-				NetworkConnectionsActive.Delete(connLabels)
-				NetworkConnectionDuration.Delete(connLabels)
-
-				// Remove from active metrics
-				delete(activeMetrics, key)
-			}
+	r.trackersLock.Lock()
+	defer r.trackersLock.Unlock()
+
+	before := time.Now().Add(-seriesTTL)
+	for _, values := range r.flowSeries.expire(before) {
+		r.networkBytesTotal.DeleteLabelValues(values...)
+		r.networkPacketsTotal.DeleteLabelValues(values...)
+	}
+	for _, values := range r.connSeries.expire(before) {
+		r.networkConnectionDuration.DeleteLabelValues(values...)
+	}
+
+	r.updateSeriesCounts()
+}
+
+// updateSeriesCounts publishes the number of tracked series of each metric
+func (r *Recorder) updateSeriesCounts() {
+	r.metricsSeries.WithLabelValues(r.metricName("network_bytes_total")).Set(float64(r.flowSeries.len()))
+	r.metricsSeries.WithLabelValues(r.metricName("network_packets_total")).Set(float64(r.flowSeries.len()))
+	r.metricsSeries.WithLabelValues(r.metricName("network_connection_duration_seconds")).Set(float64(r.connSeries.len()))
+}
+
+// remoteCIDR returns the network of ip with the configured prefix length
+func (r *Recorder) remoteCIDR(ip string) string {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return ""
+	}
+	network := net.IPNet{IP: parsed.Mask(r.remoteCIDRMask), Mask: r.remoteCIDRMask}
+	return network.String()
+}
+
+// series is a label combination of a metric
+type series struct {
+	values     []string
+	lastUpdate time.Time
+}
+
+// seriesTracker keeps track of the series of metrics sharing a label set and
+// enforces the series limit
+type seriesTracker struct {
+	labels   []string
+	max      int
+	series   map[string]*series
+	overflow []string
+}
+
+func newSeriesTracker(labels []string, max int) *seriesTracker {
+	overflow := make([]string, len(labels))
+	for i := range overflow {
+		overflow[i] = OverflowValue
+	}
+	return &seriesTracker{
+		labels:   labels,
+		max:      max,
+		series:   make(map[string]*series),
+		overflow: overflow,
+	}
+}
+
+// track records an update and returns the label values to use, which are
+// the overflow values when the limit was reached
+func (t *seriesTracker) track(all map[string]string, now time.Time) ([]string, bool) {
+	values := make([]string, len(t.labels))
+	for i, label := range t.labels {
+		values[i] = all[label]
+	}
+	key := strings.Join(values, "\xff")
+
+	overflowed := false
+	if _, exists := t.series[key]; !exists && t.max > 0 && len(t.series) >= t.max {
+		values = t.overflow
+		key = strings.Join(values, "\xff")
+		overflowed = true
+	}
+
+	s, exists := t.series[key]
+	if !exists {
+		s = &series{values: values}
+		t.series[key] = s
+	}
+	s.lastUpdate = now
+	return values, overflowed
+}
+
+// expire removes and returns the series not updated since before
+func (t *seriesTracker) expire(before time.Time) [][]string {
+	var expired [][]string
+	for key, s := range t.series {
+		if s.lastUpdate.Before(before) {
+			expired = append(expired, s.values)
+			delete(t.series, key)
 		}
 	}
+	return expired
+}
+
+func (t *seriesTracker) len() int {
+	return len(t.series)
 }
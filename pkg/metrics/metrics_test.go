@@ -0,0 +1,184 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesTrackerOverflow(t *testing.T) {
+	tracker := newSeriesTracker([]string{"namespace", "port"}, 2)
+	now := time.Now()
+
+	values, overflow := tracker.track(map[string]string{"namespace": "a", "port": "80"}, now)
+	assert.Equal(t, []string{"a", "80"}, values)
+	assert.False(t, overflow)
+
+	_, overflow = tracker.track(map[string]string{"namespace": "b", "port": "80"}, now)
+	assert.False(t, overflow)
+
+	// A third combination is folded into the overflow series
+	values, overflow = tracker.track(map[string]string{"namespace": "c", "port": "80"}, now)
+	assert.Equal(t, []string{OverflowValue, OverflowValue}, values)
+	assert.True(t, overflow)
+
+	// Known combinations keep their own series
+	values, overflow = tracker.track(map[string]string{"namespace": "a", "port": "80"}, now)
+	assert.Equal(t, []string{"a", "80"}, values)
+	assert.False(t, overflow)
+	assert.Equal(t, 3, tracker.len())
+
+	// Expired series free up room again
+	expired := tracker.expire(now.Add(time.Second))
+	assert.Len(t, expired, 3)
+	assert.Equal(t, 0, tracker.len())
+}
+
+func TestRemoteCIDR(t *testing.T) {
+	r, err := NewRecorder(Options{RemoteCIDRPrefix: 24})
+	require.NoError(t, err)
+	assert.Equal(t, "8.8.8.0/24", r.remoteCIDR("8.8.8.8"))
+	assert.Equal(t, "", r.remoteCIDR("invalid"))
+}
+
+func TestProfileLabels(t *testing.T) {
+	labels, err := ProfileLabels(ProfileWorkload)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"namespace", "name", "protocol", "direction"}, labels)
+
+	_, err = ProfileLabels("unknown")
+	assert.Error(t, err)
+}
+
+type staticConnections []ConnectionCount
+
+func (s staticConnections) ActiveConnections() []ConnectionCount {
+	return s
+}
+
+func TestConnectionsCollector(t *testing.T) {
+	r, err := NewRecorder(Options{})
+	require.NoError(t, err)
+	require.NoError(t, r.RegisterConnections(staticConnections{
+		{Namespace: "default", Name: "nginx", Protocol: "TCP", Count: 3},
+		{Namespace: "default", Name: "dns", Protocol: "UDP", Count: 1},
+	}))
+
+	expected := `
+# HELP netlog_network_connections_active Number of currently open connections
+# TYPE netlog_network_connections_active gauge
+netlog_network_connections_active{name="dns",namespace="default",protocol="UDP"} 1
+netlog_network_connections_active{name="nginx",namespace="default",protocol="TCP"} 3
+`
+	require.NoError(t, testutil.GatherAndCompare(r.Registry(), strings.NewReader(expected), "netlog_network_connections_active"))
+}
+
+type staticTopTalkers []TopTalker
+
+func (s staticTopTalkers) TopTalkers() []TopTalker {
+	return s
+}
+
+func TestTopTalkersCollector(t *testing.T) {
+	r, err := NewRecorder(Options{})
+	require.NoError(t, err)
+	require.NoError(t, r.RegisterTopTalkers(staticTopTalkers{
+		{Dimension: "owner", Window: "1m0s", Rank: 1, Key: "default/nginx", Rate: 2048},
+		{Dimension: "owner", Window: "1m0s", Rank: 2, Key: "default/dns", Rate: 16},
+	}))
+
+	expected := `
+# HELP netlog_top_talker_bytes_per_second Average bytes per second of the heaviest talkers by dimension and window
+# TYPE netlog_top_talker_bytes_per_second gauge
+netlog_top_talker_bytes_per_second{dimension="owner",key="default/dns",rank="2",window="1m0s"} 16
+netlog_top_talker_bytes_per_second{dimension="owner",key="default/nginx",rank="1",window="1m0s"} 2048
+`
+	require.NoError(t, testutil.GatherAndCompare(r.Registry(), strings.NewReader(expected), "netlog_top_talker_bytes_per_second"))
+}
+
+func TestObserveSelfMetrics(t *testing.T) {
+	r, err := NewRecorder(Options{})
+	require.NoError(t, err)
+
+	r.ObserveLookup("redis", true)
+	r.ObserveLookup("redis", false)
+	r.ObserveLookup("redis", false)
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.self.enrichmentLookupsTotal.WithLabelValues("redis", "hit")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.self.enrichmentLookupsTotal.WithLabelValues("redis", "miss")))
+
+	r.ObserveRedis("hgetall", time.Now(), nil)
+	r.ObserveRedis("hgetall", time.Now(), errors.New("connection refused"))
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.self.redisErrorsTotal.WithLabelValues("hgetall")))
+	assert.Equal(t, 1, testutil.CollectAndCount(r.self.redisRequestDuration))
+}
+
+func TestRecorderIsolation(t *testing.T) {
+	a, err := NewRecorder(Options{ConstLabels: prometheus.Labels{"node": "a"}})
+	require.NoError(t, err)
+	b, err := NewRecorder(Options{Namespace: "embedded", Subsystem: "netlog", ConstLabels: prometheus.Labels{"node": "b"}, Profile: ProfileWorkload})
+	require.NoError(t, err)
+
+	a.UpdateMetrics("default", "nginx", "10.0.0.1", "8.8.8.8", "TCP", "443", "outbound", 100, 2, 1)
+	b.UpdateMetrics("default", "nginx", "10.0.0.1", "8.8.8.8", "TCP", "443", "outbound", 50, 1, 1)
+
+	expectedA := `
+# HELP netlog_network_bytes_total Total number of bytes transferred
+# TYPE netlog_network_bytes_total counter
+netlog_network_bytes_total{destination="8.8.8.8",direction="outbound",name="nginx",namespace="default",node="a",port="443",protocol="TCP",source="10.0.0.1"} 100
+`
+	require.NoError(t, testutil.GatherAndCompare(a.Registry(), strings.NewReader(expectedA), "netlog_network_bytes_total"))
+
+	expectedB := `
+# HELP embedded_netlog_network_bytes_total Total number of bytes transferred
+# TYPE embedded_netlog_network_bytes_total counter
+embedded_netlog_network_bytes_total{direction="outbound",name="nginx",namespace="default",node="b",protocol="TCP"} 50
+`
+	require.NoError(t, testutil.GatherAndCompare(b.Registry(), strings.NewReader(expectedB), "embedded_netlog_network_bytes_total"))
+}
+
+func TestKubernetesLabels(t *testing.T) {
+	assert.Equal(t, "label_app_kubernetes_io_name", KubernetesLabel("app.kubernetes.io/name"))
+	assert.Equal(t, "namespace_label_team", NamespaceLabel("team"))
+
+	_, err := NewRecorder(Options{Labels: []string{"namespace", "label_"}})
+	assert.Error(t, err)
+	_, err = NewRecorder(Options{Labels: []string{"namespace", "label_app.kubernetes.io/name"}})
+	assert.Error(t, err)
+
+	r, err := NewRecorder(Options{Labels: []string{"namespace", "workload", "label_app_kubernetes_io_name", "namespace_label_team"}})
+	require.NoError(t, err)
+	r.UpdateFlowMetrics(FlowLabels{
+		Namespace:       "default",
+		Name:            "web-7f9f9f9f9f-x2x4z",
+		Workload:        "web",
+		Labels:          map[string]string{"app.kubernetes.io/name": "web"},
+		NamespaceLabels: map[string]string{"team": "payments"},
+	}, 100, 2, 1)
+	r.UpdateFlowMetrics(FlowLabels{Namespace: "default", Name: "debug", Workload: "debug"}, 10, 1, 1)
+
+	expected := `
+# HELP netlog_network_bytes_total Total number of bytes transferred
+# TYPE netlog_network_bytes_total counter
+netlog_network_bytes_total{label_app_kubernetes_io_name="",namespace="default",namespace_label_team="",workload="debug"} 10
+netlog_network_bytes_total{label_app_kubernetes_io_name="web",namespace="default",namespace_label_team="payments",workload="web"} 100
+`
+	require.NoError(t, testutil.GatherAndCompare(r.Registry(), strings.NewReader(expected), "netlog_network_bytes_total"))
+}
+
+func TestNilRecorder(t *testing.T) {
+	var r *Recorder
+	assert.NotPanics(t, func() {
+		r.UpdateMetrics("default", "nginx", "10.0.0.1", "8.8.8.8", "TCP", "443", "outbound", 100, 2, 1)
+		r.CleanupMetrics()
+		r.PacketDecoded()
+		r.ObserveLookup("redis", true)
+		r.SinkWriteFailed("http")
+		assert.NoError(t, r.RegisterConnections(staticConnections{}))
+	})
+}
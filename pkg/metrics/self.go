@@ -0,0 +1,364 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// selfMetrics are the metrics about netlog itself
+type selfMetrics struct {
+	packetsDecodedTotal       prometheus.Counter
+	flowTableSize             prometheus.Gauge
+	connectionTableSize       prometheus.Gauge
+	flowsFlushedTotal         prometheus.Counter
+	enrichmentLookupsTotal    *prometheus.CounterVec
+	redisRequestDuration      *prometheus.HistogramVec
+	redisErrorsTotal          *prometheus.CounterVec
+	kubernetesRequestDuration *prometheus.HistogramVec
+	kubernetesErrorsTotal     *prometheus.CounterVec
+	queueLength               *prometheus.GaugeVec
+	sinkFlushesTotal          *prometheus.CounterVec
+	sinkWriteErrorsTotal      *prometheus.CounterVec
+	sinkDroppedTotal          *prometheus.CounterVec
+	alertsFiring              *prometheus.GaugeVec
+	alertNotificationsTotal   *prometheus.CounterVec
+	securityEventsTotal       *prometheus.CounterVec
+	securityEventsDropped     prometheus.Counter
+	blocklistEntries          *prometheus.GaugeVec
+	blocklistReloadErrors     *prometheus.CounterVec
+	configReloadsTotal        *prometheus.CounterVec
+}
+
+func newSelfMetrics(namespace, subsystem string, constLabels prometheus.Labels) selfMetrics {
+	return selfMetrics{
+		packetsDecodedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "packets_decoded_total",
+			Help:        "Total number of packets decoded by the capture",
+			ConstLabels: constLabels,
+		}),
+		flowTableSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "flow_table_size",
+			Help:        "Number of flows being aggregated",
+			ConstLabels: constLabels,
+		}),
+		connectionTableSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "connection_table_size",
+			Help:        "Number of connections in the connection table",
+			ConstLabels: constLabels,
+		}),
+		flowsFlushedTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "flows_flushed_total",
+			Help:        "Total number of flows flushed from the flow table",
+			ConstLabels: constLabels,
+		}),
+		enrichmentLookupsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "enrichment_lookups_total",
+			Help:        "Total number of IP lookups by resolver and result (hit or miss)",
+			ConstLabels: constLabels,
+		}, []string{"resolver", "result"}),
+		redisRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "redis_request_duration_seconds",
+			Help:        "Duration of Redis requests in seconds",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"operation"}),
+		redisErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "redis_errors_total",
+			Help:        "Total number of failed Redis requests",
+			ConstLabels: constLabels,
+		}, []string{"operation"}),
+		kubernetesRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "kubernetes_request_duration_seconds",
+			Help:        "Duration of Kubernetes API requests in seconds",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"operation"}),
+		kubernetesErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "kubernetes_errors_total",
+			Help:        "Total number of failed Kubernetes API requests",
+			ConstLabels: constLabels,
+		}, []string{"operation"}),
+		queueLength: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "queue_length",
+			Help:        "Number of records waiting in an output queue",
+			ConstLabels: constLabels,
+		}, []string{"queue"}),
+		sinkFlushesTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "sink_flushes_total",
+			Help:        "Total number of batches flushed to a sink",
+			ConstLabels: constLabels,
+		}, []string{"sink"}),
+		sinkWriteErrorsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "sink_write_errors_total",
+			Help:        "Total number of failed writes to a sink",
+			ConstLabels: constLabels,
+		}, []string{"sink"}),
+		sinkDroppedTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "sink_dropped_flows_total",
+			Help:        "Total number of flows dropped because the queue of a sink was full",
+			ConstLabels: constLabels,
+		}, []string{"sink"}),
+		alertsFiring: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "alerts_firing",
+			Help:        "Alerts that are currently firing by rule and namespace",
+			ConstLabels: constLabels,
+		}, []string{"rule", "namespace"}),
+		alertNotificationsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "alert_notifications_total",
+			Help:        "Total number of alert notifications by receiver and result (success or error)",
+			ConstLabels: constLabels,
+		}, []string{"receiver", "result"}),
+		securityEventsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "security_events_total",
+			Help:        "Total number of security events by type and namespace",
+			ConstLabels: constLabels,
+		}, []string{"type", "namespace"}),
+		securityEventsDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "security_events_dropped_total",
+			Help:        "Total number of security events dropped because the event queue was full",
+			ConstLabels: constLabels,
+		}),
+		blocklistEntries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "blocklist_entries",
+			Help:        "Number of IP addresses and networks loaded from each blocklist",
+			ConstLabels: constLabels,
+		}, []string{"list"}),
+		blocklistReloadErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "blocklist_reload_errors_total",
+			Help:        "Total number of failed blocklist reloads by list",
+			ConstLabels: constLabels,
+		}, []string{"list"}),
+		configReloadsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Subsystem:   subsystem,
+			Name:        "config_reloads_total",
+			Help:        "Total number of configuration reloads by result",
+			ConstLabels: constLabels,
+		}, []string{"result"}),
+	}
+}
+
+func (m selfMetrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.packetsDecodedTotal,
+		m.flowTableSize,
+		m.connectionTableSize,
+		m.flowsFlushedTotal,
+		m.enrichmentLookupsTotal,
+		m.redisRequestDuration,
+		m.redisErrorsTotal,
+		m.kubernetesRequestDuration,
+		m.kubernetesErrorsTotal,
+		m.queueLength,
+		m.sinkFlushesTotal,
+		m.sinkWriteErrorsTotal,
+		m.sinkDroppedTotal,
+		m.alertsFiring,
+		m.alertNotificationsTotal,
+		m.securityEventsTotal,
+		m.securityEventsDropped,
+		m.blocklistEntries,
+		m.blocklistReloadErrors,
+		m.configReloadsTotal,
+	}
+}
+
+// PacketDecoded counts a packet decoded by the capture
+func (r *Recorder) PacketDecoded() {
+	if r == nil {
+		return
+	}
+	r.self.packetsDecodedTotal.Inc()
+}
+
+// FlowsFlushed counts flows flushed from the flow table
+func (r *Recorder) FlowsFlushed(n int) {
+	if r == nil {
+		return
+	}
+	r.self.flowsFlushedTotal.Add(float64(n))
+}
+
+// SetTableSizes publishes the size of the flow and connection tables
+func (r *Recorder) SetTableSizes(flows, connections int) {
+	if r == nil {
+		return
+	}
+	r.self.flowTableSize.Set(float64(flows))
+	r.self.connectionTableSize.Set(float64(connections))
+}
+
+// SetQueueLength publishes the number of records waiting in queue
+func (r *Recorder) SetQueueLength(queue string, n int) {
+	if r == nil {
+		return
+	}
+	r.self.queueLength.WithLabelValues(queue).Set(float64(n))
+}
+
+// SinkFlushed counts a batch flushed to sink
+func (r *Recorder) SinkFlushed(sink string) {
+	if r == nil {
+		return
+	}
+	r.self.sinkFlushesTotal.WithLabelValues(sink).Inc()
+}
+
+// SinkWriteFailed counts a failed write to sink
+func (r *Recorder) SinkWriteFailed(sink string) {
+	if r == nil {
+		return
+	}
+	r.self.sinkWriteErrorsTotal.WithLabelValues(sink).Inc()
+}
+
+// SinkDropped counts n flows dropped because the queue of sink was full
+func (r *Recorder) SinkDropped(sink string, n int) {
+	if r == nil {
+		return
+	}
+	r.self.sinkDroppedTotal.WithLabelValues(sink).Add(float64(n))
+}
+
+// ObserveRedis records the latency and outcome of a Redis request started at start
+func (r *Recorder) ObserveRedis(operation string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.self.redisRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		r.self.redisErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveKubernetes records the latency and outcome of a Kubernetes API
+// request started at start
+func (r *Recorder) ObserveKubernetes(operation string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+	r.self.kubernetesRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		r.self.kubernetesErrorsTotal.WithLabelValues(operation).Inc()
+	}
+}
+
+// ObserveLookup records whether resolver found an IP
+func (r *Recorder) ObserveLookup(resolver string, hit bool) {
+	if r == nil {
+		return
+	}
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	r.self.enrichmentLookupsTotal.WithLabelValues(resolver, result).Inc()
+}
+
+// SetAlertFiring publishes whether the alert of rule for namespace is firing
+func (r *Recorder) SetAlertFiring(rule, namespace string, firing bool) {
+	if r == nil {
+		return
+	}
+	if firing {
+		r.self.alertsFiring.WithLabelValues(rule, namespace).Set(1)
+	} else {
+		r.self.alertsFiring.DeleteLabelValues(rule, namespace)
+	}
+}
+
+// AlertNotified records the outcome of a notification sent to receiver
+func (r *Recorder) AlertNotified(receiver string, err error) {
+	if r == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	r.self.alertNotificationsTotal.WithLabelValues(receiver, result).Inc()
+}
+
+// SecurityEvent counts a security event of eventType for namespace
+func (r *Recorder) SecurityEvent(eventType, namespace string) {
+	if r == nil {
+		return
+	}
+	r.self.securityEventsTotal.WithLabelValues(eventType, namespace).Inc()
+}
+
+// SecurityEventDropped counts a security event that could not be delivered
+func (r *Recorder) SecurityEventDropped() {
+	if r == nil {
+		return
+	}
+	r.self.securityEventsDropped.Inc()
+}
+
+// SetBlocklistEntries records the number of entries loaded from list
+func (r *Recorder) SetBlocklistEntries(list string, n int) {
+	if r == nil {
+		return
+	}
+	r.self.blocklistEntries.WithLabelValues(list).Set(float64(n))
+}
+
+// BlocklistReloadFailed counts a failed reload of list
+func (r *Recorder) BlocklistReloadFailed(list string) {
+	if r == nil {
+		return
+	}
+	r.self.blocklistReloadErrors.WithLabelValues(list).Inc()
+}
+
+// ConfigReloaded counts a configuration reload that failed with err, or
+// succeeded if err is nil
+func (r *Recorder) ConfigReloaded(err error) {
+	if r == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	r.self.configReloadsTotal.WithLabelValues(result).Inc()
+}
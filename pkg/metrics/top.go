@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TopTalker is an entry of a top talkers ranking
+type TopTalker struct {
+	Dimension string
+	Window    string
+	Rank      int
+	Key       string
+	// Rate is the average bytes per second within the window
+	Rate float64
+}
+
+// TopTalkerSource reports the current top talkers rankings
+type TopTalkerSource interface {
+	TopTalkers() []TopTalker
+}
+
+// topTalkersCollector exports the rankings of a source at scrape time, so
+// the number of series is bounded by the size of the rankings
+type topTalkersCollector struct {
+	source TopTalkerSource
+	desc   *prometheus.Desc
+}
+
+// RegisterTopTalkers registers the top talkers gauge for source
+func (r *Recorder) RegisterTopTalkers(source TopTalkerSource) error {
+	if r == nil {
+		return nil
+	}
+	collector := &topTalkersCollector{
+		source: source,
+		desc: prometheus.NewDesc(
+			r.metricName("top_talker_bytes_per_second"),
+			"Average bytes per second of the heaviest talkers by dimension and window",
+			[]string{"dimension", "window", "rank", "key"},
+			r.constLabels,
+		),
+	}
+	if err := r.registry.Register(collector); err != nil {
+		return fmt.Errorf("failed to register top talkers collector: %w", err)
+	}
+	return nil
+}
+
+// Describe implements prometheus.Collector
+func (c *topTalkersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *topTalkersCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, t := range c.source.TopTalkers() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, t.Rate, t.Dimension, t.Window, strconv.Itoa(t.Rank), t.Key)
+	}
+}
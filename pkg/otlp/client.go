@@ -0,0 +1,266 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// ProtocolGRPC exports over OTLP/gRPC
+	ProtocolGRPC = "grpc"
+	// ProtocolHTTP exports over OTLP/HTTP with protobuf payloads
+	ProtocolHTTP = "http"
+	// DefaultTimeout is the default timeout of a single export
+	DefaultTimeout = 10 * time.Second
+	// scopeName is the instrumentation scope reported with every export
+	scopeName = "github.com/highscaleco/netlog"
+)
+
+// Options configures the connection to an OTLP receiver
+type Options struct {
+	// Endpoint is host:port for gRPC or the base URL for HTTP
+	Endpoint string
+	// Protocol is either ProtocolGRPC or ProtocolHTTP
+	Protocol string
+	// Insecure disables TLS
+	Insecure bool
+	// Headers are sent with every export request
+	Headers map[string]string
+	// Timeout bounds a single export request
+	Timeout time.Duration
+}
+
+// exporter sends OTLP requests over a specific transport
+type exporter interface {
+	exportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error
+	exportMetrics(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error
+	close() error
+}
+
+// Client exports logs and metrics to an OTLP receiver such as the
+// OpenTelemetry Collector
+type Client struct {
+	exporter exporter
+	timeout  time.Duration
+	resource *resourcepb.Resource
+}
+
+// New creates a client for the receiver described by opts
+func New(opts Options) (*Client, error) {
+	if opts.Endpoint == "" {
+		return nil, fmt.Errorf("otlp endpoint cannot be empty")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	var exp exporter
+	var err error
+	switch opts.Protocol {
+	case ProtocolGRPC, "":
+		exp, err = newGRPCExporter(opts)
+	case ProtocolHTTP:
+		exp, err = newHTTPExporter(opts)
+	default:
+		return nil, fmt.Errorf("unknown otlp protocol: %s", opts.Protocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		exporter: exp,
+		timeout:  opts.Timeout,
+		resource: newResource(),
+	}, nil
+}
+
+// Close releases the connection to the receiver
+func (c *Client) Close() error {
+	return c.exporter.close()
+}
+
+// newResource describes this netlog instance
+func newResource() *resourcepb.Resource {
+	attrs := []*commonpb.KeyValue{stringAttr("service.name", "netlog")}
+	if hostname, err := os.Hostname(); err == nil {
+		attrs = append(attrs, stringAttr("host.name", hostname))
+	}
+	return &resourcepb.Resource{Attributes: attrs}
+}
+
+// grpcExporter sends requests to the OTLP/gRPC services
+type grpcExporter struct {
+	conn    *grpc.ClientConn
+	logs    collogspb.LogsServiceClient
+	metrics colmetricspb.MetricsServiceClient
+	headers metadata.MD
+}
+
+func newGRPCExporter(opts Options) (*grpcExporter, error) {
+	creds := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if opts.Insecure {
+		creds = insecure.NewCredentials()
+	}
+
+	conn, err := grpc.NewClient(opts.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create otlp grpc client: %w", err)
+	}
+	return &grpcExporter{
+		conn:    conn,
+		logs:    collogspb.NewLogsServiceClient(conn),
+		metrics: colmetricspb.NewMetricsServiceClient(conn),
+		headers: metadata.New(opts.Headers),
+	}, nil
+}
+
+func (e *grpcExporter) exportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	ctx = metadata.NewOutgoingContext(ctx, e.headers)
+	resp, err := e.logs.Export(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to export logs: %w", err)
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedLogRecords(); rejected > 0 {
+		return fmt.Errorf("receiver rejected %d log records: %s", rejected, resp.GetPartialSuccess().GetErrorMessage())
+	}
+	return nil
+}
+
+func (e *grpcExporter) exportMetrics(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	ctx = metadata.NewOutgoingContext(ctx, e.headers)
+	resp, err := e.metrics.Export(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to export metrics: %w", err)
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+		return fmt.Errorf("receiver rejected %d data points: %s", rejected, resp.GetPartialSuccess().GetErrorMessage())
+	}
+	return nil
+}
+
+func (e *grpcExporter) close() error {
+	return e.conn.Close()
+}
+
+// httpExporter posts protobuf encoded requests to the OTLP/HTTP endpoints
+type httpExporter struct {
+	baseURL string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newHTTPExporter(opts Options) (*httpExporter, error) {
+	baseURL := strings.TrimSuffix(opts.Endpoint, "/")
+	if !strings.HasPrefix(baseURL, "http://") && !strings.HasPrefix(baseURL, "https://") {
+		if opts.Insecure {
+			baseURL = "http://" + baseURL
+		} else {
+			baseURL = "https://" + baseURL
+		}
+	}
+	return &httpExporter{
+		baseURL: baseURL,
+		headers: opts.Headers,
+		client:  &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+func (e *httpExporter) exportLogs(ctx context.Context, req *collogspb.ExportLogsServiceRequest) error {
+	var resp collogspb.ExportLogsServiceResponse
+	if err := e.post(ctx, "/v1/logs", req, &resp); err != nil {
+		return fmt.Errorf("failed to export logs: %w", err)
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedLogRecords(); rejected > 0 {
+		return fmt.Errorf("receiver rejected %d log records: %s", rejected, resp.GetPartialSuccess().GetErrorMessage())
+	}
+	return nil
+}
+
+func (e *httpExporter) exportMetrics(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) error {
+	var resp colmetricspb.ExportMetricsServiceResponse
+	if err := e.post(ctx, "/v1/metrics", req, &resp); err != nil {
+		return fmt.Errorf("failed to export metrics: %w", err)
+	}
+	if rejected := resp.GetPartialSuccess().GetRejectedDataPoints(); rejected > 0 {
+		return fmt.Errorf("receiver rejected %d data points: %s", rejected, resp.GetPartialSuccess().GetErrorMessage())
+	}
+	return nil
+}
+
+// post sends a protobuf request and decodes the protobuf response
+func (e *httpExporter) post(ctx context.Context, path string, in, out proto.Message) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return proto.Unmarshal(data, out)
+}
+
+func (e *httpExporter) close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+func stringAttr(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func intAttr(key string, value int64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: value}}}
+}
+
+func doubleAttr(key string, value float64) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: value}}}
+}
+
+func stringsAttr(key string, values []string) *commonpb.KeyValue {
+	array := &commonpb.ArrayValue{Values: make([]*commonpb.AnyValue, len(values))}
+	for i, v := range values {
+		array.Values[i] = &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}}
+	}
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: array}}}
+}
@@ -0,0 +1,232 @@
+package otlp
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeCollector is an OTLP receiver over gRPC and HTTP keeping the requests
+// and the authorization header it gets
+type fakeCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+
+	mu            sync.Mutex
+	logs          []*collogspb.ExportLogsServiceRequest
+	metrics       []*colmetricspb.ExportMetricsServiceRequest
+	authorization []string
+	// rejected is the number of records reported as rejected
+	rejected int64
+}
+
+func (c *fakeCollector) Export(ctx context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return c.exportLogs(req, strings.Join(md.Get("authorization"), ",")), nil
+}
+
+func (c *fakeCollector) exportLogs(req *collogspb.ExportLogsServiceRequest, authorization string) *collogspb.ExportLogsServiceResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.logs = append(c.logs, req)
+	c.authorization = append(c.authorization, authorization)
+	resp := &collogspb.ExportLogsServiceResponse{}
+	if c.rejected > 0 {
+		resp.PartialSuccess = &collogspb.ExportLogsPartialSuccess{RejectedLogRecords: c.rejected, ErrorMessage: "invalid record"}
+	}
+	return resp
+}
+
+func (c *fakeCollector) exportMetrics(req *colmetricspb.ExportMetricsServiceRequest, authorization string) *colmetricspb.ExportMetricsServiceResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.metrics = append(c.metrics, req)
+	c.authorization = append(c.authorization, authorization)
+	return &colmetricspb.ExportMetricsServiceResponse{}
+}
+
+// metricsService serves the metrics of the collector over gRPC, its Export
+// method clashes with the one of the logs service
+type metricsService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	c *fakeCollector
+}
+
+func (s metricsService) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return s.c.exportMetrics(req, strings.Join(md.Get("authorization"), ",")), nil
+}
+
+// serveGRPC starts the OTLP/gRPC services and returns their address
+func (c *fakeCollector) serveGRPC(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	collogspb.RegisterLogsServiceServer(server, c)
+	colmetricspb.RegisterMetricsServiceServer(server, metricsService{c: c})
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// serveHTTP starts the OTLP/HTTP endpoints and returns their URL
+func (c *fakeCollector) serveHTTP(t *testing.T) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		var resp proto.Message
+		switch r.URL.Path {
+		case "/v1/logs":
+			req := &collogspb.ExportLogsServiceRequest{}
+			require.NoError(t, proto.Unmarshal(body, req))
+			resp = c.exportLogs(req, r.Header.Get("Authorization"))
+		case "/v1/metrics":
+			req := &colmetricspb.ExportMetricsServiceRequest{}
+			require.NoError(t, proto.Unmarshal(body, req))
+			resp = c.exportMetrics(req, r.Header.Get("Authorization"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		data, err := proto.Marshal(resp)
+		require.NoError(t, err)
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		_, _ = w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func attributes(attrs []*commonpb.KeyValue) map[string]any {
+	result := make(map[string]any, len(attrs))
+	for _, kv := range attrs {
+		switch v := kv.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			result[kv.GetKey()] = v.StringValue
+		case *commonpb.AnyValue_IntValue:
+			result[kv.GetKey()] = v.IntValue
+		case *commonpb.AnyValue_DoubleValue:
+			result[kv.GetKey()] = v.DoubleValue
+		case *commonpb.AnyValue_ArrayValue:
+			var values []string
+			for _, value := range v.ArrayValue.GetValues() {
+				values = append(values, value.GetStringValue())
+			}
+			result[kv.GetKey()] = values
+		}
+	}
+	return result
+}
+
+func TestExport(t *testing.T) {
+	for _, protocol := range []string{ProtocolGRPC, ProtocolHTTP} {
+		t.Run(protocol, func(t *testing.T) {
+			collector := &fakeCollector{}
+			endpoint := collector.serveGRPC(t)
+			if protocol == ProtocolHTTP {
+				endpoint = collector.serveHTTP(t)
+			}
+			client, err := New(Options{
+				Endpoint: endpoint,
+				Protocol: protocol,
+				Insecure: true,
+				Headers:  map[string]string{"authorization": "Bearer s3cr3t"},
+				Timeout:  5 * time.Second,
+			})
+			require.NoError(t, err)
+			defer client.Close()
+
+			// Flows without an owner are not exported
+			start := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+			sink := NewLogSink(client)
+			require.NoError(t, sink.Write(context.Background(), []types.AggregatedInfo{
+				{Namespace: "default", Name: "nginx", Source: "10.0.0.1", Destination: "8.8.8.8", Protocol: "TCP", Port: "443", Direction: "outbound", TotalBytes: 1234, Packets: 10, StartTime: start, EndTime: start.Add(2 * time.Second), Tags: []string{"tor"}, OwnerTags: []string{"pci"},
+					Kind: "Pod", WorkloadKind: "Deployment", Workload: "nginx", Labels: map[string]string{"app": "nginx"}, NamespaceLabels: map[string]string{"team": "web"}},
+				{Source: "10.0.0.2", Destination: "8.8.4.4"},
+			}))
+			require.NoError(t, sink.WriteEvents(context.Background(), []types.Event{{Time: start, Type: "port_scan", Severity: "warning", Source: "198.51.100.1", Message: "port scan"}}))
+
+			registry := prometheus.NewRegistry()
+			counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_flows_total", Help: "flows"})
+			registry.MustRegister(counter)
+			counter.Add(3)
+			require.NoError(t, NewMetricExporter(client, registry, time.Minute).Export(context.Background()))
+
+			require.Len(t, collector.logs, 2)
+			flow := collector.logs[0].GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()
+			require.Len(t, flow, 1)
+			assert.Equal(t, uint64(start.Add(2*time.Second).UnixNano()), flow[0].GetTimeUnixNano())
+			assert.Equal(t, map[string]any{
+				"k8s.namespace.name":       "default",
+				"netlog.name":              "nginx",
+				"netlog.direction":         "outbound",
+				"source.address":           "10.0.0.1",
+				"destination.address":      "8.8.8.8",
+				"network.transport":        "tcp",
+				"netlog.port":              int64(443),
+				"netlog.bytes":             int64(1234),
+				"netlog.packets":           int64(10),
+				"netlog.duration":          2.0,
+				"netlog.tags":              []string{"tor"},
+				"netlog.owner_tags":        []string{"pci"},
+				"netlog.kind":              "Pod",
+				"k8s.pod.name":             "nginx",
+				"netlog.workload_kind":     "Deployment",
+				"netlog.workload":          "nginx",
+				"k8s.deployment.name":      "nginx",
+				"k8s.pod.label.app":        "nginx",
+				"k8s.namespace.label.team": "web",
+			}, attributes(flow[0].GetAttributes()))
+			event := collector.logs[1].GetResourceLogs()[0].GetScopeLogs()[0].GetLogRecords()
+			require.Len(t, event, 1)
+			assert.Equal(t, "WARN", event[0].GetSeverityText())
+			assert.Equal(t, "port_scan", attributes(event[0].GetAttributes())["event.name"])
+
+			require.Len(t, collector.metrics, 1)
+			metric := collector.metrics[0].GetResourceMetrics()[0].GetScopeMetrics()[0].GetMetrics()
+			require.Len(t, metric, 1)
+			assert.Equal(t, "test_flows_total", metric[0].GetName())
+			assert.Equal(t, []string{"Bearer s3cr3t", "Bearer s3cr3t", "Bearer s3cr3t"}, collector.authorization)
+
+			// Rejected records fail the export, so they are spooled
+			collector.rejected = 1
+			assert.ErrorContains(t, sink.Write(context.Background(), []types.AggregatedInfo{{Namespace: "default", Name: "nginx"}}), "rejected 1 log records")
+		})
+	}
+}
+
+func TestHTTPExportStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client, err := New(Options{Endpoint: server.URL, Protocol: ProtocolHTTP})
+	require.NoError(t, err)
+	defer client.Close()
+	err = NewLogSink(client).Write(context.Background(), []types.AggregatedInfo{{Namespace: "default", Name: "nginx"}})
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+
+	_, err = New(Options{})
+	assert.Error(t, err)
+	_, err = New(Options{Endpoint: "collector:4317", Protocol: "udp"})
+	assert.Error(t, err)
+}
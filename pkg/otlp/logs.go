@@ -0,0 +1,189 @@
+package otlp
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+)
+
+// LogSink exports flow records as OTLP log records
+type LogSink struct {
+	client *Client
+}
+
+// NewLogSink creates a sink exporting flows through client
+func NewLogSink(client *Client) *LogSink {
+	return &LogSink{client: client}
+}
+
+// Name returns the name of the sink
+func (s *LogSink) Name() string {
+	return "otlp"
+}
+
+// Write exports the batch in a single request
+func (s *LogSink) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	now := uint64(time.Now().UnixNano())
+	records := make([]*logspb.LogRecord, 0, len(flows))
+	for _, flow := range flows {
+		if !flow.Owned() {
+			continue
+		}
+		records = append(records, &logspb.LogRecord{
+			TimeUnixNano:         uint64(flow.EndTime.UnixNano()),
+			ObservedTimeUnixNano: now,
+			SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+			SeverityText:         "INFO",
+			Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: flow.String()}},
+			Attributes:           flowAttributes(flow),
+		})
+	}
+	return s.export(ctx, records)
+}
+
+// WriteEvents exports the events as warning log records
+func (s *LogSink) WriteEvents(ctx context.Context, events []types.Event) error {
+	now := uint64(time.Now().UnixNano())
+	records := make([]*logspb.LogRecord, 0, len(events))
+	for _, event := range events {
+		records = append(records, &logspb.LogRecord{
+			TimeUnixNano:         uint64(event.Time.UnixNano()),
+			ObservedTimeUnixNano: now,
+			SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_WARN,
+			SeverityText:         "WARN",
+			Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: event.Message}},
+			Attributes:           eventAttributes(event),
+		})
+	}
+	return s.export(ctx, records)
+}
+
+// export sends the log records in a single request
+func (s *LogSink) export(ctx context.Context, records []*logspb.LogRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource: s.client.resource,
+			ScopeLogs: []*logspb.ScopeLogs{{
+				Scope:      &commonpb.InstrumentationScope{Name: scopeName},
+				LogRecords: records,
+			}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.client.timeout)
+	defer cancel()
+	return s.client.exporter.exportLogs(ctx, req)
+}
+
+// Close is a no-op, the client is closed by its owner
+func (s *LogSink) Close() error {
+	return nil
+}
+
+// flowAttributes maps a flow to log record attributes
+func flowAttributes(flow types.AggregatedInfo) []*commonpb.KeyValue {
+	attrs := []*commonpb.KeyValue{
+		stringAttr("k8s.namespace.name", flow.Namespace),
+		stringAttr("netlog.name", flow.Name),
+		stringAttr("netlog.direction", flow.Direction),
+		stringAttr("source.address", flow.Source),
+		stringAttr("destination.address", flow.Destination),
+		stringAttr("network.transport", strings.ToLower(flow.Protocol)),
+		intAttr("netlog.bytes", flow.TotalBytes),
+		intAttr("netlog.packets", flow.Packets),
+		doubleAttr("netlog.duration", flow.EndTime.Sub(flow.StartTime).Seconds()),
+	}
+	if port, err := strconv.ParseInt(flow.Port, 10, 64); err == nil {
+		attrs = append(attrs, intAttr("netlog.port", port))
+	}
+	if len(flow.Tags) > 0 {
+		attrs = append(attrs, stringsAttr("netlog.tags", flow.Tags))
+	}
+	if len(flow.OwnerTags) > 0 {
+		attrs = append(attrs, stringsAttr("netlog.owner_tags", flow.OwnerTags))
+	}
+	if flow.Kind != "" {
+		attrs = append(attrs, stringAttr("netlog.kind", flow.Kind))
+		if key, ok := k8sNameKeys[flow.Kind]; ok {
+			attrs = append(attrs, stringAttr(key, flow.Name))
+		}
+	}
+	if flow.Workload != "" {
+		attrs = append(attrs, stringAttr("netlog.workload_kind", flow.WorkloadKind), stringAttr("netlog.workload", flow.Workload))
+		if key, ok := k8sNameKeys[flow.WorkloadKind]; ok {
+			attrs = append(attrs, stringAttr(key, flow.Workload))
+		}
+	}
+	labelPrefix := "netlog.label."
+	if flow.Kind != "" {
+		labelPrefix = "k8s." + strings.ToLower(flow.Kind) + ".label."
+	}
+	attrs = append(attrs, labelAttrs(labelPrefix, flow.Labels)...)
+	attrs = append(attrs, labelAttrs("k8s.namespace.label.", flow.NamespaceLabels)...)
+	return attrs
+}
+
+// k8sNameKeys are the semantic convention attributes of the names of
+// Kubernetes objects by kind
+var k8sNameKeys = map[string]string{
+	"Pod":         "k8s.pod.name",
+	"Node":        "k8s.node.name",
+	"Deployment":  "k8s.deployment.name",
+	"StatefulSet": "k8s.statefulset.name",
+	"DaemonSet":   "k8s.daemonset.name",
+	"ReplicaSet":  "k8s.replicaset.name",
+	"Job":         "k8s.job.name",
+	"CronJob":     "k8s.cronjob.name",
+}
+
+// labelAttrs returns an attribute per label named prefix and the label key,
+// sorted by key
+func labelAttrs(prefix string, labels map[string]string) []*commonpb.KeyValue {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]*commonpb.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, stringAttr(prefix+key, labels[key]))
+	}
+	return attrs
+}
+
+// eventAttributes maps a security event to log record attributes
+func eventAttributes(event types.Event) []*commonpb.KeyValue {
+	attrs := []*commonpb.KeyValue{
+		stringAttr("event.name", event.Type),
+		stringAttr("netlog.severity", event.Severity),
+		stringAttr("k8s.namespace.name", event.Namespace),
+		stringAttr("netlog.name", event.Name),
+		stringAttr("source.address", event.Source),
+	}
+	if event.Destination != "" {
+		attrs = append(attrs, stringAttr("destination.address", event.Destination))
+	}
+	if event.Protocol != "" {
+		attrs = append(attrs, stringAttr("network.transport", strings.ToLower(event.Protocol)))
+	}
+	keys := make([]string, 0, len(event.Attributes))
+	for k := range event.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, stringAttr("netlog."+k, event.Attributes[k]))
+	}
+	return attrs
+}
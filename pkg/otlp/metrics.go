@@ -0,0 +1,199 @@
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+// DefaultMetricsInterval is the default interval between metric exports
+const DefaultMetricsInterval = 30 * time.Second
+
+// MetricExporter periodically exports the metrics of a Prometheus gatherer
+// as OTLP metrics, so the collectors in pkg/metrics can be shipped to an
+// OpenTelemetry Collector with or without the /metrics endpoint
+type MetricExporter struct {
+	client   *Client
+	gatherer prometheus.Gatherer
+	interval time.Duration
+	start    time.Time
+}
+
+// NewMetricExporter creates an exporter for the metrics of gatherer
+func NewMetricExporter(client *Client, gatherer prometheus.Gatherer, interval time.Duration) *MetricExporter {
+	if interval <= 0 {
+		interval = DefaultMetricsInterval
+	}
+	return &MetricExporter{
+		client:   client,
+		gatherer: gatherer,
+		interval: interval,
+		start:    time.Now(),
+	}
+}
+
+// Run exports metrics every interval until ctx is cancelled
+func (e *MetricExporter) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil {
+				log.Printf("otlp: %v", err)
+			}
+		}
+	}
+}
+
+// Export gathers the current metrics and exports them in a single request
+func (e *MetricExporter) Export(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %w", err)
+	}
+
+	now := uint64(time.Now().UnixNano())
+	start := uint64(e.start.UnixNano())
+	metrics := make([]*metricspb.Metric, 0, len(families))
+	for _, family := range families {
+		if metric := convertFamily(family, start, now); metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	req := &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: e.client.resource,
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Scope:   &commonpb.InstrumentationScope{Name: scopeName},
+				Metrics: metrics,
+			}},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.client.timeout)
+	defer cancel()
+	return e.client.exporter.exportMetrics(ctx, req)
+}
+
+// convertFamily converts a Prometheus metric family to an OTLP metric
+func convertFamily(family *dto.MetricFamily, start, now uint64) *metricspb.Metric {
+	metric := &metricspb.Metric{
+		Name:        family.GetName(),
+		Description: family.GetHelp(),
+	}
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		points := make([]*metricspb.NumberDataPoint, 0, len(family.GetMetric()))
+		for _, m := range family.GetMetric() {
+			points = append(points, numberPoint(m, m.GetCounter().GetValue(), start, now))
+		}
+		metric.Data = &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			IsMonotonic:            true,
+			DataPoints:             points,
+		}}
+	case dto.MetricType_GAUGE:
+		points := make([]*metricspb.NumberDataPoint, 0, len(family.GetMetric()))
+		for _, m := range family.GetMetric() {
+			points = append(points, numberPoint(m, m.GetGauge().GetValue(), 0, now))
+		}
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}}
+	case dto.MetricType_UNTYPED:
+		points := make([]*metricspb.NumberDataPoint, 0, len(family.GetMetric()))
+		for _, m := range family.GetMetric() {
+			points = append(points, numberPoint(m, m.GetUntyped().GetValue(), 0, now))
+		}
+		metric.Data = &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: points}}
+	case dto.MetricType_HISTOGRAM:
+		points := make([]*metricspb.HistogramDataPoint, 0, len(family.GetMetric()))
+		for _, m := range family.GetMetric() {
+			points = append(points, histogramPoint(m, start, now))
+		}
+		metric.Data = &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			DataPoints:             points,
+		}}
+	case dto.MetricType_SUMMARY:
+		points := make([]*metricspb.SummaryDataPoint, 0, len(family.GetMetric()))
+		for _, m := range family.GetMetric() {
+			summary := m.GetSummary()
+			point := &metricspb.SummaryDataPoint{
+				Attributes:        labelAttributes(m),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      now,
+				Count:             summary.GetSampleCount(),
+				Sum:               summary.GetSampleSum(),
+			}
+			for _, q := range summary.GetQuantile() {
+				point.QuantileValues = append(point.QuantileValues, &metricspb.SummaryDataPoint_ValueAtQuantile{
+					Quantile: q.GetQuantile(),
+					Value:    q.GetValue(),
+				})
+			}
+			points = append(points, point)
+		}
+		metric.Data = &metricspb.Metric_Summary{Summary: &metricspb.Summary{DataPoints: points}}
+	default:
+		return nil
+	}
+	return metric
+}
+
+func numberPoint(m *dto.Metric, value float64, start, now uint64) *metricspb.NumberDataPoint {
+	return &metricspb.NumberDataPoint{
+		Attributes:        labelAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Value:             &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+	}
+}
+
+// histogramPoint converts cumulative Prometheus buckets to OTLP bucket counts
+func histogramPoint(m *dto.Metric, start, now uint64) *metricspb.HistogramDataPoint {
+	histogram := m.GetHistogram()
+	sum := histogram.GetSampleSum()
+	point := &metricspb.HistogramDataPoint{
+		Attributes:        labelAttributes(m),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		Count:             histogram.GetSampleCount(),
+		Sum:               &sum,
+	}
+
+	var previous uint64
+	for _, bucket := range histogram.GetBucket() {
+		if math.IsInf(bucket.GetUpperBound(), +1) {
+			continue
+		}
+		point.ExplicitBounds = append(point.ExplicitBounds, bucket.GetUpperBound())
+		point.BucketCounts = append(point.BucketCounts, bucket.GetCumulativeCount()-previous)
+		previous = bucket.GetCumulativeCount()
+	}
+	point.BucketCounts = append(point.BucketCounts, histogram.GetSampleCount()-previous)
+	return point
+}
+
+func labelAttributes(m *dto.Metric) []*commonpb.KeyValue {
+	attrs := make([]*commonpb.KeyValue, 0, len(m.GetLabel()))
+	for _, label := range m.GetLabel() {
+		attrs = append(attrs, stringAttr(label.GetName(), label.GetValue()))
+	}
+	return attrs
+}
@@ -0,0 +1,48 @@
+package otlp
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+)
+
+func TestConvertFamily(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_bytes_total", Help: "bytes"}, []string{"namespace"})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_duration_seconds", Help: "duration", Buckets: []float64{1, 5}})
+	registry.MustRegister(counter, histogram)
+
+	counter.WithLabelValues("default").Add(42)
+	histogram.Observe(0.5)
+	histogram.Observe(2)
+	histogram.Observe(10)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+
+	metrics := map[string]*metricspb.Metric{}
+	for _, family := range families {
+		metrics[family.GetName()] = convertFamily(family, 1, 2)
+	}
+
+	sum := metrics["test_bytes_total"].GetSum()
+	require.NotNil(t, sum)
+	assert.True(t, sum.GetIsMonotonic())
+	require.Len(t, sum.GetDataPoints(), 1)
+	assert.Equal(t, 42.0, sum.GetDataPoints()[0].GetAsDouble())
+	assert.Equal(t, "namespace", sum.GetDataPoints()[0].GetAttributes()[0].GetKey())
+	assert.Equal(t, "default", sum.GetDataPoints()[0].GetAttributes()[0].GetValue().GetStringValue())
+
+	hist := metrics["test_duration_seconds"].GetHistogram()
+	require.NotNil(t, hist)
+	require.Len(t, hist.GetDataPoints(), 1)
+	point := hist.GetDataPoints()[0]
+	assert.Equal(t, []float64{1, 5}, point.GetExplicitBounds())
+	assert.Equal(t, []uint64{1, 1, 1}, point.GetBucketCounts())
+	assert.Equal(t, uint64(3), point.GetCount())
+	assert.Equal(t, 12.5, point.GetSum())
+}
@@ -0,0 +1,138 @@
+// Package ownerfile attributes IP addresses to the owners listed in a local
+// file, for hosts without Kubernetes objects such as bare-metal servers
+package ownerfile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/iptrie"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// DefaultReloadInterval is the default interval between checks for a
+// changed file
+const DefaultReloadInterval = 10 * time.Second
+
+// Resolver resolves the owners of an owner file by the longest prefix
+// containing the address. The entries are held in a prefix trie which is
+// swapped atomically on reload, so lookups never wait for a reload.
+type Resolver struct {
+	path     string
+	format   string
+	recorder *metrics.Recorder
+
+	trie atomic.Pointer[iptrie.Trie[Entry]]
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// New creates a resolver for the file at path recording the lookups with
+// rec, which may be nil. Load has to be called before use. Files ending in
+// .csv are read as CSV, anything else as YAML.
+func New(path string, rec *metrics.Recorder) *Resolver {
+	r := &Resolver{path: path, format: FormatOf(path), recorder: rec}
+	r.trie.Store(iptrie.New[Entry]())
+	return r
+}
+
+// Len returns the number of entries loaded
+func (r *Resolver) Len() int {
+	return r.trie.Load().Len()
+}
+
+// Load reads the file if it changed since the last load. A file that fails
+// to load keeps the previous entries.
+func (r *Resolver) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return err
+	}
+	if r.modTime.Equal(info.ModTime()) && r.size == info.Size() {
+		return nil
+	}
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := Parse(f, r.format)
+	if err != nil {
+		return fmt.Errorf("%s: %w", r.path, err)
+	}
+
+	trie := iptrie.New[Entry]()
+	for _, e := range entries {
+		trie.Insert(e.Prefix, e)
+	}
+	r.trie.Store(trie)
+	r.modTime, r.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// Run reloads the file every interval when it changed until ctx is
+// cancelled
+func (r *Resolver) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			before := r.trie.Load()
+			if err := r.Load(); err != nil {
+				log.Printf("ownerfile: failed to reload owners: %v", err)
+				continue
+			}
+			if r.trie.Load() != before {
+				log.Printf("ownerfile: reloaded %d owners from %s", r.Len(), r.path)
+			}
+		}
+	}
+}
+
+// Resolve returns the owner of ipv4
+func (r *Resolver) Resolve(ipv4 string) (*types.OFIP, error) {
+	owner, _, err := r.Trace(ipv4)
+	return owner, err
+}
+
+// Trace resolves ipv4 like Resolve. The detail of the step is the matching
+// prefix.
+func (r *Resolver) Trace(ipv4 string) (*types.OFIP, []types.Step, error) {
+	start := time.Now()
+	addr, err := netip.ParseAddr(ipv4)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid ip address %q: %w", ipv4, err)
+	}
+	entry, ok := r.trie.Load().Lookup(addr)
+	r.recorder.ObserveLookup("static", ok)
+	step := types.Step{Resolver: "static", Result: types.StepMiss, Duration: time.Since(start)}
+	if !ok {
+		return nil, []types.Step{step}, fmt.Errorf("no static owner found for ipv4: %s", ipv4)
+	}
+
+	owner := entry.Owner
+	step.Result = types.StepHit
+	step.Owner = &owner
+	step.Detail = entry.Prefix.String()
+	return &owner, []types.Step{step}, nil
+}
@@ -0,0 +1,108 @@
+package ownerfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testYAML = `owners:
+- prefix: 198.51.100.0/24
+  namespace: dc-fra1
+  name: legacy-rack
+- prefix: 198.51.100.7
+  namespace: dc-fra1
+  name: billing-db
+  tags: [bare-metal, pci]
+- prefix: 2001:db8::/32
+  name: office
+`
+
+func TestParse(t *testing.T) {
+	entries, err := Parse(strings.NewReader(testYAML), FormatYAML)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "198.51.100.7/32", entries[1].Prefix.String())
+	assert.Equal(t, types.OFIP{Namespace: "dc-fra1", Name: "billing-db", Tags: []string{"bare-metal", "pci"}}, entries[1].Owner)
+
+	csv := "prefix,namespace,name,tags\n# legacy VMs\n203.0.113.0/25, dc-ams1, vm-pool\n203.0.113.9,dc-ams1,mail,smtp; legacy\n"
+	entries, err = Parse(strings.NewReader(csv), FormatCSV)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "203.0.113.0/25", entries[0].Prefix.String())
+	assert.Equal(t, types.OFIP{Namespace: "dc-ams1", Name: "vm-pool"}, entries[0].Owner)
+	assert.Equal(t, []string{"smtp", "legacy"}, entries[1].Owner.Tags)
+
+	for name, data := range map[string]string{
+		"invalid prefix":   "owners:\n- prefix: 198.51.100.300\n  name: a\n",
+		"missing name":     "owners:\n- prefix: 198.51.100.1\n",
+		"duplicate prefix": "owners:\n- prefix: 198.51.100.1\n  name: a\n- prefix: 198.51.100.1/32\n  name: b\n",
+		"unknown field":    "owners:\n- prefix: 198.51.100.1\n  name: a\n  owner: b\n",
+	} {
+		_, err := Parse(strings.NewReader(data), FormatYAML)
+		assert.Error(t, err, name)
+	}
+	_, err = Parse(strings.NewReader("198.51.100.1,a\n"), FormatCSV)
+	assert.ErrorContains(t, err, "line 1")
+	_, err = Parse(strings.NewReader(""), "xml")
+	assert.Error(t, err)
+
+	assert.Equal(t, FormatCSV, FormatOf("/etc/netlog/owners.CSV"))
+	assert.Equal(t, FormatYAML, FormatOf("/etc/netlog/owners.yaml"))
+}
+
+func TestResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "owners.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testYAML), 0o644))
+
+	r := New(path, nil)
+	require.NoError(t, r.Load())
+	assert.Equal(t, 3, r.Len())
+
+	// The longest prefix wins
+	owner, steps, err := r.Trace("198.51.100.7")
+	require.NoError(t, err)
+	assert.Equal(t, "dc-fra1/billing-db", owner.String())
+	assert.Equal(t, []string{"bare-metal", "pci"}, owner.Tags)
+	require.Len(t, steps, 1)
+	assert.Equal(t, types.Step{Resolver: "static", Result: types.StepHit, Owner: owner, Detail: "198.51.100.7/32", Duration: steps[0].Duration}, steps[0])
+
+	owner, err = r.Resolve("198.51.100.8")
+	require.NoError(t, err)
+	assert.Equal(t, "dc-fra1/legacy-rack", owner.String())
+	owner, err = r.Resolve("2001:db8::1")
+	require.NoError(t, err)
+	assert.Equal(t, "office", owner.String())
+
+	_, steps, err = r.Trace("203.0.113.1")
+	assert.Error(t, err)
+	assert.Equal(t, types.StepMiss, steps[0].Result)
+	_, err = r.Resolve("not-an-ip")
+	assert.Error(t, err)
+
+	// A broken file keeps the previous entries
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.WriteFile(path, []byte("owners: ["), 0o644))
+	require.NoError(t, os.Chtimes(path, future, future))
+	assert.Error(t, r.Load())
+	assert.Equal(t, 3, r.Len())
+
+	require.NoError(t, os.WriteFile(path, []byte("owners:\n- prefix: 203.0.113.0/24\n  name: vm-pool\n"), 0o644))
+	future = future.Add(time.Hour)
+	require.NoError(t, os.Chtimes(path, future, future))
+	require.NoError(t, r.Load())
+	assert.Equal(t, 1, r.Len())
+	owner, err = r.Resolve("203.0.113.1")
+	require.NoError(t, err)
+	assert.Equal(t, "vm-pool", owner.String())
+	_, err = r.Resolve("198.51.100.7")
+	assert.Error(t, err)
+
+	assert.Error(t, New(filepath.Join(t.TempDir(), "missing.yaml"), nil).Load())
+}
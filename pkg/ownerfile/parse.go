@@ -0,0 +1,143 @@
+package ownerfile
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"path/filepath"
+	"strings"
+
+	"github.com/highscaleco/netlog/pkg/iptrie"
+	"github.com/highscaleco/netlog/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// FormatYAML is a YAML or JSON document with a list of owners, see
+	// fileEntry for the fields of an owner
+	FormatYAML = "yaml"
+	// FormatCSV is a CSV file with the columns prefix, namespace, name and
+	// tags separated by semicolons. A header starting with prefix is
+	// skipped.
+	FormatCSV = "csv"
+)
+
+// Entry is the owner of an IP address or network
+type Entry struct {
+	Prefix netip.Prefix
+	Owner  types.OFIP
+}
+
+// file is the YAML document of an owner file
+type file struct {
+	Owners []fileEntry `json:"owners"`
+}
+
+// fileEntry is an owner of an owner file. Prefix is an IP address or CIDR,
+// Name is required.
+type fileEntry struct {
+	Prefix    string   `json:"prefix"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Tags      []string `json:"tags"`
+}
+
+// FormatOf returns the format of the file at path by its extension
+func FormatOf(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return FormatCSV
+	}
+	return FormatYAML
+}
+
+// Parse reads the entries of an owner file in the given format. Every
+// prefix may only be listed once.
+func Parse(r io.Reader, format string) ([]Entry, error) {
+	var owners []fileEntry
+	var err error
+	switch format {
+	case FormatYAML, "":
+		owners, err = parseYAML(r)
+	case FormatCSV:
+		owners, err = parseCSV(r)
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]Entry, 0, len(owners))
+	seen := make(map[netip.Prefix]bool, len(owners))
+	for i, o := range owners {
+		p, err := iptrie.ParsePrefix(strings.TrimSpace(o.Prefix))
+		if err != nil {
+			return nil, fmt.Errorf("owner %d: %w", i+1, err)
+		}
+		if o.Name == "" {
+			return nil, fmt.Errorf("owner %d: name cannot be empty", i+1)
+		}
+		if seen[p] {
+			return nil, fmt.Errorf("owner %d: duplicate prefix %s", i+1, p)
+		}
+		seen[p] = true
+		entries = append(entries, Entry{
+			Prefix: p,
+			Owner:  types.OFIP{Namespace: o.Namespace, Name: o.Name, Tags: o.Tags},
+		})
+	}
+	return entries, nil
+}
+
+func parseYAML(r io.Reader) ([]fileEntry, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read owners: %w", err)
+	}
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to decode owners: %w", err)
+	}
+	return f.Owners, nil
+}
+
+func parseCSV(r io.Reader) ([]fileEntry, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var owners []fileEntry
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read csv: %w", err)
+		}
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "prefix") {
+			continue
+		}
+		if len(record) < 3 || len(record) > 4 {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: expected prefix, namespace, name and optional tags", line)
+		}
+		owner := fileEntry{
+			Prefix:    record[0],
+			Namespace: strings.TrimSpace(record[1]),
+			Name:      strings.TrimSpace(record[2]),
+		}
+		if len(record) == 4 {
+			for _, tag := range strings.Split(record[3], ";") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					owner.Tags = append(owner.Tags, tag)
+				}
+			}
+		}
+		owners = append(owners, owner)
+	}
+	return owners, nil
+}
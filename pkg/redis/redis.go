@@ -1,50 +1,131 @@
+// Package redis caches the owners of IP addresses in Redis
 package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"strconv"
 	"time"
 
+	"github.com/highscaleco/netlog/pkg/metrics"
 	"github.com/redis/go-redis/v9"
 )
 
-var rdb *redis.Client
+// DefaultTimeout is the timeout of a single command
+const DefaultTimeout = 5 * time.Second
+
+// scanCount is the number of keys requested per SCAN
+const scanCount = 1000
+
+// ErrNotFound is returned when no owner is stored for an IP address
+var ErrNotFound = errors.New("no info found")
+
+// Options configures a Redis client
+type Options struct {
+	Addr string
+	// Username authenticates with Redis ACLs, the default user if empty
+	Username string
+	Password string
+	DB       int
+	// TLS enables TLS towards Redis
+	TLS bool
+	// TLSCAFile verifies the server with the CA certificates of the file
+	// instead of the system roots
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile authenticate the client with a certificate
+	TLSCertFile           string
+	TLSKeyFile            string
+	TLSInsecureSkipVerify bool
+}
 
-func redisDBFromEnv() int {
-	dbStr := os.Getenv("REDIS_DB")
-	db, err := strconv.Atoi(dbStr)
-	if err != nil {
-		return 0 // Default to DB 0 if conversion fails
+// Client caches the owners of IP addresses in Redis
+type Client struct {
+	rdb      *redis.Client
+	recorder *metrics.Recorder
+}
+
+// New creates a client recording the commands with rec, which may be nil.
+// It does not connect, see Ping.
+func New(opts Options, rec *metrics.Recorder) (*Client, error) {
+	redisOpts := &redis.Options{
+		Addr:     opts.Addr,
+		Username: opts.Username,
+		Password: opts.Password,
+		DB:       opts.DB,
 	}
-	return db
+	if opts.TLS {
+		tlsConfig, err := newTLSConfig(opts)
+		if err != nil {
+			return nil, err
+		}
+		redisOpts.TLSConfig = tlsConfig
+	}
+	return &Client{rdb: redis.NewClient(redisOpts), recorder: rec}, nil
 }
 
-func createClient() *redis.Client {
-	if rdb != nil {
-		return rdb
+func newTLSConfig(opts Options) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: opts.TLSInsecureSkipVerify,
 	}
-	rdb = redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_HOST"),
-		Password: os.Getenv("REDIS_PASSWORD"),
-		DB:       redisDBFromEnv(),
-	})
-	return rdb
+	if opts.TLSCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file: %w", err)
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", opts.TLSCAFile)
+		}
+	}
+	if opts.TLSCertFile != "" || opts.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// Redis returns the underlying client
+func (c *Client) Redis() *redis.Client {
+	return c.rdb
+}
+
+// Ping checks that Redis is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	start := time.Now()
+	err := c.rdb.Ping(ctx).Err()
+	c.recorder.ObserveRedis("ping", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connections to Redis
+func (c *Client) Close() error {
+	return c.rdb.Close()
 }
 
+// IPInfo is the owner of an IP address
 type IPInfo struct {
 	Namespace string
 	Name      string
 }
 
-func SetIP(ip string, info IPInfo) error {
+// SetIP stores the owner of ip
+func (c *Client) SetIP(ip string, info IPInfo) error {
 	if ip == "" {
 		return fmt.Errorf("ip cannot be empty")
 	}
 
-	client := createClient()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
 	// Convert struct to map for HMSet
@@ -53,30 +134,34 @@ func SetIP(ip string, info IPInfo) error {
 		"name":      info.Name,
 	}
 
-	_, err := client.HMSet(ctx, ip, fields).Result()
+	start := time.Now()
+	_, err := c.rdb.HMSet(ctx, ip, fields).Result()
+	c.recorder.ObserveRedis("hmset", start, err)
 	if err != nil {
 		return fmt.Errorf("failed to set IP info: %w", err)
 	}
 	return nil
 }
 
-func GetIP(ip string) (IPInfo, error) {
+// GetIP returns the stored owner of ip
+func (c *Client) GetIP(ip string) (IPInfo, error) {
 	if ip == "" {
 		return IPInfo{}, fmt.Errorf("ip cannot be empty")
 	}
 
-	client := createClient()
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
 	defer cancel()
 
-	info, err := client.HGetAll(ctx, ip).Result()
+	start := time.Now()
+	info, err := c.rdb.HGetAll(ctx, ip).Result()
+	c.recorder.ObserveRedis("hgetall", start, err)
 	if err != nil {
 		return IPInfo{}, fmt.Errorf("failed to get IP info: %w", err)
 	}
 
 	// Check if the key exists
 	if len(info) == 0 {
-		return IPInfo{}, fmt.Errorf("no info found for ip: %s", ip)
+		return IPInfo{}, fmt.Errorf("%w for ip: %s", ErrNotFound, ip)
 	}
 
 	// Check if required fields exist
@@ -95,3 +180,72 @@ func GetIP(ip string) (IPInfo, error) {
 		Name:      name,
 	}, nil
 }
+
+// ScanIPs calls fn with every IP address and owner stored by SetIP. Keys of
+// other data in the database are skipped.
+func (c *Client) ScanIPs(ctx context.Context, fn func(ip string, info IPInfo) error) error {
+	return c.scanIPKeys(ctx, func(keys []string) error {
+		for _, ip := range keys {
+			start := time.Now()
+			fields, err := c.rdb.HGetAll(ctx, ip).Result()
+			c.recorder.ObserveRedis("hgetall", start, err)
+			if err != nil {
+				return fmt.Errorf("failed to get IP info: %w", err)
+			}
+			if len(fields) == 0 {
+				// Deleted since the scan
+				continue
+			}
+			if err := fn(ip, IPInfo{Namespace: fields["namespace"], Name: fields["name"]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteIPs deletes every IP address stored by SetIP and returns the number
+// of deleted keys
+func (c *Client) DeleteIPs(ctx context.Context) (int, error) {
+	deleted := 0
+	err := c.scanIPKeys(ctx, func(keys []string) error {
+		start := time.Now()
+		n, err := c.rdb.Del(ctx, keys...).Result()
+		c.recorder.ObserveRedis("del", start, err)
+		if err != nil {
+			return fmt.Errorf("failed to delete IP info: %w", err)
+		}
+		deleted += int(n)
+		return nil
+	})
+	return deleted, err
+}
+
+// scanIPKeys calls fn with the keys named after an IP address, one batch of
+// the scan at a time
+func (c *Client) scanIPKeys(ctx context.Context, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		start := time.Now()
+		keys, next, err := c.rdb.Scan(ctx, cursor, "*", scanCount).Result()
+		c.recorder.ObserveRedis("scan", start, err)
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+		var ips []string
+		for _, key := range keys {
+			if net.ParseIP(key) != nil {
+				ips = append(ips, key)
+			}
+		}
+		if len(ips) > 0 {
+			if err := fn(ips); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
@@ -0,0 +1,38 @@
+package redis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTLS(t *testing.T) {
+	client, err := New(Options{Addr: "redis:6380", Username: "netlog", TLS: true}, nil)
+	require.NoError(t, err)
+	defer client.Close()
+	opts := client.Redis().Options()
+	assert.Equal(t, "netlog", opts.Username)
+	require.NotNil(t, opts.TLSConfig)
+	assert.Nil(t, opts.TLSConfig.RootCAs)
+
+	dir := t.TempDir()
+	_, err = New(Options{TLS: true, TLSCAFile: filepath.Join(dir, "missing.pem")}, nil)
+	assert.ErrorContains(t, err, "CA file")
+
+	invalid := filepath.Join(dir, "ca.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a certificate"), 0o644))
+	_, err = New(Options{TLS: true, TLSCAFile: invalid}, nil)
+	assert.ErrorContains(t, err, "no certificates")
+
+	_, err = New(Options{TLS: true, TLSCertFile: invalid, TLSKeyFile: invalid}, nil)
+	assert.ErrorContains(t, err, "client certificate")
+
+	// TLS settings are ignored unless TLS is enabled
+	client, err = New(Options{TLSCAFile: invalid}, nil)
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Nil(t, client.Redis().Options().TLSConfig)
+}
@@ -0,0 +1,196 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// DefaultBatchSize is the default number of records per batch
+	DefaultBatchSize = 500
+	// DefaultFlushInterval is the default maximum time records are buffered
+	DefaultFlushInterval = 5 * time.Second
+	// DefaultShutdownTimeout is the default time given to drain the records
+	// into the sinks on shutdown
+	DefaultShutdownTimeout = 30 * time.Second
+	// dropLogInterval is the minimum time between two logs of dropped records
+	dropLogInterval = time.Minute
+)
+
+// Batcher buffers records in memory and hands them to the wrapped sink in
+// batches from a background goroutine, so a slow sink does not stall capture.
+// Writes never wait for the queue.
+type Batcher struct {
+	sink     Sink
+	size     int
+	interval time.Duration
+	queue    chan types.AggregatedInfo
+	recorder *metrics.Recorder
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+
+	// lastErr is the error of the last flush
+	lastErr atomic.Pointer[error]
+	// dropped counts the records that didn't fit into the queue, lastDropLog
+	// is when dropping was last logged in Unix nanoseconds
+	dropped     atomic.Uint64
+	lastDropLog atomic.Int64
+}
+
+// NewBatcher creates a batching wrapper around s that flushes after size
+// records or interval, whichever comes first. Flushes and the queue length
+// are recorded by rec.
+func NewBatcher(s Sink, size int, interval time.Duration, rec *metrics.Recorder) *Batcher {
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	b := &Batcher{
+		sink:     s,
+		size:     size,
+		interval: interval,
+		queue:    make(chan types.AggregatedInfo, size*4),
+		recorder: rec,
+	}
+	b.wg.Add(1)
+	go b.run()
+	return b
+}
+
+// Name returns the name of the wrapped sink
+func (b *Batcher) Name() string {
+	return b.sink.Name()
+}
+
+// Write queues the records for the next batch without waiting. Records that
+// don't fit into the queue are spilled to the wrapped sink if it is a
+// Spiller and dropped otherwise.
+func (b *Batcher) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return fmt.Errorf("sink is closed")
+	}
+	var overflow []types.AggregatedInfo
+queue:
+	for i, flow := range flows {
+		select {
+		case b.queue <- flow:
+		default:
+			overflow = flows[i:]
+			break queue
+		}
+	}
+	if len(overflow) == 0 {
+		return nil
+	}
+
+	if spiller, ok := b.sink.(Spiller); ok {
+		err := spiller.Spill(overflow)
+		if err == nil {
+			return nil
+		}
+		log.Printf("sink %s: failed to spill %d flows: %v", b.sink.Name(), len(overflow), err)
+	}
+	b.recorder.SinkDropped(b.sink.Name(), len(overflow))
+	dropped := b.dropped.Add(uint64(len(overflow)))
+	if last := b.lastDropLog.Load(); time.Since(time.Unix(0, last)) >= dropLogInterval && b.lastDropLog.CompareAndSwap(last, time.Now().UnixNano()) {
+		log.Printf("sink %s: queue full, %d flows dropped so far", b.sink.Name(), dropped)
+	}
+	return nil
+}
+
+// Dropped returns the number of records dropped because the queue was full
+func (b *Batcher) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// WriteEvents passes the events straight to the wrapped sink, events are
+// rare and not worth batching
+func (b *Batcher) WriteEvents(ctx context.Context, events []types.Event) error {
+	return WriteEvents(ctx, b.sink, events)
+}
+
+// Len returns the number of records waiting to be flushed
+func (b *Batcher) Len() int {
+	return len(b.queue)
+}
+
+// Healthy returns the health of the wrapped sink if it has a health check
+// and the error of the last flush otherwise
+func (b *Batcher) Healthy() error {
+	if _, ok := b.sink.(HealthChecker); ok {
+		return Healthy(b.sink)
+	}
+	if err := b.lastErr.Load(); err != nil && *err != nil {
+		return fmt.Errorf("last write failed: %w", *err)
+	}
+	return nil
+}
+
+// run collects records and flushes them in batches
+func (b *Batcher) run() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]types.AggregatedInfo, 0, b.size)
+	flush := func() {
+		b.recorder.SetQueueLength(b.sink.Name(), len(b.queue))
+		if len(batch) == 0 {
+			return
+		}
+		b.recorder.SinkFlushed(b.sink.Name())
+		err := b.sink.Write(context.Background(), batch)
+		b.lastErr.Store(&err)
+		if err != nil {
+			b.recorder.SinkWriteFailed(b.sink.Name())
+			log.Printf("sink %s: failed to write %d flows: %v", b.sink.Name(), len(batch), err)
+		}
+		batch = make([]types.AggregatedInfo, 0, b.size)
+	}
+
+	for {
+		select {
+		case flow, ok := <-b.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, flow)
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close flushes the remaining records and closes the wrapped sink
+func (b *Batcher) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.queue)
+	b.mu.Unlock()
+
+	b.wg.Wait()
+	return b.sink.Close()
+}
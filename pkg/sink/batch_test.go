@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatcher(t *testing.T) {
+	fake := &fakeSink{}
+	b := NewBatcher(fake, 2, time.Hour, nil)
+	require.NoError(t, b.Write(context.Background(), flows(0, 5)))
+
+	// Full batches are flushed right away, the rest on close
+	require.Eventually(t, func() bool { return len(fake.names()) == 4 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, b.Close())
+	assert.Equal(t, names(flows(0, 5)), fake.names())
+	assert.Error(t, b.Write(context.Background(), flows(5, 6)))
+}
+
+func TestBatcherNeverBlocks(t *testing.T) {
+	// A stuck sink doesn't hold up writes, the records beyond the queue are
+	// dropped
+	fake := &fakeSink{block: make(chan struct{})}
+	b := NewBatcher(fake, 1, time.Hour, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, b.Write(context.Background(), flows(0, 100)))
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write blocked on a full queue")
+	}
+	assert.GreaterOrEqual(t, b.Dropped(), uint64(100-5))
+	close(fake.block)
+	require.NoError(t, b.Close())
+	assert.Equal(t, 100, len(fake.names())+int(b.Dropped()))
+
+	// Sinks with a spool take the records instead
+	spilling := spillingSink{&fakeSink{block: make(chan struct{})}}
+	b = NewBatcher(spilling, 1, time.Hour, nil)
+	require.NoError(t, b.Write(context.Background(), flows(0, 100)))
+	assert.Zero(t, b.Dropped())
+	assert.Equal(t, names(flows(100-len(spilling.spilled), 100)), names(spilling.spilled))
+	close(spilling.block)
+	require.NoError(t, b.Close())
+	assert.Equal(t, 100, len(spilling.names())+len(spilling.spilled))
+}
@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// DefaultHTTPTimeout is the default timeout for a single HTTP request
+const DefaultHTTPTimeout = 10 * time.Second
+
+// HTTP posts batches of flow records as newline-delimited JSON to an endpoint
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP creates a sink posting records to url
+func NewHTTP(url string, timeout time.Duration) *HTTP {
+	if timeout <= 0 {
+		timeout = DefaultHTTPTimeout
+	}
+	return &HTTP{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Name returns the name of the sink
+func (h *HTTP) Name() string {
+	return "http"
+}
+
+// Write posts the batch in a single request
+func (h *HTTP) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	var body bytes.Buffer
+	for _, flow := range flows {
+		line := flow.JSONString()
+		if line == "" {
+			continue
+		}
+		body.WriteString(line)
+		body.WriteByte('\n')
+	}
+	return h.post(ctx, &body)
+}
+
+// WriteEvents posts the events in a single request
+func (h *HTTP) WriteEvents(ctx context.Context, events []types.Event) error {
+	var body bytes.Buffer
+	for _, event := range events {
+		body.WriteString(event.JSONString())
+		body.WriteByte('\n')
+	}
+	return h.post(ctx, &body)
+}
+
+// post sends the newline-delimited JSON in body
+func (h *HTTP) post(ctx context.Context, body *bytes.Buffer) error {
+	if body.Len() == 0 {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post records: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close releases idle connections
+func (h *HTTP) Close() error {
+	h.client.CloseIdleConnections()
+	return nil
+}
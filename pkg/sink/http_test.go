@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTP(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	h := NewHTTP(server.URL, time.Second)
+	defer h.Close()
+	require.NoError(t, h.Write(context.Background(), flows(0, 2)))
+	require.Len(t, bodies, 1)
+	lines := strings.Split(strings.TrimSuffix(bodies[0], "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"name":"flow-000000"`)
+	assert.Contains(t, lines[1], `"name":"flow-000001"`)
+
+	require.NoError(t, h.WriteEvents(context.Background(), []types.Event{{Type: "port_scan", Source: "198.51.100.1"}}))
+	require.Len(t, bodies, 2)
+	assert.Contains(t, bodies[1], `"port_scan"`)
+
+	// Empty batches are not posted
+	require.NoError(t, h.Write(context.Background(), nil))
+	assert.Len(t, bodies, 2)
+
+	status = http.StatusServiceUnavailable
+	assert.ErrorContains(t, h.Write(context.Background(), flows(2, 3)), "503")
+}
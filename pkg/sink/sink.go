@@ -0,0 +1,146 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// Sink is a destination for aggregated flow records
+type Sink interface {
+	// Name returns a short identifier of the sink used in logs and metrics
+	Name() string
+	// Write delivers a batch of flow records to the sink
+	Write(ctx context.Context, flows []types.AggregatedInfo) error
+	// Close flushes any buffered records and releases resources
+	Close() error
+}
+
+// EventWriter is implemented by sinks that also deliver security events
+type EventWriter interface {
+	// WriteEvents delivers a batch of events to the sink
+	WriteEvents(ctx context.Context, events []types.Event) error
+}
+
+// WriteEvents writes events to s if it accepts them
+func WriteEvents(ctx context.Context, s Sink, events []types.Event) error {
+	ew, ok := s.(EventWriter)
+	if !ok {
+		return nil
+	}
+	return ew.WriteEvents(ctx, events)
+}
+
+// Spiller is implemented by sinks that can take records without waiting for
+// their destination, such as a spooled sink
+type Spiller interface {
+	// Spill stores the records for a later delivery
+	Spill(flows []types.AggregatedInfo) error
+}
+
+// HealthChecker is implemented by sinks that can tell whether they deliver
+// records
+type HealthChecker interface {
+	// Healthy returns an error when records are not being delivered
+	Healthy() error
+}
+
+// Healthy returns the health of s, sinks without a health check are healthy
+func Healthy(s Sink) error {
+	hc, ok := s.(HealthChecker)
+	if !ok {
+		return nil
+	}
+	return hc.Healthy()
+}
+
+// Multi fans out every batch to a set of sinks
+type Multi struct {
+	mu       sync.RWMutex
+	sinks    []Sink
+	recorder *metrics.Recorder
+}
+
+// NewMulti creates a sink writing to all of the given sinks, failed writes
+// are counted by rec
+func NewMulti(rec *metrics.Recorder, sinks ...Sink) *Multi {
+	return &Multi{sinks: sinks, recorder: rec}
+}
+
+// Name returns the name of the sink
+func (m *Multi) Name() string {
+	return "multi"
+}
+
+// Write writes the batch to every sink and returns all errors encountered
+func (m *Multi) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Write(ctx, flows); err != nil {
+			m.recorder.SinkWriteFailed(s.Name())
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteEvents writes the events to every sink accepting them and returns all
+// errors encountered
+func (m *Multi) WriteEvents(ctx context.Context, events []types.Event) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var errs []error
+	for _, s := range m.sinks {
+		if err := WriteEvents(ctx, s, events); err != nil {
+			m.recorder.SinkWriteFailed(s.Name())
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Healthy returns the errors of every unhealthy sink
+func (m *Multi) Healthy() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var errs []error
+	for _, s := range m.sinks {
+		if err := Healthy(s); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every sink and returns all errors encountered
+func (m *Multi) Close() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var errs []error
+	for _, s := range m.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Swap replaces the sinks once the writes in progress are done and returns
+// the previous sinks, which are not closed
+func (m *Multi) Swap(sinks ...Sink) []Sink {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	previous := m.sinks
+	m.sinks = sinks
+	return previous
+}
@@ -0,0 +1,228 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/spool"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// DefaultRetryInterval is the default interval between replay attempts
+	DefaultRetryInterval = 10 * time.Second
+	// replayBatchSize is the number of spooled records replayed per write
+	replayBatchSize = 500
+)
+
+// Spooled protects a network sink against outages. Batches that fail to be
+// written are appended to a disk spool and replayed in order once the sink
+// accepts writes again. While a backlog exists new records are spooled as
+// well, so the original ordering is preserved.
+type Spooled struct {
+	sink     Sink
+	spool    *spool.Spool
+	retry    time.Duration
+	recorder *metrics.Recorder
+
+	mu     sync.Mutex
+	wake   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+	closed bool
+	// lastErr is the error of the last write or replay of the sink
+	lastErr atomic.Pointer[error]
+}
+
+// NewSpooled wraps s with the disk spool sp and starts the replay loop.
+// Failed writes to s are counted by rec.
+func NewSpooled(s Sink, sp *spool.Spool, retry time.Duration, rec *metrics.Recorder) *Spooled {
+	if retry <= 0 {
+		retry = DefaultRetryInterval
+	}
+	spooled := &Spooled{
+		sink:     s,
+		spool:    sp,
+		retry:    retry,
+		recorder: rec,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	spooled.wg.Add(1)
+	go spooled.replayLoop()
+	return spooled
+}
+
+// Name returns the name of the wrapped sink
+func (s *Spooled) Name() string {
+	return s.sink.Name()
+}
+
+// Write delivers the batch directly when there is no backlog and spools it
+// otherwise or when the sink fails
+func (s *Spooled) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.spool.Len() > 0 {
+		return s.append(flows)
+	}
+
+	err := s.sink.Write(ctx, flows)
+	s.lastErr.Store(&err)
+	if err != nil {
+		s.recorder.SinkWriteFailed(s.sink.Name())
+		log.Printf("sink %s: spooling %d flows: %v", s.sink.Name(), len(flows), err)
+		if err := s.append(flows); err != nil {
+			return err
+		}
+		s.notify()
+	}
+	return nil
+}
+
+// Spill appends the batch to the spool without waiting for a write of the
+// sink in progress. It is delivered by the replay loop, possibly before
+// records written earlier.
+func (s *Spooled) Spill(flows []types.AggregatedInfo) error {
+	if err := s.append(flows); err != nil {
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// WriteEvents passes the events straight to the wrapped sink, events are
+// not spooled
+func (s *Spooled) WriteEvents(ctx context.Context, events []types.Event) error {
+	return WriteEvents(ctx, s.sink, events)
+}
+
+// Backlog returns the number of records waiting in the spool
+func (s *Spooled) Backlog() int {
+	return s.spool.Len()
+}
+
+// Healthy returns an error while flows are spooled because the sink fails
+func (s *Spooled) Healthy() error {
+	backlog := s.spool.Len()
+	if backlog == 0 {
+		return nil
+	}
+	if err := s.lastErr.Load(); err != nil && *err != nil {
+		return fmt.Errorf("%d flows spooled: %w", backlog, *err)
+	}
+	return nil
+}
+
+// append writes every record of the batch to the spool and syncs it to disk
+func (s *Spooled) append(flows []types.AggregatedInfo) error {
+	for _, flow := range flows {
+		data, err := json.Marshal(flow)
+		if err != nil {
+			return fmt.Errorf("failed to encode flow: %w", err)
+		}
+		if err := s.spool.Append(data); err != nil {
+			return fmt.Errorf("failed to spool flow: %w", err)
+		}
+	}
+	return s.spool.Sync()
+}
+
+// notify wakes up the replay loop without blocking
+func (s *Spooled) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replayLoop periodically tries to drain the spool into the sink
+func (s *Spooled) replayLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.retry)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.wake:
+			// Give the sink some time to recover before the first retry
+			select {
+			case <-s.done:
+				return
+			case <-time.After(s.retry):
+			}
+		}
+		if err := s.replay(); err != nil {
+			log.Printf("sink %s: replay failed, %d flows spooled: %v", s.sink.Name(), s.spool.Len(), err)
+		}
+	}
+}
+
+// replay writes spooled batches to the sink until the spool is empty or a
+// write fails
+func (s *Spooled) replay() error {
+	for s.spool.Len() > 0 {
+		select {
+		case <-s.done:
+			return nil
+		default:
+		}
+
+		batch, err := s.spool.Peek(replayBatchSize)
+		if err != nil {
+			return err
+		}
+
+		flows := make([]types.AggregatedInfo, 0, len(batch.Records))
+		for _, record := range batch.Records {
+			var flow types.AggregatedInfo
+			if err := json.Unmarshal(record, &flow); err != nil {
+				// Skip records that can't be decoded rather than blocking forever
+				log.Printf("sink %s: dropping corrupt spool record: %v", s.sink.Name(), err)
+				continue
+			}
+			flows = append(flows, flow)
+		}
+
+		if len(flows) > 0 {
+			err := s.sink.Write(context.Background(), flows)
+			s.lastErr.Store(&err)
+			if err != nil {
+				s.recorder.SinkWriteFailed(s.sink.Name())
+				return err
+			}
+		}
+		if err := s.spool.Commit(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the replay loop and closes the sink and the spool. Records
+// still in the spool are kept on disk for the next run.
+func (s *Spooled) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return errors.Join(s.sink.Close(), s.spool.Close())
+}
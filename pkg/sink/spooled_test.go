@@ -0,0 +1,148 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/spool"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSink keeps the flows written to it and fails while failing is set
+type fakeSink struct {
+	mu      sync.Mutex
+	failing bool
+	flows   []types.AggregatedInfo
+	spilled []types.AggregatedInfo
+	// block makes writes wait until it is closed
+	block chan struct{}
+}
+
+func (s *fakeSink) Name() string { return "fake" }
+
+func (s *fakeSink) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	if s.block != nil {
+		<-s.block
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("connection refused")
+	}
+	s.flows = append(s.flows, flows...)
+	return nil
+}
+
+func (s *fakeSink) Close() error { return nil }
+
+func (s *fakeSink) setFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *fakeSink) names() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, len(s.flows))
+	for i, flow := range s.flows {
+		names[i] = flow.Name
+	}
+	return names
+}
+
+// spillingSink is a fake sink accepting spilled records
+type spillingSink struct {
+	*fakeSink
+}
+
+func (s spillingSink) Spill(flows []types.AggregatedInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.spilled = append(s.spilled, flows...)
+	return nil
+}
+
+func flows(from, to int) []types.AggregatedInfo {
+	var result []types.AggregatedInfo
+	for i := from; i < to; i++ {
+		result = append(result, types.AggregatedInfo{Namespace: "default", Name: fmt.Sprintf("flow-%06d", i)})
+	}
+	return result
+}
+
+func names(flows []types.AggregatedInfo) []string {
+	result := make([]string, len(flows))
+	for i, flow := range flows {
+		result[i] = flow.Name
+	}
+	return result
+}
+
+func TestSpooled(t *testing.T) {
+	sp, err := spool.Open(t.TempDir(), 0)
+	require.NoError(t, err)
+	fake := &fakeSink{failing: true}
+	s := NewSpooled(fake, sp, 10*time.Millisecond, nil)
+
+	// Failed batches are spooled, later ones queue up behind them
+	require.NoError(t, s.Write(context.Background(), flows(0, 3)))
+	require.NoError(t, s.Write(context.Background(), flows(3, 5)))
+	assert.Equal(t, 5, s.Backlog())
+	assert.ErrorContains(t, s.Healthy(), "5 flows spooled")
+	require.NoError(t, s.Spill(flows(5, 6)))
+	assert.Empty(t, fake.names())
+
+	// Once the sink recovers the spool is replayed in order
+	fake.setFailing(false)
+	require.Eventually(t, func() bool { return s.Backlog() == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, s.Write(context.Background(), flows(6, 7)))
+	assert.Equal(t, names(flows(0, 7)), fake.names())
+	assert.NoError(t, s.Healthy())
+	require.NoError(t, s.Close())
+}
+
+func TestSpooledKeepsBacklog(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := spool.Open(dir, 0)
+	require.NoError(t, err)
+	s := NewSpooled(&fakeSink{failing: true}, sp, time.Hour, nil)
+	require.NoError(t, s.Write(context.Background(), flows(0, 2)))
+	require.NoError(t, s.Close())
+
+	// The backlog is replayed after a restart
+	sp, err = spool.Open(dir, 0)
+	require.NoError(t, err)
+	fake := &fakeSink{}
+	s = NewSpooled(fake, sp, 10*time.Millisecond, nil)
+	require.Eventually(t, func() bool { return s.Backlog() == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, names(flows(0, 2)), fake.names())
+	require.NoError(t, s.Close())
+}
+
+func TestSpooledTrimsOldest(t *testing.T) {
+	sp, err := spool.Open(t.TempDir(), 2<<20)
+	require.NoError(t, err)
+	fake := &fakeSink{failing: true}
+	s := NewSpooled(fake, sp, 10*time.Millisecond, nil)
+
+	// Write more than the spool holds
+	const n = 12000
+	for i := 0; i < n; i += 1000 {
+		require.NoError(t, s.Write(context.Background(), flows(i, i+1000)))
+	}
+	require.NotZero(t, sp.Dropped())
+	assert.Equal(t, n-int(sp.Dropped()), s.Backlog())
+
+	// The newest flows survive, still in order
+	fake.setFailing(false)
+	require.Eventually(t, func() bool { return s.Backlog() == 0 }, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, names(flows(int(sp.Dropped()), n)), fake.names())
+	require.NoError(t, s.Close())
+}
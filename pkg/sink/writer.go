@@ -0,0 +1,90 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/highscaleco/netlog/pkg/flowpb"
+	"github.com/highscaleco/netlog/pkg/types"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// Writer writes flow records to an io.Writer such as stdout, either line by
+// line or as a stream of length-delimited protobuf messages
+type Writer struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format string
+}
+
+// NewWriter creates a sink that writes records in the given format (text, json or proto)
+func NewWriter(w io.Writer, format string) *Writer {
+	return &Writer{w: w, format: format}
+}
+
+// Name returns the name of the sink
+func (w *Writer) Name() string {
+	return "writer"
+}
+
+// Write writes every record that has an owner as a single line
+func (w *Writer) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, flow := range flows {
+		if w.format == "proto" {
+			if !flow.Owned() {
+				continue
+			}
+			if _, err := protodelim.MarshalTo(w.w, flowpb.FromAggregatedInfo(flow)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var output string
+		if w.format == "json" {
+			output = flow.JSONString()
+		} else {
+			output = flow.String()
+		}
+		if output == "" {
+			continue
+		}
+		if _, err := fmt.Fprintln(w.w, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteEvents writes every event as a single line. Events are not part of
+// the protobuf stream, the configuration rejects the proto format with
+// sources of events.
+func (w *Writer) WriteEvents(ctx context.Context, events []types.Event) error {
+	if w.format == "proto" {
+		return fmt.Errorf("events can't be written in the proto format")
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, event := range events {
+		output := event.String()
+		if w.format == "json" {
+			output = event.JSONString()
+		}
+		if _, err := fmt.Fprintln(w.w, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close is a no-op for writers
+func (w *Writer) Close() error {
+	return nil
+}
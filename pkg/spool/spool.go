@@ -0,0 +1,483 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxBytes is the default maximum size of the spool on disk
+	DefaultMaxBytes = 1 << 30
+	// minSegmentBytes is the smallest segment size used when rotating files
+	minSegmentBytes = 1 << 20
+	// maxSegmentBytes is the largest segment size used when rotating files
+	maxSegmentBytes = 64 << 20
+	// headerSize is the size of the length and checksum prefix of a record
+	headerSize = 8
+	// segmentExt is the file extension of segment files
+	segmentExt = ".seg"
+	// cursorFile is the name of the file holding the read position
+	cursorFile = "cursor"
+	// syncBytes and syncInterval bound the records appended since the last
+	// sync, which are lost when the machine crashes
+	syncBytes    = 1 << 20
+	syncInterval = time.Second
+)
+
+// ErrTooLarge is returned when a record can never fit into the spool
+var ErrTooLarge = errors.New("record exceeds spool size")
+
+// position identifies a record boundary inside the spool
+type position struct {
+	segment uint64
+	offset  int64
+}
+
+// segment is a single append-only file of the spool
+type segment struct {
+	id      uint64
+	size    int64
+	records int
+}
+
+// Batch is a set of records read from the head of the spool
+type Batch struct {
+	Records [][]byte
+	start   position
+	end     position
+	counts  map[uint64]int
+}
+
+// Spool is a durable first-in first-out queue of records kept on local disk.
+// Records are appended to segment files which are discarded oldest-first once
+// the spool grows past its maximum size. The read position is persisted so the
+// queue survives restarts. Appends are synced to disk after every MiB or
+// second and by Sync, a crash loses at most the records appended since.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu       sync.Mutex
+	segments []*segment
+	tail     *os.File
+	head     position
+	records  int
+	bytes    int64
+	dropped  uint64
+	// unsynced is the size of the records appended since lastSync
+	unsynced int64
+	lastSync time.Time
+}
+
+// Open opens the spool in dir, creating it if needed. Records left over from
+// a previous run are kept and will be returned by Peek.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	segmentBytes := maxBytes / 8
+	if segmentBytes < minSegmentBytes {
+		segmentBytes = minSegmentBytes
+	}
+	if segmentBytes > maxSegmentBytes {
+		segmentBytes = maxSegmentBytes
+	}
+
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+		lastSync:     time.Now(),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load scans the spool directory and restores the segment list and cursor
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	head, err := s.readCursor()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// Segments fully consumed before the last shutdown are removed
+		if id < head.segment {
+			_ = os.Remove(s.segmentPath(id))
+			continue
+		}
+		from := int64(0)
+		if id == head.segment {
+			from = head.offset
+		}
+		seg, err := s.scanSegment(id, from)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, seg)
+		s.records += seg.records
+		s.bytes += seg.size
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, &segment{id: head.segment + 1})
+		head = position{segment: head.segment + 1}
+	} else if head.segment != s.segments[0].id {
+		head = position{segment: s.segments[0].id}
+	}
+	s.head = head
+
+	last := s.segments[len(s.segments)-1]
+	tail, err := os.OpenFile(s.segmentPath(last.id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	s.tail = tail
+	return nil
+}
+
+// scanSegment counts the records of a segment starting at offset and drops a
+// trailing partial record left behind by an interrupted write
+func (s *Spool) scanSegment(id uint64, offset int64) (*segment, error) {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	var valid int64
+	records := 0
+	r := bufio.NewReader(f)
+	for {
+		payload, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		if valid >= offset {
+			records++
+		}
+		valid += int64(headerSize + len(payload))
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat spool segment: %w", err)
+	}
+	if info.Size() != valid {
+		if err := os.Truncate(s.segmentPath(id), valid); err != nil {
+			return nil, fmt.Errorf("failed to repair spool segment: %w", err)
+		}
+	}
+	return &segment{id: id, size: valid, records: records}, nil
+}
+
+// Append adds a record to the end of the spool. When the spool exceeds its
+// maximum size the oldest segments are discarded.
+func (s *Spool) Append(record []byte) error {
+	size := int64(headerSize + len(record))
+	if size > s.segmentBytes {
+		return ErrTooLarge
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+size > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = s.segments[len(s.segments)-1]
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(record)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(record))
+	copy(buf[headerSize:], record)
+	if _, err := s.tail.Write(buf); err != nil {
+		return fmt.Errorf("failed to write spool record: %w", err)
+	}
+
+	last.size += size
+	last.records++
+	s.records++
+	s.bytes += size
+	s.unsynced += size
+	if s.unsynced >= syncBytes || time.Since(s.lastSync) >= syncInterval {
+		if err := s.sync(); err != nil {
+			return err
+		}
+	}
+
+	// Discard oldest segments until we fit again
+	for s.bytes > s.maxBytes && len(s.segments) > 1 {
+		s.dropOldest()
+	}
+	return nil
+}
+
+// rotate closes the current tail segment and starts a new one
+func (s *Spool) rotate() error {
+	last := s.segments[len(s.segments)-1]
+	if err := s.sync(); err != nil {
+		return err
+	}
+	if err := s.tail.Close(); err != nil {
+		return fmt.Errorf("failed to close spool segment: %w", err)
+	}
+	next := &segment{id: last.id + 1}
+	tail, err := os.OpenFile(s.segmentPath(next.id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to create spool segment: %w", err)
+	}
+	s.tail = tail
+	s.segments = append(s.segments, next)
+	return nil
+}
+
+// dropOldest removes the oldest segment along with its unread records
+func (s *Spool) dropOldest() {
+	oldest := s.segments[0]
+	_ = os.Remove(s.segmentPath(oldest.id))
+	s.segments = s.segments[1:]
+	s.records -= oldest.records
+	s.bytes -= oldest.size
+	s.dropped += uint64(oldest.records)
+
+	if s.head.segment <= oldest.id {
+		s.head = position{segment: s.segments[0].id}
+		_ = s.writeCursor()
+	}
+}
+
+// Peek returns up to max records from the head of the spool without removing
+// them. The records are removed once the batch is passed to Commit.
+func (s *Spool) Peek(max int) (*Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch := &Batch{start: s.head, end: s.head, counts: make(map[uint64]int)}
+	for i, seg := range s.segments {
+		if len(batch.Records) >= max {
+			break
+		}
+		if seg.id < batch.end.segment {
+			continue
+		}
+		offset := int64(0)
+		if seg.id == batch.end.segment {
+			offset = batch.end.offset
+		}
+		if offset >= seg.size {
+			if i < len(s.segments)-1 {
+				batch.end = position{segment: s.segments[i+1].id}
+			}
+			continue
+		}
+
+		f, err := os.Open(s.segmentPath(seg.id))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open spool segment: %w", err)
+		}
+		r := bufio.NewReader(io.NewSectionReader(f, offset, seg.size-offset))
+		for len(batch.Records) < max {
+			payload, err := readRecord(r)
+			if err != nil {
+				break
+			}
+			batch.Records = append(batch.Records, payload)
+			batch.counts[seg.id]++
+			offset += int64(headerSize + len(payload))
+		}
+		f.Close()
+
+		batch.end = position{segment: seg.id, offset: offset}
+		if offset >= seg.size && i < len(s.segments)-1 {
+			batch.end = position{segment: s.segments[i+1].id}
+		}
+	}
+	return batch, nil
+}
+
+// Commit removes the records of a batch returned by Peek from the spool
+func (s *Spool) Commit(batch *Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Records may have been discarded while the batch was in flight
+	if batch.start != s.head {
+		return nil
+	}
+
+	for _, seg := range s.segments {
+		if n, ok := batch.counts[seg.id]; ok {
+			seg.records -= n
+			s.records -= n
+		}
+	}
+	s.head = batch.end
+
+	// Remove segments that have been read completely
+	for len(s.segments) > 1 && s.segments[0].id < s.head.segment {
+		oldest := s.segments[0]
+		_ = os.Remove(s.segmentPath(oldest.id))
+		s.segments = s.segments[1:]
+		s.bytes -= oldest.size
+	}
+
+	// Start over with an empty segment once everything has been read
+	if s.records == 0 && s.segments[0].size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		oldest := s.segments[0]
+		_ = os.Remove(s.segmentPath(oldest.id))
+		s.segments = s.segments[1:]
+		s.bytes -= oldest.size
+		s.head = position{segment: s.segments[0].id}
+	}
+	return s.writeCursor()
+}
+
+// Len returns the number of records waiting in the spool
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// Size returns the number of bytes used by the spool on disk
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bytes
+}
+
+// Dropped returns the number of records discarded because the spool was full
+func (s *Spool) Dropped() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Sync flushes the records appended so far to disk
+func (s *Spool) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sync()
+}
+
+func (s *Spool) sync() error {
+	if s.unsynced == 0 {
+		return nil
+	}
+	if err := s.tail.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+	s.unsynced = 0
+	s.lastSync = time.Now()
+	return nil
+}
+
+// Close flushes the spool to disk and closes the open segment
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.tail.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool segment: %w", err)
+	}
+	if err := s.writeCursor(); err != nil {
+		return err
+	}
+	return s.tail.Close()
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+// readCursor loads the persisted read position
+func (s *Spool) readCursor() (position, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, cursorFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return position{}, nil
+	}
+	if err != nil {
+		return position{}, fmt.Errorf("failed to read spool cursor: %w", err)
+	}
+
+	var pos position
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.segment, &pos.offset); err != nil {
+		return position{}, fmt.Errorf("invalid spool cursor: %w", err)
+	}
+	return pos, nil
+}
+
+// writeCursor atomically persists the read position
+func (s *Spool) writeCursor() error {
+	path := filepath.Join(s.dir, cursorFile)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %d\n", s.head.segment, s.head.offset)
+	if err := os.WriteFile(tmp, []byte(data), 0o640); err != nil {
+		return fmt.Errorf("failed to write spool cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write spool cursor: %w", err)
+	}
+	return nil
+}
+
+// readRecord reads a single length-prefixed record and verifies its checksum
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("spool record checksum mismatch")
+	}
+	return payload, nil
+}
@@ -0,0 +1,81 @@
+package spool
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpoolOrder(t *testing.T) {
+	s, err := Open(t.TempDir(), 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Append([]byte(fmt.Sprintf("record-%d", i))))
+	}
+	assert.Equal(t, 10, s.Len())
+
+	batch, err := s.Peek(4)
+	require.NoError(t, err)
+	assert.Len(t, batch.Records, 4)
+	assert.Equal(t, "record-0", string(batch.Records[0]))
+	require.NoError(t, s.Commit(batch))
+	assert.Equal(t, 6, s.Len())
+
+	batch, err = s.Peek(100)
+	require.NoError(t, err)
+	assert.Len(t, batch.Records, 6)
+	assert.Equal(t, "record-4", string(batch.Records[0]))
+	assert.Equal(t, "record-9", string(batch.Records[5]))
+	require.NoError(t, s.Commit(batch))
+	assert.Equal(t, 0, s.Len())
+}
+
+func TestSpoolSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir, 0)
+	require.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.Append([]byte(fmt.Sprintf("record-%d", i))))
+	}
+	batch, err := s.Peek(2)
+	require.NoError(t, err)
+	require.NoError(t, s.Commit(batch))
+	require.NoError(t, s.Close())
+
+	s, err = Open(dir, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	assert.Equal(t, 3, s.Len())
+	batch, err = s.Peek(100)
+	require.NoError(t, err)
+	require.Len(t, batch.Records, 3)
+	assert.Equal(t, "record-2", string(batch.Records[0]))
+}
+
+func TestSpoolDiscardsOldest(t *testing.T) {
+	s, err := Open(t.TempDir(), 4*minSegmentBytes)
+	require.NoError(t, err)
+	defer s.Close()
+
+	record := make([]byte, 64*1024)
+	for i := 0; i < 128; i++ {
+		record[0] = byte(i)
+		require.NoError(t, s.Append(record))
+	}
+
+	assert.LessOrEqual(t, s.Size(), int64(4*minSegmentBytes))
+	assert.Greater(t, s.Dropped(), uint64(0))
+	assert.Equal(t, 128-int(s.Dropped()), s.Len())
+
+	// The oldest surviving record comes first
+	batch, err := s.Peek(1)
+	require.NoError(t, err)
+	require.Len(t, batch.Records, 1)
+	assert.Equal(t, byte(s.Dropped()), batch.Records[0][0])
+}
@@ -0,0 +1,64 @@
+package top
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Ranking is the response of the top endpoint
+type Ranking struct {
+	Dimension string  `json:"dimension"`
+	Window    string  `json:"window"`
+	Entries   []Entry `json:"entries"`
+}
+
+// Handler serves the rankings as JSON. The query parameters dimension
+// (default owner), window (default the shortest window) and limit select
+// the ranking.
+func (t *Tracker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		dimension := query.Get("dimension")
+		if dimension == "" {
+			dimension = DimensionOwner
+		}
+		window := t.Windows()[0]
+		if s := query.Get("window"); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				http.Error(w, "invalid window: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		limit := DefaultLimit
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit: "+s, http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		entries, err := t.Top(dimension, window, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Ranking{
+			Dimension: dimension,
+			Window:    window.String(),
+			Entries:   entries,
+		})
+	})
+}
@@ -0,0 +1,121 @@
+package top
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Counter is the estimated count of a key. The true count lies between
+// Count-Error and Count.
+type Counter struct {
+	Key   string
+	Count int64
+	Error int64
+}
+
+// Summary finds the heavy hitters of a stream with the space-saving
+// algorithm. It tracks at most capacity keys; a new key replaces the key with
+// the lowest count and inherits its count as error, so every key with more
+// than total/capacity is guaranteed to be tracked.
+type Summary struct {
+	capacity int
+	counters map[string]*counter
+	heap     counterHeap
+}
+
+type counter struct {
+	Counter
+	index int
+}
+
+// NewSummary creates a summary tracking up to capacity keys
+func NewSummary(capacity int) *Summary {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	return &Summary{
+		capacity: capacity,
+		counters: make(map[string]*counter),
+	}
+}
+
+// Add adds n to the count of key
+func (s *Summary) Add(key string, n int64) {
+	if c, ok := s.counters[key]; ok {
+		c.Count += n
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+
+	if len(s.heap) < s.capacity {
+		c := &counter{Counter: Counter{Key: key, Count: n}}
+		s.counters[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+
+	// Replace the key with the lowest count
+	c := s.heap[0]
+	delete(s.counters, c.Key)
+	c.Key, c.Error, c.Count = key, c.Count, c.Count+n
+	s.counters[key] = c
+	heap.Fix(&s.heap, 0)
+}
+
+// Len returns the number of tracked keys
+func (s *Summary) Len() int {
+	return len(s.heap)
+}
+
+// Counters returns the counters of all tracked keys in no particular order
+func (s *Summary) Counters() []Counter {
+	counters := make([]Counter, 0, len(s.heap))
+	for _, c := range s.heap {
+		counters = append(counters, c.Counter)
+	}
+	return counters
+}
+
+// Top returns the k keys with the highest counts
+func (s *Summary) Top(k int) []Counter {
+	counters := s.Counters()
+	sortCounters(counters)
+	if k > 0 && len(counters) > k {
+		counters = counters[:k]
+	}
+	return counters
+}
+
+// sortCounters orders counters by count, highest first
+func sortCounters(counters []Counter) {
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Count != counters[j].Count {
+			return counters[i].Count > counters[j].Count
+		}
+		return counters[i].Key < counters[j].Key
+	})
+}
+
+// counterHeap is a min-heap of counters
+type counterHeap []*counter
+
+func (h counterHeap) Len() int           { return len(h) }
+func (h counterHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h counterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *counterHeap) Push(x interface{}) {
+	c := x.(*counter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *counterHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,309 @@
+// Package top maintains rolling rankings of the heaviest talkers
+package top
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+const (
+	// DimensionNamespace ranks namespaces
+	DimensionNamespace = "namespace"
+	// DimensionOwner ranks workloads as namespace/name
+	DimensionOwner = "owner"
+	// DimensionRemote ranks remote IP addresses
+	DimensionRemote = "remote"
+	// DimensionPort ranks ports as protocol/port
+	DimensionPort = "port"
+
+	// DefaultCapacity is the default number of keys tracked per dimension and bucket
+	DefaultCapacity = 1000
+	// DefaultLimit is the default number of entries of a ranking
+	DefaultLimit = 10
+	// DefaultMetricsLimit is the default number of entries per ranking exported as gauges
+	DefaultMetricsLimit = 10
+	// windowBuckets is the number of buckets a window is split into
+	windowBuckets = 12
+)
+
+// Dimensions are the dimensions traffic is ranked by
+var Dimensions = []string{DimensionNamespace, DimensionOwner, DimensionRemote, DimensionPort}
+
+// DefaultWindows are the default ranking windows
+var DefaultWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// Entry is a ranked key
+type Entry struct {
+	Key string `json:"key"`
+	// Bytes is the estimated traffic of the key within the window
+	Bytes int64 `json:"bytes"`
+	// Rate is the average bytes per second within the window
+	Rate float64 `json:"rate"`
+	// Error is the maximum overestimation of Bytes
+	Error int64 `json:"error"`
+}
+
+// Options configures a tracker
+type Options struct {
+	// Windows are the time ranges rankings are computed over
+	Windows []time.Duration
+	// Capacity is the number of keys tracked per dimension and bucket, it
+	// bounds memory and the accuracy of the rankings
+	Capacity int
+	// MetricsLimit is the number of entries per ranking exported as gauges,
+	// 0 exports none
+	MetricsLimit int
+}
+
+// Tracker ranks the traffic of the emitted flows by namespace, owner,
+// remote IP and port over rolling windows. It is a sink, flows written to it
+// are counted in the current bucket of every window.
+type Tracker struct {
+	opts    Options
+	now     func() time.Time
+	mu      sync.Mutex
+	windows []*window
+}
+
+// New creates a tracker
+func New(opts Options) *Tracker {
+	var sizes []time.Duration
+	for _, size := range opts.Windows {
+		if size > 0 && !containsDuration(sizes, size) {
+			sizes = append(sizes, size)
+		}
+	}
+	if len(sizes) == 0 {
+		sizes = DefaultWindows
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultCapacity
+	}
+	if opts.MetricsLimit < 0 {
+		opts.MetricsLimit = 0
+	}
+
+	windows := make([]*window, 0, len(sizes))
+	for _, size := range sizes {
+		windows = append(windows, newWindow(size, opts.Capacity))
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].size < windows[j].size
+	})
+	return &Tracker{opts: opts, now: time.Now, windows: windows}
+}
+
+// Name returns the name of the sink
+func (t *Tracker) Name() string {
+	return "top"
+}
+
+// Write counts the bytes of the flows that have an owner
+func (t *Tracker) Write(ctx context.Context, flows []types.AggregatedInfo) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for _, flow := range flows {
+		if !flow.Owned() {
+			continue
+		}
+		keys := Keys(flow)
+		for _, w := range t.windows {
+			b := w.bucket(now)
+			for i, dimension := range Dimensions {
+				b.summaries[dimension].Add(keys[i], flow.TotalBytes)
+			}
+		}
+	}
+	return nil
+}
+
+// Close does nothing
+func (t *Tracker) Close() error {
+	return nil
+}
+
+// Keys returns the key of flow in each of Dimensions
+func Keys(flow types.AggregatedInfo) []string {
+	remote := flow.Destination
+	if flow.Direction == "inbound" {
+		remote = flow.Source
+	}
+	return []string{
+		flow.Namespace,
+		flow.Namespace + "/" + flow.Name,
+		remote,
+		ServicePort(flow),
+	}
+}
+
+// ServicePort returns the port of the service side of flow as
+// protocol/port: the destination port of outbound flows and the source
+// port of inbound ones, so client ports don't fill the ranking
+func ServicePort(flow types.AggregatedInfo) string {
+	port := flow.DestinationPort
+	// Flows decoded from protobuf have no destination port
+	if flow.Direction == "inbound" || port == "" {
+		port = flow.Port
+	}
+	return flow.Protocol + "/" + port
+}
+
+// Windows returns the ranking windows from the shortest to the longest
+func (t *Tracker) Windows() []time.Duration {
+	windows := make([]time.Duration, 0, len(t.windows))
+	for _, w := range t.windows {
+		windows = append(windows, w.size)
+	}
+	return windows
+}
+
+// Top returns the limit heaviest keys of dimension within window
+func (t *Tracker) Top(dimension string, window time.Duration, limit int) ([]Entry, error) {
+	if !validDimension(dimension) {
+		return nil, fmt.Errorf("unknown dimension: %s", dimension)
+	}
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, w := range t.windows {
+		if w.size == window {
+			return w.top(t.now(), dimension, limit), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown window: %s", window)
+}
+
+// TopTalkers returns the rankings exported as gauges
+func (t *Tracker) TopTalkers() []metrics.TopTalker {
+	if t.opts.MetricsLimit == 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var talkers []metrics.TopTalker
+	for _, w := range t.windows {
+		for _, dimension := range Dimensions {
+			for i, entry := range w.top(now, dimension, t.opts.MetricsLimit) {
+				talkers = append(talkers, metrics.TopTalker{
+					Dimension: dimension,
+					Window:    w.size.String(),
+					Rank:      i + 1,
+					Key:       entry.Key,
+					Rate:      entry.Rate,
+				})
+			}
+		}
+	}
+	return talkers
+}
+
+func containsDuration(durations []time.Duration, d time.Duration) bool {
+	for _, v := range durations {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
+
+func validDimension(dimension string) bool {
+	for _, d := range Dimensions {
+		if d == dimension {
+			return true
+		}
+	}
+	return false
+}
+
+// window is a ring of buckets covering a rolling time range
+type window struct {
+	size     time.Duration
+	step     time.Duration
+	capacity int
+	buckets  [windowBuckets]*bucket
+}
+
+// bucket holds the summaries of one step of a window
+type bucket struct {
+	index     int64
+	summaries map[string]*Summary
+}
+
+func newWindow(size time.Duration, capacity int) *window {
+	step := size / windowBuckets
+	if step <= 0 {
+		step = time.Nanosecond
+	}
+	return &window{size: size, step: step, capacity: capacity}
+}
+
+// bucket returns the bucket of time t, recycling the slot of an expired one
+func (w *window) bucket(t time.Time) *bucket {
+	index := t.UnixNano() / int64(w.step)
+	slot := index % windowBuckets
+	b := w.buckets[slot]
+	if b == nil || b.index != index {
+		b = &bucket{index: index, summaries: make(map[string]*Summary, len(Dimensions))}
+		for _, dimension := range Dimensions {
+			b.summaries[dimension] = NewSummary(w.capacity)
+		}
+		w.buckets[slot] = b
+	}
+	return b
+}
+
+// top merges the buckets within the window ending at now and returns the
+// limit heaviest keys of dimension
+func (w *window) top(now time.Time, dimension string, limit int) []Entry {
+	current := now.UnixNano() / int64(w.step)
+	merged := make(map[string]*Counter)
+	for _, b := range w.buckets {
+		if b == nil || b.index <= current-windowBuckets || b.index > current {
+			continue
+		}
+		for _, c := range b.summaries[dimension].Counters() {
+			m, ok := merged[c.Key]
+			if !ok {
+				m = &Counter{Key: c.Key}
+				merged[c.Key] = m
+			}
+			m.Count += c.Count
+			m.Error += c.Error
+		}
+	}
+
+	counters := make([]Counter, 0, len(merged))
+	for _, c := range merged {
+		counters = append(counters, *c)
+	}
+	sortCounters(counters)
+	if len(counters) > limit {
+		counters = counters[:limit]
+	}
+
+	entries := make([]Entry, 0, len(counters))
+	for _, c := range counters {
+		entries = append(entries, Entry{
+			Key:   c.Key,
+			Bytes: c.Count,
+			Rate:  float64(c.Count) / w.size.Seconds(),
+			Error: c.Error,
+		})
+	}
+	return entries
+}
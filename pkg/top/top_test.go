@@ -0,0 +1,155 @@
+package top
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	s := NewSummary(3)
+	s.Add("a", 10)
+	s.Add("b", 5)
+	s.Add("a", 10)
+	assert.Equal(t, []Counter{{Key: "a", Count: 20}, {Key: "b", Count: 5}}, s.Top(0))
+
+	// New keys replace the smallest counter and inherit its count as error
+	s.Add("c", 1)
+	s.Add("d", 2)
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, []Counter{{Key: "a", Count: 20}, {Key: "b", Count: 5}, {Key: "d", Count: 3, Error: 1}}, s.Top(0))
+	assert.Len(t, s.Top(1), 1)
+}
+
+func TestSummaryHeavyHitters(t *testing.T) {
+	s := NewSummary(20)
+	// Heavy hitters hidden in a long tail of small keys
+	for i := 0; i < 10000; i++ {
+		s.Add(fmt.Sprintf("tail-%d", i), 1)
+		if i%10 == 0 {
+			s.Add("heavy-1", 10)
+			s.Add("heavy-2", 5)
+		}
+	}
+
+	top := s.Top(2)
+	require.Len(t, top, 2)
+	assert.Equal(t, "heavy-1", top[0].Key)
+	assert.Equal(t, "heavy-2", top[1].Key)
+	for _, c := range top {
+		assert.LessOrEqual(t, c.Count-c.Error, map[string]int64{"heavy-1": 10000, "heavy-2": 5000}[c.Key])
+	}
+}
+
+func TestTracker(t *testing.T) {
+	tracker := New(Options{Windows: []time.Duration{5 * time.Minute, time.Minute}, MetricsLimit: 3})
+	assert.Equal(t, []time.Duration{time.Minute, 5 * time.Minute}, tracker.Windows())
+
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	write := func(namespace, name, remote string, bytes int64) {
+		require.NoError(t, tracker.Write(context.Background(), []types.AggregatedInfo{{
+			Namespace:       namespace,
+			Name:            name,
+			Direction:       "outbound",
+			Source:          "1.1.1.1",
+			Destination:     remote,
+			Protocol:        "TCP",
+			Port:            "51234",
+			DestinationPort: "443",
+			TotalBytes:      bytes,
+		}}))
+	}
+	write("tenant-a", "web", "8.8.8.8", 6000)
+	write("tenant-a", "db", "9.9.9.9", 1200)
+	write("tenant-b", "api", "8.8.8.8", 3000)
+	write("", "", "8.8.8.8", 100000)
+
+	entries, err := tracker.Top(DimensionNamespace, time.Minute, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Key: "tenant-a", Bytes: 7200, Rate: 120},
+		{Key: "tenant-b", Bytes: 3000, Rate: 50},
+	}, entries)
+
+	entries, err = tracker.Top(DimensionRemote, time.Minute, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Key: "8.8.8.8", Bytes: 9000, Rate: 150}}, entries)
+
+	entries, err = tracker.Top(DimensionOwner, 5*time.Minute, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "tenant-a/web", entries[0].Key)
+	assert.Equal(t, 20.0, entries[0].Rate)
+
+	// Outbound flows rank by the port they are sent to, not the client port
+	entries, err = tracker.Top(DimensionPort, time.Minute, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "TCP/443", entries[0].Key)
+
+	// Traffic rolls out of the shorter window first
+	now = now.Add(2 * time.Minute)
+	write("tenant-b", "api", "8.8.8.8", 60)
+	entries, err = tracker.Top(DimensionNamespace, time.Minute, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{{Key: "tenant-b", Bytes: 60, Rate: 1}}, entries)
+	entries, err = tracker.Top(DimensionNamespace, 5*time.Minute, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7200), entries[0].Bytes)
+
+	_, err = tracker.Top("pod", time.Minute, 0)
+	assert.Error(t, err)
+	_, err = tracker.Top(DimensionOwner, time.Hour, 0)
+	assert.Error(t, err)
+
+	talkers := tracker.TopTalkers()
+	assert.NotEmpty(t, talkers)
+	assert.Equal(t, 1, talkers[0].Rank)
+	assert.Equal(t, "1m0s", talkers[0].Window)
+}
+
+func TestHandler(t *testing.T) {
+	tracker := New(Options{})
+	require.NoError(t, tracker.Write(context.Background(), []types.AggregatedInfo{
+		{Namespace: "tenant-a", Name: "web", Direction: "inbound", Source: "8.8.8.8", TotalBytes: 600},
+	}))
+
+	server := httptest.NewServer(tracker.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "?dimension=remote&window=5m&limit=5")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var ranking Ranking
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&ranking))
+	assert.Equal(t, "remote", ranking.Dimension)
+	assert.Equal(t, "5m0s", ranking.Window)
+	assert.Equal(t, []Entry{{Key: "8.8.8.8", Bytes: 600, Rate: 2}}, ranking.Entries)
+
+	for _, query := range []string{"?dimension=pod", "?window=abc", "?window=2h", "?limit=-1"} {
+		resp, err := http.Get(server.URL + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	outbound := types.AggregatedInfo{Namespace: "default", Name: "web", Direction: "outbound", Source: "10.0.0.1", Destination: "8.8.8.8", Protocol: "TCP", Port: "51234", DestinationPort: "443"}
+	assert.Equal(t, []string{"default", "default/web", "8.8.8.8", "TCP/443"}, Keys(outbound))
+
+	// The replies come from the service port
+	inbound := types.AggregatedInfo{Namespace: "default", Name: "web", Direction: "inbound", Source: "8.8.8.8", Destination: "10.0.0.1", Protocol: "TCP", Port: "443", DestinationPort: "51234"}
+	assert.Equal(t, []string{"default", "default/web", "8.8.8.8", "TCP/443"}, Keys(inbound))
+}
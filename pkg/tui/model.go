@@ -0,0 +1,298 @@
+// Package tui implements the live traffic table of netlog top
+package tui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/top"
+)
+
+const (
+	// ViewFlows lists individual flows
+	ViewFlows = "flows"
+
+	// SortBytes orders rows by bytes
+	SortBytes = "bytes"
+	// SortPackets orders rows by packets
+	SortPackets = "packets"
+	// SortRate orders rows by bytes per second
+	SortRate = "rate"
+)
+
+// Views are the tables that can be shown, flows and the top talkers dimensions
+var Views = append([]string{ViewFlows}, top.Dimensions...)
+
+// Sorts are the orders rows can be sorted by
+var Sorts = []string{SortBytes, SortPackets, SortRate}
+
+// Row is a line of a table
+type Row struct {
+	Key     string
+	Bytes   int64
+	Packets int64
+	// Rate is the average bytes per second within the window
+	Rate float64
+}
+
+// Snapshot is the state of the traffic at one point in time
+type Snapshot struct {
+	Time time.Time
+	// Window is the time range the rows cover
+	Window time.Duration
+	// Rows holds the rows of each view
+	Rows map[string][]Row
+	// Unavailable explains why a view has no rows, by view
+	Unavailable map[string]string
+	// NoPackets is set when the source does not report packets
+	NoPackets bool
+}
+
+// Source provides snapshots of the traffic
+type Source interface {
+	// Name describes the source in the header
+	Name() string
+	// Snapshot returns the current state of the traffic
+	Snapshot(ctx context.Context) (Snapshot, error)
+}
+
+// Model is the state of the user interface
+type Model struct {
+	View   string
+	Sort   string
+	Filter string
+	Paused bool
+
+	source   string
+	snapshot Snapshot
+	err      error
+	// editing is set while the filter is typed
+	editing bool
+	input   string
+}
+
+// NewModel creates a model showing view sorted by sortBy
+func NewModel(source, view, sortBy, filter string) (*Model, error) {
+	if !contains(Views, view) {
+		return nil, fmt.Errorf("unknown view %q, must be one of %s", view, strings.Join(Views, ", "))
+	}
+	if !contains(Sorts, sortBy) {
+		return nil, fmt.Errorf("unknown sort %q, must be one of %s", sortBy, strings.Join(Sorts, ", "))
+	}
+	return &Model{View: view, Sort: sortBy, Filter: filter, source: source}, nil
+}
+
+// Update replaces the snapshot unless the model is paused
+func (m *Model) Update(snapshot Snapshot, err error) {
+	if m.Paused {
+		return
+	}
+	m.err = err
+	if err == nil {
+		m.snapshot = snapshot
+	}
+}
+
+// HandleKey applies a key press and reports whether to quit
+func (m *Model) HandleKey(key string) bool {
+	if m.editing {
+		switch key {
+		case "enter":
+			m.Filter, m.editing = m.input, false
+		case "esc":
+			m.editing = false
+		case "backspace":
+			if len(m.input) > 0 {
+				m.input = m.input[:len(m.input)-1]
+			}
+		case "ctrl+c":
+			return true
+		default:
+			if len(key) == 1 {
+				m.input += key
+			}
+		}
+		return false
+	}
+
+	switch key {
+	case "q", "ctrl+c":
+		return true
+	case " ", "p":
+		m.Paused = !m.Paused
+	case "tab", "right":
+		m.View = next(Views, m.View, 1)
+	case "left":
+		m.View = next(Views, m.View, -1)
+	case "s":
+		m.Sort = next(Sorts, m.Sort, 1)
+	case "b":
+		m.Sort = SortBytes
+	case "k":
+		m.Sort = SortPackets
+	case "r":
+		m.Sort = SortRate
+	case "/":
+		m.editing, m.input = true, m.Filter
+	case "esc":
+		m.Filter = ""
+	default:
+		if len(key) == 1 && key[0] >= '1' && key[0] <= '9' {
+			if i := int(key[0] - '1'); i < len(Views) {
+				m.View = Views[i]
+			}
+		}
+	}
+	return false
+}
+
+// Rows returns the filtered and sorted rows of the current view
+func (m *Model) Rows() []Row {
+	filter := strings.ToLower(m.Filter)
+	var rows []Row
+	for _, row := range m.snapshot.Rows[m.View] {
+		if filter == "" || strings.Contains(strings.ToLower(row.Key), filter) {
+			rows = append(rows, row)
+		}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		switch m.Sort {
+		case SortPackets:
+			if a.Packets != b.Packets {
+				return a.Packets > b.Packets
+			}
+		case SortRate:
+			if a.Rate != b.Rate {
+				return a.Rate > b.Rate
+			}
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Key < b.Key
+	})
+	return rows
+}
+
+// Render draws the screen, lines are terminated with \r\n for raw terminals
+func (m *Model) Render(w io.Writer, width, height int) {
+	var lines []string
+
+	status := fmt.Sprintf("netlog top - %s - window %s - sort %s", m.source, m.snapshot.Window, m.Sort)
+	if !m.snapshot.Time.IsZero() {
+		status += " - " + m.snapshot.Time.Format("15:04:05")
+	}
+	if m.Paused {
+		status += " - PAUSED"
+	}
+	lines = append(lines, status)
+
+	var tabs []string
+	for i, view := range Views {
+		label := fmt.Sprintf("%d:%s", i+1, view)
+		if view == m.View {
+			label = "\x1b[7m" + label + "\x1b[0m"
+		}
+		tabs = append(tabs, label)
+	}
+	lines = append(lines, strings.Join(tabs, " "))
+
+	switch {
+	case m.editing:
+		lines = append(lines, "filter: "+m.input+"_")
+	case m.Filter != "":
+		lines = append(lines, "filter: "+m.Filter)
+	default:
+		lines = append(lines, "")
+	}
+
+	keyWidth := width - 40
+	if keyWidth < 20 {
+		keyWidth = 20
+	}
+	lines = append(lines, "\x1b[1m"+fmt.Sprintf("%-*s %12s %10s %12s", keyWidth, strings.ToUpper(m.View), "BYTES", "PACKETS", "RATE")+"\x1b[0m")
+
+	rows := m.Rows()
+	footer := "q quit  space pause  tab/1-5 view  s/b/k/r sort  / filter  esc clear"
+	body := height - len(lines) - 2
+	switch {
+	case m.err != nil:
+		lines = append(lines, "error: "+m.err.Error())
+	case m.snapshot.Unavailable[m.View] != "":
+		lines = append(lines, m.snapshot.Unavailable[m.View])
+	case len(rows) == 0:
+		lines = append(lines, "no traffic")
+	}
+	for i, row := range rows {
+		if i >= body {
+			break
+		}
+		packets := "-"
+		if !m.snapshot.NoPackets {
+			packets = fmt.Sprint(row.Packets)
+		}
+		lines = append(lines, fmt.Sprintf("%-*s %12s %10s %12s", keyWidth, truncate(row.Key, keyWidth), FormatBytes(float64(row.Bytes)), packets, FormatBytes(row.Rate)+"/s"))
+	}
+
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	lines = append(lines, footer)
+
+	// Home, then every line cleared to its end
+	io.WriteString(w, "\x1b[H")
+	for i, line := range lines {
+		io.WriteString(w, line+"\x1b[K")
+		if i < len(lines)-1 {
+			io.WriteString(w, "\r\n")
+		}
+	}
+}
+
+// FormatBytes formats n with a binary unit
+func FormatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	if n <= 1 {
+		return s[:n]
+	}
+	return s[:n-1] + "~"
+}
+
+func next(values []string, current string, step int) string {
+	for i, v := range values {
+		if v == current {
+			return values[(i+step+len(values))%len(values)]
+		}
+	}
+	return values[0]
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package tui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/top"
+	"github.com/highscaleco/netlog/pkg/types"
+)
+
+// DefaultWindow is the default time range of the local source
+const DefaultWindow = time.Minute
+
+// LocalSource aggregates the flows of a local capture over a rolling window
+type LocalSource struct {
+	name   string
+	window time.Duration
+	now    func() time.Time
+	start  time.Time
+
+	mu    sync.Mutex
+	flows []localFlow
+}
+
+// localFlow is a flow with the time it was added
+type localFlow struct {
+	added time.Time
+	flow  types.AggregatedInfo
+}
+
+// NewLocalSource creates a source keeping the flows of the last window
+func NewLocalSource(name string, window time.Duration) *LocalSource {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &LocalSource{name: name, window: window, now: time.Now, start: time.Now()}
+}
+
+// Name returns the name of the source
+func (s *LocalSource) Name() string {
+	return s.name
+}
+
+// Add records a flow, flows without an owner are ignored
+func (s *LocalSource) Add(flow types.AggregatedInfo) {
+	if !flow.Owned() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flows = append(s.flows, localFlow{added: s.now(), flow: flow})
+}
+
+// Consume adds the flows of ch until it is closed or ctx is cancelled
+func (s *LocalSource) Consume(ctx context.Context, ch <-chan types.AggregatedInfo) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case flow, ok := <-ch:
+			if !ok {
+				return
+			}
+			s.Add(flow)
+		}
+	}
+}
+
+// Snapshot aggregates the flows within the window
+func (s *LocalSource) Snapshot(ctx context.Context) (Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	cutoff := now.Add(-s.window)
+	i := 0
+	for i < len(s.flows) && !s.flows[i].added.After(cutoff) {
+		i++
+	}
+	s.flows = s.flows[i:]
+
+	// Rates are averaged over the time captured so far until the window is full
+	seconds := s.window.Seconds()
+	if elapsed := now.Sub(s.start).Seconds(); elapsed < seconds {
+		seconds = elapsed
+	}
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	groups := make(map[string]map[string]*Row, len(Views))
+	for _, view := range Views {
+		groups[view] = make(map[string]*Row)
+	}
+	add := func(view, key string, flow types.AggregatedInfo) {
+		row, ok := groups[view][key]
+		if !ok {
+			row = &Row{Key: key}
+			groups[view][key] = row
+		}
+		row.Bytes += flow.TotalBytes
+		row.Packets += flow.Packets
+	}
+	for _, f := range s.flows {
+		add(ViewFlows, flowKey(f.flow), f.flow)
+		for i, key := range top.Keys(f.flow) {
+			add(top.Dimensions[i], key, f.flow)
+		}
+	}
+
+	snapshot := Snapshot{Time: now, Window: s.window, Rows: make(map[string][]Row, len(Views))}
+	for view, rows := range groups {
+		for _, row := range rows {
+			row.Rate = float64(row.Bytes) / seconds
+			snapshot.Rows[view] = append(snapshot.Rows[view], *row)
+		}
+	}
+	return snapshot, nil
+}
+
+// flowKey describes a flow in the flows view
+func flowKey(flow types.AggregatedInfo) string {
+	arrow := "->"
+	if flow.Direction == "inbound" {
+		arrow = "<-"
+	}
+	local, remote := flow.Source, flow.Destination
+	if flow.Direction == "inbound" {
+		local, remote = flow.Destination, flow.Source
+	}
+	return fmt.Sprintf("%s/%s %s %s %s %s", flow.Namespace, flow.Name, local, arrow, remote, top.ServicePort(flow))
+}
+
+// RemoteSource polls the top talkers API of a running netlog instance
+type RemoteSource struct {
+	baseURL string
+	window  time.Duration
+	limit   int
+	client  *http.Client
+}
+
+// NewRemoteSource creates a source for the netlog instance at baseURL. A
+// zero window uses the shortest window of the instance.
+func NewRemoteSource(baseURL string, window time.Duration, limit int) *RemoteSource {
+	if limit <= 0 {
+		limit = 100
+	}
+	return &RemoteSource{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		window:  window,
+		limit:   limit,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Name returns the name of the source
+func (s *RemoteSource) Name() string {
+	return s.baseURL
+}
+
+// Snapshot fetches the rankings of every dimension
+func (s *RemoteSource) Snapshot(ctx context.Context) (Snapshot, error) {
+	snapshot := Snapshot{
+		Time:      time.Now(),
+		Window:    s.window,
+		Rows:      make(map[string][]Row, len(Views)),
+		NoPackets: true,
+		Unavailable: map[string]string{
+			ViewFlows: "individual flows are only shown for a local capture",
+		},
+	}
+
+	for _, dimension := range top.Dimensions {
+		ranking, err := s.fetch(ctx, dimension)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if d, err := time.ParseDuration(ranking.Window); err == nil {
+			snapshot.Window = d
+		}
+		for _, entry := range ranking.Entries {
+			snapshot.Rows[dimension] = append(snapshot.Rows[dimension], Row{Key: entry.Key, Bytes: entry.Bytes, Rate: entry.Rate})
+		}
+	}
+	return snapshot, nil
+}
+
+func (s *RemoteSource) fetch(ctx context.Context, dimension string) (top.Ranking, error) {
+	params := url.Values{}
+	params.Set("dimension", dimension)
+	params.Set("limit", fmt.Sprint(s.limit))
+	if s.window > 0 {
+		params.Set("window", s.window.String())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/api/v1/top?"+params.Encode(), nil)
+	if err != nil {
+		return top.Ranking{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return top.Ranking{}, fmt.Errorf("failed to fetch rankings: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return top.Ranking{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var ranking top.Ranking
+	if err := json.NewDecoder(resp.Body).Decode(&ranking); err != nil {
+		return top.Ranking{}, fmt.Errorf("failed to decode rankings: %w", err)
+	}
+	return ranking, nil
+}
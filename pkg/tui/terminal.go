@@ -0,0 +1,124 @@
+package tui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// DefaultRefresh is the default interval between screen updates
+const DefaultRefresh = 2 * time.Second
+
+// Run shows model on the terminal of in and out and refreshes it from
+// source every refresh until q is pressed or ctx is cancelled
+func Run(ctx context.Context, model *Model, source Source, in, out *os.File, refresh time.Duration) error {
+	if refresh <= 0 {
+		refresh = DefaultRefresh
+	}
+	fd := int(in.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("stdin is not a terminal")
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("failed to switch terminal to raw mode: %w", err)
+	}
+	defer term.Restore(fd, state)
+
+	// Alternate screen without cursor, restored on exit
+	io.WriteString(out, "\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer io.WriteString(out, "\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string, 16)
+	go readKeys(in, keys)
+
+	update := func() {
+		fetchCtx, cancel := context.WithTimeout(ctx, refresh)
+		defer cancel()
+		model.Update(source.Snapshot(fetchCtx))
+	}
+	draw := func() {
+		width, height, err := term.GetSize(int(out.Fd()))
+		if err != nil {
+			width, height = 80, 24
+		}
+		var buf bytes.Buffer
+		model.Render(&buf, width, height)
+		out.Write(buf.Bytes())
+	}
+
+	update()
+	draw()
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			update()
+			draw()
+		case key, ok := <-keys:
+			if !ok || model.HandleKey(key) {
+				return nil
+			}
+			draw()
+		}
+	}
+}
+
+// readKeys sends the keys pressed on in until it fails
+func readKeys(in io.Reader, keys chan<- string) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, key := range parseKeys(buf[:n]) {
+			keys <- key
+		}
+	}
+}
+
+// parseKeys translates the bytes of a raw terminal read into key names
+func parseKeys(data []byte) []string {
+	var keys []string
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case 3:
+			keys = append(keys, "ctrl+c")
+		case '\t':
+			keys = append(keys, "tab")
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case 8, 127:
+			keys = append(keys, "backspace")
+		case 27:
+			// Escape sequences of arrow keys, a lone escape is the esc key
+			if i+2 < len(data) && data[i+1] == '[' {
+				switch data[i+2] {
+				case 'C':
+					keys = append(keys, "right")
+				case 'D', 'Z':
+					keys = append(keys, "left")
+				}
+				i += 2
+				continue
+			}
+			keys = append(keys, "esc")
+		default:
+			if b >= 32 && b < 127 {
+				keys = append(keys, string(b))
+			}
+		}
+	}
+	return keys
+}
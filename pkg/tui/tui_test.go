@@ -0,0 +1,154 @@
+package tui
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/top"
+	"github.com/highscaleco/netlog/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testSnapshot() Snapshot {
+	return Snapshot{
+		Window: time.Minute,
+		Rows: map[string][]Row{
+			top.DimensionOwner: {
+				{Key: "tenant-a/web", Bytes: 100, Packets: 50, Rate: 1},
+				{Key: "tenant-b/api", Bytes: 300, Packets: 10, Rate: 5},
+				{Key: "tenant-a/db", Bytes: 200, Packets: 90, Rate: 3},
+			},
+		},
+	}
+}
+
+func keys(rows []Row) []string {
+	var result []string
+	for _, row := range rows {
+		result = append(result, row.Key)
+	}
+	return result
+}
+
+func TestModel(t *testing.T) {
+	_, err := NewModel("test", "pods", SortBytes, "")
+	assert.Error(t, err)
+	_, err = NewModel("test", ViewFlows, "name", "")
+	assert.Error(t, err)
+
+	m, err := NewModel("test", top.DimensionOwner, SortBytes, "")
+	require.NoError(t, err)
+	m.Update(testSnapshot(), nil)
+	assert.Equal(t, []string{"tenant-b/api", "tenant-a/db", "tenant-a/web"}, keys(m.Rows()))
+
+	m.HandleKey("k")
+	assert.Equal(t, []string{"tenant-a/db", "tenant-a/web", "tenant-b/api"}, keys(m.Rows()))
+	m.HandleKey("s")
+	assert.Equal(t, SortRate, m.Sort)
+
+	// Typing a filter only applies it on enter
+	for _, key := range []string{"/", "T", "e", "n", "x", "backspace", "a", "n", "t", "-", "a"} {
+		m.HandleKey(key)
+	}
+	assert.Len(t, m.Rows(), 3)
+	m.HandleKey("enter")
+	assert.Equal(t, []string{"tenant-a/db", "tenant-a/web"}, keys(m.Rows()))
+	m.HandleKey("esc")
+	assert.Len(t, m.Rows(), 3)
+
+	// Paused models keep their snapshot
+	m.HandleKey(" ")
+	m.Update(Snapshot{}, nil)
+	assert.Len(t, m.Rows(), 3)
+	m.HandleKey(" ")
+	m.Update(Snapshot{}, nil)
+	assert.Empty(t, m.Rows())
+
+	m.HandleKey("tab")
+	assert.Equal(t, top.DimensionRemote, m.View)
+	m.HandleKey("1")
+	assert.Equal(t, ViewFlows, m.View)
+	m.HandleKey("left")
+	assert.Equal(t, top.DimensionPort, m.View)
+
+	assert.True(t, m.HandleKey("q"))
+}
+
+func TestRender(t *testing.T) {
+	m, err := NewModel("capture on eth0", top.DimensionOwner, SortBytes, "")
+	require.NoError(t, err)
+	m.Update(testSnapshot(), nil)
+	m.HandleKey("p")
+
+	var buf bytes.Buffer
+	m.Render(&buf, 100, 10)
+	screen := buf.String()
+	assert.Contains(t, screen, "capture on eth0")
+	assert.Contains(t, screen, "PAUSED")
+	assert.Contains(t, screen, "tenant-b/api")
+	assert.Contains(t, screen, "300 B")
+	assert.Equal(t, 9, strings.Count(screen, "\r\n"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 B", FormatBytes(512))
+	assert.Equal(t, "1.5 KiB", FormatBytes(1536))
+	assert.Equal(t, "2.0 GiB", FormatBytes(2<<30))
+}
+
+func TestParseKeys(t *testing.T) {
+	assert.Equal(t, []string{"q"}, parseKeys([]byte("q")))
+	assert.Equal(t, []string{"right", "left", "esc"}, parseKeys([]byte("\x1b[C\x1b[D\x1b")))
+	assert.Equal(t, []string{"ctrl+c", "tab", "enter", "backspace"}, parseKeys([]byte{3, 9, 13, 127}))
+}
+
+func TestLocalSource(t *testing.T) {
+	s := NewLocalSource("test", time.Minute)
+	now := time.Date(2024, 2, 14, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	s.start = now.Add(-time.Hour)
+
+	s.Add(types.AggregatedInfo{Namespace: "tenant-a", Name: "web", Direction: "outbound", Source: "1.1.1.1", Destination: "8.8.8.8", Protocol: "TCP", Port: "443", TotalBytes: 600, Packets: 6})
+	s.Add(types.AggregatedInfo{Namespace: "tenant-a", Name: "web", Direction: "inbound", Source: "9.9.9.9", Destination: "1.1.1.1", Protocol: "TCP", Port: "80", TotalBytes: 1200, Packets: 2})
+	s.Add(types.AggregatedInfo{Source: "1.1.1.1", Destination: "8.8.8.8", TotalBytes: 1000})
+
+	snapshot, err := s.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Row{{Key: "tenant-a", Bytes: 1800, Packets: 8, Rate: 30}}, snapshot.Rows[top.DimensionNamespace])
+	assert.Len(t, snapshot.Rows[top.DimensionRemote], 2)
+	assert.ElementsMatch(t, []string{
+		"tenant-a/web 1.1.1.1 -> 8.8.8.8 TCP/443",
+		"tenant-a/web 1.1.1.1 <- 9.9.9.9 TCP/80",
+	}, keys(snapshot.Rows[ViewFlows]))
+
+	// Flows leave the window
+	now = now.Add(2 * time.Minute)
+	snapshot, err = s.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, snapshot.Rows[top.DimensionNamespace])
+}
+
+func TestRemoteSource(t *testing.T) {
+	tracker := top.New(top.Options{})
+	require.NoError(t, tracker.Write(context.Background(), []types.AggregatedInfo{
+		{Namespace: "tenant-a", Name: "web", Direction: "outbound", Destination: "8.8.8.8", Protocol: "TCP", Port: "443", TotalBytes: 600},
+	}))
+	server := httptest.NewServer(tracker.Handler())
+	defer server.Close()
+
+	s := NewRemoteSource(server.URL+"/", 5*time.Minute, 10)
+	snapshot, err := s.Snapshot(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, snapshot.Window)
+	assert.True(t, snapshot.NoPackets)
+	assert.NotEmpty(t, snapshot.Unavailable[ViewFlows])
+	assert.Equal(t, []Row{{Key: "tenant-a/web", Bytes: 600, Rate: 2}}, snapshot.Rows[top.DimensionOwner])
+
+	_, err = NewRemoteSource(server.URL, time.Hour, 10).Snapshot(context.Background())
+	assert.Error(t, err)
+}
@@ -0,0 +1,149 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/k8s"
+	"github.com/highscaleco/netlog/pkg/metrics"
+)
+
+// Chain tries its resolvers in order and returns the first owner found
+type Chain []Resolver
+
+// Resolve returns the owner of ipv4
+func (c Chain) Resolve(ipv4 string) (*OFIP, error) {
+	return c.ResolvePort(ipv4, "")
+}
+
+// ResolvePort returns the owner of ipv4, passing port to the resolvers
+// that take it
+func (c Chain) ResolvePort(ipv4, port string) (*OFIP, error) {
+	var errs []error
+	for _, r := range c {
+		var owner *OFIP
+		var err error
+		if pr, ok := r.(PortResolver); ok && port != "" {
+			owner, err = pr.ResolvePort(ipv4, port)
+		} else {
+			owner, err = r.Resolve(ipv4)
+		}
+		if err == nil && owner != nil {
+			return owner, nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no owner found for ipv4: %s", ipv4)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Trace resolves ipv4 like Resolve and returns the steps of every resolver
+// tried. Resolvers that are not tracers report a single step.
+func (c Chain) Trace(ipv4 string) (*OFIP, []Step, error) {
+	var steps []Step
+	var errs []error
+	for _, r := range c {
+		var owner *OFIP
+		var err error
+		if tracer, ok := r.(Tracer); ok {
+			var trace []Step
+			owner, trace, err = tracer.Trace(ipv4)
+			steps = append(steps, trace...)
+		} else {
+			start := time.Now()
+			owner, err = r.Resolve(ipv4)
+			step := Step{Resolver: fmt.Sprintf("%T", r), Result: StepHit, Owner: owner, Duration: time.Since(start)}
+			if err != nil || owner == nil {
+				step.Result = StepMiss
+				if err != nil {
+					step.Detail = err.Error()
+				}
+			}
+			steps = append(steps, step)
+		}
+		if err == nil && owner != nil {
+			return owner, steps, nil
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil, steps, fmt.Errorf("no owner found for ipv4: %s", ipv4)
+	}
+	return nil, steps, errors.Join(errs...)
+}
+
+// ownerIndex finds the Kubernetes objects of IP addresses, see k8s.Index
+type ownerIndex interface {
+	LookupPort(ip, port string) []k8s.Owner
+}
+
+// ClusterResolver resolves the Pods, Services and Nodes known to the
+// informers of an index
+type ClusterResolver struct {
+	index    ownerIndex
+	recorder *metrics.Recorder
+}
+
+// NewClusterResolver creates a resolver looking up index and recording the
+// lookups with rec, which may be nil
+func NewClusterResolver(index *k8s.Index, rec *metrics.Recorder) *ClusterResolver {
+	return &ClusterResolver{index: index, recorder: rec}
+}
+
+// Resolve returns the owner of ipv4
+func (r *ClusterResolver) Resolve(ipv4 string) (*OFIP, error) {
+	owner, _, err := r.trace(ipv4, "")
+	return owner, err
+}
+
+// ResolvePort returns the owner of ipv4 for a flow at port, only the DNAT
+// rules of that port are considered
+func (r *ClusterResolver) ResolvePort(ipv4, port string) (*OFIP, error) {
+	owner, _, err := r.trace(ipv4, port)
+	return owner, err
+}
+
+// Trace resolves ipv4 like Resolve. The detail of the step lists the
+// objects that lost on precedence.
+func (r *ClusterResolver) Trace(ipv4 string) (*OFIP, []Step, error) {
+	return r.trace(ipv4, "")
+}
+
+// trace resolves ipv4 for a flow at port, any port when it is empty
+func (r *ClusterResolver) trace(ipv4, port string) (*OFIP, []Step, error) {
+	start := time.Now()
+	owners := r.index.LookupPort(ipv4, port)
+	r.recorder.ObserveLookup("informers", len(owners) > 0)
+	step := Step{Resolver: "informers", Result: StepMiss, Duration: time.Since(start)}
+	if len(owners) == 0 {
+		return nil, []Step{step}, fmt.Errorf("no kubernetes object found for ipv4: %s", ipv4)
+	}
+
+	owner := &OFIP{
+		Kind:            owners[0].Kind,
+		Namespace:       owners[0].Namespace,
+		Name:            owners[0].Name,
+		WorkloadKind:    owners[0].WorkloadKind,
+		Workload:        owners[0].Workload,
+		Labels:          owners[0].Labels,
+		NamespaceLabels: owners[0].NamespaceLabels,
+	}
+	step.Result = StepHit
+	step.Owner = owner
+	if len(owners) > 1 {
+		others := make([]string, 0, len(owners)-1)
+		for _, o := range owners[1:] {
+			others = append(others, OFIP{Kind: o.Kind, Namespace: o.Namespace, Name: o.Name}.String())
+		}
+		step.Detail = "also " + strings.Join(others, ", ")
+	}
+	return owner, []Step{step}, nil
+}
@@ -0,0 +1,71 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/highscaleco/netlog/pkg/k8s"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeIndex map[string][]k8s.Owner
+
+func (i fakeIndex) LookupPort(ip, port string) []k8s.Owner {
+	if owners, ok := i[ip+":"+port]; ok && port != "" {
+		return owners
+	}
+	return i[ip]
+}
+
+func TestChainTrace(t *testing.T) {
+	cluster := &ClusterResolver{index: fakeIndex{
+		"10.244.0.5": {{Kind: k8s.KindPod, Namespace: "default", Name: "nginx", WorkloadKind: "Deployment", Workload: "web", Labels: map[string]string{"team": "a"}}},
+		"192.168.1.10": {
+			{Kind: k8s.KindService, Namespace: "default", Name: "web"},
+			{Kind: k8s.KindNode, Name: "worker-1"},
+		},
+		"203.0.113.30": {
+			{Kind: k8s.KindOvnDnatRule, Namespace: "crm", Name: "ssh"},
+			{Kind: k8s.KindOvnEip, Namespace: "shop", Name: "gw"},
+		},
+		"203.0.113.30:443": {{Kind: k8s.KindOvnEip, Namespace: "shop", Name: "gw"}},
+	}}
+	kube := &KubernetesResolver{kube: fakeKube{"203.0.113.10": "default-nginx"}}
+	chain := Chain{cluster, kube}
+
+	owner, steps, err := chain.Trace("10.244.0.5")
+	require.NoError(t, err)
+	assert.Equal(t, "Pod default/nginx", owner.String())
+	assert.Equal(t, "Deployment", owner.WorkloadKind)
+	assert.Equal(t, "web", owner.Workload)
+	assert.Equal(t, map[string]string{"team": "a"}, owner.Labels)
+	require.Len(t, steps, 1)
+	assert.Equal(t, "informers", steps[0].Resolver)
+
+	owner, steps, err = chain.Trace("192.168.1.10")
+	require.NoError(t, err)
+	assert.Equal(t, &OFIP{Kind: k8s.KindService, Namespace: "default", Name: "web"}, owner)
+	assert.Equal(t, "also Node worker-1", steps[0].Detail)
+
+	owner, steps, err = chain.Trace("203.0.113.10")
+	require.NoError(t, err)
+	assert.Equal(t, "default/nginx", owner.String())
+	require.Len(t, steps, 2)
+	assert.Equal(t, []string{StepMiss, StepHit}, []string{steps[0].Result, steps[1].Result})
+
+	owner, err = chain.Resolve("8.8.8.8")
+	assert.Error(t, err)
+	assert.Nil(t, owner)
+
+	owner, err = chain.Resolve("192.168.1.10")
+	require.NoError(t, err)
+	assert.Equal(t, "Service default/web", owner.String())
+
+	// The port of the flow picks the DNAT rule
+	owner, err = chain.ResolvePort("203.0.113.30", "443")
+	require.NoError(t, err)
+	assert.Equal(t, "OvnEip shop/gw", owner.String())
+	owner, err = chain.ResolvePort("203.0.113.30", "")
+	require.NoError(t, err)
+	assert.Equal(t, "OvnDnatRule crm/ssh", owner.String())
+}
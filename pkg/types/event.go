@@ -0,0 +1,72 @@
+package types
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Packet holds the decoded headers of a captured TCP or UDP packet together
+// with the owner resolved for its flow
+type Packet struct {
+	Timestamp       time.Time
+	Source          net.IP
+	Destination     net.IP
+	SourcePort      uint16
+	DestinationPort uint16
+	Protocol        string
+	Length          int
+	SYN             bool
+	ACK             bool
+	FIN             bool
+	RST             bool
+	Namespace       string
+	Name            string
+	Direction       string
+}
+
+// Event is a security event raised by a detector
+type Event struct {
+	Time        time.Time         `json:"time"`
+	Type        string            `json:"type"`
+	Severity    string            `json:"severity"`
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Source      string            `json:"source"`
+	Destination string            `json:"destination,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	Message     string            `json:"message"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// String returns a human-readable string representation of the event
+func (e Event) String() string {
+	keys := make([]string, 0, len(e.Attributes))
+	for k := range e.Attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, k+"="+e.Attributes[k])
+	}
+
+	return strings.TrimSpace(fmt.Sprintf("%s EVENT %s %s %s %s %s => %s %s: %s %s",
+		e.Time, e.Severity, e.Type, e.Namespace, e.Name, e.Source, e.Destination, e.Protocol, e.Message, strings.Join(attrs, " ")))
+}
+
+// JSONString returns a JSON string representation of the event
+func (e Event) JSONString() string {
+	data := struct {
+		Kind string `json:"kind"`
+		Event
+	}{
+		Kind:  "event",
+		Event: e,
+	}
+	jsonData, _ := json.Marshal(data)
+	return string(jsonData)
+}
@@ -3,11 +3,8 @@ package types
 import (
 	"encoding/json"
 	"fmt"
-	"regexp"
+	"strings"
 	"time"
-
-	"github.com/highscaleco/netlog/pkg/k8s"
-	"github.com/highscaleco/netlog/pkg/redis"
 )
 
 // PacketInfo represents information about a captured network packet
@@ -48,112 +45,119 @@ func (p PacketInfo) JSONString() string {
 
 // AggregatedInfo represents aggregated packet information
 type AggregatedInfo struct {
-	Namespace   string
-	Name        string
-	StartTime   time.Time
-	EndTime     time.Time
-	Source      string
-	Destination string
-	Protocol    string
-	Port        string
-	Direction   string
-	TotalBytes  int64
-	Packets     int64
-	LastSeen    time.Time
+	Namespace string
+	Name      string
+	// Kind is the kind of the owner such as Pod, Service, Node or a kube-ovn
+	// NAT kind, empty for OVN floating IPs cached in Redis
+	Kind string
+	// WorkloadKind and Workload name the controller owning a pod, such as
+	// its Deployment
+	WorkloadKind string
+	Workload     string
+	// Labels and NamespaceLabels are the allow-listed Kubernetes labels of
+	// the owner and its namespace
+	Labels          map[string]string
+	NamespaceLabels map[string]string
+	StartTime       time.Time
+	EndTime         time.Time
+	Source          string
+	Destination     string
+	Protocol        string
+	// Port is the source port of the flow, DestinationPort the destination
+	// port of its first packet
+	Port            string
+	DestinationPort string
+	Direction       string
+	TotalBytes      int64
+	Packets         int64
+	LastSeen        time.Time
+	// Tags are the names of the blocklists the remote endpoint is on
+	Tags []string
+	// OwnerTags are the tags of the owner from the owner file
+	OwnerTags []string
+}
+
+// Owned reports whether the flow was attributed to an owner. Cluster scoped
+// owners such as nodes have no namespace.
+func (a AggregatedInfo) Owned() bool {
+	return a.Namespace != "" || a.Name != ""
 }
 
 // String returns a human-readable string representation of the aggregated info
 func (a AggregatedInfo) String() string {
-	// Return empty string if no namespace is found
-	if a.Namespace == "" {
+	// Return empty string if no owner is found
+	if !a.Owned() {
 		return ""
 	}
 
+	namespace, name := a.Namespace, a.Name
+	if namespace == "" {
+		namespace = "-"
+	}
+	if a.Kind != "" {
+		name = a.Kind + "/" + name
+	}
 	duration := a.EndTime.Sub(a.StartTime).Seconds()
-	return fmt.Sprintf("%s %s %s %s => %s %s %s %s %d bytes (%d packets in %.2fs)",
-		a.StartTime, a.Namespace, a.Name, a.Direction, a.Source, a.Destination, a.Protocol, a.Port, a.TotalBytes, a.Packets, duration)
+	output := fmt.Sprintf("%s %s %s %s => %s %s %s %s %d bytes (%d packets in %.2fs)",
+		a.StartTime, namespace, name, a.Direction, a.Source, a.Destination, a.Protocol, a.Port, a.TotalBytes, a.Packets, duration)
+	if len(a.OwnerTags) > 0 {
+		output += " {" + strings.Join(a.OwnerTags, ",") + "}"
+	}
+	if len(a.Tags) > 0 {
+		output += " [" + strings.Join(a.Tags, ",") + "]"
+	}
+	return output
 }
 
 // JSONString returns a JSON-like string representation of the aggregated info
 func (a AggregatedInfo) JSONString() string {
-	// Return empty string if no namespace is found
-	if a.Namespace == "" {
+	// Return empty string if no owner is found
+	if !a.Owned() {
 		return ""
 	}
 
 	duration := a.EndTime.Sub(a.StartTime).Seconds()
 	data := struct {
-		Timestamp   string `json:"timestamp"`
-		Namespace   string `json:"namespace"`
-		Name        string `json:"name"`
-		Duration    string `json:"duration"`
-		Source      string `json:"source"`
-		Destination string `json:"destination"`
-		Protocol    string `json:"protocol"`
-		Port        string `json:"port"`
-		Direction   string `json:"direction"`
-		TotalBytes  int64  `json:"total_bytes"`
-		Packets     int64  `json:"packets"`
+		Timestamp       string            `json:"timestamp"`
+		Namespace       string            `json:"namespace"`
+		Name            string            `json:"name"`
+		Kind            string            `json:"kind,omitempty"`
+		WorkloadKind    string            `json:"workload_kind,omitempty"`
+		Workload        string            `json:"workload,omitempty"`
+		Labels          map[string]string `json:"labels,omitempty"`
+		NamespaceLabels map[string]string `json:"namespace_labels,omitempty"`
+		Duration        string            `json:"duration"`
+		Source          string            `json:"source"`
+		Destination     string            `json:"destination"`
+		Protocol        string            `json:"protocol"`
+		Port            string            `json:"port"`
+		DestinationPort string            `json:"destination_port,omitempty"`
+		Direction       string            `json:"direction"`
+		TotalBytes      int64             `json:"total_bytes"`
+		Packets         int64             `json:"packets"`
+		Tags            []string          `json:"tags,omitempty"`
+		OwnerTags       []string          `json:"owner_tags,omitempty"`
 	}{
-		Timestamp:   a.StartTime.Format("2006-01-02 15:04:05.999"),
-		Namespace:   a.Namespace,
-		Name:        a.Name,
-		Duration:    fmt.Sprintf("%.2fs", duration),
-		Source:      a.Source,
-		Destination: a.Destination,
-		Protocol:    a.Protocol,
-		Port:        a.Port,
-		Direction:   a.Direction,
-		TotalBytes:  a.TotalBytes,
-		Packets:     a.Packets,
+		Timestamp:       a.StartTime.Format("2006-01-02 15:04:05.999"),
+		Namespace:       a.Namespace,
+		Name:            a.Name,
+		Kind:            a.Kind,
+		WorkloadKind:    a.WorkloadKind,
+		Workload:        a.Workload,
+		Labels:          a.Labels,
+		NamespaceLabels: a.NamespaceLabels,
+		Duration:        fmt.Sprintf("%.2fs", duration),
+		Source:          a.Source,
+		Destination:     a.Destination,
+		Protocol:        a.Protocol,
+		Port:            a.Port,
+		DestinationPort: a.DestinationPort,
+		Direction:       a.Direction,
+		TotalBytes:      a.TotalBytes,
+		Packets:         a.Packets,
+		Tags:            a.Tags,
+		OwnerTags:       a.OwnerTags,
 	}
 	jsonData, _ := json.Marshal(data)
 	return string(jsonData)
 }
-
-type OFIP struct {
-	Namespace string
-	Name      string
-}
-
-func GetNamespaceAndNameByIPv4(ipv4 string) (*OFIP, error) {
-	if ipv4 == "" {
-		return nil, fmt.Errorf("ipv4 cannot be empty")
-	}
-
-	// Try to get from Redis first
-	info, err := redis.GetIP(ipv4)
-	if err == nil && info.Namespace != "" {
-		return &OFIP{
-			Namespace: info.Namespace,
-			Name:      info.Name,
-		}, nil
-	}
-
-	// If Redis fails or no data found, try K8s
-	ofip, err := k8s.GetOFIPByIPv4(ipv4)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get namespace and name by ipv4: %w", err)
-	}
-
-	// Parse the ofip string (format: namespace-name)
-	parts := regexp.MustCompile(`-`).Split(ofip, 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid ofip format: %s", ofip)
-	}
-
-	// Store in Redis for future use
-	redisInfo := redis.IPInfo{
-		Namespace: parts[0],
-		Name:      parts[1],
-	}
-	if err := redis.SetIP(ipv4, redisInfo); err != nil {
-		// Log the error but don't fail the operation
-		fmt.Printf("warning: failed to cache IP info in Redis: %v\n", err)
-	}
-
-	return &OFIP{
-		Namespace: parts[0],
-		Name:      parts[1],
-	}, nil
-}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -54,3 +55,30 @@ func TestAggregatedInfo(t *testing.T) {
 		t.Error("AggregatedInfo.JSONString() returned empty string")
 	}
 }
+
+func TestAggregatedInfoOwned(t *testing.T) {
+	agg := AggregatedInfo{Source: "192.168.1.10", Destination: "8.8.8.8", Protocol: "TCP", Port: "443", Direction: "outbound"}
+	if agg.Owned() || agg.String() != "" {
+		t.Error("AggregatedInfo without owner should not be owned")
+	}
+
+	// Nodes are cluster scoped
+	agg.Kind, agg.Name = "Node", "worker-1"
+	if !agg.Owned() {
+		t.Error("AggregatedInfo of a node should be owned")
+	}
+	if !strings.Contains(agg.String(), " - Node/worker-1 outbound") {
+		t.Errorf("AggregatedInfo.String() = %v, want the node as owner", agg.String())
+	}
+	if !strings.Contains(agg.JSONString(), `"namespace":"","name":"worker-1","kind":"Node"`) {
+		t.Errorf("AggregatedInfo.JSONString() = %v, want the node as owner", agg.JSONString())
+	}
+
+	agg.Namespace, agg.Kind, agg.Name = "shop", "Pod", "web-7f9f9f9f9f-x2x4z"
+	agg.WorkloadKind, agg.Workload = "Deployment", "web"
+	agg.Labels = map[string]string{"app.kubernetes.io/name": "web"}
+	want := `"kind":"Pod","workload_kind":"Deployment","workload":"web","labels":{"app.kubernetes.io/name":"web"},"duration"`
+	if !strings.Contains(agg.JSONString(), want) {
+		t.Errorf("AggregatedInfo.JSONString() = %v, want the workload and labels", agg.JSONString())
+	}
+}
@@ -0,0 +1,228 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/highscaleco/netlog/pkg/k8s"
+	"github.com/highscaleco/netlog/pkg/metrics"
+	"github.com/highscaleco/netlog/pkg/redis"
+)
+
+// OFIP is the owner of an IP address. Kind is empty for the OVN floating
+// IPs of KubernetesResolver and RedisResolver, Namespace for cluster scoped
+// objects such as nodes.
+type OFIP struct {
+	Kind      string `json:"kind,omitempty"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// WorkloadKind and Workload name the controller owning a pod, such as
+	// its Deployment
+	WorkloadKind string `json:"workload_kind,omitempty"`
+	Workload     string `json:"workload,omitempty"`
+	// Labels and NamespaceLabels are the allow-listed Kubernetes labels of
+	// the owner and its namespace
+	Labels          map[string]string `json:"labels,omitempty"`
+	NamespaceLabels map[string]string `json:"namespace_labels,omitempty"`
+	// Tags are the tags of an owner of the owner file
+	Tags []string `json:"tags,omitempty"`
+}
+
+// String returns the owner as kind namespace/name
+func (o OFIP) String() string {
+	s := o.Name
+	if o.Namespace != "" {
+		s = o.Namespace + "/" + s
+	}
+	if o.Kind != "" {
+		s = o.Kind + " " + s
+	}
+	return s
+}
+
+// ParseOFIPName returns the owner of an OVN floating IP named
+// namespace-name
+func ParseOFIPName(ofip string) (*OFIP, error) {
+	namespace, name, ok := k8s.SplitName(ofip)
+	if !ok {
+		return nil, fmt.Errorf("invalid ofip format: %s", ofip)
+	}
+	return &OFIP{Namespace: namespace, Name: name}, nil
+}
+
+// Resolver finds the owner of an IP address
+type Resolver interface {
+	Resolve(ipv4 string) (*OFIP, error)
+}
+
+// PortResolver is a resolver that takes the port of a flow at the address
+// into account, e.g. to tell apart the DNAT rules of an EIP
+type PortResolver interface {
+	Resolver
+	ResolvePort(ipv4, port string) (*OFIP, error)
+}
+
+// Tracer is a resolver that reports the steps taken to find an owner
+type Tracer interface {
+	Resolver
+	Trace(ipv4 string) (*OFIP, []Step, error)
+}
+
+const (
+	// StepHit is the result of a resolver that knows the owner
+	StepHit = "hit"
+	// StepMiss is the result of a resolver that doesn't know the owner
+	StepMiss = "miss"
+	// StepError is the result of a resolver that failed
+	StepError = "error"
+	// StepStored is the result of storing the owner in the cache
+	StepStored = "stored"
+)
+
+// Step is the result of one resolver of the chain
+type Step struct {
+	// Resolver is informers, static, redis or kubernetes, or cache when the
+	// owner is stored
+	Resolver string        `json:"resolver"`
+	Result   string        `json:"result"`
+	Owner    *OFIP         `json:"owner,omitempty"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// ipCache stores the owners of IP addresses, see redis.Client
+type ipCache interface {
+	GetIP(ip string) (redis.IPInfo, error)
+	SetIP(ip string, info redis.IPInfo) error
+}
+
+// ofipLookup finds OVN floating IPs, see k8s.Client
+type ofipLookup interface {
+	GetOFIPByIPv4(ipv4 string) (string, error)
+}
+
+// RedisResolver resolves the owners cached in Redis
+type RedisResolver struct {
+	cache    ipCache
+	recorder *metrics.Recorder
+}
+
+// NewRedisResolver creates a resolver looking up cache and recording the
+// lookups with rec, which may be nil
+func NewRedisResolver(cache *redis.Client, rec *metrics.Recorder) *RedisResolver {
+	return &RedisResolver{cache: cache, recorder: rec}
+}
+
+// Resolve returns the owner of ipv4
+func (r *RedisResolver) Resolve(ipv4 string) (*OFIP, error) {
+	owner, _, err := r.Trace(ipv4)
+	return owner, err
+}
+
+// Trace resolves ipv4 like Resolve and returns the step taken
+func (r *RedisResolver) Trace(ipv4 string) (*OFIP, []Step, error) {
+	if ipv4 == "" {
+		return nil, nil, fmt.Errorf("ipv4 cannot be empty")
+	}
+
+	start := time.Now()
+	info, err := r.cache.GetIP(ipv4)
+	hit := err == nil && info.Namespace != ""
+	r.recorder.ObserveLookup("redis", hit)
+	step := Step{Resolver: "redis", Result: StepMiss, Duration: time.Since(start)}
+	switch {
+	case hit:
+		step.Result = StepHit
+		step.Owner = &OFIP{Namespace: info.Namespace, Name: info.Name}
+		return step.Owner, []Step{step}, nil
+	case err != nil && !errors.Is(err, redis.ErrNotFound):
+		step.Result = StepError
+		step.Detail = err.Error()
+		return nil, []Step{step}, fmt.Errorf("failed to get cached owner: %w", err)
+	}
+	return nil, []Step{step}, fmt.Errorf("no owner cached for ipv4: %s", ipv4)
+}
+
+// KubernetesResolver resolves the OVN floating IPs in Kubernetes and stores
+// the owners found in the Redis cache
+type KubernetesResolver struct {
+	kube     ofipLookup
+	cache    ipCache
+	recorder *metrics.Recorder
+	// readOnly keeps the owners found out of the cache
+	readOnly bool
+}
+
+// NewKubernetesResolver creates a resolver looking up kube and recording
+// the lookups with rec, which may be nil. The owners found are stored in
+// cache unless it is nil.
+func NewKubernetesResolver(kube *k8s.Client, cache *redis.Client, rec *metrics.Recorder) *KubernetesResolver {
+	r := &KubernetesResolver{kube: kube, recorder: rec}
+	if cache != nil {
+		r.cache = cache
+	}
+	return r
+}
+
+// SetReadOnly makes lookups leave the cache untouched, owners found in
+// Kubernetes are not stored
+func (r *KubernetesResolver) SetReadOnly(readOnly bool) {
+	r.readOnly = readOnly
+}
+
+// Resolve returns the owner of ipv4
+func (r *KubernetesResolver) Resolve(ipv4 string) (*OFIP, error) {
+	owner, _, err := r.Trace(ipv4)
+	return owner, err
+}
+
+// Trace resolves ipv4 like Resolve and returns the steps taken, the last
+// one storing the owner in the cache
+func (r *KubernetesResolver) Trace(ipv4 string) (*OFIP, []Step, error) {
+	if ipv4 == "" {
+		return nil, nil, fmt.Errorf("ipv4 cannot be empty")
+	}
+
+	start := time.Now()
+	ofip, err := r.kube.GetOFIPByIPv4(ipv4)
+	r.recorder.ObserveLookup("kubernetes", err == nil)
+	step := Step{Resolver: "kubernetes", Duration: time.Since(start)}
+	if err != nil {
+		step.Result = StepError
+		if errors.Is(err, k8s.ErrNotFound) {
+			step.Result = StepMiss
+		}
+		step.Detail = err.Error()
+		return nil, []Step{step}, fmt.Errorf("failed to get namespace and name by ipv4: %w", err)
+	}
+
+	// Parse the ofip string (format: namespace-name)
+	owner, err := ParseOFIPName(ofip)
+	if err != nil {
+		step.Result = StepError
+		step.Detail = err.Error()
+		return nil, []Step{step}, err
+	}
+	step.Result = StepHit
+	step.Owner = owner
+	step.Detail = "ovn-fip " + ofip
+	steps := []Step{step}
+
+	// Store in Redis for future use
+	if r.cache != nil && !r.readOnly {
+		step := Step{Resolver: "cache", Result: StepStored}
+		start := time.Now()
+		if err := r.cache.SetIP(ipv4, redis.IPInfo{Namespace: owner.Namespace, Name: owner.Name}); err != nil {
+			// Log the error but don't fail the operation
+			log.Printf("resolver: failed to cache IP info in Redis: %v", err)
+			step.Result = StepError
+			step.Detail = err.Error()
+		}
+		step.Duration = time.Since(start)
+		steps = append(steps, step)
+	}
+
+	return owner, steps, nil
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/highscaleco/netlog/pkg/k8s"
+	"github.com/highscaleco/netlog/pkg/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeCache map[string]redis.IPInfo
+
+func (c fakeCache) GetIP(ip string) (redis.IPInfo, error) {
+	info, ok := c[ip]
+	if !ok {
+		return redis.IPInfo{}, fmt.Errorf("%w for ip: %s", redis.ErrNotFound, ip)
+	}
+	return info, nil
+}
+
+func (c fakeCache) SetIP(ip string, info redis.IPInfo) error {
+	c[ip] = info
+	return nil
+}
+
+type fakeKube map[string]string
+
+func (k fakeKube) GetOFIPByIPv4(ipv4 string) (string, error) {
+	name, ok := k[ipv4]
+	if !ok {
+		return "", fmt.Errorf("%w for ipv4: %s", k8s.ErrNotFound, ipv4)
+	}
+	return name, nil
+}
+
+func TestKubernetesResolverTrace(t *testing.T) {
+	cache := fakeCache{}
+	kube := &KubernetesResolver{kube: fakeKube{"203.0.113.10": "default-nginx", "203.0.113.11": "invalid"}, cache: cache}
+	chain := Chain{&RedisResolver{cache: cache}, kube}
+
+	owner, steps, err := chain.Trace("203.0.113.10")
+	require.NoError(t, err)
+	assert.Equal(t, &OFIP{Namespace: "default", Name: "nginx"}, owner)
+	require.Len(t, steps, 3)
+	assert.Equal(t, []string{"redis", "kubernetes", "cache"}, []string{steps[0].Resolver, steps[1].Resolver, steps[2].Resolver})
+	assert.Equal(t, []string{StepMiss, StepHit, StepStored}, []string{steps[0].Result, steps[1].Result, steps[2].Result})
+	assert.Equal(t, "ovn-fip default-nginx", steps[1].Detail)
+
+	// The owner is cached now
+	owner, steps, err = chain.Trace("203.0.113.10")
+	require.NoError(t, err)
+	assert.Equal(t, "default/nginx", owner.String())
+	require.Len(t, steps, 1)
+	assert.Equal(t, StepHit, steps[0].Result)
+
+	_, steps, err = chain.Trace("203.0.113.12")
+	assert.True(t, errors.Is(err, k8s.ErrNotFound))
+	require.Len(t, steps, 2)
+	assert.Equal(t, StepMiss, steps[1].Result)
+
+	_, steps, err = chain.Trace("203.0.113.11")
+	assert.Error(t, err)
+	assert.Equal(t, StepError, steps[1].Result)
+
+	// Read-only lookups don't store the owner
+	kube.SetReadOnly(true)
+	kube.kube = fakeKube{"203.0.113.13": "default-redis"}
+	owner, steps, err = chain.Trace("203.0.113.13")
+	require.NoError(t, err)
+	assert.Equal(t, "default/redis", owner.String())
+	require.Len(t, steps, 2)
+	assert.NotContains(t, cache, "203.0.113.13")
+
+	_, steps, err = NewKubernetesResolver(nil, nil, nil).Trace("")
+	assert.Error(t, err)
+	assert.Empty(t, steps)
+
+	// Without a cache the owners are not stored
+	kube = &KubernetesResolver{kube: fakeKube{"203.0.113.10": "default-nginx"}}
+	_, steps, err = kube.Trace("203.0.113.10")
+	require.NoError(t, err)
+	assert.Len(t, steps, 1)
+}
+
+func TestRedisResolver(t *testing.T) {
+	r := &RedisResolver{cache: fakeCache{"203.0.113.10": {Namespace: "default", Name: "nginx"}}}
+	owner, err := r.Resolve("203.0.113.10")
+	require.NoError(t, err)
+	assert.Equal(t, "default/nginx", owner.String())
+
+	_, steps, err := r.Trace("203.0.113.11")
+	assert.ErrorContains(t, err, "no owner cached")
+	assert.Equal(t, []string{"redis"}, []string{steps[0].Resolver})
+	assert.Equal(t, StepMiss, steps[0].Result)
+}
+
+func TestParseOFIPName(t *testing.T) {
+	owner, err := ParseOFIPName("tenant-a-web")
+	require.NoError(t, err)
+	assert.Equal(t, &OFIP{Namespace: "tenant", Name: "a-web"}, owner)
+
+	_, err = ParseOFIPName("nginx")
+	assert.Error(t, err)
+}